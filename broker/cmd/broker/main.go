@@ -6,46 +6,315 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/purdue-af/vscode-k8s-connector/internal/auth"
+	"github.com/purdue-af/vscode-k8s-connector/internal/httpclient"
 	"github.com/purdue-af/vscode-k8s-connector/internal/jupyterhub"
 	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
 	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+	"github.com/purdue-af/vscode-k8s-connector/internal/tracing"
 	"github.com/purdue-af/vscode-k8s-connector/internal/tunnel"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 	"github.com/purdue-af/vscode-k8s-connector/pkg/api"
 )
 
+// defaultReadHeaderTimeout bounds how long a client may take to send request
+// headers, so slow or stalled clients cannot hold connections open
+const defaultReadHeaderTimeout = 10 * time.Second
+
 func main() {
 	// Load configuration from environment
 	config := loadConfig()
 
+	shutdownTracing, err := tracing.Setup(context.Background(), config.Tracing)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	// Initialize components
-	k8sClient, err := k8s.NewClient(config.KubeconfigPath)
+	// The identity provider and JupyterHub clients share one connection pool
+	httpClient := httpclient.NewClient(config.HTTP)
+
+	// The API server would silently change a lifetime outside its bounds
+	if ttl, clamped := config.K8s.TokenTTL.Clamp(config.K8s.TokenTTL.TTL); clamped {
+		log.Printf("K8S_TOKEN_TTL %v is outside the cluster's token lifetime bounds, requesting %v instead",
+			config.K8s.TokenTTL.TTL, ttl)
+	}
+
+	k8sClient, err := k8s.NewClient(k8s.ClientConfig{
+		KubeconfigPath:         config.K8s.KubeconfigPath,
+		RoleMode:               config.K8s.RoleMode,
+		AccessMode:             config.K8s.AccessMode,
+		ImpersonationGroups:    config.K8s.ImpersonationGroups,
+		ServiceAccountMode:     config.K8s.ServiceAccountMode,
+		DebugContainers:        config.K8s.DebugContainers,
+		DebugImage:             config.K8s.DebugImage,
+		ExecEnvSources:         config.K8s.ExecEnvSources,
+		DeniedNamespaces:       config.K8s.DeniedNamespaces,
+		ExecProtocol:           config.K8s.ExecProtocol,
+		CreateNamespaces:       config.K8s.CreateNamespaces,
+		NamespaceLabels:        config.K8s.NamespaceLabels,
+		NamespaceQuota:         config.K8s.NamespaceQuota,
+		RequiredPodLabels:      config.K8s.RequiredPodLabels,
+		RequiredPodAnnotations: config.K8s.RequiredPodAnnotations,
+		PodOwnerAnnotation:     config.K8s.PodOwnerAnnotation,
+		QPS:                    config.K8s.QPS,
+		Burst:                  config.K8s.Burst,
+		Retry:                  config.K8s.Retry,
+		Timeouts:               config.K8s.Timeouts,
+		TokenTTL:               config.K8s.TokenTTL,
+	})
 	if err != nil {
 		log.Fatalf("Failed to create Kubernetes client: %v", err)
 	}
 
-	oidcProvider := auth.NewCILogonProvider(auth.CILogonConfig{
-		Issuer:       config.OIDC.Issuer,
-		ClientID:     config.OIDC.ClientID,
-		ClientSecret: config.OIDC.ClientSecret,
-		RedirectURL:  config.OIDC.RedirectURL,
+	validateCtx, validateCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = k8sClient.ValidateAccessMode(validateCtx)
+	validateCancel()
+	if err != nil {
+		log.Fatalf("Kubernetes access mode %q is not usable: %v", config.K8s.AccessMode, err)
+	}
+
+	// Sessions do not survive a restart of the in-memory store, so session
+	// resources created (or, for per-user ServiceAccounts, last used) longer
+	// ago than any session can live are orphaned, even if other brokers with
+	// the same SESSION_MAX_TTL share the cluster
+	if config.K8s.CleanupOnStartup {
+		cleanupCtx, cleanupCancel := context.WithTimeout(context.Background(), time.Minute)
+		reclaimed, err := k8sClient.CleanupStaleResources(cleanupCtx, config.SessionMaxTTL, nil)
+		cleanupCancel()
+		if err != nil {
+			log.Printf("Failed to clean up stale session resources after reclaiming %d service accounts: %v", reclaimed, err)
+		} else {
+			log.Printf("Reclaimed %d stale session service accounts", reclaimed)
+		}
+	}
+
+	if len(config.OIDC.LatencyBuckets) > 0 {
+		metrics.SetAuthBuckets(config.OIDC.LatencyBuckets)
+	}
+	extraAuthParams, err := auth.ParseAuthParams(config.OIDC.ExtraAuthParams)
+	if err != nil {
+		log.Fatalf("Invalid OIDC_EXTRA_AUTH_PARAMS: %v", err)
+	}
+	cilogonProvider := auth.NewCILogonProvider(auth.CILogonConfig{
+		Issuer:          config.OIDC.Issuer,
+		ClientID:        config.OIDC.ClientID,
+		ClientSecret:    config.OIDC.ClientSecret,
+		RedirectURL:     config.OIDC.RedirectURL,
+		HTTPClient:      httpClient,
+		ExtraAuthParams: extraAuthParams,
+		AuthFlowTimeout: config.OIDC.AuthFlowTimeout,
+		MaxPendingFlows: config.OIDC.MaxPendingFlows,
+	})
+	discoverCtx, discoverCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := cilogonProvider.Discover(discoverCtx); err != nil {
+		log.Printf("OIDC discovery failed, using default CILogon endpoints: %v", err)
+	}
+	discoverCancel()
+	var oidcProvider auth.Provider = auth.NewInstrumentedProvider(cilogonProvider)
+	if config.OIDC.TokenCacheTTL > 0 {
+		oidcProvider = auth.NewCachingProvider(oidcProvider, auth.CacheConfig{
+			TTL:        config.OIDC.TokenCacheTTL,
+			RevokedTTL: config.OIDC.RevokedTokenTTL,
+		})
+	}
+	tokenSigner, err := session.NewSigner(session.SigningConfig{
+		Algorithm:       config.JWTAlgorithm,
+		Secret:          config.JWTSecret,
+		PrivateKeyFile:  config.JWTPrivateKeyFile,
+		PreviousSecrets: config.JWTPreviousSecrets,
 	})
-	sessionStore := session.NewInMemoryStore(config.SessionTTL, config.JWTSecret)
-	jupyterHubClient := jupyterhub.NewClient(jupyterhub.JupyterHubConfig{
-		APIURL:   config.JupyterHub.APIURL,
-		APIToken: config.JupyterHub.APIToken,
+	if err != nil {
+		log.Fatalf("Invalid session token signing configuration: %v", err)
+	}
+	if err := session.ValidateTTL(config.SessionTTL, config.SessionMaxTTL); err != nil {
+		log.Fatalf("Invalid session TTL: %v", err)
+	}
+	switch config.SessionEvictionPolicy {
+	case session.EvictionReject, session.EvictionOldest:
+	default:
+		log.Fatalf("Invalid session eviction policy %q", config.SessionEvictionPolicy)
+	}
+	// Evicted sessions are torn down by the handlers, which are set up before
+	// any session can be created
+	var handlers *api.Handlers
+	sessionStore := session.NewInMemoryStoreWithConfig(session.StoreConfig{
+		TTL:               config.SessionTTL,
+		MaxTTL:            config.SessionMaxTTL,
+		Signer:            tokenSigner,
+		ClockSkewLeeway:   config.ClockSkewLeeway,
+		TokenTTL:          config.SessionTokenTTL,
+		TokenRenewalGrace: config.SessionTokenRenewalGrace,
+		CleanupInterval:   config.SessionCleanupInterval,
+		HandoffTTL:        config.SessionHandoffTTL,
+		IdempotencyTTL:    config.SessionIdempotencyTTL,
+		MaxSessions:       config.SessionMaxCount,
+		EvictionPolicy:    config.SessionEvictionPolicy,
+		OnEvict: func(evicted *types.Session) {
+			go handlers.EndEvictedSession(evicted)
+		},
+	})
+	namespaceResolver, err := jupyterhub.NewNamespaceResolver(
+		config.JupyterHub.NamespaceStrategy, config.JupyterHub.namespaceStrategyValue(), k8sClient)
+	if err != nil {
+		log.Fatalf("Invalid namespace configuration: %v", err)
+	}
+	var podSelector *jupyterhub.PodSelector
+	var serverPodNamer *jupyterhub.ServerPodNamer
+	switch config.JupyterHub.PodDiscovery {
+	case jupyterhub.PodDiscoveryName:
+	case jupyterhub.PodDiscoveryLabel:
+		podSelector, err = jupyterhub.NewPodSelector(config.JupyterHub.PodSelector, k8sClient)
+		if err != nil {
+			log.Fatalf("Invalid pod discovery configuration: %v", err)
+		}
+	case jupyterhub.PodDiscoveryServer:
+		serverPodNamer, err = jupyterhub.NewServerPodNamer(config.JupyterHub.PodNameTemplate)
+		if err != nil {
+			log.Fatalf("Invalid pod discovery configuration: %v", err)
+		}
+	default:
+		log.Fatalf("Invalid pod discovery mode %q", config.JupyterHub.PodDiscovery)
+	}
+	hubClient := jupyterhub.NewClient(jupyterhub.JupyterHubConfig{
+		APIURL:            config.JupyterHub.APIURL,
+		APIToken:          config.JupyterHub.APIToken,
+		NamespaceResolver: namespaceResolver,
+		HTTPClient:        httpClient,
+		PodSelector:       podSelector,
+		ServerPodNamer:    serverPodNamer,
+		SpawnTimeout:      config.JupyterHub.SpawnTimeout,
+	})
+	switch config.JupyterHub.SpawnConcurrency {
+	case jupyterhub.SpawnConcurrencyWait, jupyterhub.SpawnConcurrencyReject:
+	default:
+		log.Fatalf("Invalid spawn concurrency %q", config.JupyterHub.SpawnConcurrency)
+	}
+	// Concurrent requests for one user share a single server start
+	var jupyterHubClient jupyterhub.ClientInterface = jupyterhub.NewCoalescingClient(hubClient, config.JupyterHub.SpawnConcurrency)
+	switch config.Tunnel.DuplicateTunnels {
+	case tunnel.DuplicateTunnelReplace, tunnel.DuplicateTunnelReject:
+	default:
+		log.Fatalf("Invalid tunnel duplicate policy %q", config.Tunnel.DuplicateTunnels)
+	}
+	switch config.Tunnel.ExecRunAsMechanism {
+	case "", tunnel.RunAsRunuser, tunnel.RunAsSu, tunnel.RunAsSetpriv:
+	default:
+		log.Fatalf("Invalid exec run-as mechanism %q", config.Tunnel.ExecRunAsMechanism)
+	}
+	if err := tunnel.ValidateCompressionLevel(config.Tunnel.CompressionLevel); err != nil {
+		log.Fatalf("Invalid TUNNEL_COMPRESSION_LEVEL: %v", err)
+	}
+	if root := config.Tunnel.PermissionsRoot; root != "" && !strings.HasPrefix(root, "/") {
+		log.Fatalf("Invalid FILE_PERMISSIONS_ROOT %q: must be an absolute path", root)
+	}
+	if err := tunnel.ValidateProcessColumns(config.Tunnel.ProcessColumns); err != nil {
+		log.Fatalf("Invalid process list columns: %v", err)
+	}
+	tunnelManager := tunnel.NewManager(k8sClient, tunnel.ManagerConfig{
+		MaxTotalTunnels:          config.Tunnel.MaxTotalTunnels,
+		WriteTimeout:             config.Tunnel.WriteTimeout,
+		ReconnectBackoff:         config.Tunnel.ReconnectBackoff,
+		SetupTimeout:             config.Tunnel.SetupTimeout,
+		HandshakeTimeout:         config.Tunnel.HandshakeTimeout,
+		DisableCompression:       !config.Tunnel.Compression,
+		CompressionLevel:         config.Tunnel.CompressionLevel,
+		CompressionThreshold:     config.Tunnel.CompressionThreshold,
+		CredentialReleaseGrace:   config.Tunnel.CredentialReleaseGrace,
+		DisableResumeTokens:      !config.Tunnel.ResumeTokens,
+		MaxTunnelLifetime:        config.Tunnel.MaxLifetime,
+		LogMessageTypes:          config.Tunnel.LogMessageTypes,
+		MaxNamespaceRelays:       config.Tunnel.MaxNamespaceRelays,
+		DuplicateTunnels:         config.Tunnel.DuplicateTunnels,
+		ExecPrelude:              config.Tunnel.ExecPrelude,
+		ExecShell:                config.Tunnel.ExecShell,
+		ExecLoginShell:           config.Tunnel.ExecLoginShell,
+		RunAsMechanism:           config.Tunnel.ExecRunAsMechanism,
+		AllowedCommands:          config.Tunnel.ExecAllowedCommands,
+		SanitizeExecOutput:       config.Tunnel.SanitizeExecOutput,
+		ExecResourceWrappers:     config.Tunnel.ExecResourceWrappers,
+		FileCompressionThreshold: config.Tunnel.FileCompressionThreshold,
+		MaxBatchOperations:       config.Tunnel.MaxBatchOperations,
+		MaxFileSize:              int64(config.Tunnel.MaxFileSize),
+		MaxConcurrentFileOps:     config.Tunnel.MaxConcurrentFileOps,
+		FileOperationTimeout:     config.Tunnel.FileOperationTimeout,
+		MaxListEntries:           config.Tunnel.MaxListEntries,
+		WorkspaceRoot:            config.Tunnel.WorkspaceRoot,
+		PermissionsRoot:          config.Tunnel.PermissionsRoot,
+		AllowChown:               config.Tunnel.AllowChown,
+		ExecFlushInterval:        config.Tunnel.ExecFlushInterval,
+		ExecTTYFlushInterval:     config.Tunnel.ExecTTYFlushInterval,
+		ExecFlushBufferSize:      config.Tunnel.ExecFlushBufferSize,
+		ExecMaxOutputBytes:       config.Tunnel.ExecMaxOutputBytes,
+		ExecTTYWeight:            config.Tunnel.ExecTTYWeight,
+		ExecOutputQueueLength:    config.Tunnel.ExecOutputQueueLength,
+		ExecSlowReaderTimeout:    config.Tunnel.ExecSlowReaderTimeout,
+		MaxExecStreams:           config.Tunnel.MaxExecStreams,
+		ProcessColumns:           config.Tunnel.ProcessColumns,
+		TokenRenewer:             sessionStore,
+		TokenRenewalInterval:     config.SessionTokenRenewalInterval,
+		InstanceURL:              config.InstanceURL,
+		TunnelOwners:             sessionStore,
 	})
-	tunnelManager := tunnel.NewManager(k8sClient)
 
 	// Initialize API handlers
-	handlers := api.NewHandlers(oidcProvider, sessionStore, jupyterHubClient, tunnelManager)
+	usernameNormalizer, err := jupyterhub.NewUsernameNormalizer(jupyterhub.UsernameNormalizerConfig{
+		Pattern:     config.JupyterHub.UsernamePattern,
+		StripDomain: config.JupyterHub.UsernameStripDomain,
+		Lowercase:   config.JupyterHub.UsernameLowercase,
+		Template:    config.JupyterHub.UsernameTemplate,
+	})
+	if err != nil {
+		log.Fatalf("Invalid username normalization configuration: %v", err)
+	}
+
+	switch config.ReadinessPolicy {
+	case api.CapabilityTunnels, api.CapabilitySessions:
+	default:
+		log.Fatalf("Invalid readiness policy %q", config.ReadinessPolicy)
+	}
+	if config.PublicURL != "" {
+		if _, err := api.TunnelBaseURL(config.PublicURL); err != nil {
+			log.Fatalf("Invalid PUBLIC_URL: %v", err)
+		}
+	}
+	handlers = api.NewHandlers(oidcProvider, sessionStore, jupyterHubClient, tunnelManager, api.HandlersConfig{
+		UsernameNormalizer: usernameNormalizer,
+		MaxBodyBytes:       int64(config.MaxBodyBytes),
+		PodGetter:          k8sClient,
+		TokenSigner:        tokenSigner,
+		PublicURL:          config.PublicURL,
+		TrustedProxies:     config.TrustedProxies,
+		InstanceURL:        config.InstanceURL,
+		InternalToken:      config.InternalToken,
+		ForwardClient:      httpClient,
+		DependencyChecks: map[string]api.DependencyCheck{
+			api.DependencyKubernetes: k8sClient.Ping,
+			api.DependencyOIDC:       cilogonProvider.Ping,
+			api.DependencyJupyterHub: hubClient.Ping,
+		},
+		ReadinessPolicy:      config.ReadinessPolicy,
+		RequestTimeout:       config.RequestTimeout,
+		SessionCreateTimeout: config.SessionCreateTimeout,
+		CreateConcurrency:    config.JupyterHub.SpawnConcurrency,
+	})
 
 	// Setup Gin router
 	router := gin.Default()
+	// Client IPs are read from X-Forwarded-For or X-Real-IP only on requests
+	// from trusted proxies; without any, the connection's address is used
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+	router.Use(tracing.Middleware())
 
 	// Add CORS middleware
 	router.Use(func(c *gin.Context) {
@@ -65,10 +334,7 @@ func main() {
 	api.RegisterRoutes(router, handlers)
 
 	// Start server
-	srv := &http.Server{
-		Addr:    config.ListenAddr,
-		Handler: router,
-	}
+	srv := newServer(config, router)
 
 	// Start server in goroutine
 	go func() {
@@ -78,12 +344,31 @@ func main() {
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown
+	// Wait for interrupt signal to gracefully shutdown. SIGUSR1 starts
+	// draining ahead of a planned shutdown without stopping the broker.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	drain := make(chan os.Signal, 1)
+	signal.Notify(drain, syscall.SIGUSR1)
+waiting:
+	for {
+		select {
+		case <-drain:
+			tunnelManager.Drain(config.Tunnel.DrainLeadTime)
+		case <-quit:
+			break waiting
+		}
+	}
 	log.Println("Shutting down server...")
 
+	// Warn open tunnels and leave their clients the lead time to move to
+	// another replica; a second signal skips the wait
+	shutdownAt := tunnelManager.Drain(config.Tunnel.DrainLeadTime)
+	select {
+	case <-time.After(time.Until(shutdownAt)):
+	case <-quit:
+	}
+
 	// Give outstanding requests 30 seconds to complete
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -91,25 +376,166 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	tunnelManager.CloseAll(ctx)
+	// Credentials kept for reconnects would otherwise outlive the broker
+	tunnelManager.ReleasePending(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
 
 	log.Println("Server exited")
 }
 
 func loadConfig() *Config {
 	return &Config{
-		ListenAddr:     getEnv("LISTEN_ADDR", ":8080"),
-		KubeconfigPath: getEnv("KUBECONFIG", ""),
-		SessionTTL:     getEnv("SESSION_TTL", "24h"),
-		JWTSecret:      getEnv("JWT_SECRET", "change-me-in-production"),
+		ListenAddr:                  getEnv("LISTEN_ADDR", ":8080"),
+		ReadHeaderTimeout:           getEnvDuration("READ_HEADER_TIMEOUT", defaultReadHeaderTimeout),
+		SessionTTL:                  getEnv("SESSION_TTL", "24h"),
+		SessionMaxTTL:               getEnvDuration("SESSION_MAX_TTL", session.DefaultMaxTTL),
+		JWTSecret:                   getEnv("JWT_SECRET", "change-me-in-production"),
+		JWTAlgorithm:                getEnv("JWT_ALGORITHM", session.SigningHS256),
+		JWTPrivateKeyFile:           getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTPreviousSecrets:          getEnvList("JWT_PREVIOUS_SECRETS"),
+		ClockSkewLeeway:             getEnvDuration("CLOCK_SKEW_LEEWAY", 30*time.Second),
+		SessionTokenTTL:             getEnvDuration("SESSION_TOKEN_TTL", session.DefaultTokenTTL),
+		SessionTokenRenewalInterval: getEnvDuration("SESSION_TOKEN_RENEWAL_INTERVAL", 10*time.Minute),
+		SessionTokenRenewalGrace:    getEnvDuration("SESSION_TOKEN_RENEWAL_GRACE", 5*time.Minute),
+		SessionCleanupInterval:      getEnvDuration("SESSION_CLEANUP_INTERVAL", session.DefaultCleanupInterval),
+		SessionHandoffTTL:           getEnvDuration("SESSION_HANDOFF_TTL", session.DefaultHandoffTTL),
+		SessionIdempotencyTTL:       getEnvDuration("SESSION_IDEMPOTENCY_TTL", session.DefaultIdempotencyTTL),
+		SessionMaxCount:             getEnvInt("SESSION_MAX_COUNT", 0),
+		SessionEvictionPolicy:       getEnv("SESSION_EVICTION_POLICY", session.EvictionReject),
+		MaxBodyBytes:                getEnvInt("MAX_REQUEST_BODY_BYTES", api.DefaultMaxBodyBytes),
+		PublicURL:                   getEnv("PUBLIC_URL", ""),
+		InstanceURL:                 getEnv("BROKER_INSTANCE_URL", ""),
+		InternalToken:               getEnv("INTERNAL_API_TOKEN", ""),
+		TrustedProxies:              getEnvList("TRUSTED_PROXIES"),
+		ReadinessPolicy:             getEnv("READINESS_POLICY", api.CapabilityTunnels),
+		RequestTimeout:              getEnvDuration("REQUEST_TIMEOUT", api.DefaultRequestTimeout),
+		SessionCreateTimeout:        getEnvDuration("SESSION_CREATE_TIMEOUT", api.DefaultSessionCreateTimeout),
+		HTTP: httpclient.Config{
+			DialTimeout:           getEnvDuration("HTTP_DIAL_TIMEOUT", httpclient.DefaultDialTimeout),
+			TLSHandshakeTimeout:   getEnvDuration("HTTP_TLS_HANDSHAKE_TIMEOUT", httpclient.DefaultTLSHandshakeTimeout),
+			ResponseHeaderTimeout: getEnvDuration("HTTP_RESPONSE_HEADER_TIMEOUT", httpclient.DefaultResponseHeaderTimeout),
+			Timeout:               getEnvDuration("HTTP_TIMEOUT", httpclient.DefaultTimeout),
+			MaxIdleConns:          getEnvInt("HTTP_MAX_IDLE_CONNS", httpclient.DefaultMaxIdleConns),
+			MaxIdleConnsPerHost:   getEnvInt("HTTP_MAX_IDLE_CONNS_PER_HOST", httpclient.DefaultMaxIdleConnsPerHost),
+			IdleConnTimeout:       getEnvDuration("HTTP_IDLE_CONN_TIMEOUT", httpclient.DefaultIdleConnTimeout),
+		},
+		Tracing: tracing.Config{
+			Enabled:     getEnvBool("TRACING_ENABLED", false),
+			Endpoint:    getEnv("TRACING_OTLP_ENDPOINT", ""),
+			ServiceName: getEnv("TRACING_SERVICE_NAME", tracing.DefaultServiceName),
+			SampleRatio: getEnvFloat("TRACING_SAMPLE_RATIO", 1),
+		},
 		OIDC: OIDCConfig{
-			Issuer:       getEnv("OIDC_ISSUER", "https://cilogon.org"),
-			ClientID:     getEnv("OIDC_CLIENT_ID", ""),
-			ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
-			RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+			Issuer:          getEnv("OIDC_ISSUER", "https://cilogon.org"),
+			ClientID:        getEnv("OIDC_CLIENT_ID", ""),
+			ClientSecret:    getEnv("OIDC_CLIENT_SECRET", ""),
+			RedirectURL:     getEnv("OIDC_REDIRECT_URL", ""),
+			TokenCacheTTL:   getEnvDuration("TOKEN_CACHE_TTL", 0),
+			RevokedTokenTTL: getEnvDuration("REVOKED_TOKEN_TTL", time.Hour),
+			LatencyBuckets:  getEnvFloatList("AUTH_LATENCY_BUCKETS"),
+			ExtraAuthParams: getEnv("OIDC_EXTRA_AUTH_PARAMS", ""),
+			AuthFlowTimeout: getEnvDuration("AUTH_FLOW_TIMEOUT", auth.DefaultAuthFlowTimeout),
+			MaxPendingFlows: getEnvInt("AUTH_MAX_PENDING_FLOWS", auth.DefaultMaxPendingFlows),
 		},
 		JupyterHub: JupyterHubConfig{
-			APIURL:   getEnv("JUPYTERHUB_API_URL", ""),
-			APIToken: getEnv("JUPYTERHUB_API_TOKEN", ""),
+			APIURL:              getEnv("JUPYTERHUB_API_URL", ""),
+			APIToken:            getEnv("JUPYTERHUB_API_TOKEN", ""),
+			NamespaceStrategy:   getEnv("NAMESPACE_STRATEGY", jupyterhub.NamespaceStrategyTemplate),
+			NamespaceTemplate:   getEnv("NAMESPACE_TEMPLATE", jupyterhub.DefaultNamespaceTemplate),
+			NamespaceName:       getEnv("NAMESPACE_NAME", ""),
+			NamespaceLabelKey:   getEnv("NAMESPACE_LABEL_KEY", ""),
+			PodDiscovery:        getEnv("POD_DISCOVERY", jupyterhub.PodDiscoveryName),
+			PodSelector:         getEnv("POD_LABEL_SELECTOR", jupyterhub.DefaultPodSelector),
+			PodNameTemplate:     getEnv("POD_NAME_TEMPLATE", jupyterhub.DefaultServerPodName),
+			UsernamePattern:     getEnv("JUPYTERHUB_USERNAME_PATTERN", ""),
+			UsernameStripDomain: getEnvBool("JUPYTERHUB_USERNAME_STRIP_DOMAIN", false),
+			UsernameLowercase:   getEnvBool("JUPYTERHUB_USERNAME_LOWERCASE", false),
+			UsernameTemplate:    getEnv("JUPYTERHUB_USERNAME_TEMPLATE", ""),
+			SpawnConcurrency:    getEnv("JUPYTERHUB_SPAWN_CONCURRENCY", jupyterhub.SpawnConcurrencyWait),
+			SpawnTimeout:        getEnvDuration("JUPYTERHUB_SPAWN_TIMEOUT", jupyterhub.DefaultSpawnTimeout),
+		},
+		Tunnel: TunnelConfig{
+			MaxTotalTunnels:          getEnvInt("MAX_TOTAL_TUNNELS", 0),
+			WriteTimeout:             getEnvDuration("TUNNEL_WRITE_TIMEOUT", tunnel.DefaultWriteTimeout),
+			ReconnectBackoff:         getEnvDuration("TUNNEL_RECONNECT_BACKOFF", tunnel.DefaultReconnectBackoff),
+			SetupTimeout:             getEnvDuration("TUNNEL_SETUP_TIMEOUT", tunnel.DefaultSetupTimeout),
+			HandshakeTimeout:         getEnvDuration("TUNNEL_HANDSHAKE_TIMEOUT", tunnel.DefaultHandshakeTimeout),
+			DrainLeadTime:            getEnvDuration("TUNNEL_DRAIN_LEAD_TIME", 0),
+			Compression:              getEnvBool("TUNNEL_COMPRESSION", true),
+			CompressionLevel:         getEnvInt("TUNNEL_COMPRESSION_LEVEL", tunnel.DefaultCompressionLevel),
+			CompressionThreshold:     getEnvInt("TUNNEL_COMPRESSION_THRESHOLD", tunnel.DefaultCompressionThreshold),
+			CredentialReleaseGrace:   getEnvDuration("TUNNEL_CREDENTIAL_RELEASE_GRACE", 0),
+			ResumeTokens:             getEnvBool("TUNNEL_RESUME_TOKENS", true),
+			MaxLifetime:              getEnvDuration("TUNNEL_MAX_LIFETIME", tunnel.DefaultMaxTunnelLifetime),
+			LogMessageTypes:          getEnvBool("TUNNEL_LOG_MESSAGE_TYPES", false),
+			MaxNamespaceRelays:       getEnvInt("TUNNEL_MAX_NAMESPACE_RELAYS", 0),
+			DuplicateTunnels:         getEnv("TUNNEL_DUPLICATE_POLICY", tunnel.DuplicateTunnelReplace),
+			ExecPrelude:              getEnv("EXEC_PRELUDE", ""),
+			ExecShell:                getEnv("EXEC_SHELL", tunnel.DefaultExecShell),
+			ExecLoginShell:           getEnvBool("EXEC_LOGIN_SHELL", false),
+			ExecRunAsMechanism:       getEnv("EXEC_RUN_AS_MECHANISM", ""),
+			ExecAllowedCommands:      getEnvList("EXEC_ALLOWED_COMMANDS"),
+			SanitizeExecOutput:       getEnvBool("EXEC_SANITIZE_OUTPUT", true),
+			ExecResourceWrappers:     getEnvList("EXEC_RESOURCE_WRAPPERS"),
+			FileCompressionThreshold: getEnvInt("FILE_COMPRESSION_THRESHOLD", tunnel.DefaultFileCompressionThreshold),
+			MaxBatchOperations:       getEnvInt("FILE_BATCH_MAX_OPERATIONS", tunnel.DefaultMaxBatchOperations),
+			MaxFileSize:              getEnvInt("FILE_MAX_SIZE", tunnel.DefaultMaxFileSize),
+			MaxConcurrentFileOps:     getEnvInt("FILE_CONCURRENCY", tunnel.DefaultMaxConcurrentFileOps),
+			FileOperationTimeout:     getEnvDuration("FILE_OPERATION_TIMEOUT", tunnel.DefaultFileOperationTimeout),
+			MaxListEntries:           getEnvInt("FILE_LIST_MAX_ENTRIES", tunnel.DefaultMaxListEntries),
+			WorkspaceRoot:            getEnv("WORKSPACE_ROOT", ""),
+			PermissionsRoot:          getEnv("FILE_PERMISSIONS_ROOT", ""),
+			AllowChown:               getEnvBool("FILE_ALLOW_CHOWN", false),
+			ExecFlushInterval:        getEnvDuration("EXEC_FLUSH_INTERVAL", tunnel.DefaultExecFlushInterval),
+			ExecTTYFlushInterval:     getEnvDuration("EXEC_TTY_FLUSH_INTERVAL", tunnel.DefaultExecTTYFlushInterval),
+			ExecFlushBufferSize:      getEnvInt("EXEC_FLUSH_BUFFER_SIZE", tunnel.DefaultExecFlushBufferSize),
+			ExecMaxOutputBytes:       getEnvInt("EXEC_MAX_OUTPUT_BYTES", tunnel.DefaultExecMaxOutputBytes),
+			ExecTTYWeight:            getEnvInt("EXEC_TTY_WEIGHT", tunnel.DefaultExecTTYWeight),
+			ExecOutputQueueLength:    getEnvInt("EXEC_OUTPUT_QUEUE_LENGTH", tunnel.DefaultExecOutputQueueLength),
+			ExecSlowReaderTimeout:    getEnvDuration("EXEC_SLOW_READER_TIMEOUT", tunnel.DefaultExecSlowReaderTimeout),
+			MaxExecStreams:           getEnvInt("TUNNEL_MAX_EXEC_STREAMS", 0),
+			ProcessColumns:           getEnvList("PROCESS_LIST_COLUMNS"),
+		},
+		K8s: K8sConfig{
+			KubeconfigPath:         getEnv("KUBECONFIG", ""),
+			RoleMode:               getEnv("K8S_ROLE_MODE", k8s.RoleModePerSession),
+			AccessMode:             getEnv("K8S_ACCESS_MODE", k8s.AccessModeServiceAccount),
+			ImpersonationGroups:    getEnvList("K8S_IMPERSONATION_GROUPS"),
+			ServiceAccountMode:     getEnv("K8S_SERVICE_ACCOUNT_MODE", k8s.ServiceAccountModePerSession),
+			DebugContainers:        getEnvBool("K8S_DEBUG_CONTAINERS", false),
+			DebugImage:             getEnv("K8S_DEBUG_IMAGE", k8s.DefaultDebugImage),
+			ExecEnvSources:         getEnvList("K8S_EXEC_ENV_SOURCES"),
+			DeniedNamespaces:       getEnvList("K8S_DENIED_NAMESPACES"),
+			ExecProtocol:           getEnv("K8S_EXEC_PROTOCOL", k8s.ExecProtocolAuto),
+			CreateNamespaces:       getEnvBool("K8S_CREATE_NAMESPACES", false),
+			NamespaceLabels:        getEnvList("K8S_NAMESPACE_LABELS"),
+			NamespaceQuota:         getEnvList("K8S_NAMESPACE_QUOTA"),
+			CleanupOnStartup:       getEnvBool("K8S_CLEANUP_ON_STARTUP", false),
+			RequiredPodLabels:      getEnvList("K8S_REQUIRED_POD_LABELS"),
+			RequiredPodAnnotations: getEnvList("K8S_REQUIRED_POD_ANNOTATIONS"),
+			PodOwnerAnnotation:     getEnv("K8S_POD_OWNER_ANNOTATION", k8s.DefaultPodOwnerAnnotation),
+			QPS:                    float32(getEnvFloat("K8S_CLIENT_QPS", k8s.DefaultQPS)),
+			Burst:                  getEnvInt("K8S_CLIENT_BURST", k8s.DefaultBurst),
+			Retry: k8s.RetryConfig{
+				Attempts:       getEnvInt("K8S_RETRY_ATTEMPTS", k8s.DefaultRetryAttempts),
+				InitialBackoff: getEnvDuration("K8S_RETRY_INITIAL_BACKOFF", k8s.DefaultRetryInitialBackoff),
+				MaxBackoff:     getEnvDuration("K8S_RETRY_MAX_BACKOFF", k8s.DefaultRetryMaxBackoff),
+			},
+			Timeouts: k8s.TimeoutConfig{
+				GetPod:               getEnvDuration("K8S_GET_POD_TIMEOUT", k8s.DefaultOperationTimeout),
+				CreateServiceAccount: getEnvDuration("K8S_CREATE_SERVICE_ACCOUNT_TIMEOUT", k8s.DefaultOperationTimeout),
+				CreateRoleBinding:    getEnvDuration("K8S_CREATE_ROLE_BINDING_TIMEOUT", k8s.DefaultOperationTimeout),
+				MintToken:            getEnvDuration("K8S_MINT_TOKEN_TIMEOUT", k8s.DefaultOperationTimeout),
+				DeleteServiceAccount: getEnvDuration("K8S_DELETE_SERVICE_ACCOUNT_TIMEOUT", k8s.DefaultOperationTimeout),
+			},
+			TokenTTL: k8s.TokenTTLConfig{
+				TTL: getEnvDuration("K8S_TOKEN_TTL", k8s.DefaultTokenTTL),
+				Min: getEnvDuration("K8S_MIN_TOKEN_TTL", k8s.DefaultMinTokenTTL),
+				Max: getEnvDuration("K8S_MAX_TOKEN_TTL", 0),
+			},
 		},
 	}
 }
@@ -121,13 +547,147 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid integer for %s: %v", key, err)
+	}
+	return n
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid number for %s: %v", key, err)
+	}
+	return f
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid boolean for %s: %v", key, err)
+	}
+	return b
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func getEnvFloatList(key string) []float64 {
+	var values []float64
+	for _, entry := range getEnvList(key) {
+		value, err := strconv.ParseFloat(entry, 64)
+		if err != nil {
+			log.Fatalf("Invalid number in %s: %v", key, err)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid duration for %s: %v", key, err)
+	}
+	return duration
+}
+
+// newServer creates the broker's HTTP server. Only reading request headers
+// is bounded: bodies and responses are left to the handlers, since tunnels
+// and session streams stay open for as long as they are used.
+func newServer(config *Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+	}
+}
+
 type Config struct {
-	ListenAddr     string
-	KubeconfigPath string
-	SessionTTL     string
-	JWTSecret      string
-	OIDC           OIDCConfig
-	JupyterHub     JupyterHubConfig
+	ListenAddr string
+	// ReadHeaderTimeout bounds reading each request's headers
+	ReadHeaderTimeout time.Duration
+	SessionTTL        string
+	// SessionMaxTTL caps the lifetime of any session
+	SessionMaxTTL time.Duration
+	JWTSecret     string
+	// JWTAlgorithm signs session tokens with JWTSecret (HS256) or with the key
+	// in JWTPrivateKeyFile (RS256, ES256)
+	JWTAlgorithm      string
+	JWTPrivateKeyFile string
+	// JWTPreviousSecrets still verify HS256 tokens during a secret rotation
+	JWTPreviousSecrets []string
+	// ClockSkewLeeway tolerates clock drift when checking session and token expiry
+	ClockSkewLeeway time.Duration
+	// SessionTokenTTL is each session token's lifetime, capped at the session's; tunnels
+	// renew tokens every SessionTokenRenewalInterval, and renewed tokens remain
+	// valid for SessionTokenRenewalGrace
+	SessionTokenTTL             time.Duration
+	SessionTokenRenewalInterval time.Duration
+	SessionTokenRenewalGrace    time.Duration
+	// SessionCleanupInterval is how often expired sessions are removed
+	SessionCleanupInterval time.Duration
+	// SessionHandoffTTL is how long a code for continuing a session on
+	// another device can be claimed
+	SessionHandoffTTL time.Duration
+	// SessionIdempotencyTTL is how long Idempotency-Key headers on session
+	// creation are remembered
+	SessionIdempotencyTTL time.Duration
+	// SessionMaxCount caps the sessions stored at once, 0 for no limit, and
+	// SessionEvictionPolicy says what happens to a new session beyond it
+	SessionMaxCount       int
+	SessionEvictionPolicy string
+	// MaxBodyBytes bounds JSON request bodies
+	MaxBodyBytes int
+	// PublicURL is the broker's external address, used for the tunnel URLs
+	// it advertises
+	PublicURL string
+	// InstanceURL is this replica's address for the others, and InternalToken
+	// authenticates requests between replicas
+	InstanceURL   string
+	InternalToken string
+	// TrustedProxies are the IPs and CIDRs of reverse proxies whose
+	// forwarding headers name the client
+	TrustedProxies []string
+	// ReadinessPolicy is the capability /ready requires: tunnels or sessions
+	ReadinessPolicy string
+	// RequestTimeout bounds HTTP requests, and SessionCreateTimeout session
+	// creation, which may wait for a server to start
+	RequestTimeout       time.Duration
+	SessionCreateTimeout time.Duration
+	// HTTP configures outbound calls to the OIDC issuer and JupyterHub
+	HTTP httpclient.Config
+	// Tracing exports OpenTelemetry spans over OTLP when enabled
+	Tracing    tracing.Config
+	OIDC       OIDCConfig
+	JupyterHub JupyterHubConfig
+	Tunnel     TunnelConfig
+	K8s        K8sConfig
 }
 
 type OIDCConfig struct {
@@ -135,9 +695,186 @@ type OIDCConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+	// TokenCacheTTL reuses successful token validations for this long, 0 disables caching
+	TokenCacheTTL time.Duration
+	// RevokedTokenTTL is how long logged-out tokens are denied when caching is enabled
+	RevokedTokenTTL time.Duration
+	// LatencyBuckets overrides the auth latency histogram buckets, in seconds
+	LatencyBuckets []float64
+	// ExtraAuthParams is a URL query string added to the authorization URL
+	ExtraAuthParams string
+	// AuthFlowTimeout is the longest a login may take from start to callback
+	AuthFlowTimeout time.Duration
+	// MaxPendingFlows bounds the logins waiting for their callback
+	MaxPendingFlows int
 }
 
 type JupyterHubConfig struct {
 	APIURL   string
 	APIToken string
+	// NamespaceStrategy selects how usernames map to namespaces: template, single or label
+	NamespaceStrategy string
+	NamespaceTemplate string
+	NamespaceName     string
+	NamespaceLabelKey string
+	// PodDiscovery selects how the user's pod is found: name, label, matching
+	// pods against the PodSelector template, or server, rendering
+	// PodNameTemplate from the user's server URL and name
+	PodDiscovery    string
+	PodSelector     string
+	PodNameTemplate string
+	// Username* mirror the JupyterHub authenticator's email to username mapping
+	UsernamePattern     string
+	UsernameStripDomain bool
+	UsernameLowercase   bool
+	UsernameTemplate    string
+	// SpawnConcurrency is how a request to start a server already being
+	// started for the same user is handled: wait or reject
+	SpawnConcurrency string
+	// SpawnTimeout bounds waiting for a started server to become ready
+	SpawnTimeout time.Duration
+}
+
+// namespaceStrategyValue returns the setting used by the configured namespace strategy
+func (c JupyterHubConfig) namespaceStrategyValue() string {
+	switch c.NamespaceStrategy {
+	case jupyterhub.NamespaceStrategySingle:
+		return c.NamespaceName
+	case jupyterhub.NamespaceStrategyLabel:
+		return c.NamespaceLabelKey
+	default:
+		return c.NamespaceTemplate
+	}
+}
+
+type TunnelConfig struct {
+	// MaxTotalTunnels caps concurrent tunnels across all users, 0 for no limit
+	MaxTotalTunnels int
+	// WriteTimeout bounds each tunnel write before the connection is considered dead
+	WriteTimeout time.Duration
+	// ReconnectBackoff is suggested to clients after transient closes
+	ReconnectBackoff time.Duration
+	// SetupTimeout bounds issuing k8s credentials for a new tunnel
+	SetupTimeout time.Duration
+	// HandshakeTimeout bounds completing the WebSocket upgrade
+	HandshakeTimeout time.Duration
+	// DrainLeadTime is how long tunnels are warned before shutdown
+	DrainLeadTime time.Duration
+	// Compression negotiates permessage-deflate with clients that offer it
+	Compression bool
+	// CompressionLevel is the flate level of compressed messages, 1 to 9
+	CompressionLevel int
+	// CompressionThreshold is the smallest message compressed, in bytes
+	CompressionThreshold int
+	// CredentialReleaseGrace keeps a closed tunnel's credentials for a reconnect
+	CredentialReleaseGrace time.Duration
+	// ResumeTokens requires a reconnect to present the closed tunnel's resume
+	// token to reuse its credentials
+	ResumeTokens bool
+	// MaxLifetime bounds how long one set of credentials serves tunnels
+	MaxLifetime time.Duration
+	// LogMessageTypes logs the type of each tunnel message, never its payload
+	LogMessageTypes bool
+	// MaxNamespaceRelays caps reverse port forwards open in one namespace, 0 for no limit
+	MaxNamespaceRelays int
+	// DuplicateTunnels is replace or reject, for a second tunnel to one session
+	DuplicateTunnels string
+	// ExecPrelude runs before non-TTY exec commands, e.g. to activate an environment
+	ExecPrelude string
+	// ExecShell runs the prelude and TTY shells
+	ExecShell string
+	// ExecLoginShell runs TTY shells as login shells
+	ExecLoginShell bool
+	// ExecRunAsMechanism switches users for exec requests with run_as_user,
+	// which are refused if empty
+	ExecRunAsMechanism string
+	// ExecAllowedCommands, if set, are the only commands exec and
+	// process_kill requests may run
+	ExecAllowedCommands []string
+	// SanitizeExecOutput makes non-TTY exec output valid UTF-8
+	SanitizeExecOutput bool
+	// ExecResourceWrappers run exec commands with lower priority or limits
+	ExecResourceWrappers []string
+	// FileCompressionThreshold is the smallest file read gzipped on request, in bytes
+	FileCompressionThreshold int
+	// MaxBatchOperations bounds the file operations in one batch message
+	MaxBatchOperations int
+	// MaxFileSize bounds the files read or written in one operation, in bytes
+	MaxFileSize int
+	// MaxConcurrentFileOps bounds the file and batch messages running at once
+	// per tunnel, and FileOperationTimeout each of their operations
+	MaxConcurrentFileOps int
+	FileOperationTimeout time.Duration
+	// MaxListEntries bounds the entries in one page of a directory listing
+	MaxListEntries int
+	// WorkspaceRoot is the project directory reported to clients
+	WorkspaceRoot string
+	// PermissionsRoot confines chmod and chown, WorkspaceRoot if empty
+	PermissionsRoot string
+	// AllowChown enables the chown file operation
+	AllowChown bool
+	// ExecFlushInterval is how long streamed exec output may wait before being sent
+	ExecFlushInterval time.Duration
+	// ExecTTYFlushInterval is the flush interval for interactive (TTY) execs
+	ExecTTYFlushInterval time.Duration
+	// ExecFlushBufferSize is the most streamed output buffered before a flush
+	ExecFlushBufferSize int
+	// ExecMaxOutputBytes bounds each output stream of a non-streamed exec
+	ExecMaxOutputBytes int
+	// ExecTTYWeight is how many output messages TTY streams send per bulk stream message
+	ExecTTYWeight int
+	// ExecOutputQueueLength is how many output messages a stream may have
+	// waiting, and ExecSlowReaderTimeout how long its queue may stay full
+	// before the stream is cancelled
+	ExecOutputQueueLength int
+	ExecSlowReaderTimeout time.Duration
+	// MaxExecStreams caps exec streams running at once on a tunnel, 0 for no limit
+	MaxExecStreams int
+	// ProcessColumns are the extra ps columns reported by processlist
+	ProcessColumns []string
+}
+
+type K8sConfig struct {
+	KubeconfigPath      string
+	RoleMode            string
+	AccessMode          string
+	ImpersonationGroups []string
+	// ServiceAccountMode is per-session or per-user (one reference-counted
+	// ServiceAccount shared by a user's sessions)
+	ServiceAccountMode string
+	// DebugContainers lets sessions attach ephemeral containers running DebugImage
+	DebugContainers bool
+	DebugImage      string
+	// ExecEnvSources lists the secrets and config maps exec requests may read
+	ExecEnvSources []string
+	// DeniedNamespaces replaces k8s.DefaultDeniedNamespaces when set
+	DeniedNamespaces []string
+	// ExecProtocol selects WebSocket or SPDY exec streams, or auto
+	ExecProtocol string
+	// CreateNamespaces creates missing user namespaces with NamespaceLabels
+	// and a ResourceQuota of NamespaceQuota
+	CreateNamespaces bool
+	NamespaceLabels  []string
+	NamespaceQuota   []string
+	// CleanupOnStartup deletes session resources older than SessionMaxTTL at
+	// startup
+	CleanupOnStartup bool
+	// RequiredPodLabels and RequiredPodAnnotations restrict sessions to pods
+	// carrying the listed "key" or "key=value" entries
+	RequiredPodLabels      []string
+	RequiredPodAnnotations []string
+	// PodOwnerAnnotation names the JupyterHub user a pod was started for,
+	// checked on every tunnel setup; empty disables the check
+	PodOwnerAnnotation string
+	// QPS and Burst are the client-side API rate limits
+	QPS   float32
+	Burst int
+	// Retry bounds retries of session setup calls on transient API errors
+	Retry k8s.RetryConfig
+
+	// Timeouts bound individual API operations
+	Timeouts k8s.TimeoutConfig
+
+	// TokenTTL is the lifetime of minted tokens and the cluster's bounds on it
+	TokenTTL k8s.TokenTTLConfig
 }
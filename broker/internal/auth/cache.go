@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// ErrTokenRevoked is returned when validating a token that was logged out
+var ErrTokenRevoked = errors.New("token has been revoked")
+
+// CacheConfig represents token validation cache configuration
+type CacheConfig struct {
+	// TTL is how long a successful validation is reused
+	TTL time.Duration
+
+	// RevokedTTL is how long a logged-out token is denied locally, TTL if zero.
+	// It should cover the token's remaining lifetime at the issuer.
+	RevokedTTL time.Duration
+}
+
+// CachingProvider wraps a Provider, caching successful token validations and
+// rejecting tokens revoked through it without consulting the cache
+type CachingProvider struct {
+	Provider
+
+	ttl        time.Duration
+	revokedTTL time.Duration
+
+	mutex     sync.Mutex
+	validated map[string]cachedValidation
+	revoked   map[string]time.Time
+	lastPrune time.Time
+}
+
+type cachedValidation struct {
+	userInfo  *types.UserInfo
+	expiresAt time.Time
+}
+
+// NewCachingProvider wraps provider with a validation cache
+func NewCachingProvider(provider Provider, config CacheConfig) *CachingProvider {
+	revokedTTL := config.RevokedTTL
+	if revokedTTL <= 0 {
+		revokedTTL = config.TTL
+	}
+
+	return &CachingProvider{
+		Provider:   provider,
+		ttl:        config.TTL,
+		revokedTTL: revokedTTL,
+		validated:  make(map[string]cachedValidation),
+		revoked:    make(map[string]time.Time),
+	}
+}
+
+// ValidateToken returns the cached validation for the token, validating it with
+// the wrapped provider on a miss. Revoked tokens are always rejected.
+func (p *CachingProvider) ValidateToken(ctx context.Context, accessToken string) (*types.UserInfo, error) {
+	key := tokenKey(accessToken)
+	now := time.Now()
+
+	p.mutex.Lock()
+	if until, revoked := p.revoked[key]; revoked && now.Before(until) {
+		p.mutex.Unlock()
+		return nil, ErrTokenRevoked
+	}
+	if cached, exists := p.validated[key]; exists && now.Before(cached.expiresAt) {
+		p.mutex.Unlock()
+		return cached.userInfo, nil
+	}
+	p.mutex.Unlock()
+
+	userInfo, err := p.Provider.ValidateToken(ctx, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// The token may have been revoked while it was being validated
+	if until, revoked := p.revoked[key]; revoked && now.Before(until) {
+		return nil, ErrTokenRevoked
+	}
+	p.validated[key] = cachedValidation{userInfo: userInfo, expiresAt: now.Add(p.ttl)}
+	p.prune(now)
+
+	return userInfo, nil
+}
+
+// RevokeToken evicts the token from the cache and denies it locally before
+// revoking it with the wrapped provider, so it is rejected even if the issuer
+// cannot be reached
+func (p *CachingProvider) RevokeToken(ctx context.Context, accessToken string) error {
+	key := tokenKey(accessToken)
+	now := time.Now()
+
+	p.mutex.Lock()
+	delete(p.validated, key)
+	p.revoked[key] = now.Add(p.revokedTTL)
+	p.prune(now)
+	p.mutex.Unlock()
+
+	return p.Provider.RevokeToken(ctx, accessToken)
+}
+
+// prune drops expired entries at most once per TTL; callers must hold the mutex
+func (p *CachingProvider) prune(now time.Time) {
+	if now.Sub(p.lastPrune) < p.ttl {
+		return
+	}
+	p.lastPrune = now
+
+	for key, cached := range p.validated {
+		if !now.Before(cached.expiresAt) {
+			delete(p.validated, key)
+		}
+	}
+	for key, until := range p.revoked {
+		if !now.Before(until) {
+			delete(p.revoked, key)
+		}
+	}
+}
+
+// tokenKey hashes tokens so they are not held in memory in the clear
+func tokenKey(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// countingProvider accepts every token and counts validations
+type countingProvider struct {
+	mutex       sync.Mutex
+	validations int
+	revoked     []string
+	revokeErr   error
+}
+
+func (p *countingProvider) StartFlow(ctx context.Context, silent bool) (string, string, error) {
+	return "", "", nil
+}
+
+func (p *countingProvider) HandleCallback(ctx context.Context, code, state string) (*types.TokenSet, error) {
+	return nil, nil
+}
+
+func (p *countingProvider) ValidateToken(ctx context.Context, accessToken string) (*types.UserInfo, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.validations++
+	return &types.UserInfo{Email: "alice@purdue.edu"}, nil
+}
+
+func (p *countingProvider) RefreshToken(ctx context.Context, refreshToken string) (*types.TokenSet, error) {
+	return nil, nil
+}
+
+func (p *countingProvider) RevokeToken(ctx context.Context, accessToken string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.revoked = append(p.revoked, accessToken)
+	return p.revokeErr
+}
+
+func TestCachingProvider_CachesValidations(t *testing.T) {
+	inner := &countingProvider{}
+	provider := NewCachingProvider(inner, CacheConfig{TTL: time.Minute})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		userInfo, err := provider.ValidateToken(ctx, "token")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if userInfo.Email != "alice@purdue.edu" {
+			t.Errorf("Expected alice@purdue.edu, got %s", userInfo.Email)
+		}
+	}
+
+	if inner.validations != 1 {
+		t.Errorf("Expected 1 upstream validation, got %d", inner.validations)
+	}
+}
+
+func TestCachingProvider_ExpiredEntryRevalidates(t *testing.T) {
+	inner := &countingProvider{}
+	provider := NewCachingProvider(inner, CacheConfig{TTL: time.Millisecond})
+	ctx := context.Background()
+
+	provider.ValidateToken(ctx, "token")
+	time.Sleep(5 * time.Millisecond)
+	provider.ValidateToken(ctx, "token")
+
+	if inner.validations != 2 {
+		t.Errorf("Expected 2 upstream validations, got %d", inner.validations)
+	}
+}
+
+func TestCachingProvider_LogoutRejectsCachedToken(t *testing.T) {
+	inner := &countingProvider{}
+	provider := NewCachingProvider(inner, CacheConfig{TTL: time.Hour})
+	ctx := context.Background()
+
+	if _, err := provider.ValidateToken(ctx, "token"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if err := provider.RevokeToken(ctx, "token"); err != nil {
+		t.Fatalf("Expected no error revoking token, got %v", err)
+	}
+	if len(inner.revoked) != 1 {
+		t.Errorf("Expected token revoked upstream, got %v", inner.revoked)
+	}
+
+	// The issuer still accepts the token, but the cached entry must not be used
+	if _, err := provider.ValidateToken(ctx, "token"); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Expected ErrTokenRevoked after logout, got %v", err)
+	}
+	if inner.validations != 1 {
+		t.Errorf("Expected no upstream validation after logout, got %d", inner.validations)
+	}
+
+	// Other tokens are unaffected
+	if _, err := provider.ValidateToken(ctx, "other-token"); err != nil {
+		t.Errorf("Expected other token to validate, got %v", err)
+	}
+}
+
+func TestCachingProvider_LogoutDeniesLocallyWhenIssuerFails(t *testing.T) {
+	inner := &countingProvider{revokeErr: errors.New("issuer unavailable")}
+	provider := NewCachingProvider(inner, CacheConfig{TTL: time.Hour})
+	ctx := context.Background()
+
+	provider.ValidateToken(ctx, "token")
+	if err := provider.RevokeToken(ctx, "token"); err == nil {
+		t.Error("Expected issuer error to be returned")
+	}
+
+	if _, err := provider.ValidateToken(ctx, "token"); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected ErrTokenRevoked after failed upstream revoke, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -22,8 +23,88 @@ const (
 	codeVerifierLength  = 128
 )
 
-// StartFlow initiates the OIDC authorization flow with PKCE
-func (p *CILogonProvider) StartFlow(ctx context.Context) (string, string, error) {
+// authScope is the space-separated list of scopes requested at login
+const authScope = "openid email org.cilogon.userinfo profile"
+
+// DefaultAuthFlowTimeout is how long a login may take from StartFlow to
+// HandleCallback unless configured
+const DefaultAuthFlowTimeout = 10 * time.Minute
+
+// ErrAuthFlowExpired means the callback arrived after the auth flow timeout,
+// e.g. because the user left the login page open; the flow must be restarted
+var ErrAuthFlowExpired = errors.New("login took too long, please retry")
+
+// ErrInteractionRequired means a silent login could not complete without the
+// user, e.g. because their session with the identity provider has ended; the
+// client should fall back to an interactive login
+var ErrInteractionRequired = errors.New("the identity provider requires an interactive login")
+
+// interactionErrors are the authorization errors with which a prompt=none
+// request reports that the user has to interact with the identity provider
+// (OpenID Connect Core 3.1.2.6)
+var interactionErrors = map[string]bool{
+	"login_required":             true,
+	"interaction_required":       true,
+	"consent_required":           true,
+	"account_selection_required": true,
+}
+
+// managedAuthParams are the authorization request parameters set by the flow,
+// which extra parameters may not replace
+var managedAuthParams = map[string]bool{
+	"response_type":         true,
+	"client_id":             true,
+	"redirect_uri":          true,
+	"scope":                 true,
+	"state":                 true,
+	"nonce":                 true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+}
+
+// ValidateAuthParams checks that extra authorization parameters do not
+// collide with the ones managed by the flow
+func ValidateAuthParams(params map[string]string) error {
+	for key := range params {
+		if key == "" {
+			return fmt.Errorf("empty authorization parameter name")
+		}
+		if managedAuthParams[key] {
+			return fmt.Errorf("authorization parameter %q is managed by the broker and cannot be overridden", key)
+		}
+	}
+	return nil
+}
+
+// ParseAuthParams parses extra authorization parameters from a URL query
+// string such as "prompt=login&selected_idp=https://idp.example.org" and
+// validates them
+func ParseAuthParams(raw string) (map[string]string, error) {
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid authorization parameters: %w", err)
+	}
+
+	params := make(map[string]string, len(values))
+	for key, value := range values {
+		if len(value) > 1 {
+			return nil, fmt.Errorf("authorization parameter %q is set more than once", key)
+		}
+		params[key] = value[0]
+	}
+	if err := ValidateAuthParams(params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// StartFlow initiates the OIDC authorization flow with PKCE. The PKCE
+// verifier stays on the broker, keyed by the returned state, until the
+// callback; StartFlow fails with ErrTooManyPendingFlows while the store of
+// pending logins is full. A silent flow asks the issuer not to show any page
+// with prompt=none, succeeding only while the user's session with the
+// identity provider is still valid.
+func (p *CILogonProvider) StartFlow(ctx context.Context, silent bool) (string, string, error) {
 	// Generate PKCE code verifier and challenge
 	codeVerifier, err := generateCodeVerifier()
 	if err != nil {
@@ -34,50 +115,50 @@ func (p *CILogonProvider) StartFlow(ctx context.Context) (string, string, error)
 	state := generateState()
 
 	// Build authorization URL
-	authURL, err := p.buildAuthURL(codeChallenge, state)
+	authURL, err := p.buildAuthURL(codeChallenge, state, silent)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to build auth URL: %w", err)
 	}
 
-	// Store PKCE parameters for later use (in production, use secure storage)
-	// For now, we'll include them in the state parameter
-	stateData := map[string]string{
-		"state":         state,
-		"code_verifier": codeVerifier,
+	if err := p.flows.add(state, pendingFlow{codeVerifier: codeVerifier, issuedAt: time.Now()}); err != nil {
+		return "", "", err
 	}
-	stateJSON, _ := json.Marshal(stateData)
-	encodedState := base64.URLEncoding.EncodeToString(stateJSON)
-
-	return authURL, encodedState, nil
+	return authURL, state, nil
 }
 
-// HandleCallback processes the OIDC callback and exchanges code for tokens
-func (p *CILogonProvider) HandleCallback(ctx context.Context, code, encodedState string) (*types.TokenSet, error) {
-	// Decode state to get PKCE parameters
-	stateData := make(map[string]string)
-	stateJSON, err := base64.URLEncoding.DecodeString(encodedState)
-	if err != nil {
-		return nil, fmt.Errorf("invalid state parameter: %w", err)
+// CallbackError converts an error the issuer returned to the redirect URL
+// instead of a code. The errors a silent flow ends with when the user has to
+// log in interactively wrap ErrInteractionRequired.
+func CallbackError(code, description string) error {
+	message := code
+	if description != "" {
+		message += ": " + description
 	}
-
-	if err := json.Unmarshal(stateJSON, &stateData); err != nil {
-		return nil, fmt.Errorf("invalid state format: %w", err)
+	if interactionErrors[code] {
+		return fmt.Errorf("%w (%s)", ErrInteractionRequired, message)
 	}
+	return fmt.Errorf("login failed: %s", message)
+}
 
-	codeVerifier := stateData["code_verifier"]
-	if codeVerifier == "" {
-		return nil, fmt.Errorf("missing code verifier in state")
+// HandleCallback processes the OIDC callback and exchanges code for tokens
+func (p *CILogonProvider) HandleCallback(ctx context.Context, code, state string) (*types.TokenSet, error) {
+	flow, exists := p.flows.take(state)
+	if !exists {
+		return nil, ErrUnknownFlow
+	}
+	if time.Since(flow.issuedAt) > p.authFlowTimeout {
+		return nil, ErrAuthFlowExpired
 	}
 
 	// Exchange code for tokens
-	tokenURL := p.issuer + "/oauth2/token"
+	tokenURL := p.endpoints.Load().Token
 	data := url.Values{
 		"grant_type":    {"authorization_code"},
 		"code":          {code},
 		"redirect_uri":  {p.redirectURL},
 		"client_id":     {p.clientID},
 		"client_secret": {p.clientSecret},
-		"code_verifier": {codeVerifier},
+		"code_verifier": {flow.codeVerifier},
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
@@ -88,41 +169,37 @@ func (p *CILogonProvider) HandleCallback(ctx context.Context, code, encodedState
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("token request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token exchange failed: %s", string(body))
+		return nil, fmt.Errorf("token exchange failed: %w", statusError(resp))
 	}
 
-	var tokenResponse struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-		ExpiresIn    int    `json:"expires_in"`
-		TokenType    string `json:"token_type"`
+	body, err := readJSONBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
+	}
+	tokens, err := decodeTokenResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange failed: %w", err)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	// An omitted scope means the requested scopes were granted (RFC 6749 5.1)
+	if tokens.Scopes == nil {
+		tokens.Scopes = strings.Fields(authScope)
 	}
 
-	return &types.TokenSet{
-		AccessToken:  tokenResponse.AccessToken,
-		RefreshToken: tokenResponse.RefreshToken,
-		ExpiresIn:    tokenResponse.ExpiresIn,
-		TokenType:    tokenResponse.TokenType,
-	}, nil
+	return tokens, nil
 }
 
 // ValidateToken validates an access token and returns user information
 func (p *CILogonProvider) ValidateToken(ctx context.Context, accessToken string) (*types.UserInfo, error) {
 	// Get user info from CILogon
-	userInfoURL := p.issuer + "/oauth2/userinfo"
+	userInfoURL := p.endpoints.Load().UserInfo
 	req, err := http.NewRequestWithContext(ctx, "GET", userInfoURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create userinfo request: %w", err)
@@ -131,16 +208,19 @@ func (p *CILogonProvider) ValidateToken(ctx context.Context, accessToken string)
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("userinfo request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("userinfo request failed: %s", string(body))
+		return nil, fmt.Errorf("userinfo request failed: %w", statusError(resp))
+	}
+
+	body, err := readJSONBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
 	}
 
 	var userInfo struct {
@@ -148,7 +228,7 @@ func (p *CILogonProvider) ValidateToken(ctx context.Context, accessToken string)
 		Name  string `json:"name"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&userInfo); err != nil {
+	if err := json.Unmarshal(body, &userInfo); err != nil {
 		return nil, fmt.Errorf("failed to decode userinfo response: %w", err)
 	}
 
@@ -160,7 +240,7 @@ func (p *CILogonProvider) ValidateToken(ctx context.Context, accessToken string)
 
 // RefreshToken exchanges a refresh token for new access token
 func (p *CILogonProvider) RefreshToken(ctx context.Context, refreshToken string) (*types.TokenSet, error) {
-	tokenURL := p.issuer + "/oauth2/token"
+	tokenURL := p.endpoints.Load().Token
 	data := url.Values{
 		"grant_type":    {"refresh_token"},
 		"refresh_token": {refreshToken},
@@ -176,39 +256,107 @@ func (p *CILogonProvider) RefreshToken(ctx context.Context, refreshToken string)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("refresh request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token refresh failed: %w", statusError(resp))
+	}
+
+	body, err := readJSONBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+	tokens, err := decodeTokenResponse(body)
+	if err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+
+	// The issuer may keep the refresh token rather than rotate it
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = refreshToken
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken revokes an access token at the issuer's revocation endpoint (RFC 7009)
+func (p *CILogonProvider) RevokeToken(ctx context.Context, accessToken string) error {
+	revokeURL := p.endpoints.Load().Revocation
+	data := url.Values{
+		"token":           {accessToken},
+		"token_type_hint": {"access_token"},
+		"client_id":       {p.clientID},
+		"client_secret":   {p.clientSecret},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", revokeURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create revoke request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("revoke request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("token refresh failed: %s", string(body))
+		return fmt.Errorf("token revocation failed: %s", string(body))
 	}
 
+	return nil
+}
+
+// Helper functions
+
+// decodeTokenResponse parses a token endpoint response. Some issuers answer
+// errors with 200, so an error field or a missing access token is an error.
+func decodeTokenResponse(body []byte) (*types.TokenSet, error) {
 	var tokenResponse struct {
-		AccessToken  string `json:"access_token"`
-		RefreshToken string `json:"refresh_token"`
-		ExpiresIn    int    `json:"expires_in"`
-		TokenType    string `json:"token_type"`
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		IDToken          string `json:"id_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		TokenType        string `json:"token_type"`
+		Scope            string `json:"scope"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode refresh response: %w", err)
+	if err := json.Unmarshal(body, &tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
 	}
 
-	return &types.TokenSet{
+	if tokenResponse.Error != "" {
+		if tokenResponse.ErrorDescription != "" {
+			return nil, fmt.Errorf("%s: %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+		}
+		return nil, fmt.Errorf("%s", tokenResponse.Error)
+	}
+	if tokenResponse.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+
+	tokens := &types.TokenSet{
 		AccessToken:  tokenResponse.AccessToken,
 		RefreshToken: tokenResponse.RefreshToken,
+		IDToken:      tokenResponse.IDToken,
 		ExpiresIn:    tokenResponse.ExpiresIn,
 		TokenType:    tokenResponse.TokenType,
-	}, nil
+	}
+	if tokenResponse.Scope != "" {
+		tokens.Scopes = strings.Fields(tokenResponse.Scope)
+	}
+	return tokens, nil
 }
 
-// Helper functions
-
 func generateCodeVerifier() (string, error) {
 	bytes := make([]byte, codeVerifierLength)
 	if _, err := rand.Read(bytes); err != nil {
@@ -228,9 +376,8 @@ func generateState() string {
 	return base64.URLEncoding.WithPadding(base64.NoPadding).EncodeToString(bytes)
 }
 
-func (p *CILogonProvider) buildAuthURL(codeChallenge, state string) (string, error) {
-	// CILogon uses /authorize instead of /oauth2/authorize
-	u, err := url.Parse(p.issuer + "/authorize")
+func (p *CILogonProvider) buildAuthURL(codeChallenge, state string, silent bool) (string, error) {
+	u, err := url.Parse(p.endpoints.Load().Authorization)
 	if err != nil {
 		return "", err
 	}
@@ -239,7 +386,7 @@ func (p *CILogonProvider) buildAuthURL(codeChallenge, state string) (string, err
 	q.Set("response_type", "code")
 	q.Set("client_id", p.clientID)
 	q.Set("redirect_uri", p.redirectURL)
-	q.Set("scope", "openid email org.cilogon.userinfo profile")
+	q.Set("scope", authScope)
 	q.Set("state", state)
 	q.Set("code_challenge", codeChallenge)
 	q.Set("code_challenge_method", codeChallengeMethod)
@@ -247,6 +394,17 @@ func (p *CILogonProvider) buildAuthURL(codeChallenge, state string) (string, err
 	// Add CILogon-specific selected_idp parameter
 	q.Set("selected_idp", "https://cern.ch/login,https://idp.fnal.gov/idp/shibboleth,https://idp.purdue.edu/idp/shibboleth")
 
+	// Extra parameters may replace selected_idp, but never the managed ones
+	for key, value := range p.extraAuthParams {
+		if !managedAuthParams[key] {
+			q.Set(key, value)
+		}
+	}
+	// A configured prompt such as login would defeat a silent flow
+	if silent {
+		q.Set("prompt", "none")
+	}
+
 	u.RawQuery = q.Encode()
 	return u.String(), nil
 }
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// discoveryPath is where an issuer publishes its OIDC discovery document
+const discoveryPath = "/.well-known/openid-configuration"
+
+// endpoints are the issuer URLs the provider calls
+type endpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+	Revocation    string `json:"revocation_endpoint"`
+}
+
+// defaultEndpoints are CILogon's endpoint paths, used until discovery
+// succeeds and for any endpoint the discovery document omits
+func defaultEndpoints(issuer string) *endpoints {
+	return &endpoints{
+		Authorization: issuer + "/authorize",
+		Token:         issuer + "/oauth2/token",
+		UserInfo:      issuer + "/oauth2/userinfo",
+		Revocation:    issuer + "/oauth2/revoke",
+	}
+}
+
+// Ping checks that the issuer is reachable by fetching its discovery
+// document, without changing the endpoints in use
+func (p *CILogonProvider) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(p.issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("discovery request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("discovery request failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Discover fetches the issuer's OIDC discovery document and uses the
+// endpoints it lists. On error the provider keeps the default CILogon paths,
+// so a failed discovery at startup is not fatal. Call it before serving.
+func (p *CILogonProvider) Discover(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(p.issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create discovery request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("discovery request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discovery request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var document struct {
+		Issuer string `json:"issuer"`
+		endpoints
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&document); err != nil {
+		return fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+
+	// The document must be for the configured issuer (OIDC Discovery 4.3)
+	if strings.TrimSuffix(document.Issuer, "/") != strings.TrimSuffix(p.issuer, "/") {
+		return fmt.Errorf("discovery document is for issuer %q, expected %q", document.Issuer, p.issuer)
+	}
+
+	discovered := defaultEndpoints(p.issuer)
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&discovered.Authorization, document.Authorization},
+		{&discovered.Token, document.Token},
+		{&discovered.UserInfo, document.UserInfo},
+		{&discovered.Revocation, document.Revocation},
+	} {
+		if field.src != "" {
+			*field.dst = field.src
+		}
+	}
+
+	p.endpoints.Store(discovered)
+	return nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+)
+
+// DefaultMaxPendingFlows bounds the logins waiting for their callback unless
+// configured
+const DefaultMaxPendingFlows = 10000
+
+// flowReapInterval is how often logins past the auth flow timeout are removed
+const flowReapInterval = time.Minute
+
+// ErrTooManyPendingFlows means the pending login store is full, e.g. because
+// of many abandoned logins; the login can be retried once older ones expire
+var ErrTooManyPendingFlows = errors.New("too many logins in progress, please retry later")
+
+// ErrUnknownFlow means the callback's state matches no pending login: it was
+// never started here, already completed, or expired and was removed
+var ErrUnknownFlow = errors.New("unknown or already completed login, please retry")
+
+// pendingFlow is a login waiting for its callback
+type pendingFlow struct {
+	codeVerifier string
+	issuedAt     time.Time
+}
+
+// flowStore keeps each login's PKCE verifier on the broker, keyed by the
+// state sent to the issuer, until its callback. It holds at most limit logins
+// and removes those older than ttl every flowReapInterval.
+type flowStore struct {
+	mutex sync.Mutex
+	flows map[string]pendingFlow
+	limit int
+	ttl   time.Duration
+}
+
+func newFlowStore(limit int, ttl time.Duration) *flowStore {
+	if limit <= 0 {
+		limit = DefaultMaxPendingFlows
+	}
+	return &flowStore{flows: make(map[string]pendingFlow), limit: limit, ttl: ttl}
+}
+
+// add stores a new login, removing expired ones first if the store is full
+func (s *flowStore) add(state string, flow pendingFlow) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.flows) >= s.limit {
+		s.reapLocked(time.Now())
+	}
+	if len(s.flows) >= s.limit {
+		return ErrTooManyPendingFlows
+	}
+	s.flows[state] = flow
+	metrics.AuthPendingFlows.Set(float64(len(s.flows)))
+	return nil
+}
+
+// take removes and returns the login for state, so each completes only once
+func (s *flowStore) take(state string) (pendingFlow, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	flow, exists := s.flows[state]
+	if exists {
+		delete(s.flows, state)
+		metrics.AuthPendingFlows.Set(float64(len(s.flows)))
+	}
+	return flow, exists
+}
+
+// reap removes the logins older than the store's ttl, returning how many
+func (s *flowStore) reap(now time.Time) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.reapLocked(now)
+}
+
+func (s *flowStore) reapLocked(now time.Time) int {
+	reaped := 0
+	for state, flow := range s.flows {
+		if now.Sub(flow.issuedAt) > s.ttl {
+			delete(s.flows, state)
+			reaped++
+		}
+	}
+	metrics.AuthPendingFlows.Set(float64(len(s.flows)))
+	return reaped
+}
+
+func (s *flowStore) reapLoop() {
+	ticker := time.NewTicker(flowReapInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.reap(now)
+	}
+}
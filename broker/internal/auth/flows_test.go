@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFlowStore_Limit(t *testing.T) {
+	store := newFlowStore(2, time.Minute)
+	now := time.Now()
+
+	for _, state := range []string{"first", "second"} {
+		if err := store.add(state, pendingFlow{issuedAt: now}); err != nil {
+			t.Fatalf("Expected %s flow to be stored, got %v", state, err)
+		}
+	}
+	if err := store.add("third", pendingFlow{issuedAt: now}); !errors.Is(err, ErrTooManyPendingFlows) {
+		t.Fatalf("Expected ErrTooManyPendingFlows, got %v", err)
+	}
+
+	// Completing a login frees its slot
+	if _, exists := store.take("first"); !exists {
+		t.Fatal("Expected the first flow to be pending")
+	}
+	if _, exists := store.take("first"); exists {
+		t.Error("Expected a flow to complete only once")
+	}
+	if err := store.add("third", pendingFlow{issuedAt: now}); err != nil {
+		t.Errorf("Expected a freed slot to be reused, got %v", err)
+	}
+}
+
+func TestFlowStore_LimitReapsExpired(t *testing.T) {
+	store := newFlowStore(1, time.Minute)
+	store.add("abandoned", pendingFlow{issuedAt: time.Now().Add(-2 * time.Minute)})
+
+	if err := store.add("fresh", pendingFlow{issuedAt: time.Now()}); err != nil {
+		t.Fatalf("Expected an expired flow to make room, got %v", err)
+	}
+	if _, exists := store.take("abandoned"); exists {
+		t.Error("Expected the expired flow to be removed")
+	}
+}
+
+func TestFlowStore_Reap(t *testing.T) {
+	store := newFlowStore(0, time.Minute)
+	now := time.Now()
+	store.add("expired", pendingFlow{issuedAt: now.Add(-2 * time.Minute)})
+	store.add("pending", pendingFlow{issuedAt: now.Add(-30 * time.Second)})
+
+	if reaped := store.reap(now); reaped != 1 {
+		t.Errorf("Expected 1 flow reaped, got %d", reaped)
+	}
+	if _, exists := store.take("expired"); exists {
+		t.Error("Expected the expired flow to be reaped")
+	}
+	if _, exists := store.take("pending"); !exists {
+		t.Error("Expected the pending flow to be kept")
+	}
+}
+
+func TestCILogonProvider_MaxPendingFlows(t *testing.T) {
+	provider := NewCILogonProvider(CILogonConfig{
+		Issuer:          "https://cilogon.org",
+		ClientID:        "test-client",
+		RedirectURL:     "http://localhost:8080/auth/callback",
+		MaxPendingFlows: 1,
+	})
+
+	_, state, err := provider.StartFlow(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, _, err := provider.StartFlow(context.Background(), false); !errors.Is(err, ErrTooManyPendingFlows) {
+		t.Fatalf("Expected ErrTooManyPendingFlows, got %v", err)
+	}
+	if flow, exists := provider.flows.take(state); !exists || flow.codeVerifier == "" {
+		t.Errorf("Expected the returned state to key the stored verifier, got %+v", flow)
+	}
+}
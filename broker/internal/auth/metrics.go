@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// InstrumentedProvider wraps a Provider, recording the duration and outcome
+// of each call in metrics.AuthDuration
+type InstrumentedProvider struct {
+	provider Provider
+}
+
+// NewInstrumentedProvider wraps provider with latency metrics. Wrap it before
+// adding a cache so that cache hits do not mask issuer latency.
+func NewInstrumentedProvider(provider Provider) *InstrumentedProvider {
+	return &InstrumentedProvider{provider: provider}
+}
+
+// StartFlow initiates the OIDC authorization flow
+func (p *InstrumentedProvider) StartFlow(ctx context.Context, silent bool) (string, string, error) {
+	done := observe("start_flow", time.Now())
+	authURL, state, err := p.provider.StartFlow(ctx, silent)
+	done(err)
+	return authURL, state, err
+}
+
+// HandleCallback processes the OIDC callback and exchanges code for tokens
+func (p *InstrumentedProvider) HandleCallback(ctx context.Context, code, state string) (*types.TokenSet, error) {
+	done := observe("handle_callback", time.Now())
+	tokens, err := p.provider.HandleCallback(ctx, code, state)
+	done(err)
+	return tokens, err
+}
+
+// ValidateToken validates an access token and returns user information
+func (p *InstrumentedProvider) ValidateToken(ctx context.Context, accessToken string) (*types.UserInfo, error) {
+	done := observe("validate_token", time.Now())
+	userInfo, err := p.provider.ValidateToken(ctx, accessToken)
+	done(err)
+	return userInfo, err
+}
+
+// RefreshToken exchanges a refresh token for new access token
+func (p *InstrumentedProvider) RefreshToken(ctx context.Context, refreshToken string) (*types.TokenSet, error) {
+	done := observe("refresh_token", time.Now())
+	tokens, err := p.provider.RefreshToken(ctx, refreshToken)
+	done(err)
+	return tokens, err
+}
+
+// RevokeToken revokes an access token with the issuer
+func (p *InstrumentedProvider) RevokeToken(ctx context.Context, accessToken string) error {
+	done := observe("revoke_token", time.Now())
+	err := p.provider.RevokeToken(ctx, accessToken)
+	done(err)
+	return err
+}
+
+// observe returns a function recording the call's duration since start with its outcome
+func observe(operation string, start time.Time) func(err error) {
+	return func(err error) {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+		metrics.AuthDuration.WithLabelValues(operation, outcome).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+)
+
+func TestInstrumentedProvider_RecordsOutcome(t *testing.T) {
+	inner := &countingProvider{}
+	provider := NewInstrumentedProvider(inner)
+	ctx := context.Background()
+
+	before := testutil.CollectAndCount(metrics.AuthDuration, "broker_auth_duration_seconds")
+	provider.ValidateToken(ctx, "token")
+	provider.ValidateToken(ctx, "token")
+
+	inner.revokeErr = context.DeadlineExceeded
+	if err := provider.RevokeToken(ctx, "token"); err != context.DeadlineExceeded {
+		t.Errorf("Expected wrapped error to be returned, got %v", err)
+	}
+
+	// One series each for validate_token/success and revoke_token/error
+	if after := testutil.CollectAndCount(metrics.AuthDuration, "broker_auth_duration_seconds"); after != before+2 {
+		t.Errorf("Expected 2 new series, got %d", after-before)
+	}
+	if inner.validations != 2 {
+		t.Errorf("Expected calls to reach the wrapped provider, got %d validations", inner.validations)
+	}
+}
@@ -2,14 +2,19 @@ package auth
 
 import (
 	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
 
+	"github.com/purdue-af/vscode-k8s-connector/internal/httpclient"
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
 // Provider defines the interface for OIDC authentication providers
 type Provider interface {
-	// StartFlow initiates the OIDC authorization flow
-	StartFlow(ctx context.Context) (authURL string, state string, err error)
+	// StartFlow initiates the OIDC authorization flow, silently with
+	// prompt=none if requested
+	StartFlow(ctx context.Context, silent bool) (authURL string, state string, err error)
 
 	// HandleCallback processes the OIDC callback and exchanges code for tokens
 	HandleCallback(ctx context.Context, code, state string) (*types.TokenSet, error)
@@ -19,6 +24,9 @@ type Provider interface {
 
 	// RefreshToken exchanges a refresh token for new access token
 	RefreshToken(ctx context.Context, refreshToken string) (*types.TokenSet, error)
+
+	// RevokeToken revokes an access token with the issuer
+	RevokeToken(ctx context.Context, accessToken string) error
 }
 
 // CILogonProvider implements Provider for CILogon OIDC
@@ -27,16 +35,42 @@ type CILogonProvider struct {
 	clientID     string
 	clientSecret string
 	redirectURL  string
+	client       *http.Client
+
+	extraAuthParams map[string]string
+	authFlowTimeout time.Duration
+	flows           *flowStore
+
+	// endpoints are the default CILogon paths until Discover succeeds
+	endpoints atomic.Pointer[endpoints]
 }
 
 // NewCILogonProvider creates a new CILogon provider
 func NewCILogonProvider(config CILogonConfig) *CILogonProvider {
-	return &CILogonProvider{
+	client := config.HTTPClient
+	if client == nil {
+		client = httpclient.NewClient(httpclient.Config{})
+	}
+
+	authFlowTimeout := config.AuthFlowTimeout
+	if authFlowTimeout <= 0 {
+		authFlowTimeout = DefaultAuthFlowTimeout
+	}
+
+	provider := &CILogonProvider{
 		issuer:       config.Issuer,
 		clientID:     config.ClientID,
 		clientSecret: config.ClientSecret,
 		redirectURL:  config.RedirectURL,
+		client:       client,
+
+		extraAuthParams: config.ExtraAuthParams,
+		authFlowTimeout: authFlowTimeout,
+		flows:           newFlowStore(config.MaxPendingFlows, authFlowTimeout),
 	}
+	provider.endpoints.Store(defaultEndpoints(config.Issuer))
+	go provider.flows.reapLoop()
+	return provider
 }
 
 type CILogonConfig struct {
@@ -44,6 +78,22 @@ type CILogonConfig struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+
+	// HTTPClient makes requests to the issuer, a default httpclient client if nil
+	HTTPClient *http.Client
+
+	// ExtraAuthParams are added to the authorization URL query, e.g. prompt or
+	// a narrower selected_idp. Check them with ValidateAuthParams, parameters
+	// managed by the flow itself cannot be overridden.
+	ExtraAuthParams map[string]string
+
+	// AuthFlowTimeout is the longest a login may take from StartFlow to
+	// HandleCallback, DefaultAuthFlowTimeout if zero
+	AuthFlowTimeout time.Duration
+
+	// MaxPendingFlows bounds the logins waiting for their callback,
+	// DefaultMaxPendingFlows if zero
+	MaxPendingFlows int
 }
 
 
@@ -1,7 +1,17 @@
 package auth
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCILogonProvider_NewCILogonProvider(t *testing.T) {
@@ -74,3 +84,387 @@ func TestCILogonProvider_ValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestCILogonProvider_SilentFlow(t *testing.T) {
+	provider := NewCILogonProvider(CILogonConfig{
+		Issuer:          "https://cilogon.org",
+		ClientID:        "test-client",
+		RedirectURL:     "http://localhost:8080/auth/callback",
+		ExtraAuthParams: map[string]string{"prompt": "login"},
+	})
+
+	for _, silent := range []bool{true, false} {
+		authURL, _, err := provider.StartFlow(context.Background(), silent)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatalf("Expected a valid auth URL, got %v", err)
+		}
+		want := "login"
+		if silent {
+			want = "none"
+		}
+		if got := u.Query().Get("prompt"); got != want {
+			t.Errorf("Expected prompt=%s for silent=%v, got %q", want, silent, got)
+		}
+	}
+}
+
+func TestCallbackError(t *testing.T) {
+	for _, code := range []string{"login_required", "interaction_required", "consent_required", "account_selection_required"} {
+		if err := CallbackError(code, ""); !errors.Is(err, ErrInteractionRequired) {
+			t.Errorf("Expected %s to require an interactive login, got %v", code, err)
+		}
+	}
+
+	err := CallbackError("access_denied", "user cancelled")
+	if errors.Is(err, ErrInteractionRequired) {
+		t.Errorf("Expected access_denied not to ask for an interactive login, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "user cancelled") {
+		t.Errorf("Expected the issuer's description in the error, got %v", err)
+	}
+}
+
+func TestCILogonProvider_ExtraAuthParams(t *testing.T) {
+	provider := NewCILogonProvider(CILogonConfig{
+		Issuer:      "https://cilogon.org",
+		ClientID:    "test-client",
+		RedirectURL: "http://localhost:8080/auth/callback",
+		ExtraAuthParams: map[string]string{
+			"prompt":       "login",
+			"selected_idp": "https://idp.purdue.edu/idp/shibboleth",
+			"state":        "attacker-chosen",
+		},
+	})
+
+	authURL, _, err := provider.StartFlow(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Expected a valid auth URL, got %v", err)
+	}
+	q := u.Query()
+
+	if got := q.Get("prompt"); got != "login" {
+		t.Fatalf("Expected prompt=login, got %q", got)
+	}
+	if got := q.Get("selected_idp"); got != "https://idp.purdue.edu/idp/shibboleth" {
+		t.Fatalf("Expected selected_idp to be overridden, got %q", got)
+	}
+	if got := q.Get("state"); got == "attacker-chosen" {
+		t.Fatal("Expected managed state parameter not to be overridden")
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", raw: "", want: map[string]string{}},
+		{
+			name: "prompt and idp",
+			raw:  "prompt=login&selected_idp=https://idp.purdue.edu/idp/shibboleth",
+			want: map[string]string{"prompt": "login", "selected_idp": "https://idp.purdue.edu/idp/shibboleth"},
+		},
+		{name: "managed state", raw: "state=abc", wantErr: true},
+		{name: "managed code challenge", raw: "prompt=login&code_challenge=abc", wantErr: true},
+		{name: "repeated", raw: "prompt=login&prompt=none", wantErr: true},
+		{name: "malformed", raw: "prompt=%zz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, err := ParseAuthParams(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if len(params) != len(tt.want) {
+				t.Fatalf("Expected %v, got %v", tt.want, params)
+			}
+			for key, value := range tt.want {
+				if params[key] != value {
+					t.Fatalf("Expected %s=%q, got %q", key, value, params[key])
+				}
+			}
+		})
+	}
+}
+
+func TestCILogonProvider_AuthFlowTimeout(t *testing.T) {
+	provider := NewCILogonProvider(CILogonConfig{
+		Issuer:          "https://cilogon.org",
+		ClientID:        "test-client",
+		RedirectURL:     "http://localhost:8080/auth/callback",
+		AuthFlowTimeout: time.Minute,
+	})
+
+	provider.flows.add("stale-state", pendingFlow{codeVerifier: "test-verifier", issuedAt: time.Now().Add(-2 * time.Minute)})
+	_, err := provider.HandleCallback(context.Background(), "test-code", "stale-state")
+	if !errors.Is(err, ErrAuthFlowExpired) {
+		t.Fatalf("Expected ErrAuthFlowExpired for a stale flow, got %v", err)
+	}
+
+	_, err = provider.HandleCallback(context.Background(), "test-code", "unknown-state")
+	if !errors.Is(err, ErrUnknownFlow) {
+		t.Fatalf("Expected ErrUnknownFlow for a state that was never issued, got %v", err)
+	}
+}
+
+func TestCILogonProvider_IssueTimeStaysOnBroker(t *testing.T) {
+	provider := NewCILogonProvider(CILogonConfig{
+		Issuer:          "https://cilogon.org",
+		ClientID:        "test-client",
+		RedirectURL:     "http://localhost:8080/auth/callback",
+		AuthFlowTimeout: time.Minute,
+	})
+	_, state, err := provider.StartFlow(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The state is only a key, so a client cannot vouch for its own issue time
+	forgedJSON, _ := json.Marshal(map[string]string{
+		"state":         state,
+		"code_verifier": "attacker-verifier",
+		"issued_at":     strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10),
+	})
+	forged := base64.URLEncoding.EncodeToString(forgedJSON)
+	if _, err := provider.HandleCallback(context.Background(), "test-code", forged); !errors.Is(err, ErrUnknownFlow) {
+		t.Errorf("Expected a state carrying its own issue time to be rejected, got %v", err)
+	}
+}
+
+func TestCILogonProvider_TokenResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantErr     bool
+		wantScopes  []string
+		wantIDToken string
+	}{
+		{
+			name:        "full response",
+			body:        `{"access_token":"at","refresh_token":"rt","id_token":"idt","expires_in":900,"token_type":"Bearer","scope":"openid email"}`,
+			wantScopes:  []string{"openid", "email"},
+			wantIDToken: "idt",
+		},
+		{
+			name:       "scope omitted",
+			body:       `{"access_token":"at","refresh_token":"rt","expires_in":900}`,
+			wantScopes: []string{"openid", "email", "org.cilogon.userinfo", "profile"},
+		},
+		{name: "error with status 200", body: `{"error":"invalid_grant","error_description":"code expired"}`, wantErr: true},
+		{name: "missing access token", body: `{"refresh_token":"rt"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.body))
+			}))
+			defer issuer.Close()
+
+			provider := NewCILogonProvider(CILogonConfig{Issuer: issuer.URL, ClientID: "test-client"})
+			_, state, err := provider.StartFlow(context.Background(), false)
+			if err != nil {
+				t.Fatalf("Expected no error starting flow, got %v", err)
+			}
+
+			tokens, err := provider.HandleCallback(context.Background(), "test-code", state)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, got %+v", tokens)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if tokens.IDToken != tt.wantIDToken {
+				t.Errorf("Expected id token %q, got %q", tt.wantIDToken, tokens.IDToken)
+			}
+			if len(tokens.Scopes) != len(tt.wantScopes) {
+				t.Fatalf("Expected scopes %v, got %v", tt.wantScopes, tokens.Scopes)
+			}
+			for _, scope := range tt.wantScopes {
+				if !tokens.HasScope(scope) {
+					t.Errorf("Expected scope %s to be granted, got %v", scope, tokens.Scopes)
+				}
+			}
+		})
+	}
+}
+
+func TestCILogonProvider_RefreshKeepsRefreshToken(t *testing.T) {
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"access_token":"new-at","expires_in":900}`))
+	}))
+	defer issuer.Close()
+
+	provider := NewCILogonProvider(CILogonConfig{Issuer: issuer.URL, ClientID: "test-client"})
+	tokens, err := provider.RefreshToken(context.Background(), "old-rt")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if tokens.AccessToken != "new-at" || tokens.RefreshToken != "old-rt" {
+		t.Errorf("Expected new access token and unchanged refresh token, got %+v", tokens)
+	}
+}
+
+func TestCILogonProvider_UpstreamErrors(t *testing.T) {
+	const page = "<html><body><h1>502 Bad Gateway</h1></body></html>"
+	tests := []struct {
+		name         string
+		status       int
+		contentType  string
+		body         string
+		wantUpstream bool
+	}{
+		{name: "HTML with status 200", status: http.StatusOK, contentType: "text/html", body: page, wantUpstream: true},
+		{name: "HTML with status 503", status: http.StatusServiceUnavailable, contentType: "text/html; charset=utf-8", body: page, wantUpstream: true},
+		{name: "JSON server error", status: http.StatusInternalServerError, contentType: "application/json", body: `{"error":"server_error"}`, wantUpstream: true},
+		{name: "JSON client error", status: http.StatusBadRequest, contentType: "application/json", body: `{"error":"invalid_grant"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer issuer.Close()
+
+			provider := NewCILogonProvider(CILogonConfig{Issuer: issuer.URL, ClientID: "test-client"})
+			_, state, _ := provider.StartFlow(context.Background(), false)
+			_, callbackErr := provider.HandleCallback(context.Background(), "test-code", state)
+			_, refreshErr := provider.RefreshToken(context.Background(), "rt")
+			_, userInfoErr := provider.ValidateToken(context.Background(), "at")
+
+			for _, err := range []error{callbackErr, refreshErr, userInfoErr} {
+				if err == nil {
+					t.Fatal("Expected an error")
+				}
+				if errors.Is(err, ErrUpstream) != tt.wantUpstream {
+					t.Errorf("Expected upstream error %v, got %v", tt.wantUpstream, err)
+				}
+				if strings.Contains(err.Error(), "invalid character") {
+					t.Errorf("Expected no JSON decoding error, got %v", err)
+				}
+			}
+			if tt.contentType == "text/html" && !strings.Contains(callbackErr.Error(), `content type "text/html": <html><body><h1>502 Bad Gateway`) {
+				t.Errorf("Expected content type and body snippet in error, got %v", callbackErr)
+			}
+		})
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("é", snippetLength)
+	if got := snippet([]byte(long)); len(got) > snippetLength+3 || !strings.HasSuffix(got, "...") {
+		t.Errorf("Expected long bodies to be truncated, got %d bytes", len(got))
+	}
+	if got := snippet([]byte("<p>\n  Service\tUnavailable\n</p>")); got != "<p> Service Unavailable </p>" {
+		t.Errorf("Expected whitespace collapsed to one line, got %q", got)
+	}
+}
+
+func TestCILogonProvider_Discover(t *testing.T) {
+	var document map[string]string
+	var tokenCalls int
+	var issuer *httptest.Server
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if document == nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(document)
+		case "/custom/token":
+			tokenCalls++
+			w.Write([]byte(`{"access_token":"at","expires_in":900}`))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer issuer.Close()
+
+	provider := NewCILogonProvider(CILogonConfig{Issuer: issuer.URL, ClientID: "test-client"})
+
+	// Without a discovery document the default paths stay in place
+	if err := provider.Discover(context.Background()); err == nil {
+		t.Fatal("Expected error when discovery document is missing")
+	}
+	if got := provider.endpoints.Load().Token; got != issuer.URL+"/oauth2/token" {
+		t.Fatalf("Expected default token endpoint, got %s", got)
+	}
+
+	document = map[string]string{"issuer": "https://other.example.org", "token_endpoint": issuer.URL + "/custom/token"}
+	if err := provider.Discover(context.Background()); err == nil {
+		t.Fatal("Expected error for mismatched issuer")
+	}
+
+	document = map[string]string{
+		"issuer":                 issuer.URL,
+		"authorization_endpoint": issuer.URL + "/custom/authorize",
+		"token_endpoint":         issuer.URL + "/custom/token",
+	}
+	if err := provider.Discover(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	authURL, _, err := provider.StartFlow(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL+"/custom/authorize?") {
+		t.Errorf("Expected discovered authorization endpoint, got %s", authURL)
+	}
+	if _, err := provider.RefreshToken(context.Background(), "rt"); err != nil || tokenCalls != 1 {
+		t.Errorf("Expected refresh against discovered token endpoint, got err %v and %d calls", err, tokenCalls)
+	}
+	if got := provider.endpoints.Load().Revocation; got != issuer.URL+"/oauth2/revoke" {
+		t.Errorf("Expected default revocation endpoint for omitted field, got %s", got)
+	}
+}
+
+func TestCILogonProvider_Ping(t *testing.T) {
+	up := true
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up || r.URL.Path != discoveryPath {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"issuer":"elsewhere","token_endpoint":"https://elsewhere/token"}`))
+	}))
+	defer issuer.Close()
+
+	provider := NewCILogonProvider(CILogonConfig{Issuer: issuer.URL, ClientID: "test-client"})
+	if err := provider.Ping(context.Background()); err != nil {
+		t.Fatalf("Expected reachable issuer, got %v", err)
+	}
+	if got := provider.endpoints.Load().Token; got != issuer.URL+"/oauth2/token" {
+		t.Errorf("Expected ping to leave endpoints unchanged, got %s", got)
+	}
+
+	up = false
+	if err := provider.Ping(context.Background()); err == nil {
+		t.Error("Expected an error while the issuer is down")
+	}
+}
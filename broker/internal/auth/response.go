@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ErrUpstream means the issuer, or a proxy in front of it, failed or answered
+// with something other than JSON, such as an HTML error page. It is not the
+// client's fault, so handlers report it as a bad gateway.
+var ErrUpstream = errors.New("identity provider returned an unusable response")
+
+const (
+	// maxResponseBytes bounds how much of an issuer response is read
+	maxResponseBytes = 1 << 20
+
+	// snippetLength is how much of a response body is quoted in errors
+	snippetLength = 200
+)
+
+// readJSONBody reads a successful response, failing with ErrUpstream if the
+// body is not JSON. Bodies served without a JSON content type are accepted
+// when they look like JSON, as some issuers label them text/plain.
+func readJSONBody(resp *http.Response) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !isJSONContentType(contentType) && !looksLikeJSON(body) {
+		return nil, fmt.Errorf("%w: status %d with content type %q: %s",
+			ErrUpstream, resp.StatusCode, contentType, snippet(body))
+	}
+	return body, nil
+}
+
+// statusError describes a non-200 response. Client errors such as an
+// expired code are returned as is; anything else is an ErrUpstream.
+func statusError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	contentType := resp.Header.Get("Content-Type")
+
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+		if isJSONContentType(contentType) || looksLikeJSON(body) {
+			return fmt.Errorf("status %d: %s", resp.StatusCode, snippet(body))
+		}
+		return fmt.Errorf("status %d with content type %q: %s", resp.StatusCode, contentType, snippet(body))
+	}
+	return fmt.Errorf("%w: status %d with content type %q: %s",
+		ErrUpstream, resp.StatusCode, contentType, snippet(body))
+}
+
+// isJSONContentType reports whether a Content-Type header names JSON
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// looksLikeJSON reports whether body starts like a JSON object or array
+func looksLikeJSON(body []byte) bool {
+	body = bytes.TrimSpace(body)
+	return len(body) > 0 && (body[0] == '{' || body[0] == '[')
+}
+
+// snippet returns the start of body on one line, for error messages
+func snippet(body []byte) string {
+	text := strings.Join(strings.Fields(string(body)), " ")
+	if len(text) > snippetLength {
+		return strings.ToValidUTF8(text[:snippetLength], "") + "..."
+	}
+	return text
+}
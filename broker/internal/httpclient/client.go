@@ -0,0 +1,89 @@
+package httpclient
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/tracing"
+)
+
+const (
+	// DefaultDialTimeout bounds establishing a TCP connection
+	DefaultDialTimeout = 10 * time.Second
+
+	// DefaultTLSHandshakeTimeout bounds the TLS handshake
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+
+	// DefaultResponseHeaderTimeout bounds waiting for response headers once the
+	// request is written; JupyterHub spawn calls can be slow to answer
+	DefaultResponseHeaderTimeout = 60 * time.Second
+
+	// DefaultTimeout bounds a whole request, including reading the response
+	// body, so a server that stalls mid-response cannot hang the caller. It
+	// is longer than DefaultResponseHeaderTimeout to leave slow spawn calls
+	// time to answer.
+	DefaultTimeout = 2 * time.Minute
+
+	// DefaultMaxIdleConns bounds idle connections kept across all hosts
+	DefaultMaxIdleConns = 100
+
+	// DefaultMaxIdleConnsPerHost keeps enough idle connections to the issuer
+	// and JupyterHub to avoid new TLS handshakes under concurrent logins;
+	// net/http keeps only 2
+	DefaultMaxIdleConnsPerHost = 16
+
+	// DefaultIdleConnTimeout closes connections idle for longer
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// Config represents outbound HTTP client configuration. Zero values use the
+// defaults above.
+type Config struct {
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	Timeout               time.Duration
+
+	// Connection pool settings
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// NewClient creates an HTTP client for outbound calls to the identity provider
+// and JupyterHub. The transport bounds each connection phase and the client
+// bounds the whole request; callers may set shorter context deadlines.
+// Requests are traced and carry the caller's trace context.
+func NewClient(config Config) *http.Client {
+	return &http.Client{
+		Transport: tracing.Transport(NewTransport(config)),
+		Timeout:   orDefault(config.Timeout, DefaultTimeout),
+	}
+}
+
+// NewTransport creates a transport with separate connect, TLS handshake and
+// response header timeouts and a tuned connection pool. Share one transport,
+// or one client, between callers so they reuse each other's connections.
+func NewTransport(config Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   orDefault(config.DialTimeout, DefaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = orDefault(config.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout)
+	transport.ResponseHeaderTimeout = orDefault(config.ResponseHeaderTimeout, DefaultResponseHeaderTimeout)
+	transport.MaxIdleConns = orDefault(config.MaxIdleConns, DefaultMaxIdleConns)
+	transport.MaxIdleConnsPerHost = orDefault(config.MaxIdleConnsPerHost, DefaultMaxIdleConnsPerHost)
+	transport.IdleConnTimeout = orDefault(config.IdleConnTimeout, DefaultIdleConnTimeout)
+	return transport
+}
+
+func orDefault[T int | time.Duration](value, defaultValue T) T {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
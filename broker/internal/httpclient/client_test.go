@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewTransport_Defaults(t *testing.T) {
+	transport := NewTransport(Config{})
+	if transport.TLSHandshakeTimeout != DefaultTLSHandshakeTimeout {
+		t.Errorf("Expected TLS handshake timeout %v, got %v", DefaultTLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	}
+	if transport.ResponseHeaderTimeout != DefaultResponseHeaderTimeout {
+		t.Errorf("Expected response header timeout %v, got %v", DefaultResponseHeaderTimeout, transport.ResponseHeaderTimeout)
+	}
+
+	if transport.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || transport.IdleConnTimeout != DefaultIdleConnTimeout {
+		t.Errorf("Expected default pool settings, got %d per host and %v idle timeout",
+			transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	transport = NewTransport(Config{ResponseHeaderTimeout: 5 * time.Minute})
+	if transport.ResponseHeaderTimeout != 5*time.Minute {
+		t.Errorf("Expected configured response header timeout, got %v", transport.ResponseHeaderTimeout)
+	}
+}
+
+func TestNewClient_Timeouts(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewClient(Config{ResponseHeaderTimeout: 50 * time.Millisecond})
+	if client.Timeout != DefaultTimeout {
+		t.Fatalf("Expected overall client timeout %v, got %v", DefaultTimeout, client.Timeout)
+	}
+
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected response header timeout")
+	}
+
+	// The caller's deadline applies when it is shorter than the transport's
+	client = NewClient(Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err = client.Do(req)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected context deadline error")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline exceeded, got %v", err)
+	}
+}
+
+func TestNewClient_ReusesConnections(t *testing.T) {
+	var conns int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns++
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client := NewClient(Config{MaxIdleConnsPerHost: 4})
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	if conns != 1 {
+		t.Errorf("Expected sequential requests to share one connection, got %d", conns)
+	}
+}
+
+func TestNewClient_StalledBody(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// Headers arrive in time, so only the overall timeout ends the request
+	client := NewClient(Config{Timeout: 50 * time.Millisecond})
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected response headers, got %v", err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err == nil {
+		t.Error("Expected the stalled body read to time out")
+	}
+}
@@ -3,11 +3,14 @@ package jupyterhub
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/purdue-af/vscode-k8s-connector/internal/httpclient"
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
@@ -19,25 +22,49 @@ type ClientInterface interface {
 	// EnsurePodRunning ensures the user's pod is running, starting it if necessary
 	EnsurePodRunning(ctx context.Context, username string) (*types.PodInfo, error)
 
+	// EnsurePodRunningWithProgress is EnsurePodRunning, reporting spawn progress to the callback
+	EnsurePodRunningWithProgress(ctx context.Context, username string, progress ProgressFunc) (*types.PodInfo, error)
+
 	// StopUserPod stops the user's pod
 	StopUserPod(ctx context.Context, username string) error
 }
 
 // Client implements the jupyterhub.ClientInterface interface
 type Client struct {
-	apiURL   string
-	apiToken string
-	client   *http.Client
+	apiURL            string
+	apiToken          string
+	client            *http.Client
+	namespaceResolver NamespaceResolver
+	podSelector       *PodSelector
+	serverPodNamer    *ServerPodNamer
+	spawnTimeout      time.Duration
 }
 
 // NewClient creates a new JupyterHub client
 func NewClient(config JupyterHubConfig) *Client {
+	resolver := config.NamespaceResolver
+	if resolver == nil {
+		resolver, _ = NewTemplateNamespaceResolver(DefaultNamespaceTemplate)
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = httpclient.NewClient(httpclient.Config{})
+	}
+
+	spawnTimeout := config.SpawnTimeout
+	if spawnTimeout <= 0 {
+		spawnTimeout = DefaultSpawnTimeout
+	}
+
 	return &Client{
-		apiURL:   config.APIURL,
-		apiToken: config.APIToken,
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		apiURL:            config.APIURL,
+		apiToken:          config.APIToken,
+		client:            client,
+		namespaceResolver: resolver,
+		podSelector:       config.PodSelector,
+		serverPodNamer:    config.ServerPodNamer,
+		spawnTimeout:      spawnTimeout,
 	}
 }
 
@@ -45,8 +72,65 @@ func NewClient(config JupyterHubConfig) *Client {
 type JupyterHubConfig struct {
 	APIURL   string
 	APIToken string
+
+	// NamespaceResolver maps usernames to namespaces, defaults to user-<username>
+	NamespaceResolver NamespaceResolver
+
+	// HTTPClient makes requests to the hub API, a default httpclient client if nil
+	HTTPClient *http.Client
+
+	// PodSelector, if set, discovers the user's pod by label instead of
+	// deriving its name from the username
+	PodSelector *PodSelector
+
+	// ServerPodNamer, if set, derives the pod name from the user's server URL
+	// and name instead of the username
+	ServerPodNamer *ServerPodNamer
+
+	// SpawnTimeout bounds waiting for a started server to become ready,
+	// DefaultSpawnTimeout if zero
+	SpawnTimeout time.Duration
+}
+
+// DefaultSpawnTimeout is how long a started server may take to become ready
+const DefaultSpawnTimeout = 5 * time.Minute
+
+// ErrSpawnTimeout means the user's server did not become ready within the
+// spawn timeout. It may still be starting, so the request can be retried.
+var ErrSpawnTimeout = errors.New("timed out waiting for the server to be ready")
+
+// SpawnTimeoutError reports how far a timed out spawn got
+type SpawnTimeoutError struct {
+	// Elapsed is how long the server had been starting
+	Elapsed time.Duration
+	// Progress is the last progress JupyterHub reported
+	Progress SpawnProgress
+	// Pod is the user's pod, if it could be located
+	Pod *types.PodInfo
+}
+
+func (e *SpawnTimeoutError) Error() string {
+	return fmt.Sprintf("%v after %s, last in phase %s at %d%%",
+		ErrSpawnTimeout, e.Elapsed.Round(time.Second), e.Progress.Phase, e.Progress.Percent)
 }
 
+func (e *SpawnTimeoutError) Unwrap() error { return ErrSpawnTimeout }
+
+// Spawn phases reported through ProgressFunc
+const (
+	PhaseSpawning        = "spawning"
+	PhaseWaitingForReady = "waiting_for_ready"
+)
+
+// SpawnProgress represents the state of a server spawn
+type SpawnProgress struct {
+	Phase   string `json:"phase"`
+	Percent int    `json:"percent"`
+}
+
+// ProgressFunc receives spawn progress updates
+type ProgressFunc func(SpawnProgress)
+
 // JupyterHubUser represents a JupyterHub user
 type JupyterHubUser struct {
 	Name   string            `json:"name"`
@@ -79,12 +163,40 @@ func (c *Client) GetUserPod(ctx context.Context, username string) (*types.PodInf
 	if !user.Server.Ready {
 		return nil, fmt.Errorf("user server is not ready")
 	}
+	return c.locatePod(ctx, username, user.Server)
+}
+
+// locatePod finds the user's pod, whether or not their server is ready,
+// logging which discovery mode found it so mis-resolution can be diagnosed.
+// server is the user's server as JupyterHub reports it, nil if unknown.
+func (c *Client) locatePod(ctx context.Context, username string, server *JupyterHubServer) (*types.PodInfo, error) {
+	namespace, err := c.namespaceResolver.Resolve(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve namespace: %w", err)
+	}
 
-	// Extract pod information from server URL or name
-	// This is a simplified implementation - in practice, you might need
-	// to query Kubernetes directly or use JupyterHub's pod API
+	if c.podSelector != nil {
+		pod, err := c.podSelector.Find(ctx, namespace, username)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover pod: %w", err)
+		}
+		log.Printf("Resolved pod %s/%s for user %s by %s discovery", pod.Namespace, pod.Name, username, PodDiscoveryLabel)
+		return pod, nil
+	}
+
+	// Without a server URL to go by, server discovery falls back to the name
 	podName := fmt.Sprintf("jupyter-%s", username)
-	namespace := fmt.Sprintf("user-%s", username)
+	discovery := PodDiscoveryName
+	if c.serverPodNamer != nil {
+		if server == nil {
+			log.Printf("User %s has no server to derive its pod name from, falling back to %s discovery", username, PodDiscoveryName)
+		} else if name, err := c.serverPodNamer.Name(server); err != nil {
+			log.Printf("Failed to derive pod name from the server of user %s, falling back to %s discovery: %v", username, PodDiscoveryName, err)
+		} else {
+			podName, discovery = name, PodDiscoveryServer
+		}
+	}
+	log.Printf("Resolved pod %s/%s for user %s by %s discovery", namespace, podName, username, discovery)
 
 	return &types.PodInfo{
 		Name:      podName,
@@ -95,6 +207,15 @@ func (c *Client) GetUserPod(ctx context.Context, username string) (*types.PodInf
 
 // EnsurePodRunning ensures the user's pod is running, starting it if necessary
 func (c *Client) EnsurePodRunning(ctx context.Context, username string) (*types.PodInfo, error) {
+	return c.EnsurePodRunningWithProgress(ctx, username, nil)
+}
+
+// EnsurePodRunningWithProgress is EnsurePodRunning, reporting spawn progress to the callback
+func (c *Client) EnsurePodRunningWithProgress(ctx context.Context, username string, progress ProgressFunc) (*types.PodInfo, error) {
+	if progress == nil {
+		progress = func(SpawnProgress) {}
+	}
+
 	user, err := c.getUser(ctx, username)
 	if err != nil {
 		return nil, err
@@ -102,12 +223,23 @@ func (c *Client) EnsurePodRunning(ctx context.Context, username string) (*types.
 
 	// If user has no server or server is not ready, start it
 	if user.Server == nil || !user.Server.Ready {
+		started := time.Now()
 		if err := c.startServer(ctx, username); err != nil {
 			return nil, fmt.Errorf("failed to start server: %w", err)
 		}
+		progress(SpawnProgress{Phase: PhaseSpawning})
 
 		// Wait for server to be ready
-		if err := c.waitForServerReady(ctx, username); err != nil {
+		if err := c.waitForServerReady(ctx, username, started, progress); err != nil {
+			// The pod's events may explain a slow start
+			var timeoutErr *SpawnTimeoutError
+			if errors.As(err, &timeoutErr) {
+				var server *JupyterHubServer
+				if user, err := c.getUser(ctx, username); err == nil {
+					server = user.Server
+				}
+				timeoutErr.Pod, _ = c.locatePod(ctx, username, server)
+			}
 			return nil, fmt.Errorf("server failed to become ready: %w", err)
 		}
 	}
@@ -115,6 +247,26 @@ func (c *Client) EnsurePodRunning(ctx context.Context, username string) (*types.
 	return c.GetUserPod(ctx, username)
 }
 
+// Ping checks that the hub API is reachable. Its root reports the hub
+// version and needs no token.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL+"/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create ping request: %w", err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("hub API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hub API returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // StopUserPod stops the user's pod
 func (c *Client) StopUserPod(ctx context.Context, username string) error {
 	req, err := http.NewRequestWithContext(ctx, "DELETE",
@@ -192,15 +344,21 @@ func (c *Client) startServer(ctx context.Context, username string) error {
 	return nil
 }
 
-func (c *Client) waitForServerReady(ctx context.Context, username string) error {
-	timeout := time.After(5 * time.Minute)
+// waitForServerReady polls the server started at started until it is ready,
+// failing with a SpawnTimeoutError once the spawn timeout has passed
+func (c *Client) waitForServerReady(ctx context.Context, username string, started time.Time, progress ProgressFunc) error {
+	timeout := time.NewTimer(time.Until(started.Add(c.spawnTimeout)))
+	defer timeout.Stop()
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
 
+	last := SpawnProgress{Phase: PhaseSpawning}
 	for {
 		select {
-		case <-timeout:
-			return fmt.Errorf("timeout waiting for server to be ready")
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return &SpawnTimeoutError{Elapsed: time.Since(started), Progress: last}
 		case <-ticker.C:
 			user, err := c.getUser(ctx, username)
 			if err != nil {
@@ -210,6 +368,15 @@ func (c *Client) waitForServerReady(ctx context.Context, username string) error
 			if user.Server != nil && user.Server.Ready {
 				return nil
 			}
+
+			if user.Server != nil {
+				phase := PhaseSpawning
+				if user.Server.Pending == "" {
+					phase = PhaseWaitingForReady
+				}
+				last = SpawnProgress{Phase: phase, Percent: user.Server.Progress}
+				progress(last)
+			}
 		}
 	}
 }
@@ -0,0 +1,115 @@
+package jupyterhub
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_SpawnTimeout(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Write([]byte(`{"name": "alice", "server": {"ready": false, "pending": "spawn", "progress": 40}}`))
+		}
+	}))
+	defer hub.Close()
+
+	client := NewClient(JupyterHubConfig{APIURL: hub.URL, SpawnTimeout: 50 * time.Millisecond})
+	_, err := client.EnsurePodRunning(context.Background(), "alice")
+	if !errors.Is(err, ErrSpawnTimeout) {
+		t.Fatalf("Expected ErrSpawnTimeout, got %v", err)
+	}
+
+	var timeoutErr *SpawnTimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected a SpawnTimeoutError, got %T", err)
+	}
+	if timeoutErr.Elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the elapsed time to cover the timeout, got %v", timeoutErr.Elapsed)
+	}
+	if timeoutErr.Progress.Phase != PhaseSpawning {
+		t.Errorf("Expected the last phase to be reported, got %+v", timeoutErr.Progress)
+	}
+	if timeoutErr.Pod == nil || timeoutErr.Pod.Name != "jupyter-alice" || timeoutErr.Pod.Namespace != "user-alice" {
+		t.Errorf("Expected the pod to be located for its events, got %+v", timeoutErr.Pod)
+	}
+}
+
+func TestClient_SpawnCancelled(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Write([]byte(`{"name": "alice"}`))
+	}))
+	defer hub.Close()
+
+	client := NewClient(JupyterHubConfig{APIURL: hub.URL})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// The caller giving up ends the wait, rather than the spawn timeout
+	start := time.Now()
+	_, err := client.EnsurePodRunning(ctx, "alice")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the caller's deadline, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected the wait to stop with the caller, took %v", time.Since(start))
+	}
+}
+
+func TestClient_GetUserPod_ServerDiscovery(t *testing.T) {
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "alice", "server": {"ready": true, "url": "/user/alice.smith/"}}`))
+	}))
+	defer hub.Close()
+
+	namer, _ := NewServerPodNamer("")
+	client := NewClient(JupyterHubConfig{APIURL: hub.URL, ServerPodNamer: namer})
+	pod, err := client.GetUserPod(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if pod.Name != "jupyter-alice.smith" || pod.Namespace != "user-alice" {
+		t.Errorf("Expected the pod named after the server URL, got %s/%s", pod.Namespace, pod.Name)
+	}
+
+	// Without a server to go by, the pod is named after the username
+	pod, err = client.locatePod(context.Background(), "alice", nil)
+	if err != nil || pod.Name != "jupyter-alice" {
+		t.Errorf("Expected the name discovery fallback, got %+v (%v)", pod, err)
+	}
+}
+
+func TestClient_Ping(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer hub.Close()
+	client := NewClient(JupyterHubConfig{APIURL: hub.URL})
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Expected a reachable hub to pass, got %v", err)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("Expected an unavailable hub to fail")
+	}
+
+	hub.Close()
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("Expected an unreachable hub to fail")
+	}
+}
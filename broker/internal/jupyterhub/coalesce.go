@@ -0,0 +1,137 @@
+package jupyterhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// Ways CoalescingClient treats a request for a user whose server is already
+// being started
+const (
+	// SpawnConcurrencyWait makes the request wait for the start in progress
+	// and share its result
+	SpawnConcurrencyWait = "wait"
+
+	// SpawnConcurrencyReject fails the request with ErrSpawnInProgress
+	SpawnConcurrencyReject = "reject"
+)
+
+// ErrSpawnInProgress means another request is already starting the user's
+// server; retrying after it finishes finds the server running
+var ErrSpawnInProgress = errors.New("the user's server is already being started by another request")
+
+// CoalescingClient wraps a ClientInterface so that concurrent requests to
+// start the same user's server, as when several editor windows open at once,
+// make a single start. The start runs independently of the request that began
+// it, so the others still get its result if that request goes away.
+type CoalescingClient struct {
+	ClientInterface
+	reject bool
+
+	mutex sync.Mutex
+	calls map[string]*spawnCall
+}
+
+// spawnCall is a start in progress, with the progress callbacks of the
+// requests waiting for it
+type spawnCall struct {
+	done chan struct{}
+	pod  *types.PodInfo
+	err  error
+
+	mutex     sync.Mutex
+	nextID    int
+	listeners map[int]ProgressFunc
+}
+
+// NewCoalescingClient wraps client, with concurrency SpawnConcurrencyWait or
+// SpawnConcurrencyReject
+func NewCoalescingClient(client ClientInterface, concurrency string) *CoalescingClient {
+	return &CoalescingClient{
+		ClientInterface: client,
+		reject:          concurrency == SpawnConcurrencyReject,
+		calls:           make(map[string]*spawnCall),
+	}
+}
+
+// EnsurePodRunning ensures the user's pod is running, starting it if necessary
+func (c *CoalescingClient) EnsurePodRunning(ctx context.Context, username string) (*types.PodInfo, error) {
+	return c.EnsurePodRunningWithProgress(ctx, username, nil)
+}
+
+// EnsurePodRunningWithProgress is EnsurePodRunning, joining a start of the
+// same user's server already in progress and receiving its progress
+func (c *CoalescingClient) EnsurePodRunningWithProgress(ctx context.Context, username string, progress ProgressFunc) (*types.PodInfo, error) {
+	c.mutex.Lock()
+	call, inProgress := c.calls[username]
+	if inProgress && c.reject {
+		c.mutex.Unlock()
+		return nil, ErrSpawnInProgress
+	}
+	if !inProgress {
+		call = &spawnCall{done: make(chan struct{}), listeners: make(map[int]ProgressFunc)}
+		c.calls[username] = call
+	}
+	id := call.listen(progress)
+	c.mutex.Unlock()
+
+	if !inProgress {
+		go c.spawn(context.WithoutCancel(ctx), username, call)
+	}
+	defer call.unlisten(id)
+
+	select {
+	case <-call.done:
+		if call.pod == nil {
+			return nil, call.err
+		}
+		pod := *call.pod
+		return &pod, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// spawn starts the user's server for everyone waiting on call
+func (c *CoalescingClient) spawn(ctx context.Context, username string, call *spawnCall) {
+	call.pod, call.err = c.ClientInterface.EnsurePodRunningWithProgress(ctx, username, call.report)
+
+	c.mutex.Lock()
+	delete(c.calls, username)
+	c.mutex.Unlock()
+	close(call.done)
+}
+
+// listen registers a waiting request's progress callback, if any
+func (s *spawnCall) listen(progress ProgressFunc) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextID++
+	if progress != nil {
+		s.listeners[s.nextID] = progress
+	}
+	return s.nextID
+}
+
+// unlisten removes the callback of a request that stopped waiting, so
+// progress is never reported to a finished response
+func (s *spawnCall) unlisten(id int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.listeners, id)
+}
+
+// report passes progress to every waiting request
+func (s *spawnCall) report(progress SpawnProgress) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, listener := range s.listeners {
+		listener(progress)
+	}
+}
@@ -0,0 +1,140 @@
+package jupyterhub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// fakeSpawner starts servers once release is closed, counting the starts
+type fakeSpawner struct {
+	ClientInterface
+	release chan struct{}
+	spawns  atomic.Int32
+}
+
+func (f *fakeSpawner) EnsurePodRunningWithProgress(ctx context.Context, username string, progress ProgressFunc) (*types.PodInfo, error) {
+	f.spawns.Add(1)
+	progress(SpawnProgress{Phase: PhaseSpawning})
+	<-f.release
+	return &types.PodInfo{Name: "jupyter-" + username, Namespace: "user-" + username, Status: "Running"}, nil
+}
+
+func TestCoalescingClient_Wait(t *testing.T) {
+	spawner := &fakeSpawner{release: make(chan struct{})}
+	client := NewCoalescingClient(spawner, SpawnConcurrencyWait)
+
+	var wg sync.WaitGroup
+	var progressed atomic.Int32
+	pods := make([]*types.PodInfo, 3)
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pod, err := client.EnsurePodRunningWithProgress(context.Background(), "alice", func(SpawnProgress) {
+				progressed.Add(1)
+			})
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			pods[i] = pod
+		}(i)
+	}
+
+	// Let the requests join the start before it finishes
+	time.Sleep(20 * time.Millisecond)
+	close(spawner.release)
+	wg.Wait()
+
+	if spawns := spawner.spawns.Load(); spawns != 1 {
+		t.Errorf("Expected concurrent requests to share one start, got %d", spawns)
+	}
+	for _, pod := range pods {
+		if pod == nil || pod.Name != "jupyter-alice" {
+			t.Fatalf("Expected every request to get the pod, got %+v", pod)
+		}
+	}
+	if pods[0] == pods[1] {
+		t.Error("Expected each request to get its own copy of the pod")
+	}
+
+	// A later request starts again, once the first start has finished
+	spawner.release = make(chan struct{})
+	close(spawner.release)
+	if _, err := client.EnsurePodRunning(context.Background(), "alice"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if spawns := spawner.spawns.Load(); spawns != 2 {
+		t.Errorf("Expected a new start after the first finished, got %d starts", spawns)
+	}
+}
+
+func TestCoalescingClient_Reject(t *testing.T) {
+	spawner := &fakeSpawner{release: make(chan struct{})}
+	client := NewCoalescingClient(spawner, SpawnConcurrencyReject)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.EnsurePodRunning(context.Background(), "alice")
+		done <- err
+	}()
+	for spawner.spawns.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := client.EnsurePodRunning(context.Background(), "alice"); !errors.Is(err, ErrSpawnInProgress) {
+		t.Errorf("Expected ErrSpawnInProgress, got %v", err)
+	}
+	other := make(chan error, 1)
+	go func() {
+		_, err := client.EnsurePodRunning(context.Background(), "bob")
+		other <- err
+	}()
+	for spawner.spawns.Load() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(spawner.release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the first request to succeed, got %v", err)
+	}
+	if err := <-other; err != nil {
+		t.Errorf("Expected other users not to be affected, got %v", err)
+	}
+}
+
+func TestCoalescingClient_CallerGone(t *testing.T) {
+	spawner := &fakeSpawner{release: make(chan struct{})}
+	client := NewCoalescingClient(spawner, SpawnConcurrencyWait)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := client.EnsurePodRunning(ctx, "alice")
+		first <- err
+	}()
+	for spawner.spawns.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := client.EnsurePodRunning(context.Background(), "alice")
+		second <- err
+	}()
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled request to return, got %v", err)
+	}
+
+	close(spawner.release)
+	if err := <-second; err != nil {
+		t.Errorf("Expected the start to finish for the remaining request, got %v", err)
+	}
+}
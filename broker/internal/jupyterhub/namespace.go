@@ -0,0 +1,116 @@
+package jupyterhub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+)
+
+// Namespace resolution strategies
+const (
+	// NamespaceStrategyTemplate renders a template such as "user-{{.Username}}"
+	NamespaceStrategyTemplate = "template"
+
+	// NamespaceStrategySingle places every user in one shared namespace
+	NamespaceStrategySingle = "single"
+
+	// NamespaceStrategyLabel looks up the namespace labelled with the username
+	NamespaceStrategyLabel = "label"
+
+	// DefaultNamespaceTemplate matches the historical user-<username> layout
+	DefaultNamespaceTemplate = "user-{{.Username}}"
+)
+
+// NamespaceResolver maps a JupyterHub username to the namespace holding its pod
+type NamespaceResolver interface {
+	// Resolve returns the namespace for the given username
+	Resolve(ctx context.Context, username string) (string, error)
+}
+
+// NamespaceLister finds namespaces by label selector
+type NamespaceLister interface {
+	// ListNamespaces returns the names of namespaces matching the label selector
+	ListNamespaces(ctx context.Context, labelSelector string) ([]string, error)
+}
+
+// NewNamespaceResolver builds the resolver for a strategy. The value is the
+// template, the namespace name or the label key, depending on the strategy.
+func NewNamespaceResolver(strategy, value string, lister NamespaceLister) (NamespaceResolver, error) {
+	switch strategy {
+	case "", NamespaceStrategyTemplate:
+		if value == "" {
+			value = DefaultNamespaceTemplate
+		}
+		return NewTemplateNamespaceResolver(value)
+	case NamespaceStrategySingle:
+		if value == "" {
+			return nil, fmt.Errorf("single namespace strategy requires a namespace")
+		}
+		return SingleNamespaceResolver(value), nil
+	case NamespaceStrategyLabel:
+		if value == "" {
+			return nil, fmt.Errorf("label namespace strategy requires a label key")
+		}
+		if lister == nil {
+			return nil, fmt.Errorf("label namespace strategy requires a namespace lister")
+		}
+		return &LabelNamespaceResolver{LabelKey: value, Lister: lister}, nil
+	default:
+		return nil, fmt.Errorf("unknown namespace strategy %q", strategy)
+	}
+}
+
+// TemplateNamespaceResolver renders the namespace from a text/template with a .Username field
+type TemplateNamespaceResolver struct {
+	tmpl *template.Template
+}
+
+// NewTemplateNamespaceResolver parses the namespace template
+func NewTemplateNamespaceResolver(text string) (*TemplateNamespaceResolver, error) {
+	tmpl, err := template.New("namespace").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace template: %w", err)
+	}
+	return &TemplateNamespaceResolver{tmpl: tmpl}, nil
+}
+
+// Resolve renders the template for the username
+func (r *TemplateNamespaceResolver) Resolve(ctx context.Context, username string) (string, error) {
+	var buf bytes.Buffer
+	if err := r.tmpl.Execute(&buf, struct{ Username string }{Username: username}); err != nil {
+		return "", fmt.Errorf("failed to render namespace template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// SingleNamespaceResolver resolves every user to the same namespace
+type SingleNamespaceResolver string
+
+// Resolve returns the configured namespace
+func (r SingleNamespaceResolver) Resolve(ctx context.Context, username string) (string, error) {
+	return string(r), nil
+}
+
+// LabelNamespaceResolver finds the namespace whose LabelKey label equals the username
+type LabelNamespaceResolver struct {
+	LabelKey string
+	Lister   NamespaceLister
+}
+
+// Resolve looks up the single namespace labelled with the username
+func (r *LabelNamespaceResolver) Resolve(ctx context.Context, username string) (string, error) {
+	namespaces, err := r.Lister.ListNamespaces(ctx, fmt.Sprintf("%s=%s", r.LabelKey, username))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up namespace: %w", err)
+	}
+
+	switch len(namespaces) {
+	case 0:
+		return "", fmt.Errorf("no namespace labelled %s=%s", r.LabelKey, username)
+	case 1:
+		return namespaces[0], nil
+	default:
+		return "", fmt.Errorf("multiple namespaces labelled %s=%s: %v", r.LabelKey, username, namespaces)
+	}
+}
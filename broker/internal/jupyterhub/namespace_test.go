@@ -0,0 +1,81 @@
+package jupyterhub
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeNamespaceLister struct {
+	namespaces map[string][]string // label selector -> namespaces
+}
+
+func (f *fakeNamespaceLister) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	return f.namespaces[labelSelector], nil
+}
+
+func TestNamespaceResolver_Strategies(t *testing.T) {
+	lister := &fakeNamespaceLister{namespaces: map[string][]string{
+		"hub.jupyter.org/username=alice": {"jhub-alice"},
+		"hub.jupyter.org/username=bob":   {"jhub-bob-1", "jhub-bob-2"},
+	}}
+
+	tests := []struct {
+		name     string
+		strategy string
+		value    string
+		username string
+		want     string
+		wantErr  bool
+	}{
+		{name: "default template", strategy: "", value: "", username: "alice", want: "user-alice"},
+		{name: "custom template", strategy: NamespaceStrategyTemplate, value: "jupyter-{{.Username}}-ns", username: "alice", want: "jupyter-alice-ns"},
+		{name: "single namespace", strategy: NamespaceStrategySingle, value: "jupyterhub", username: "alice", want: "jupyterhub"},
+		{name: "label lookup", strategy: NamespaceStrategyLabel, value: "hub.jupyter.org/username", username: "alice", want: "jhub-alice"},
+		{name: "label lookup no match", strategy: NamespaceStrategyLabel, value: "hub.jupyter.org/username", username: "carol", wantErr: true},
+		{name: "label lookup multiple matches", strategy: NamespaceStrategyLabel, value: "hub.jupyter.org/username", username: "bob", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewNamespaceResolver(tt.strategy, tt.value, lister)
+			if err != nil {
+				t.Fatalf("Expected no error creating resolver, got %v", err)
+			}
+
+			got, err := resolver.Resolve(context.Background(), tt.username)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got namespace %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected namespace %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceResolver_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		value    string
+	}{
+		{name: "unknown strategy", strategy: "hash", value: ""},
+		{name: "single without namespace", strategy: NamespaceStrategySingle, value: ""},
+		{name: "label without key", strategy: NamespaceStrategyLabel, value: ""},
+		{name: "bad template", strategy: NamespaceStrategyTemplate, value: "user-{{.Username"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNamespaceResolver(tt.strategy, tt.value, &fakeNamespaceLister{}); err == nil {
+				t.Error("Expected error for invalid configuration")
+			}
+		})
+	}
+}
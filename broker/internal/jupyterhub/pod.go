@@ -0,0 +1,137 @@
+package jupyterhub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// Pod discovery modes
+const (
+	// PodDiscoveryName derives the pod name as jupyter-<username>
+	PodDiscoveryName = "name"
+
+	// PodDiscoveryLabel finds the pod by a label selector, for spawners that
+	// randomize pod names
+	PodDiscoveryLabel = "label"
+
+	// PodDiscoveryServer derives the pod name from the server JupyterHub
+	// reports, using the user and server names its URL routes to, for hubs
+	// where these differ from the username or users run named servers
+	PodDiscoveryServer = "server"
+
+	// DefaultPodSelector matches the labels KubeSpawner puts on user pods
+	DefaultPodSelector = "component=singleuser-server,hub.jupyter.org/username={{.Username}}"
+
+	// DefaultServerPodName matches KubeSpawner's jupyter-<username>[--<server>]
+	// pod names
+	DefaultServerPodName = "jupyter-{{.Username}}{{if .ServerName}}--{{.ServerName}}{{end}}"
+)
+
+// PodFinder finds a user's pod by label selector
+type PodFinder interface {
+	// FindUserPod returns the user's pod among those matching the label selector
+	FindUserPod(ctx context.Context, namespace, selector string) (*types.PodInfo, error)
+}
+
+// PodSelector discovers user pods by a label selector rendered from a
+// text/template with a .Username field
+type PodSelector struct {
+	tmpl   *template.Template
+	finder PodFinder
+}
+
+// NewPodSelector parses the label selector template
+func NewPodSelector(text string, finder PodFinder) (*PodSelector, error) {
+	if finder == nil {
+		return nil, fmt.Errorf("label pod discovery requires a pod finder")
+	}
+	if text == "" {
+		text = DefaultPodSelector
+	}
+
+	tmpl, err := template.New("selector").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pod selector template: %w", err)
+	}
+	return &PodSelector{tmpl: tmpl, finder: finder}, nil
+}
+
+// Find returns the user's pod in the namespace
+func (s *PodSelector) Find(ctx context.Context, namespace, username string) (*types.PodInfo, error) {
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, struct{ Username string }{Username: username}); err != nil {
+		return nil, fmt.Errorf("failed to render pod selector: %w", err)
+	}
+	return s.finder.FindUserPod(ctx, namespace, buf.String())
+}
+
+// ServerPodNamer derives pod names from the server JupyterHub reports for a
+// user, rendering a text/template with .Username and .ServerName fields
+type ServerPodNamer struct {
+	tmpl *template.Template
+}
+
+// NewServerPodNamer parses the pod name template
+func NewServerPodNamer(text string) (*ServerPodNamer, error) {
+	if text == "" {
+		text = DefaultServerPodName
+	}
+
+	tmpl, err := template.New("pod").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server pod name template: %w", err)
+	}
+	return &ServerPodNamer{tmpl: tmpl}, nil
+}
+
+// Name returns the name of the pod backing server. The server's own name
+// wins over the one in its URL.
+func (n *ServerPodNamer) Name(server *JupyterHubServer) (string, error) {
+	username, serverName, err := parseServerURL(server.URL)
+	if err != nil {
+		return "", err
+	}
+	if server.Name != "" {
+		serverName = server.Name
+	}
+
+	var buf bytes.Buffer
+	data := struct{ Username, ServerName string }{Username: username, ServerName: serverName}
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render pod name: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// parseServerURL returns the user and server names a JupyterHub server URL
+// routes to, such as alice and lab from /hub-prefix/user/alice/lab/. The
+// server name is empty for a user's default server.
+func parseServerURL(raw string) (username, serverName string, err error) {
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid server URL %q: %w", raw, err)
+	}
+
+	segments := strings.Split(strings.Trim(parsed.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		if segment != "user" || i+1 >= len(segments) || segments[i+1] == "" {
+			continue
+		}
+		if username, err = url.PathUnescape(segments[i+1]); err != nil {
+			return "", "", fmt.Errorf("invalid username in server URL %q: %w", raw, err)
+		}
+		if i+2 < len(segments) {
+			if serverName, err = url.PathUnescape(segments[i+2]); err != nil {
+				return "", "", fmt.Errorf("invalid server name in server URL %q: %w", raw, err)
+			}
+		}
+		return username, serverName, nil
+	}
+	return "", "", fmt.Errorf("server URL %q does not route to a user", raw)
+}
@@ -0,0 +1,87 @@
+package jupyterhub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+type fakePodFinder struct {
+	selector string
+}
+
+func (f *fakePodFinder) FindUserPod(ctx context.Context, namespace, selector string) (*types.PodInfo, error) {
+	f.selector = selector
+	return &types.PodInfo{Name: "jupyter-alice-x7k2", Namespace: namespace, Status: "Running"}, nil
+}
+
+func TestPodSelector_Find(t *testing.T) {
+	finder := &fakePodFinder{}
+	selector, err := NewPodSelector("", finder)
+	if err != nil {
+		t.Fatalf("Expected no error creating selector, got %v", err)
+	}
+
+	pod, err := selector.Find(context.Background(), "user-alice", "alice")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if want := "component=singleuser-server,hub.jupyter.org/username=alice"; finder.selector != want {
+		t.Errorf("Expected selector %s, got %s", want, finder.selector)
+	}
+	if pod.Name != "jupyter-alice-x7k2" || pod.Namespace != "user-alice" {
+		t.Errorf("Expected discovered pod user-alice/jupyter-alice-x7k2, got %s/%s", pod.Namespace, pod.Name)
+	}
+}
+
+func TestNewPodSelector_Invalid(t *testing.T) {
+	if _, err := NewPodSelector("app={{.Username", &fakePodFinder{}); err == nil {
+		t.Error("Expected error for malformed template")
+	}
+	if _, err := NewPodSelector(DefaultPodSelector, nil); err == nil {
+		t.Error("Expected error without a pod finder")
+	}
+}
+
+func TestServerPodNamer_Name(t *testing.T) {
+	namer, err := NewServerPodNamer("")
+	if err != nil {
+		t.Fatalf("Expected no error creating namer, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		server  JupyterHubServer
+		want    string
+		wantErr bool
+	}{
+		{name: "default server", server: JupyterHubServer{URL: "/user/alice/"}, want: "jupyter-alice"},
+		{name: "base URL prefix", server: JupyterHubServer{URL: "/jupyter/user/alice/"}, want: "jupyter-alice"},
+		{name: "named server", server: JupyterHubServer{Name: "gpu", URL: "/user/alice/gpu/"}, want: "jupyter-alice--gpu"},
+		{name: "server name from URL", server: JupyterHubServer{URL: "https://hub.example.org/user/alice/gpu/"}, want: "jupyter-alice--gpu"},
+		{name: "routing name differs from username", server: JupyterHubServer{URL: "/user/alice-2eadmin/"}, want: "jupyter-alice-2eadmin"},
+		{name: "escaped username", server: JupyterHubServer{URL: "/user/alice%40example/"}, want: "jupyter-alice@example"},
+		{name: "no user route", server: JupyterHubServer{URL: "/hub/spawn-pending/"}, wantErr: true},
+		{name: "empty URL", server: JupyterHubServer{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := namer.Name(&tt.server)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Expected %q, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+
+	if _, err := NewServerPodNamer("jupyter-{{.Username"); err == nil {
+		t.Error("Expected error for malformed template")
+	}
+}
@@ -0,0 +1,112 @@
+package jupyterhub
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// UsernameNormalizer maps an authenticated identity (usually an email) to the
+// username JupyterHub knows the user by
+type UsernameNormalizer interface {
+	// Normalize returns the JupyterHub username for the identity
+	Normalize(identity string) (string, error)
+}
+
+// UsernameNormalizerConfig configures the built-in normalizer. Steps apply in
+// field order: Pattern, StripDomain, Lowercase, then Template.
+type UsernameNormalizerConfig struct {
+	// Pattern extracts the username with a regular expression; the "username"
+	// named group is used if present, otherwise the first group, otherwise the
+	// whole match. Identities that do not match are rejected.
+	Pattern string
+
+	// StripDomain drops everything from the first "@"
+	StripDomain bool
+
+	// Lowercase lowercases the username
+	Lowercase bool
+
+	// Template renders the final username from .Username (the result of the
+	// previous steps) and .Identity (the original identity)
+	Template string
+}
+
+// NewUsernameNormalizer builds a normalizer, which passes identities through
+// unchanged when no steps are configured
+func NewUsernameNormalizer(config UsernameNormalizerConfig) (UsernameNormalizer, error) {
+	n := &configuredNormalizer{
+		stripDomain: config.StripDomain,
+		lowercase:   config.Lowercase,
+	}
+
+	if config.Pattern != "" {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid username pattern: %w", err)
+		}
+		n.pattern = pattern
+	}
+
+	if config.Template != "" {
+		tmpl, err := template.New("username").Option("missingkey=error").Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid username template: %w", err)
+		}
+		n.tmpl = tmpl
+	}
+
+	return n, nil
+}
+
+type configuredNormalizer struct {
+	pattern     *regexp.Regexp
+	stripDomain bool
+	lowercase   bool
+	tmpl        *template.Template
+}
+
+// Normalize applies the configured steps to the identity
+func (n *configuredNormalizer) Normalize(identity string) (string, error) {
+	username := identity
+
+	if n.pattern != nil {
+		match := n.pattern.FindStringSubmatch(username)
+		if match == nil {
+			return "", fmt.Errorf("identity %q does not match username pattern", identity)
+		}
+		username = match[0]
+		if i := n.pattern.SubexpIndex("username"); i > 0 {
+			username = match[i]
+		} else if len(match) > 1 {
+			username = match[1]
+		}
+	}
+
+	if n.stripDomain {
+		if i := strings.Index(username, "@"); i >= 0 {
+			username = username[:i]
+		}
+	}
+
+	if n.lowercase {
+		username = strings.ToLower(username)
+	}
+
+	if n.tmpl != nil {
+		var buf bytes.Buffer
+		data := struct{ Username, Identity string }{Username: username, Identity: identity}
+		if err := n.tmpl.Execute(&buf, data); err != nil {
+			return "", fmt.Errorf("failed to render username template: %w", err)
+		}
+		username = buf.String()
+	}
+
+	if username == "" {
+		return "", fmt.Errorf("identity %q normalizes to an empty username", identity)
+	}
+
+	return username, nil
+}
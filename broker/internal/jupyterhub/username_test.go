@@ -0,0 +1,57 @@
+package jupyterhub
+
+import (
+	"testing"
+)
+
+func TestUsernameNormalizer_CILogonEmails(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   UsernameNormalizerConfig
+		identity string
+		want     string
+		wantErr  bool
+	}{
+		{name: "passthrough", config: UsernameNormalizerConfig{}, identity: "asmith@purdue.edu", want: "asmith@purdue.edu"},
+		{name: "strip domain", config: UsernameNormalizerConfig{StripDomain: true}, identity: "asmith@purdue.edu", want: "asmith"},
+		{name: "strip domain and lowercase", config: UsernameNormalizerConfig{StripDomain: true, Lowercase: true}, identity: "Alice.Smith@Purdue.EDU", want: "alice.smith"},
+		{name: "lowercase only", config: UsernameNormalizerConfig{Lowercase: true}, identity: "ASmith@FNAL.gov", want: "asmith@fnal.gov"},
+		{name: "strip plus tag with pattern", config: UsernameNormalizerConfig{Pattern: `^([^+@]+)`}, identity: "alice+cms@cern.ch", want: "alice"},
+		{name: "named group", config: UsernameNormalizerConfig{Pattern: `^(?P<username>[^@]+)@purdue\.edu$`}, identity: "asmith@purdue.edu", want: "asmith"},
+		{name: "pattern rejects other domains", config: UsernameNormalizerConfig{Pattern: `^(?P<username>[^@]+)@purdue\.edu$`}, identity: "asmith@cern.ch", wantErr: true},
+		{name: "template with domain suffix", config: UsernameNormalizerConfig{StripDomain: true, Lowercase: true, Template: "{{.Username}}-cms"}, identity: "ASmith@cern.ch", want: "asmith-cms"},
+		{name: "empty result", config: UsernameNormalizerConfig{StripDomain: true}, identity: "@purdue.edu", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalizer, err := NewUsernameNormalizer(tt.config)
+			if err != nil {
+				t.Fatalf("Expected no error creating normalizer, got %v", err)
+			}
+
+			got, err := normalizer.Normalize(tt.identity)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got username %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected username %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewUsernameNormalizer_InvalidConfig(t *testing.T) {
+	if _, err := NewUsernameNormalizer(UsernameNormalizerConfig{Pattern: "("}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	if _, err := NewUsernameNormalizer(UsernameNormalizerConfig{Template: "{{.Username"}); err == nil {
+		t.Error("Expected error for invalid template")
+	}
+}
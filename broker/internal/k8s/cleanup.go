@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// sessionLabels returns the labels applied to every resource backing a session ServiceAccount
+func sessionLabels(saName string) map[string]string {
+	return map[string]string{
+		managedByLabel: managedByValue,
+		sessionSALabel: saName,
+	}
+}
+
+// CleanupStaleResources deletes session ServiceAccounts, RoleBindings and Roles
+// across all namespaces that are older than minAge and whose ServiceAccount is
+// not reported as in use. A per-user ServiceAccount outlives its sessions, so
+// its resources are aged from the ServiceAccount's last-used annotation. A nil
+// inUse treats every such resource as stale; with minAge at the longest
+// session lifetime that is safe even while other brokers share the cluster,
+// since no live session can have created or last used older resources. It
+// returns the number of ServiceAccounts reclaimed, and keeps going past failed
+// deletions, returning them together.
+func (c *Client) CleanupStaleResources(ctx context.Context, minAge time.Duration, inUse func(namespace, saName string) bool) (int, error) {
+	listOptions := metav1.ListOptions{LabelSelector: sessionSelector}
+	createdBefore := time.Now().Add(-minAge)
+
+	serviceAccounts, err := c.clientset.CoreV1().ServiceAccounts(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list session service accounts: %w", err)
+	}
+
+	// Per-user ServiceAccounts used recently, keyed by namespace/name
+	recentlyUsed := make(map[string]bool)
+	for _, sa := range serviceAccounts.Items {
+		lastUsed, err := time.Parse(time.RFC3339, sa.Annotations[lastUsedAnnotation])
+		if err == nil && lastUsed.After(createdBefore) {
+			recentlyUsed[sa.Namespace+"/"+sa.Name] = true
+		}
+	}
+
+	isStale := func(object metav1.ObjectMeta) bool {
+		if object.CreationTimestamp.Time.After(createdBefore) {
+			return false
+		}
+		saName := object.Labels[sessionSALabel]
+		if recentlyUsed[object.Namespace+"/"+saName] {
+			return false
+		}
+		return inUse == nil || !inUse(object.Namespace, saName)
+	}
+
+	var errs []error
+	reclaimed := 0
+	for _, sa := range serviceAccounts.Items {
+		if !isStale(sa.ObjectMeta) {
+			continue
+		}
+		if err := c.DeleteServiceAccount(ctx, sa.Namespace, sa.Name); err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s: %w", sa.Namespace, sa.Name, err))
+			continue
+		}
+		reclaimed++
+	}
+
+	// Remove bindings and roles left behind by partially-created sessions
+	roleBindings, err := c.clientset.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return reclaimed, errors.Join(append(errs, fmt.Errorf("failed to list session role bindings: %w", err))...)
+	}
+	for _, rb := range roleBindings.Items {
+		if !isStale(rb.ObjectMeta) {
+			continue
+		}
+		err := c.clientset.RbacV1().RoleBindings(rb.Namespace).Delete(ctx, rb.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete role binding %s/%s: %w", rb.Namespace, rb.Name, err))
+		}
+	}
+
+	roles, err := c.clientset.RbacV1().Roles(metav1.NamespaceAll).List(ctx, listOptions)
+	if err != nil {
+		return reclaimed, errors.Join(append(errs, fmt.Errorf("failed to list session roles: %w", err))...)
+	}
+	for _, role := range roles.Items {
+		if !isStale(role.ObjectMeta) {
+			continue
+		}
+		err := c.clientset.RbacV1().Roles(role.Namespace).Delete(ctx, role.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to delete role %s/%s: %w", role.Namespace, role.Name, err))
+		}
+	}
+
+	return reclaimed, errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClient_CleanupStaleResources(t *testing.T) {
+	unrelated := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "user-alice"},
+	}
+	client := &Client{clientset: fake.NewSimpleClientset(unrelated), roleMode: RoleModePerSession}
+	ctx := context.Background()
+
+	sessions := []struct{ namespace, saName, podName string }{
+		{"user-alice", "vscode-sess-aaaa", "jupyter-alice"},
+		{"user-bob", "vscode-sess-bbbb", "jupyter-bob"},
+		{"user-bob", "vscode-sess-cccc", "jupyter-bob"},
+	}
+	for _, s := range sessions {
+		if err := client.CreateServiceAccount(ctx, s.namespace, s.saName); err != nil {
+			t.Fatalf("Expected no error creating service account, got %v", err)
+		}
+		if err := client.CreateRoleBinding(ctx, s.namespace, s.saName, s.podName); err != nil {
+			t.Fatalf("Expected no error creating role binding, got %v", err)
+		}
+	}
+
+	// Keep one session alive, as a persistent store would report
+	reclaimed, err := client.CleanupStaleResources(ctx, 0, func(namespace, saName string) bool {
+		return saName == "vscode-sess-cccc"
+	})
+	if err != nil {
+		t.Fatalf("Expected no error cleaning up, got %v", err)
+	}
+	if reclaimed != 2 {
+		t.Errorf("Expected 2 service accounts reclaimed, got %d", reclaimed)
+	}
+
+	if _, err := client.clientset.CoreV1().ServiceAccounts("user-bob").Get(ctx, "vscode-sess-cccc", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected in-use service account to survive, got %v", err)
+	}
+	if _, err := client.clientset.CoreV1().ServiceAccounts("user-alice").Get(ctx, "default", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected unrelated service account to survive, got %v", err)
+	}
+
+	bindings, _ := client.clientset.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if len(bindings.Items) != 1 || bindings.Items[0].Name != sessionRoleName("vscode-sess-cccc") {
+		t.Errorf("Expected only the in-use role binding to remain, got %d", len(bindings.Items))
+	}
+
+	// After an in-memory restart nothing is in use
+	reclaimed, err = client.CleanupStaleResources(ctx, 0, nil)
+	if err != nil {
+		t.Fatalf("Expected no error cleaning up, got %v", err)
+	}
+	if reclaimed != 1 {
+		t.Errorf("Expected 1 service account reclaimed, got %d", reclaimed)
+	}
+}
+
+func TestClient_CleanupStaleResourcesMinAge(t *testing.T) {
+	client := &Client{clientset: fake.NewSimpleClientset(), roleMode: RoleModePerSession}
+	ctx := context.Background()
+
+	for _, saName := range []string{"vscode-sess-old", "vscode-sess-new"} {
+		client.CreateServiceAccount(ctx, "user-alice", saName)
+	}
+	// The fake clientset leaves creation times unset, so set them by hand
+	for saName, created := range map[string]time.Time{
+		"vscode-sess-old": time.Now().Add(-2 * time.Hour),
+		"vscode-sess-new": time.Now().Add(-time.Minute),
+	} {
+		sa, _ := client.clientset.CoreV1().ServiceAccounts("user-alice").Get(ctx, saName, metav1.GetOptions{})
+		sa.CreationTimestamp = metav1.NewTime(created)
+		client.clientset.CoreV1().ServiceAccounts("user-alice").Update(ctx, sa, metav1.UpdateOptions{})
+	}
+
+	reclaimed, err := client.CleanupStaleResources(ctx, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Expected no error cleaning up, got %v", err)
+	}
+	if reclaimed != 1 {
+		t.Errorf("Expected 1 service account reclaimed, got %d", reclaimed)
+	}
+	if _, err := client.clientset.CoreV1().ServiceAccounts("user-alice").Get(ctx, "vscode-sess-new", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the service account younger than the minimum age to survive, got %v", err)
+	}
+}
+
+func TestClient_CleanupStaleResourcesReportsErrors(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := &Client{clientset: clientset, roleMode: RoleModePerSession}
+	ctx := context.Background()
+	client.CreateRoleBinding(ctx, "user-alice", "vscode-sess-aaaa", "jupyter-alice")
+
+	clientset.PrependReactor("delete", "roles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("forbidden")
+	})
+
+	_, err := client.CleanupStaleResources(ctx, 0, nil)
+	if err == nil || !strings.Contains(err.Error(), "forbidden") {
+		t.Fatalf("Expected the failed role deletion to be reported, got %v", err)
+	}
+
+	// Deletion continues past the failure
+	bindings, _ := clientset.RbacV1().RoleBindings(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if len(bindings.Items) != 0 {
+		t.Errorf("Expected the role binding to be deleted, got %d", len(bindings.Items))
+	}
+}
+
+func TestClient_CleanupStaleResourcesKeepsRecentlyUsedUserAccounts(t *testing.T) {
+	client := newPerUserTestClient()
+	ctx := context.Background()
+
+	creds, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error creating credentials, got %v", err)
+	}
+	// The account was created long ago but a session acquired it just now
+	sa, _ := client.clientset.CoreV1().ServiceAccounts("user-alice").Get(ctx, creds.ServiceAccount, metav1.GetOptions{})
+	sa.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+	client.clientset.CoreV1().ServiceAccounts("user-alice").Update(ctx, sa, metav1.UpdateOptions{})
+
+	reclaimed, err := client.CleanupStaleResources(ctx, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Expected no error cleaning up, got %v", err)
+	}
+	if reclaimed != 0 {
+		t.Errorf("Expected the recently used service account to survive, got %d reclaimed", reclaimed)
+	}
+	bindings, _ := client.clientset.RbacV1().RoleBindings("user-alice").List(ctx, metav1.ListOptions{})
+	if len(bindings.Items) == 0 {
+		t.Error("Expected the recently used service account's role bindings to survive")
+	}
+
+	// Once no session has used it for longer than minAge it is reclaimed
+	sa, _ = client.clientset.CoreV1().ServiceAccounts("user-alice").Get(ctx, creds.ServiceAccount, metav1.GetOptions{})
+	sa.Annotations[lastUsedAnnotation] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	client.clientset.CoreV1().ServiceAccounts("user-alice").Update(ctx, sa, metav1.UpdateOptions{})
+
+	reclaimed, err = client.CleanupStaleResources(ctx, time.Hour, nil)
+	if err != nil {
+		t.Fatalf("Expected no error cleaning up, got %v", err)
+	}
+	if reclaimed != 1 {
+		t.Errorf("Expected the unused service account to be reclaimed, got %d", reclaimed)
+	}
+}
@@ -2,17 +2,26 @@ package k8s
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/purdue-af/vscode-k8s-connector/internal/tracing"
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 	authenticationv1 "k8s.io/api/authentication/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 )
 
 // ClientInterface defines the interface for Kubernetes operations
@@ -23,8 +32,9 @@ type ClientInterface interface {
 	// CreateRoleBinding creates a RoleBinding for the ServiceAccount
 	CreateRoleBinding(ctx context.Context, namespace, saName, podName string) error
 
-	// MintToken creates a short-lived token for the ServiceAccount
-	MintToken(ctx context.Context, namespace, saName string, ttl int64) (string, error)
+	// MintToken creates a short-lived token for the ServiceAccount, returning
+	// it with the expiry the API server gave it
+	MintToken(ctx context.Context, namespace, saName string, ttl time.Duration) (string, time.Time, error)
 
 	// DeleteServiceAccount removes a ServiceAccount and its RoleBinding
 	DeleteServiceAccount(ctx context.Context, namespace, name string) error
@@ -32,52 +42,372 @@ type ClientInterface interface {
 	// GetPod retrieves pod information
 	GetPod(ctx context.Context, namespace, name string) (*types.PodInfo, error)
 
+	// GetPodEvents retrieves the pod's recent events, oldest first
+	GetPodEvents(ctx context.Context, namespace, name string) ([]types.PodEvent, error)
+
 	// CreateSessionServiceAccount creates a ServiceAccount and RoleBinding for a session
 	CreateSessionServiceAccount(ctx context.Context, namespace, podName string) (string, error)
+
+	// CreateSessionCredentials issues pod access credentials for a session using the configured access mode
+	CreateSessionCredentials(ctx context.Context, namespace, podName, userID string) (*SessionCredentials, error)
+
+	// RefreshSessionCredentials mints a new token for session credentials
+	// before their current one expires
+	RefreshSessionCredentials(ctx context.Context, namespace string, creds *SessionCredentials) (*SessionCredentials, error)
+
+	// ReleaseSessionCredentials removes any cluster resources backing session credentials
+	ReleaseSessionCredentials(ctx context.Context, namespace string, creds *SessionCredentials) error
+
+	// Exec runs a command in a pod using the session credentials
+	Exec(ctx context.Context, creds *SessionCredentials, opts ExecOptions) error
+
+	// CopyToPod extracts a tar archive into a directory in the pod
+	CopyToPod(ctx context.Context, creds *SessionCredentials, opts CopyOptions, destDir string, archive io.Reader) error
+
+	// CopyFromPod writes a tar archive of a path in the pod
+	CopyFromPod(ctx context.Context, creds *SessionCredentials, opts CopyOptions, srcPath string, archive io.Writer) error
+
+	// ListNamespaces returns the names of namespaces matching the label selector
+	ListNamespaces(ctx context.Context, labelSelector string) ([]string, error)
+
+	// FindUserPod returns the user's pod among those matching the label selector
+	FindUserPod(ctx context.Context, namespace, selector string) (*types.PodInfo, error)
+
+	// CreateDebugContainer adds an ephemeral debug container to a pod and returns its name
+	CreateDebugContainer(ctx context.Context, creds *SessionCredentials, namespace, podName, targetContainer string) (string, error)
+
+	// ReadEnvSources reads the referenced secrets and config maps into an exec environment
+	ReadEnvSources(ctx context.Context, creds *SessionCredentials, namespace string, refs []string) (map[string]string, error)
+
+	// GetVolumeMounts returns the volumes mounted into the pod's default container
+	GetVolumeMounts(ctx context.Context, namespace, name string) ([]types.VolumeMount, error)
+
+	// VerifyPodOwner checks the pod is still annotated as belonging to the
+	// JupyterHub user
+	VerifyPodOwner(ctx context.Context, namespace, name, owner string) error
+
+	// GetPodResourceUsage returns the pod's CPU and memory usage with its
+	// containers' requests and limits
+	GetPodResourceUsage(ctx context.Context, namespace, name string) (*types.ResourceUsage, error)
 }
 
+var (
+	// ErrNamespaceNotFound is returned when a session's namespace does not exist
+	ErrNamespaceNotFound = errors.New("namespace does not exist")
+
+	// ErrPodNotFound is returned when a session's pod does not exist
+	ErrPodNotFound = errors.New("pod does not exist")
+
+	// ErrMultiplePods is returned when a pod selector matches more than one
+	// running pod, so the user's pod cannot be told apart
+	ErrMultiplePods = errors.New("multiple running pods match")
+)
+
+// Role modes control how session Roles are laid out in a namespace
+const (
+	// RoleModePerSession creates one Role per session ServiceAccount, scoped to
+	// that session's pod
+	RoleModePerSession = "per-session"
+
+	// RoleModeShared reuses a single Role per namespace for the rules that do
+	// not name a pod, such as reading env sources, while each session still
+	// gets its own Role granting access to its pod only
+	RoleModeShared = "shared"
+
+	sharedRoleName = "vscode-session"
+)
+
+// Default client-side rate limits for API requests. client-go's own defaults
+// of 5 QPS and a burst of 10 suit controllers, not a broker minting tokens and
+// opening execs for many sessions at once.
+const (
+	DefaultQPS   = 50
+	DefaultBurst = 100
+)
+
+// Labels identifying resources the broker creates for sessions
+const (
+	managedByLabel  = "app.kubernetes.io/managed-by"
+	managedByValue  = "purdue-af-broker"
+	sessionSALabel  = "purdue-af.io/session-sa"
+	sessionSelector = managedByLabel + "=" + managedByValue + "," + sessionSALabel
+)
+
 // Client implements the k8s.ClientInterface interface
 type Client struct {
-	clientset *kubernetes.Clientset
+	clientset           kubernetes.Interface
+	config              *rest.Config
+	roleMode            string
+	accessMode          string
+	impersonationGroups []string
+	serviceAccountMode  string
+	userAccounts        userServiceAccounts
+	debugContainers     bool
+	debugImage          string
+
+	// sessionClientset builds a clientset acting with session credentials
+	sessionClientset func(creds *SessionCredentials) (kubernetes.Interface, error)
+
+	requiredPodLabels      []podRequirement
+	requiredPodAnnotations []podRequirement
+	podOwnerAnnotation     string
+
+	envSources envSources
+
+	deniedNamespaces map[string]bool
+
+	createNamespaces  bool
+	namespaceTemplate namespaceTemplate
+
+	// execProtocol selects the exec executor; spdyExecutor and
+	// websocketExecutor replace the client-go executors in tests
+	execProtocol         string
+	spdyExecutor         executorFunc
+	websocketExecutor    executorFunc
+	websocketUnsupported atomic.Bool
+
+	retryConfig RetryConfig
+	timeouts    TimeoutConfig
+	tokenTTL    TokenTTLConfig
+}
+
+// ClientConfig represents Kubernetes client configuration
+type ClientConfig struct {
+	KubeconfigPath      string
+	RoleMode            string
+	AccessMode          string
+	ImpersonationGroups []string
+
+	// ServiceAccountMode is per-session (default) or per-user
+	ServiceAccountMode string
+
+	// DebugContainers allows sessions to add ephemeral debug containers to
+	// their pod, running DebugImage (DefaultDebugImage if empty)
+	DebugContainers bool
+	DebugImage      string
+
+	// RequiredPodLabels and RequiredPodAnnotations list "key" or "key=value"
+	// entries a pod must carry before sessions are granted access to it
+	RequiredPodLabels      []string
+	RequiredPodAnnotations []string
+
+	// PodOwnerAnnotation names the annotation holding the JupyterHub user a
+	// pod was started for, such as DefaultPodOwnerAnnotation. Tunnels are
+	// refused once their session's pod no longer names its user. Empty
+	// disables the check.
+	PodOwnerAnnotation string
+
+	// ExecEnvSources lists the "secret/<name>" and "configmap/<name>" objects
+	// sessions may read into exec environments. Session Roles are extended to
+	// read exactly these; none are readable if empty.
+	ExecEnvSources []string
+
+	// DeniedNamespaces are namespaces no session may create resources in,
+	// exec into or read pods from, DefaultDeniedNamespaces if nil. The
+	// broker's own namespace is always denied.
+	DeniedNamespaces []string
+
+	// CreateNamespaces creates a missing user namespace before its session
+	// ServiceAccount, labelled with the "key=value" NamespaceLabels and, if
+	// NamespaceQuota lists "resource=quantity" limits, a ResourceQuota.
+	// Without it a missing namespace fails with ErrNamespaceNotFound.
+	CreateNamespaces bool
+	NamespaceLabels  []string
+	NamespaceQuota   []string
+
+	// ExecProtocol is ExecProtocolAuto (default), ExecProtocolWebSocket or
+	// ExecProtocolSPDY
+	ExecProtocol string
+
+	// QPS and Burst rate limit API requests on the client side,
+	// DefaultQPS and DefaultBurst if zero. Session clients inherit them.
+	QPS   float32
+	Burst int
+
+	// Retry bounds retries of ServiceAccount creation and token minting on
+	// transient API errors; unset fields take the Default* retry values
+	Retry RetryConfig
+
+	// Timeouts bound individual API operations; operations exceeding them fail
+	// with ErrTimeout
+	Timeouts TimeoutConfig
+
+	// TokenTTL sets the lifetime of minted ServiceAccount tokens, clamped to
+	// the cluster's bounds
+	TokenTTL TokenTTLConfig
 }
 
 // NewClient creates a new Kubernetes client
-func NewClient(kubeconfigPath string) (*Client, error) {
-	var config *rest.Config
-	var err error
+func NewClient(cfg ClientConfig) (*Client, error) {
+	roleMode := cfg.RoleMode
+	if roleMode == "" {
+		roleMode = RoleModePerSession
+	}
+	if roleMode != RoleModePerSession && roleMode != RoleModeShared {
+		return nil, fmt.Errorf("unknown role mode %q", roleMode)
+	}
 
-	if kubeconfigPath != "" {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfigPath)
-	} else {
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			// Fall back to default kubeconfig
-			config, err = clientcmd.BuildConfigFromFlags("", clientcmd.RecommendedHomeFile)
-		}
+	accessMode := cfg.AccessMode
+	if accessMode == "" {
+		accessMode = AccessModeServiceAccount
+	}
+	if accessMode != AccessModeServiceAccount && accessMode != AccessModeImpersonation {
+		return nil, fmt.Errorf("unknown access mode %q", accessMode)
+	}
+
+	serviceAccountMode := cfg.ServiceAccountMode
+	if serviceAccountMode == "" {
+		serviceAccountMode = ServiceAccountModePerSession
+	}
+	if serviceAccountMode != ServiceAccountModePerSession && serviceAccountMode != ServiceAccountModePerUser {
+		return nil, fmt.Errorf("unknown service account mode %q", serviceAccountMode)
+	}
+
+	execProtocol := cfg.ExecProtocol
+	if execProtocol == "" {
+		execProtocol = ExecProtocolAuto
+	}
+	if execProtocol != ExecProtocolAuto && execProtocol != ExecProtocolWebSocket && execProtocol != ExecProtocolSPDY {
+		return nil, fmt.Errorf("unknown exec protocol %q", execProtocol)
+	}
+
+	debugImage := cfg.DebugImage
+	if debugImage == "" {
+		debugImage = DefaultDebugImage
 	}
 
+	requiredPodLabels, err := parsePodRequirements(cfg.RequiredPodLabels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid required pod labels: %w", err)
+	}
+	requiredPodAnnotations, err := parsePodRequirements(cfg.RequiredPodAnnotations)
+	if err != nil {
+		return nil, fmt.Errorf("invalid required pod annotations: %w", err)
+	}
+
+	envSources, err := parseEnvSources(cfg.ExecEnvSources)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exec environment sources: %w", err)
+	}
+
+	namespaceTemplate, err := parseNamespaceTemplate(cfg.NamespaceLabels, cfg.NamespaceQuota)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.QPS < 0 || cfg.Burst < 0 {
+		return nil, fmt.Errorf("QPS and burst must not be negative")
+	}
+
+	if err := cfg.TokenTTL.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid token lifetime: %w", err)
+	}
+
+	config, err := loadRESTConfig(cfg.KubeconfigPath, clientcmd.RecommendedHomeFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create k8s config: %w", err)
 	}
 
+	// Trace API requests, including those made with session credentials
+	config.Wrap(tracing.Transport)
+
+	config.QPS = cfg.QPS
+	if config.QPS == 0 {
+		config.QPS = DefaultQPS
+	}
+	config.Burst = cfg.Burst
+	if config.Burst == 0 {
+		config.Burst = DefaultBurst
+	}
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create k8s clientset: %w", err)
 	}
 
-	return &Client{clientset: clientset}, nil
+	return &Client{
+		clientset:           clientset,
+		config:              config,
+		roleMode:            roleMode,
+		accessMode:          accessMode,
+		impersonationGroups: cfg.ImpersonationGroups,
+		serviceAccountMode:  serviceAccountMode,
+		debugContainers:     cfg.DebugContainers,
+		debugImage:          debugImage,
+
+		requiredPodLabels:      requiredPodLabels,
+		requiredPodAnnotations: requiredPodAnnotations,
+		podOwnerAnnotation:     cfg.PodOwnerAnnotation,
+
+		envSources: envSources,
+
+		deniedNamespaces: newNamespaceDenylist(cfg.DeniedNamespaces, brokerNamespace()),
+
+		createNamespaces:  cfg.CreateNamespaces,
+		namespaceTemplate: namespaceTemplate,
+
+		execProtocol: execProtocol,
+
+		retryConfig: cfg.Retry.withDefaults(),
+		timeouts:    cfg.Timeouts.withDefaults(),
+		tokenTTL:    cfg.TokenTTL.withDefaults(),
+	}, nil
+}
+
+// loadRESTConfig loads an explicit kubeconfig, which may list several paths
+// separated like $PATH and merged as kubectl does. Without one it tries the
+// in-cluster config, then fallbackPath, and reports why each attempt failed.
+func loadRESTConfig(kubeconfigPath, fallbackPath string) (*rest.Config, error) {
+	if kubeconfigPath != "" {
+		rules := &clientcmd.ClientConfigLoadingRules{Precedence: filepath.SplitList(kubeconfigPath)}
+		config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", kubeconfigPath, err)
+		}
+		return config, nil
+	}
+
+	config, inClusterErr := rest.InClusterConfig()
+	if inClusterErr == nil {
+		return config, nil
+	}
+
+	// Fall back to default kubeconfig
+	config, err := clientcmd.BuildConfigFromFlags("", fallbackPath)
+	if err != nil {
+		return nil, fmt.Errorf("in-cluster config failed (%v) and kubeconfig %s failed (%v); set KUBECONFIG or run in a pod",
+			inClusterErr, fallbackPath, err)
+	}
+	return config, nil
 }
 
 // CreateServiceAccount creates a ServiceAccount in the specified namespace
 func (c *Client) CreateServiceAccount(ctx context.Context, namespace, name string) error {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return err
+	}
+
 	sa := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
+			Labels:    sessionLabels(name),
 		},
 	}
 
-	_, err := c.clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+	retried := false
+	err := withTimeout(ctx, "creating service account", c.timeouts.CreateServiceAccount, func(ctx context.Context) error {
+		return c.retry(ctx, func() error {
+			_, err := c.clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+			// A timed-out attempt may have succeeded on the server
+			if retried && apierrors.IsAlreadyExists(err) {
+				return nil
+			}
+			retried = true
+			return err
+		})
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create service account: %w", err)
 	}
@@ -87,10 +417,49 @@ func (c *Client) CreateServiceAccount(ctx context.Context, namespace, name strin
 
 // CreateRoleBinding creates a RoleBinding for the ServiceAccount
 func (c *Client) CreateRoleBinding(ctx context.Context, namespace, saName, podName string) error {
-	roleBinding := &rbacv1.RoleBinding{
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return err
+	}
+
+	// Every step tolerates what an earlier attempt already created, so a
+	// timed-out attempt that succeeded on the server can be retried
+	return withTimeout(ctx, "creating role binding", c.timeouts.CreateRoleBinding, func(ctx context.Context) error {
+		return c.retry(ctx, func() error {
+			return c.createRoleBinding(ctx, namespace, saName, podName)
+		})
+	})
+}
+
+func (c *Client) createRoleBinding(ctx context.Context, namespace, saName, podName string) error {
+	roleName, err := c.ensureRole(ctx, namespace, saName, podName)
+	if err != nil {
+		return err
+	}
+	if err := c.ensureRoleBinding(ctx, namespace, sessionRoleName(saName), saName, roleName); err != nil {
+		return err
+	}
+
+	return c.ensureSharedRoleBinding(ctx, namespace, saName)
+}
+
+// ensureSharedRoleBinding grants saName the shared namespace Role in shared mode
+func (c *Client) ensureSharedRoleBinding(ctx context.Context, namespace, saName string) error {
+	if c.roleMode != RoleModeShared {
+		return nil
+	}
+	if err := c.ensureSharedRole(ctx, namespace); err != nil {
+		return err
+	}
+	return c.ensureRoleBinding(ctx, namespace, sharedRoleBindingName(saName), saName, sharedRoleName)
+}
+
+// sessionRoleBinding returns the RoleBinding name granting saName the Role roleName
+func sessionRoleBinding(namespace, name, saName, roleName string) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("vscode-session-%s", saName),
+			Name:      name,
 			Namespace: namespace,
+			Labels:    sessionLabels(saName),
 		},
 		Subjects: []rbacv1.Subject{
 			{
@@ -101,72 +470,317 @@ func (c *Client) CreateRoleBinding(ctx context.Context, namespace, saName, podNa
 		},
 		RoleRef: rbacv1.RoleRef{
 			Kind:     "Role",
-			Name:     "vscode-session",
+			Name:     roleName,
 			APIGroup: "rbac.authorization.k8s.io",
 		},
 	}
+}
+
+// ensureRoleBinding creates the RoleBinding name granting saName the Role
+// roleName. One left by an earlier attempt is reused once it is checked to
+// bind exactly that ServiceAccount and Role: drifted subjects are updated, and
+// a drifted Role, which the API does not allow changing, is bound by
+// recreating it.
+func (c *Client) ensureRoleBinding(ctx context.Context, namespace, name, saName, roleName string) error {
+	bindings := c.clientset.RbacV1().RoleBindings(namespace)
+	want := sessionRoleBinding(namespace, name, saName, roleName)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		_, err := bindings.Create(ctx, want, metav1.CreateOptions{})
+		if err == nil {
+			return nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create role binding: %w", err)
+		}
 
-	// Create the Role first
+		existing, err := bindings.Get(ctx, want.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			// Deleted since the create, try again
+			return apierrors.NewConflict(rbacv1.Resource("rolebindings"), want.Name, err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get role binding: %w", err)
+		}
+
+		if existing.RoleRef != want.RoleRef {
+			err := bindings.Delete(ctx, want.Name, metav1.DeleteOptions{
+				Preconditions: &metav1.Preconditions{UID: &existing.UID},
+			})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete drifted role binding: %w", err)
+			}
+			return apierrors.NewConflict(rbacv1.Resource("rolebindings"), want.Name, errors.New("recreating drifted role binding"))
+		}
+		if equality.Semantic.DeepEqual(existing.Subjects, want.Subjects) {
+			return nil
+		}
+
+		existing.Subjects = want.Subjects
+		if _, err := bindings.Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+			if apierrors.IsConflict(err) {
+				return err
+			}
+			return fmt.Errorf("failed to update role binding: %w", err)
+		}
+		return nil
+	})
+}
+
+// ensureRole makes sure the session's Role granting access to podName exists
+// and returns its name
+func (c *Client) ensureRole(ctx context.Context, namespace, saName, podName string) (string, error) {
+	roleName := sessionRoleName(saName)
 	role := &rbacv1.Role{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "vscode-session",
+			Name:      roleName,
 			Namespace: namespace,
+			Labels:    sessionLabels(saName),
 		},
-		Rules: []rbacv1.PolicyRule{
-			{
-				APIGroups: []string{""},
-				Resources: []string{"pods"},
-				Verbs:     []string{"get"},
-			},
-			{
-				APIGroups:     []string{""},
-				Resources:     []string{"pods/exec", "pods/portforward", "pods/log"},
-				Verbs:         []string{"create", "get"},
-				ResourceNames: []string{podName},
-			},
-		},
+		Rules: c.sessionRoleRules([]string{podName}),
 	}
 
-	_, err := c.clientset.RbacV1().Roles(namespace).Create(ctx, role, metav1.CreateOptions{})
+	roles := c.clientset.RbacV1().Roles(namespace)
+	_, err := roles.Create(ctx, role, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// Left by an earlier attempt for the same ServiceAccount, scope it to podName
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			existing, err := roles.Get(ctx, roleName, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			existing.Rules = role.Rules
+			_, err = roles.Update(ctx, existing, metav1.UpdateOptions{})
+			return err
+		})
+	}
 	if err != nil {
-		// Role might already exist, continue
+		return "", fmt.Errorf("failed to create role: %w", err)
 	}
 
-	_, err = c.clientset.RbacV1().RoleBindings(namespace).Create(ctx, roleBinding, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to create role binding: %w", err)
+	return roleName, nil
+}
+
+// ensureSharedRole creates the shared namespace Role if needed and keeps its
+// rules current. It never names a pod, so binding it to a session grants no
+// access to other sessions' pods.
+func (c *Client) ensureSharedRole(ctx context.Context, namespace string) error {
+	roles := c.clientset.RbacV1().Roles(namespace)
+	rules := c.sharedRoleRules()
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		role, err := roles.Get(ctx, sharedRoleName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			role = &rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      sharedRoleName,
+					Namespace: namespace,
+					Labels:    map[string]string{managedByLabel: managedByValue},
+				},
+				Rules: rules,
+			}
+			_, err = roles.Create(ctx, role, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost a creation race, retry as an update
+				return apierrors.NewConflict(rbacv1.Resource("roles"), sharedRoleName, err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create shared role: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get shared role: %w", err)
+		}
+		if equality.Semantic.DeepEqual(role.Rules, rules) {
+			return nil
+		}
+
+		role.Rules = rules
+		_, err = roles.Update(ctx, role, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// ensureRolePod adds podName to the named Role, creating it with labels if needed
+func (c *Client) ensureRolePod(ctx context.Context, namespace, roleName string, labels map[string]string, podName string) error {
+	roles := c.clientset.RbacV1().Roles(namespace)
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		role, err := roles.Get(ctx, roleName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			role = &rbacv1.Role{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      roleName,
+					Namespace: namespace,
+					Labels:    labels,
+				},
+				Rules: c.sessionRoleRules([]string{podName}),
+			}
+			_, err = roles.Create(ctx, role, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost a creation race, retry as an update
+				return apierrors.NewConflict(rbacv1.Resource("roles"), roleName, err)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to create role: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get role: %w", err)
+		}
+
+		podNames := rolePodNames(role)
+		for _, name := range podNames {
+			if name == podName {
+				return nil
+			}
+		}
+
+		role.Rules = c.sessionRoleRules(append(podNames, podName))
+		_, err = roles.Update(ctx, role, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// sessionRoleName returns the name shared by a session's Role and RoleBinding
+func sessionRoleName(saName string) string {
+	return fmt.Sprintf("vscode-session-%s", saName)
+}
+
+// sharedRoleBindingName returns the name of the RoleBinding granting a session
+// the shared namespace Role
+func sharedRoleBindingName(saName string) string {
+	return fmt.Sprintf("vscode-session-%s-shared", saName)
+}
+
+// sessionRoleRules returns the rules of a session's own Role, granting access
+// to the given pods. In shared mode the rules that name no pod are left to the
+// shared Role.
+func (c *Client) sessionRoleRules(podNames []string) []rbacv1.PolicyRule {
+	if c.roleMode == RoleModeShared {
+		return c.podRoleRules(podNames)
+	}
+	return append(c.podRoleRules(podNames), c.sharedRoleRules()...)
+}
+
+// sharedRoleRules returns the session rules that name no pod
+func (c *Client) sharedRoleRules() []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+			Verbs:     []string{"get"},
+		},
+	}
+	return append(rules, c.envSources.roleRules()...)
+}
+
+// podRoleRules returns the rules granting access to the given pods
+func (c *Client) podRoleRules(podNames []string) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"pods/exec", "pods/portforward", "pods/log"},
+			Verbs:         []string{"create", "get"},
+			ResourceNames: podNames,
+		},
 	}
 
+	if c.debugContainers {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"pods/ephemeralcontainers"},
+			Verbs:         []string{"get", "patch", "update"},
+			ResourceNames: podNames,
+		})
+	}
+
+	return rules
+}
+
+// rolePodNames returns the pod names a session Role is scoped to
+func rolePodNames(role *rbacv1.Role) []string {
+	for _, rule := range role.Rules {
+		for _, resource := range rule.Resources {
+			if resource == "pods/exec" {
+				return append([]string(nil), rule.ResourceNames...)
+			}
+		}
+	}
 	return nil
 }
 
-// MintToken creates a short-lived token for the ServiceAccount
-func (c *Client) MintToken(ctx context.Context, namespace, saName string, ttl int64) (string, error) {
+// MintToken creates a short-lived token for the ServiceAccount. The ttl is
+// clamped to the cluster's bounds, and the returned expiry is the API
+// server's, which may still differ from the lifetime requested.
+func (c *Client) MintToken(ctx context.Context, namespace, saName string, ttl time.Duration) (string, time.Time, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return "", time.Time{}, err
+	}
+
+	ttl, _ = c.tokenTTL.Clamp(ttl)
+	expirationSeconds := int64(ttl / time.Second)
 	tokenRequest := &authenticationv1.TokenRequest{
 		Spec: authenticationv1.TokenRequestSpec{
 			Audiences:         []string{"https://kubernetes.default.svc.cluster.local"},
-			ExpirationSeconds: &ttl,
+			ExpirationSeconds: &expirationSeconds,
 		},
 	}
 
-	tokenRequest, err := c.clientset.CoreV1().ServiceAccounts(namespace).CreateToken(
-		ctx, saName, tokenRequest, metav1.CreateOptions{})
+	var token string
+	var expiresAt time.Time
+	err := withTimeout(ctx, "minting token", c.timeouts.MintToken, func(ctx context.Context) error {
+		return c.retry(ctx, func() error {
+			requestedAt := time.Now()
+			result, err := c.clientset.CoreV1().ServiceAccounts(namespace).CreateToken(
+				ctx, saName, tokenRequest, metav1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+			token = result.Status.Token
+			expiresAt = result.Status.ExpirationTimestamp.Time
+			if expiresAt.IsZero() {
+				expiresAt = requestedAt.Add(ttl)
+			}
+			return nil
+		})
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to create token: %w", err)
 	}
 
-	return tokenRequest.Status.Token, nil
+	return token, expiresAt, nil
 }
 
 // DeleteServiceAccount removes a ServiceAccount and its RoleBinding
 func (c *Client) DeleteServiceAccount(ctx context.Context, namespace, name string) error {
+	return withTimeout(ctx, "deleting service account", c.timeouts.DeleteServiceAccount, func(ctx context.Context) error {
+		return c.deleteServiceAccount(ctx, namespace, name)
+	})
+}
+
+func (c *Client) deleteServiceAccount(ctx context.Context, namespace, name string) error {
 	// Delete RoleBinding first
-	roleBindingName := fmt.Sprintf("vscode-session-%s", name)
+	roleBindingName := sessionRoleName(name)
 	err := c.clientset.RbacV1().RoleBindings(namespace).Delete(ctx, roleBindingName, metav1.DeleteOptions{})
 	if err != nil {
 		// Log but don't fail - RoleBinding might not exist
 	}
 
+	// Only bound in shared mode, but removed regardless in case the mode changed
+	err = c.clientset.RbacV1().RoleBindings(namespace).Delete(ctx, sharedRoleBindingName(name), metav1.DeleteOptions{})
+	if err != nil {
+		// RoleBinding might not exist
+	}
+
+	// Session Roles share the RoleBinding's name; the shared Role is left in place
+	err = c.clientset.RbacV1().Roles(namespace).Delete(ctx, roleBindingName, metav1.DeleteOptions{})
+	if err != nil {
+		// Role might not exist
+	}
+
 	// Delete ServiceAccount
 	err = c.clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, name, metav1.DeleteOptions{})
 	if err != nil {
@@ -178,11 +792,101 @@ func (c *Client) DeleteServiceAccount(ctx context.Context, namespace, name strin
 
 // GetPod retrieves pod information
 func (c *Client) GetPod(ctx context.Context, namespace, name string) (*types.PodInfo, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+
+	var info *types.PodInfo
+	err := withTimeout(ctx, "getting pod", c.timeouts.GetPod, func(ctx context.Context) error {
+		var err error
+		info, err = c.getPod(ctx, namespace, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *Client) getPod(ctx context.Context, namespace, name string) (*types.PodInfo, error) {
 	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		// Tell a mis-resolved namespace apart from a missing pod
+		if nsErr := c.ensureNamespaceExists(ctx, namespace); errors.Is(nsErr, ErrNamespaceNotFound) {
+			return nil, nsErr
+		}
+		return nil, fmt.Errorf("%w: %s/%s", ErrPodNotFound, namespace, name)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pod: %w", err)
 	}
 
+	if err := c.checkPodAllowed(pod); err != nil {
+		return nil, err
+	}
+
+	return &types.PodInfo{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Status:    string(pod.Status.Phase),
+	}, nil
+}
+
+// FindUserPod returns the user's pod among those matching the label selector.
+// Terminating pods are ignored. A single running pod is preferred; without one,
+// the newest pod is returned with its phase, so the caller can report why it
+// is not running.
+func (c *Client) FindUserPod(ctx context.Context, namespace, selector string) (*types.PodInfo, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var running, candidates []*corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		candidates = append(candidates, pod)
+		if pod.Status.Phase == corev1.PodRunning {
+			running = append(running, pod)
+		}
+	}
+
+	var pod *corev1.Pod
+	switch {
+	case len(running) == 1:
+		pod = running[0]
+	case len(running) > 1:
+		names := make([]string, 0, len(running))
+		for _, p := range running {
+			names = append(names, p.Name)
+		}
+		return nil, fmt.Errorf("%w %q in %s: %v", ErrMultiplePods, selector, namespace, names)
+	case len(candidates) > 0:
+		pod = candidates[0]
+		for _, p := range candidates[1:] {
+			if p.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+				pod = p
+			}
+		}
+	default:
+		// Tell a mis-resolved namespace apart from a missing pod
+		if nsErr := c.ensureNamespaceExists(ctx, namespace); errors.Is(nsErr, ErrNamespaceNotFound) {
+			return nil, nsErr
+		}
+		return nil, fmt.Errorf("%w: no pod in %s matches %q", ErrPodNotFound, namespace, selector)
+	}
+
+	if err := c.checkPodAllowed(pod); err != nil {
+		return nil, err
+	}
+
 	return &types.PodInfo{
 		Name:      pod.Name,
 		Namespace: pod.Namespace,
@@ -190,30 +894,83 @@ func (c *Client) GetPod(ctx context.Context, namespace, name string) (*types.Pod
 	}, nil
 }
 
+// ListNamespaces returns the names of namespaces matching the label selector
+func (c *Client) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	namespaces, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	names := make([]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		names = append(names, ns.Name)
+	}
+	return names, nil
+}
+
+// ensureNamespaceExists returns an error if the namespace does not exist
+func (c *Client) ensureNamespaceExists(ctx context.Context, namespace string) error {
+	_, err := c.clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrNamespaceNotFound, namespace)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+	return nil
+}
+
 // CreateSessionServiceAccount creates a ServiceAccount and RoleBinding for a session
 func (c *Client) CreateSessionServiceAccount(ctx context.Context, namespace, podName string) (string, error) {
+	creds, err := c.createSessionServiceAccount(ctx, namespace, podName)
+	if err != nil {
+		return "", err
+	}
+	return creds.Token, nil
+}
+
+// createSessionServiceAccount creates a session ServiceAccount and returns
+// credentials with a token minted for it
+func (c *Client) createSessionServiceAccount(ctx context.Context, namespace, podName string) (*SessionCredentials, error) {
+	// Refuse to create anything in a namespace that was mis-resolved, unless
+	// user namespaces are created on demand
+	if err := c.ensureSessionNamespace(ctx, namespace); err != nil {
+		return nil, err
+	}
+
 	// Generate unique ServiceAccount name
 	saName := fmt.Sprintf("vscode-sess-%s", uuid.New().String()[:8])
 
 	// Create ServiceAccount
 	if err := c.CreateServiceAccount(ctx, namespace, saName); err != nil {
-		return "", fmt.Errorf("failed to create service account: %w", err)
+		return nil, fmt.Errorf("failed to create service account: %w", err)
 	}
 
 	// Create RoleBinding
 	if err := c.CreateRoleBinding(ctx, namespace, saName, podName); err != nil {
 		// Cleanup ServiceAccount if RoleBinding fails
-		c.DeleteServiceAccount(ctx, namespace, saName)
-		return "", fmt.Errorf("failed to create role binding: %w", err)
+		c.cleanupServiceAccount(ctx, namespace, saName)
+		return nil, fmt.Errorf("failed to create role binding: %w", err)
 	}
 
-	// Mint token (1 hour TTL)
-	token, err := c.MintToken(ctx, namespace, saName, 3600)
+	token, expiresAt, err := c.MintToken(ctx, namespace, saName, c.tokenTTL.requestTTL())
 	if err != nil {
 		// Cleanup if token creation fails
-		c.DeleteServiceAccount(ctx, namespace, saName)
-		return "", fmt.Errorf("failed to mint token: %w", err)
+		c.cleanupServiceAccount(ctx, namespace, saName)
+		return nil, fmt.Errorf("failed to mint token: %w", err)
 	}
 
-	return token, nil
+	return &SessionCredentials{ServiceAccount: saName, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// partialCleanupTimeout bounds removing resources left by a failed session setup
+const partialCleanupTimeout = 30 * time.Second
+
+// cleanupServiceAccount removes a partially created session ServiceAccount. It
+// ignores ctx cancellation, since setup commonly fails because ctx expired.
+func (c *Client) cleanupServiceAccount(ctx context.Context, namespace, saName string) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), partialCleanupTimeout)
+	defer cancel()
+
+	c.DeleteServiceAccount(cleanupCtx, namespace, saName)
 }
@@ -0,0 +1,378 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClient_CreateRoleBinding_ConcurrentSessionsSameNamespace(t *testing.T) {
+	for _, mode := range []string{RoleModePerSession, RoleModeShared} {
+		t.Run(mode, func(t *testing.T) {
+			client := &Client{clientset: fake.NewSimpleClientset(), roleMode: mode}
+			ctx := context.Background()
+
+			sessions := map[string]string{
+				"vscode-sess-aaaa": "jupyter-alice",
+				"vscode-sess-bbbb": "jupyter-bob",
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, len(sessions))
+			for saName, podName := range sessions {
+				wg.Add(1)
+				go func(saName, podName string) {
+					defer wg.Done()
+					errs <- client.CreateRoleBinding(ctx, "shared-ns", saName, podName)
+				}(saName, podName)
+			}
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				if err != nil {
+					t.Fatalf("Expected no error creating role binding, got %v", err)
+				}
+			}
+
+			for saName, podName := range sessions {
+				binding, err := client.clientset.RbacV1().RoleBindings("shared-ns").Get(
+					ctx, sessionRoleName(saName), metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Expected role binding for %s, got %v", saName, err)
+				}
+
+				role, err := client.clientset.RbacV1().Roles("shared-ns").Get(
+					ctx, binding.RoleRef.Name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Expected role %s, got %v", binding.RoleRef.Name, err)
+				}
+
+				if !containsString(rolePodNames(role), podName) {
+					t.Errorf("Expected role %s to grant access to %s, got %v",
+						role.Name, podName, rolePodNames(role))
+				}
+			}
+		})
+	}
+}
+
+func TestClient_CreateRoleBinding_SessionsCannotReachOtherPods(t *testing.T) {
+	for _, mode := range []string{RoleModePerSession, RoleModeShared} {
+		t.Run(mode, func(t *testing.T) {
+			client := &Client{clientset: fake.NewSimpleClientset(), roleMode: mode}
+			ctx := context.Background()
+
+			if err := client.CreateRoleBinding(ctx, "shared-ns", "vscode-sess-aaaa", "jupyter-alice"); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.CreateRoleBinding(ctx, "shared-ns", "vscode-sess-bbbb", "jupyter-bob"); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, resource := range []string{"pods/exec", "pods/portforward", "pods/log"} {
+				if !canAccessPod(t, client, "shared-ns", "vscode-sess-aaaa", resource, "jupyter-alice") {
+					t.Errorf("Expected session A to reach its own pod's %s", resource)
+				}
+				if canAccessPod(t, client, "shared-ns", "vscode-sess-aaaa", resource, "jupyter-bob") {
+					t.Errorf("Expected session A not to reach session B's pod's %s", resource)
+				}
+				if canAccessPod(t, client, "shared-ns", "vscode-sess-bbbb", resource, "jupyter-alice") {
+					t.Errorf("Expected session B not to reach session A's pod's %s", resource)
+				}
+			}
+		})
+	}
+}
+
+// canAccessPod evaluates the namespace's RoleBindings like the API server's
+// RBAC authorizer, reporting whether saName may create resource on podName
+func canAccessPod(t *testing.T, client *Client, namespace, saName, resource, podName string) bool {
+	t.Helper()
+	ctx := context.Background()
+
+	bindings, err := client.clientset.RbacV1().RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, binding := range bindings.Items {
+		bound := false
+		for _, subject := range binding.Subjects {
+			bound = bound || (subject.Kind == "ServiceAccount" && subject.Name == saName && subject.Namespace == namespace)
+		}
+		if !bound {
+			continue
+		}
+
+		role, err := client.clientset.RbacV1().Roles(namespace).Get(ctx, binding.RoleRef.Name, metav1.GetOptions{})
+		if err != nil {
+			continue
+		}
+		for _, rule := range role.Rules {
+			if containsString(rule.Resources, resource) && containsString(rule.Verbs, "create") &&
+				(len(rule.ResourceNames) == 0 || containsString(rule.ResourceNames, podName)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestClient_CreateRoleBinding_PerSessionIsolation(t *testing.T) {
+	client := &Client{clientset: fake.NewSimpleClientset(), roleMode: RoleModePerSession}
+	ctx := context.Background()
+
+	if err := client.CreateRoleBinding(ctx, "shared-ns", "vscode-sess-aaaa", "jupyter-alice"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := client.CreateRoleBinding(ctx, "shared-ns", "vscode-sess-bbbb", "jupyter-bob"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	role, err := client.clientset.RbacV1().Roles("shared-ns").Get(
+		ctx, sessionRoleName("vscode-sess-aaaa"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected per-session role, got %v", err)
+	}
+
+	podNames := rolePodNames(role)
+	if len(podNames) != 1 || podNames[0] != "jupyter-alice" {
+		t.Errorf("Expected role scoped to jupyter-alice only, got %v", podNames)
+	}
+
+	if err := client.CreateServiceAccount(ctx, "shared-ns", "vscode-sess-aaaa"); err != nil {
+		t.Fatalf("Expected no error creating service account, got %v", err)
+	}
+	if err := client.DeleteServiceAccount(ctx, "shared-ns", "vscode-sess-aaaa"); err != nil {
+		t.Fatalf("Expected no error deleting service account, got %v", err)
+	}
+
+	_, err = client.clientset.RbacV1().Roles("shared-ns").Get(
+		ctx, sessionRoleName("vscode-sess-aaaa"), metav1.GetOptions{})
+	if err == nil {
+		t.Error("Expected per-session role to be deleted with its service account")
+	}
+}
+
+func TestClient_CreateRoleBinding_AlreadyExists(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *rbacv1.RoleBinding
+	}{
+		{
+			name:     "matching",
+			existing: sessionRoleBinding("shared-ns", sessionRoleName("vscode-sess-aaaa"), "vscode-sess-aaaa", sessionRoleName("vscode-sess-aaaa")),
+		},
+		{
+			name: "drifted subjects",
+			existing: func() *rbacv1.RoleBinding {
+				binding := sessionRoleBinding("shared-ns", sessionRoleName("vscode-sess-aaaa"), "vscode-sess-aaaa", sessionRoleName("vscode-sess-aaaa"))
+				binding.Subjects[0].Name = "vscode-sess-other"
+				binding.Subjects = append(binding.Subjects, rbacv1.Subject{Kind: "User", Name: "mallory"})
+				return binding
+			}(),
+		},
+		{
+			name: "drifted role",
+			existing: func() *rbacv1.RoleBinding {
+				binding := sessionRoleBinding("shared-ns", sessionRoleName("vscode-sess-aaaa"), "vscode-sess-aaaa", "cluster-admin-ish")
+				binding.RoleRef.Kind = "ClusterRole"
+				return binding
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{clientset: fake.NewSimpleClientset(tt.existing), roleMode: RoleModePerSession}
+			ctx := context.Background()
+
+			if err := client.CreateRoleBinding(ctx, "shared-ns", "vscode-sess-aaaa", "jupyter-alice"); err != nil {
+				t.Fatalf("Expected an existing role binding to be reused, got %v", err)
+			}
+
+			binding, err := client.clientset.RbacV1().RoleBindings("shared-ns").Get(
+				ctx, sessionRoleName("vscode-sess-aaaa"), metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected role binding, got %v", err)
+			}
+			want := sessionRoleBinding("shared-ns", sessionRoleName("vscode-sess-aaaa"), "vscode-sess-aaaa", sessionRoleName("vscode-sess-aaaa"))
+			if !reflect.DeepEqual(binding.Subjects, want.Subjects) {
+				t.Errorf("Expected subjects %v, got %v", want.Subjects, binding.Subjects)
+			}
+			if binding.RoleRef != want.RoleRef {
+				t.Errorf("Expected role ref %v, got %v", want.RoleRef, binding.RoleRef)
+			}
+		})
+	}
+}
+
+func TestClient_CreateSessionServiceAccount_RetryAfterPartialFailure(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "user-alice"}})
+	failTokenRequests(clientset, 0, nil)
+
+	// The first role binding request times out after the server stored it,
+	// leaving the Role and RoleBinding for the retry to find
+	bindingAttempts := 0
+	clientset.PrependReactor("create", "rolebindings", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		bindingAttempts++
+		if bindingAttempts > 1 {
+			return false, nil, nil
+		}
+		create := action.(k8stesting.CreateAction)
+		if err := clientset.Tracker().Create(action.GetResource(), create.GetObject(), action.GetNamespace()); err != nil {
+			t.Fatal(err)
+		}
+		return true, nil, apierrors.NewServerTimeout(rbacv1.Resource("rolebindings"), "create", 1)
+	})
+
+	client := &Client{clientset: clientset, roleMode: RoleModePerSession, retryConfig: testRetryConfig}
+	ctx := context.Background()
+
+	token, err := client.CreateSessionServiceAccount(ctx, "user-alice", "jupyter-alice")
+	if err != nil {
+		t.Fatalf("Expected session setup to survive the retry, got %v", err)
+	}
+	if token != "minted" {
+		t.Errorf("Expected minted token, got %q", token)
+	}
+	if bindingAttempts != 2 {
+		t.Errorf("Expected the role binding to be retried once, got %d attempts", bindingAttempts)
+	}
+
+	serviceAccounts, err := clientset.CoreV1().ServiceAccounts("user-alice").List(ctx, metav1.ListOptions{})
+	if err != nil || len(serviceAccounts.Items) != 1 {
+		t.Fatalf("Expected the session ServiceAccount to be kept, got %v (%v)", serviceAccounts, err)
+	}
+	saName := serviceAccounts.Items[0].Name
+	binding, err := clientset.RbacV1().RoleBindings("user-alice").Get(ctx, sessionRoleName(saName), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected role binding, got %v", err)
+	}
+	if len(binding.Subjects) != 1 || binding.Subjects[0].Name != saName {
+		t.Errorf("Expected role binding for %s, got %v", saName, binding.Subjects)
+	}
+	role, err := clientset.RbacV1().Roles("user-alice").Get(ctx, binding.RoleRef.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected role, got %v", err)
+	}
+	if podNames := rolePodNames(role); len(podNames) != 1 || podNames[0] != "jupyter-alice" {
+		t.Errorf("Expected role scoped to jupyter-alice, got %v", podNames)
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClient_GetPod_NotFound(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "user-alice"}}
+	client := &Client{clientset: fake.NewSimpleClientset(namespace, testPod(nil, nil))}
+	ctx := context.Background()
+
+	if _, err := client.GetPod(ctx, "user-alice", "jupyter-alice"); err != nil {
+		t.Fatalf("Expected pod to be found, got %v", err)
+	}
+
+	if _, err := client.GetPod(ctx, "user-alice", "jupyter-bob"); !errors.Is(err, ErrPodNotFound) {
+		t.Errorf("Expected ErrPodNotFound, got %v", err)
+	}
+
+	if _, err := client.GetPod(ctx, "user-bob", "jupyter-bob"); !errors.Is(err, ErrNamespaceNotFound) {
+		t.Errorf("Expected ErrNamespaceNotFound, got %v", err)
+	}
+}
+
+func TestClient_FindUserPod(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "user-alice"}}
+	userPod := func(name string, phase corev1.PodPhase, created time.Time) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "user-alice",
+				Labels:            map[string]string{"hub.jupyter.org/username": "alice"},
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	now := time.Now()
+	selector := "hub.jupyter.org/username=alice"
+
+	tests := []struct {
+		name    string
+		pods    []runtime.Object
+		wantPod string
+		wantErr error
+	}{
+		{
+			name:    "single running pod",
+			pods:    []runtime.Object{userPod("jupyter-alice-x7k2", corev1.PodRunning, now)},
+			wantPod: "jupyter-alice-x7k2",
+		},
+		{
+			name: "running pod preferred over newer pending one",
+			pods: []runtime.Object{
+				userPod("jupyter-alice-old", corev1.PodRunning, now.Add(-time.Hour)),
+				userPod("jupyter-alice-new", corev1.PodPending, now),
+			},
+			wantPod: "jupyter-alice-old",
+		},
+		{
+			name: "newest pod without a running one",
+			pods: []runtime.Object{
+				userPod("jupyter-alice-old", corev1.PodFailed, now.Add(-time.Hour)),
+				userPod("jupyter-alice-new", corev1.PodPending, now),
+			},
+			wantPod: "jupyter-alice-new",
+		},
+		{
+			name: "multiple running pods",
+			pods: []runtime.Object{
+				userPod("jupyter-alice-a", corev1.PodRunning, now),
+				userPod("jupyter-alice-b", corev1.PodRunning, now),
+			},
+			wantErr: ErrMultiplePods,
+		},
+		{
+			name:    "no matching pod",
+			wantErr: ErrPodNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{clientset: fake.NewSimpleClientset(append(tt.pods, namespace)...)}
+
+			pod, err := client.FindUserPod(context.Background(), "user-alice", selector)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if pod.Name != tt.wantPod {
+				t.Errorf("Expected pod %s, got %s", tt.wantPod, pod.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package k8s
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKubeconfigClusters = `apiVersion: v1
+kind: Config
+clusters:
+- name: purdue
+  cluster:
+    server: https://purdue.example.com
+current-context: purdue
+contexts:
+- name: purdue
+  context:
+    cluster: purdue
+    user: broker
+`
+
+const testKubeconfigUsers = `apiVersion: v1
+kind: Config
+users:
+- name: broker
+  user:
+    token: broker-token
+`
+
+func TestLoadRESTConfig_MergesMultiplePaths(t *testing.T) {
+	dir := t.TempDir()
+	clusters := filepath.Join(dir, "clusters.yaml")
+	users := filepath.Join(dir, "users.yaml")
+	os.WriteFile(clusters, []byte(testKubeconfigClusters), 0o600)
+	os.WriteFile(users, []byte(testKubeconfigUsers), 0o600)
+
+	config, err := loadRESTConfig(clusters+string(filepath.ListSeparator)+users, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if config.Host != "https://purdue.example.com" {
+		t.Errorf("Expected host from first file, got %s", config.Host)
+	}
+	if config.BearerToken != "broker-token" {
+		t.Errorf("Expected token from second file, got %q", config.BearerToken)
+	}
+}
+
+func TestLoadRESTConfig_ReportsBothFailures(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	fallback := filepath.Join(t.TempDir(), "missing-config")
+
+	_, err := loadRESTConfig("", fallback)
+	if err == nil {
+		t.Fatal("Expected error when no config is available")
+	}
+	if !strings.Contains(err.Error(), "in-cluster config failed") || !strings.Contains(err.Error(), fallback) {
+		t.Errorf("Expected both attempts in error, got %v", err)
+	}
+}
+
+func TestLoadRESTConfig_ExplicitPathError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing-config")
+
+	_, err := loadRESTConfig(path, "")
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("Expected error naming %s, got %v", path, err)
+	}
+}
+
+func TestNewClient_RateLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte(testKubeconfigClusters), 0o600)
+
+	tests := []struct {
+		name      string
+		qps       float32
+		burst     int
+		wantQPS   float32
+		wantBurst int
+	}{
+		{name: "defaults", wantQPS: DefaultQPS, wantBurst: DefaultBurst},
+		{name: "configured", qps: 200, burst: 400, wantQPS: 200, wantBurst: 400},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(ClientConfig{KubeconfigPath: path, QPS: tt.qps, Burst: tt.burst})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if client.config.QPS != tt.wantQPS || client.config.Burst != tt.wantBurst {
+				t.Errorf("Expected QPS %v and burst %d, got %v and %d",
+					tt.wantQPS, tt.wantBurst, client.config.QPS, client.config.Burst)
+			}
+		})
+	}
+
+	if _, err := NewClient(ClientConfig{KubeconfigPath: path, QPS: -1}); err == nil {
+		t.Error("Expected error for negative QPS")
+	}
+}
@@ -0,0 +1,140 @@
+package k8s
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotRegularFile is returned when a single-file copy finds a directory or
+// other non-regular, non-symlink entry
+var ErrNotRegularFile = errors.New("not a regular file")
+
+// ErrFileTooLarge is returned when a single-file copy holds more than the
+// allowed size
+var ErrFileTooLarge = errors.New("file too large")
+
+// CopyOptions identifies the container files are copied to or from
+type CopyOptions struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+// ArchivedFile is a single file read from or written to a pod
+type ArchivedFile struct {
+	Name    string
+	Mode    int64
+	Content []byte
+	// LinkTarget is set, with no content, when the file is a symlink
+	LinkTarget string
+}
+
+// CopyToPod extracts a tar archive into destDir in the pod, like kubectl cp:
+// tar runs in the pod reading the archive from stdin. File modes and symlinks
+// in the archive are recreated; modification times are set to now.
+func (c *Client) CopyToPod(ctx context.Context, creds *SessionCredentials, opts CopyOptions, destDir string, archive io.Reader) error {
+	var stderr bytes.Buffer
+	err := c.Exec(ctx, creds, ExecOptions{
+		Namespace: opts.Namespace,
+		Pod:       opts.Pod,
+		Container: opts.Container,
+		Command:   []string{"tar", "-xmf", "-", "-C", destDir},
+		Stdin:     archive,
+		Stdout:    io.Discard,
+		Stderr:    &stderr,
+	})
+	return copyError(err, "tar", &stderr)
+}
+
+// CopyFromPod writes a tar archive of srcPath in the pod to archive, like
+// kubectl cp. Symlinks are archived as links rather than followed. Entries are
+// named relative to srcPath's parent directory.
+func (c *Client) CopyFromPod(ctx context.Context, creds *SessionCredentials, opts CopyOptions, srcPath string, archive io.Writer) error {
+	var stderr bytes.Buffer
+	err := c.Exec(ctx, creds, ExecOptions{
+		Namespace: opts.Namespace,
+		Pod:       opts.Pod,
+		Container: opts.Container,
+		Command:   []string{"tar", "-cf", "-", "-C", path.Dir(srcPath), path.Base(srcPath)},
+		Stdout:    archive,
+		Stderr:    &stderr,
+	})
+	return copyError(err, "tar", &stderr)
+}
+
+// copyError adds the pod's stderr to a failed tar, which explains missing
+// files and permission errors
+func copyError(err error, command string, stderr *bytes.Buffer) error {
+	if err == nil {
+		return nil
+	}
+	if _, exited := ExitCode(err); exited {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s failed in pod: %s", command, msg)
+		}
+	}
+	return fmt.Errorf("%s failed in pod: %w", command, err)
+}
+
+// WriteFileArchive writes a tar archive holding the single file
+func WriteFileArchive(w io.Writer, file ArchivedFile) error {
+	header := &tar.Header{
+		Name:    file.Name,
+		Mode:    file.Mode,
+		ModTime: time.Now(),
+	}
+	if file.LinkTarget != "" {
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = file.LinkTarget
+	} else {
+		header.Typeflag = tar.TypeReg
+		header.Size = int64(len(file.Content))
+	}
+
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive header: %w", err)
+	}
+	if _, err := tw.Write(file.Content); err != nil {
+		return fmt.Errorf("failed to write archive content: %w", err)
+	}
+	return tw.Close()
+}
+
+// ReadFileArchive reads the single file in a tar archive produced by
+// CopyFromPod for a file path, reporting ErrNotRegularFile for directories.
+// A file over maxSize bytes fails with ErrFileTooLarge before its content is
+// read; maxSize 0 means no limit.
+func ReadFileArchive(r io.Reader, maxSize int64) (*ArchivedFile, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("archive is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	file := &ArchivedFile{Name: header.Name, Mode: header.Mode}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		if maxSize > 0 && header.Size > maxSize {
+			return nil, fmt.Errorf("%w: %s is %d bytes, the limit is %d", ErrFileTooLarge, header.Name, header.Size, maxSize)
+		}
+		if file.Content, err = io.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("failed to read archive content: %w", err)
+		}
+	case tar.TypeSymlink:
+		file.LinkTarget = header.Linkname
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotRegularFile, header.Name)
+	}
+	return file, nil
+}
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestFileArchive_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		file ArchivedFile
+	}{
+		{
+			name: "binary file",
+			file: ArchivedFile{Name: "model.bin", Mode: 0600, Content: []byte{0x00, 0xff, 0xfe, '\n', 0x80}},
+		},
+		{
+			name: "executable script",
+			file: ArchivedFile{Name: "run.sh", Mode: 0755, Content: []byte("#!/bin/sh\necho hi\n")},
+		},
+		{
+			name: "empty file",
+			file: ArchivedFile{Name: "empty", Mode: 0644, Content: []byte{}},
+		},
+		{
+			name: "symlink",
+			file: ArchivedFile{Name: "data", Mode: 0777, LinkTarget: "/scratch/alice/data"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteFileArchive(&buf, tt.file); err != nil {
+				t.Fatalf("Expected no error writing archive, got %v", err)
+			}
+
+			file, err := ReadFileArchive(&buf, 0)
+			if err != nil {
+				t.Fatalf("Expected no error reading archive, got %v", err)
+			}
+			if file.Name != tt.file.Name || file.Mode != tt.file.Mode || file.LinkTarget != tt.file.LinkTarget {
+				t.Errorf("Expected %s mode %o link %q, got %s mode %o link %q", tt.file.Name, tt.file.Mode,
+					tt.file.LinkTarget, file.Name, file.Mode, file.LinkTarget)
+			}
+			if !bytes.Equal(file.Content, tt.file.Content) {
+				t.Errorf("Expected content %q, got %q", tt.file.Content, file.Content)
+			}
+		})
+	}
+}
+
+func TestReadFileArchive_Directory(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.Close()
+
+	if _, err := ReadFileArchive(&buf, 0); !errors.Is(err, ErrNotRegularFile) {
+		t.Errorf("Expected ErrNotRegularFile, got %v", err)
+	}
+
+	if _, err := ReadFileArchive(&bytes.Buffer{}, 0); err == nil {
+		t.Error("Expected error for empty archive")
+	}
+}
+
+func TestReadFileArchive_MaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	WriteFileArchive(&buf, ArchivedFile{Name: "data.bin", Mode: 0644, Content: make([]byte, 1024)})
+	archive := buf.Bytes()
+
+	if _, err := ReadFileArchive(bytes.NewReader(archive), 1023); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("Expected ErrFileTooLarge over the limit, got %v", err)
+	}
+	if file, err := ReadFileArchive(bytes.NewReader(archive), 1024); err != nil || len(file.Content) != 1024 {
+		t.Errorf("Expected a file at the limit to be read, got %v", err)
+	}
+}
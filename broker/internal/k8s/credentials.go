@@ -0,0 +1,185 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+// Access modes control how sessions are granted access to their pod
+const (
+	// AccessModeServiceAccount mints a token for a per-session ServiceAccount
+	// bound to a pod-scoped Role
+	AccessModeServiceAccount = "serviceaccount"
+
+	// AccessModeImpersonation uses the broker's own credentials while
+	// impersonating the session user, leaving authorization to cluster RBAC.
+	// The cluster does not scope that to the session's pod, so the broker
+	// refuses to use the credentials on any other pod.
+	AccessModeImpersonation = "impersonation"
+
+	// impersonationPodExtra is the Impersonate-Extra key carrying the target
+	// pod. It only labels requests in the API server's audit log; RBAC
+	// ignores extras, so scoping is left to SessionCredentials.checkPod.
+	impersonationPodExtra = "purdue-af.io/pod"
+)
+
+// ErrPodNotPermitted is returned when session credentials are used on a pod
+// other than the one they were issued for
+var ErrPodNotPermitted = errors.New("session credentials do not grant access to this pod")
+
+// SessionCredentials represents the credentials a tunnel uses to reach its pod
+type SessionCredentials struct {
+	// ServiceAccount is the per-session ServiceAccount, empty in impersonation mode
+	ServiceAccount string
+
+	// Token is the bearer token minted for ServiceAccount, empty in impersonation mode
+	Token string
+
+	// ExpiresAt is when Token expires as reported by the API server, zero in
+	// impersonation mode
+	ExpiresAt time.Time
+
+	// Impersonate is the identity to impersonate, empty in serviceaccount mode
+	Impersonate rest.ImpersonationConfig
+
+	// Namespace and Pod are the pod the credentials were issued for. The
+	// broker uses them on no other pod.
+	Namespace string
+	Pod       string
+}
+
+// checkPod refuses to use the credentials on any pod but the one they were
+// issued for, or, with an empty pod, in any other namespace. ServiceAccount
+// tokens are also scoped by their Role; impersonation relies on this alone.
+func (creds *SessionCredentials) checkPod(namespace, pod string) error {
+	if creds.Impersonate.UserName == "" && creds.Pod == "" {
+		return nil
+	}
+	if namespace != creds.Namespace || (pod != "" && pod != creds.Pod) {
+		return fmt.Errorf("%w: %s/%s", ErrPodNotPermitted, namespace, pod)
+	}
+	return nil
+}
+
+// CreateSessionCredentials issues pod access credentials for a session using the configured access mode
+func (c *Client) CreateSessionCredentials(ctx context.Context, namespace, podName, userID string) (*SessionCredentials, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+	if err := c.ensurePodAllowed(ctx, namespace, podName); err != nil {
+		return nil, err
+	}
+
+	if c.accessMode == AccessModeImpersonation {
+		return &SessionCredentials{
+			Impersonate: rest.ImpersonationConfig{
+				UserName: userID,
+				Groups:   c.impersonationGroups,
+				Extra: map[string][]string{
+					impersonationPodExtra: {namespace + "/" + podName},
+				},
+			},
+			Namespace: namespace,
+			Pod:       podName,
+		}, nil
+	}
+
+	var creds *SessionCredentials
+	var err error
+	if c.serviceAccountMode == ServiceAccountModePerUser {
+		creds, err = c.acquireUserServiceAccount(ctx, namespace, podName, userID)
+	} else {
+		creds, err = c.createSessionServiceAccount(ctx, namespace, podName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	creds.Namespace = namespace
+	creds.Pod = podName
+	return creds, nil
+}
+
+// RefreshSessionCredentials mints a new token for the credentials'
+// ServiceAccount, returning new credentials. Impersonation credentials do not
+// expire and are returned as they are.
+func (c *Client) RefreshSessionCredentials(ctx context.Context, namespace string, creds *SessionCredentials) (*SessionCredentials, error) {
+	if creds.ServiceAccount == "" {
+		return creds, nil
+	}
+
+	token, expiresAt, err := c.MintToken(ctx, namespace, creds.ServiceAccount, c.tokenTTL.requestTTL())
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint token: %w", err)
+	}
+
+	refreshed := *creds
+	refreshed.Token = token
+	refreshed.ExpiresAt = expiresAt
+	return &refreshed, nil
+}
+
+// ReleaseSessionCredentials removes any cluster resources backing session credentials.
+// Per-user ServiceAccounts are only removed when the user's last session releases them.
+func (c *Client) ReleaseSessionCredentials(ctx context.Context, namespace string, creds *SessionCredentials) error {
+	if creds == nil || creds.ServiceAccount == "" {
+		return nil
+	}
+
+	if c.serviceAccountMode == ServiceAccountModePerUser {
+		return c.releaseUserServiceAccount(ctx, namespace, creds.ServiceAccount)
+	}
+
+	return c.DeleteServiceAccount(ctx, namespace, creds.ServiceAccount)
+}
+
+// RESTConfigFor returns a rest.Config that authenticates with the session credentials
+func (c *Client) RESTConfigFor(creds *SessionCredentials) *rest.Config {
+	if creds.Token != "" {
+		config := rest.AnonymousClientConfig(c.config)
+		config.BearerToken = creds.Token
+		return config
+	}
+
+	config := rest.CopyConfig(c.config)
+	config.Impersonate = creds.Impersonate
+	return config
+}
+
+// ValidateAccessMode checks the broker's own RBAC permits the configured access mode
+func (c *Client) ValidateAccessMode(ctx context.Context) error {
+	if c.accessMode != AccessModeImpersonation {
+		return nil
+	}
+
+	required := []authorizationv1.ResourceAttributes{
+		{Verb: "impersonate", Resource: "users"},
+		{Verb: "impersonate", Group: "authentication.k8s.io", Resource: "userextras", Subresource: impersonationPodExtra},
+	}
+	for _, group := range c.impersonationGroups {
+		required = append(required, authorizationv1.ResourceAttributes{Verb: "impersonate", Resource: "groups", Name: group})
+	}
+
+	for i := range required {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &required[i],
+			},
+		}
+
+		result, err := c.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to check impersonation permissions: %w", err)
+		}
+		if !result.Status.Allowed {
+			return fmt.Errorf("broker is not permitted to impersonate %s", required[i].Resource)
+		}
+	}
+
+	return nil
+}
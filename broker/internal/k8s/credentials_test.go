@@ -0,0 +1,126 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestClient_CreateSessionCredentials_Impersonation(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := &Client{
+		clientset:           clientset,
+		accessMode:          AccessModeImpersonation,
+		impersonationGroups: []string{"purdue-af:users"},
+	}
+
+	creds, err := client.CreateSessionCredentials(context.Background(), "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if creds.ServiceAccount != "" || creds.Token != "" {
+		t.Errorf("Expected no ServiceAccount credentials in impersonation mode, got %+v", creds)
+	}
+	if creds.Impersonate.UserName != "alice@purdue.edu" {
+		t.Errorf("Expected to impersonate alice@purdue.edu, got %s", creds.Impersonate.UserName)
+	}
+	if pods := creds.Impersonate.Extra[impersonationPodExtra]; len(pods) != 1 || pods[0] != "user-alice/jupyter-alice" {
+		t.Errorf("Expected requests labelled with user-alice/jupyter-alice, got %v", pods)
+	}
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" {
+			t.Errorf("Expected no resources to be created, got %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestClient_ImpersonationScopedToPod(t *testing.T) {
+	client := &Client{clientset: fake.NewSimpleClientset(), accessMode: AccessModeImpersonation}
+	ctx := context.Background()
+
+	creds, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := creds.checkPod("user-alice", "jupyter-alice"); err != nil {
+		t.Errorf("Expected the session's own pod to be allowed, got %v", err)
+	}
+
+	others := map[string]error{
+		"Exec other pod":       client.Exec(ctx, creds, ExecOptions{Namespace: "user-alice", Pod: "jupyter-bob"}),
+		"Exec other namespace": client.Exec(ctx, creds, ExecOptions{Namespace: "user-bob", Pod: "jupyter-alice"}),
+	}
+	_, others["ReadEnvSources"] = client.ReadEnvSources(ctx, creds, "user-bob", []string{"secret/db-creds"})
+	client.debugContainers = true
+	_, others["CreateDebugContainer"] = client.CreateDebugContainer(ctx, creds, "user-alice", "jupyter-bob", "")
+
+	for name, err := range others {
+		if !errors.Is(err, ErrPodNotPermitted) {
+			t.Errorf("%s: expected ErrPodNotPermitted, got %v", name, err)
+		}
+	}
+}
+
+func TestClient_ValidateAccessMode_ChecksEachGroup(t *testing.T) {
+	var groups []string
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			if attributes := review.Spec.ResourceAttributes; attributes.Resource == "groups" {
+				groups = append(groups, attributes.Name)
+			}
+			review.Status.Allowed = true
+			return true, review, nil
+		})
+
+	client := &Client{
+		clientset:           clientset,
+		accessMode:          AccessModeImpersonation,
+		impersonationGroups: []string{"purdue-af:users", "purdue-af:staff"},
+	}
+	if err := client.ValidateAccessMode(context.Background()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(groups) != 2 || groups[0] != "purdue-af:users" || groups[1] != "purdue-af:staff" {
+		t.Errorf("Expected impersonation checked per configured group, got %v", groups)
+	}
+}
+
+func TestClient_ValidateAccessMode(t *testing.T) {
+	tests := []struct {
+		name       string
+		accessMode string
+		allowed    bool
+		wantErr    bool
+	}{
+		{name: "serviceaccount mode skips check", accessMode: AccessModeServiceAccount, allowed: false, wantErr: false},
+		{name: "impersonation permitted", accessMode: AccessModeImpersonation, allowed: true, wantErr: false},
+		{name: "impersonation denied", accessMode: AccessModeImpersonation, allowed: false, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "selfsubjectaccessreviews",
+				func(action k8stesting.Action) (bool, runtime.Object, error) {
+					review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+					review.Status.Allowed = tt.allowed
+					return true, review, nil
+				})
+
+			client := &Client{clientset: clientset, accessMode: tt.accessMode}
+			err := client.ValidateAccessMode(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error=%v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
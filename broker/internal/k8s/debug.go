@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultDebugImage is the tools image used for ephemeral debug containers
+const DefaultDebugImage = "busybox:stable"
+
+// debugContainerStartTimeout bounds waiting for a debug container to start,
+// which includes pulling its image
+const debugContainerStartTimeout = 2 * time.Minute
+
+var (
+	// ErrDebugContainersDisabled is returned when debug containers are not enabled on the broker
+	ErrDebugContainersDisabled = errors.New("debug containers are disabled on this broker")
+
+	// ErrEphemeralContainersUnsupported is returned when the cluster does not serve
+	// the pods/ephemeralcontainers subresource
+	ErrEphemeralContainersUnsupported = errors.New("ephemeral containers are not enabled on this cluster")
+)
+
+// CreateDebugContainer adds an ephemeral debug container to the pod using the
+// session credentials and waits for it to run, returning its name for Exec.
+// When targetContainer is set, the debug container shares its process namespace.
+// The container lives until the pod is deleted.
+func (c *Client) CreateDebugContainer(ctx context.Context, creds *SessionCredentials, namespace, podName, targetContainer string) (string, error) {
+	if !c.debugContainers {
+		return "", ErrDebugContainersDisabled
+	}
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return "", err
+	}
+	if err := creds.checkPod(namespace, podName); err != nil {
+		return "", err
+	}
+
+	clientset, err := c.clientsetFor(creds)
+	if err != nil {
+		return "", err
+	}
+	pods := clientset.CoreV1().Pods(namespace)
+
+	pod, err := pods.Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	name := fmt.Sprintf("debugger-%s", uuid.New().String()[:5])
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    c.debugImage,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+			// Keep the image's shell alive so sessions can exec into it
+			Stdin: true,
+			TTY:   true,
+		},
+		TargetContainerName: targetContainer,
+	})
+
+	_, err = pods.UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+		// The pod exists, so a missing subresource means the feature is off
+		return "", ErrEphemeralContainersUnsupported
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to add debug container: %w", err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, debugContainerStartTimeout, true,
+		func(ctx context.Context) (bool, error) {
+			pod, err := pods.Get(ctx, podName, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("failed to get pod: %w", err)
+			}
+			for _, status := range pod.Status.EphemeralContainerStatuses {
+				if status.Name != name {
+					continue
+				}
+				if status.State.Terminated != nil {
+					return false, fmt.Errorf("debug container exited: %s", status.State.Terminated.Reason)
+				}
+				return status.State.Running != nil, nil
+			}
+			return false, nil
+		})
+	if err != nil {
+		return "", fmt.Errorf("debug container %s did not start: %w", name, err)
+	}
+
+	return name, nil
+}
+
+// clientsetFor returns a clientset acting with the session credentials
+func (c *Client) clientsetFor(creds *SessionCredentials) (kubernetes.Interface, error) {
+	if c.sessionClientset != nil {
+		return c.sessionClientset(creds)
+	}
+
+	clientset, err := kubernetes.NewForConfig(c.RESTConfigFor(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session clientset: %w", err)
+	}
+	return clientset, nil
+}
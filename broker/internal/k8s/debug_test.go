@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newDebugTestClient returns a client with debug containers enabled whose
+// session clientset is the given fake
+func newDebugTestClient(clientset *fake.Clientset) *Client {
+	return &Client{
+		clientset:       clientset,
+		debugContainers: true,
+		debugImage:      DefaultDebugImage,
+		sessionClientset: func(creds *SessionCredentials) (kubernetes.Interface, error) {
+			return clientset, nil
+		},
+	}
+}
+
+func TestClient_CreateDebugContainer(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil, nil))
+
+	// Report every ephemeral container as running, as the kubelet would
+	clientset.PrependReactor("get", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().Get(corev1.SchemeGroupVersion.WithResource("pods"), action.GetNamespace(), "jupyter-alice")
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*corev1.Pod)
+		for _, container := range pod.Spec.EphemeralContainers {
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+				Name:  container.Name,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			})
+		}
+		return true, pod, nil
+	})
+
+	client := newDebugTestClient(clientset)
+	name, err := client.CreateDebugContainer(context.Background(), &SessionCredentials{}, "user-alice", "jupyter-alice", "notebook")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	pod, _ := clientset.CoreV1().Pods("user-alice").Get(context.Background(), "jupyter-alice", metav1.GetOptions{})
+	if len(pod.Spec.EphemeralContainers) != 1 {
+		t.Fatalf("Expected 1 ephemeral container, got %d", len(pod.Spec.EphemeralContainers))
+	}
+	container := pod.Spec.EphemeralContainers[0]
+	if container.Name != name || container.Image != DefaultDebugImage || container.TargetContainerName != "notebook" {
+		t.Errorf("Unexpected debug container %s (%s) targeting %s", container.Name, container.Image, container.TargetContainerName)
+	}
+}
+
+func TestClient_CreateDebugContainer_Disabled(t *testing.T) {
+	client := newDebugTestClient(fake.NewSimpleClientset(testPod(nil, nil)))
+	client.debugContainers = false
+
+	_, err := client.CreateDebugContainer(context.Background(), &SessionCredentials{}, "user-alice", "jupyter-alice", "")
+	if !errors.Is(err, ErrDebugContainersDisabled) {
+		t.Errorf("Expected ErrDebugContainersDisabled, got %v", err)
+	}
+}
+
+func TestClient_CreateDebugContainer_ClusterUnsupported(t *testing.T) {
+	clientset := fake.NewSimpleClientset(testPod(nil, nil))
+	clientset.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "ephemeralcontainers" {
+			return false, nil, nil
+		}
+		return true, nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods/ephemeralcontainers"}, "jupyter-alice")
+	})
+
+	client := newDebugTestClient(clientset)
+	_, err := client.CreateDebugContainer(context.Background(), &SessionCredentials{}, "user-alice", "jupyter-alice", "")
+	if !errors.Is(err, ErrEphemeralContainersUnsupported) {
+		t.Errorf("Expected ErrEphemeralContainersUnsupported, got %v", err)
+	}
+}
+
+func TestClient_SessionRoleRules_DebugContainers(t *testing.T) {
+	hasEphemeral := func(client *Client) bool {
+		for _, rule := range client.sessionRoleRules([]string{"jupyter-alice"}) {
+			for _, resource := range rule.Resources {
+				if resource == "pods/ephemeralcontainers" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if hasEphemeral(&Client{}) {
+		t.Error("Expected no ephemeral container access when debug containers are disabled")
+	}
+	if !hasEphemeral(&Client{debugContainers: true}) {
+		t.Error("Expected ephemeral container access when debug containers are enabled")
+	}
+}
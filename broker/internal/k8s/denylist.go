@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrNamespaceDenied is returned for operations in a namespace the broker is
+// configured never to touch
+var ErrNamespaceDenied = errors.New("namespace is denied to sessions")
+
+// DefaultDeniedNamespaces are cluster-critical namespaces sessions never use.
+// The broker's own namespace is always denied as well.
+var DefaultDeniedNamespaces = []string{"kube-system", "kube-public", "kube-node-lease", "default"}
+
+// inClusterNamespaceFile holds the namespace of a pod's service account
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// newNamespaceDenylist builds the set of denied namespaces from the
+// configured list, DefaultDeniedNamespaces if nil, plus ownNamespace
+func newNamespaceDenylist(configured []string, ownNamespace string) map[string]bool {
+	if configured == nil {
+		configured = DefaultDeniedNamespaces
+	}
+
+	denied := make(map[string]bool)
+	for _, namespace := range configured {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			denied[namespace] = true
+		}
+	}
+	if ownNamespace != "" {
+		denied[ownNamespace] = true
+	}
+	return denied
+}
+
+// brokerNamespace returns the namespace the broker runs in, or "" outside a
+// cluster
+func brokerNamespace() string {
+	data, err := os.ReadFile(inClusterNamespaceFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// checkNamespaceAllowed guards every session operation against denied
+// namespaces, whatever the namespace was derived from
+func (c *Client) checkNamespaceAllowed(namespace string) error {
+	if c.deniedNamespaces[namespace] {
+		return fmt.Errorf("%w: %s", ErrNamespaceDenied, namespace)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewNamespaceDenylist(t *testing.T) {
+	denied := newNamespaceDenylist(nil, "vscode-broker")
+	for _, namespace := range append(DefaultDeniedNamespaces, "vscode-broker") {
+		if !denied[namespace] {
+			t.Errorf("Expected %s to be denied by default", namespace)
+		}
+	}
+
+	denied = newNamespaceDenylist([]string{"infra", " "}, "")
+	if !denied["infra"] || denied["kube-system"] || len(denied) != 1 {
+		t.Errorf("Expected only the configured namespace to be denied, got %v", denied)
+	}
+}
+
+func TestClient_DeniedNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	client := &Client{
+		clientset:        clientset,
+		roleMode:         RoleModePerSession,
+		deniedNamespaces: newNamespaceDenylist(nil, "vscode-broker"),
+	}
+	ctx := context.Background()
+
+	checks := map[string]error{
+		"CreateServiceAccount": client.CreateServiceAccount(ctx, "kube-system", "vscode-session-1"),
+		"CreateRoleBinding":    client.CreateRoleBinding(ctx, "default", "vscode-session-1", "jupyter-alice"),
+		"Exec":                 client.Exec(ctx, &SessionCredentials{}, ExecOptions{Namespace: "vscode-broker", Pod: "broker"}),
+	}
+	_, checks["CreateSessionCredentials"] = client.CreateSessionCredentials(ctx, "kube-system", "coredns", "alice@purdue.edu")
+	_, checks["GetPod"] = client.GetPod(ctx, "kube-public", "jupyter-alice")
+
+	for name, err := range checks {
+		if !errors.Is(err, ErrNamespaceDenied) {
+			t.Errorf("Expected %s to return ErrNamespaceDenied, got %v", name, err)
+		}
+	}
+	if actions := clientset.Actions(); len(actions) != 0 {
+		t.Errorf("Expected no API calls for denied namespaces, got %d", len(actions))
+	}
+}
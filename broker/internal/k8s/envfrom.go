@@ -0,0 +1,150 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Kinds of exec environment sources, referenced as "<kind>/<name>"
+const (
+	EnvSourceSecret    = "secret"
+	EnvSourceConfigMap = "configmap"
+)
+
+// ErrEnvSourceNotAllowed is returned for an exec environment source that is
+// not in the broker's configured list
+var ErrEnvSourceNotAllowed = errors.New("environment source is not allowed on this broker")
+
+// envVarName matches the keys that are usable as environment variables; like
+// a pod's envFrom, other keys are skipped
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envSources lists the secrets and config maps sessions may read into their
+// exec environment
+type envSources struct {
+	secrets    []string
+	configMaps []string
+}
+
+// parseEnvSources parses "secret/<name>" and "configmap/<name>" entries
+func parseEnvSources(entries []string) (envSources, error) {
+	var sources envSources
+	for _, entry := range entries {
+		kind, name, err := parseEnvSourceRef(entry)
+		if err != nil {
+			return envSources{}, err
+		}
+		if kind == EnvSourceSecret {
+			sources.secrets = append(sources.secrets, name)
+		} else {
+			sources.configMaps = append(sources.configMaps, name)
+		}
+	}
+	return sources, nil
+}
+
+func parseEnvSourceRef(ref string) (kind, name string, err error) {
+	kind, name, found := strings.Cut(ref, "/")
+	if !found || name == "" || (kind != EnvSourceSecret && kind != EnvSourceConfigMap) {
+		return "", "", fmt.Errorf("invalid environment source %q, expected secret/<name> or configmap/<name>", ref)
+	}
+	return kind, name, nil
+}
+
+// allows reports whether sessions may read the source
+func (s envSources) allows(kind, name string) bool {
+	names := s.configMaps
+	if kind == EnvSourceSecret {
+		names = s.secrets
+	}
+	for _, allowed := range names {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// roleRules grants read access to exactly the configured sources
+func (s envSources) roleRules() []rbacv1.PolicyRule {
+	var rules []rbacv1.PolicyRule
+	if len(s.secrets) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"secrets"},
+			Verbs:         []string{"get"},
+			ResourceNames: s.secrets,
+		})
+	}
+	if len(s.configMaps) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps"},
+			Verbs:         []string{"get"},
+			ResourceNames: s.configMaps,
+		})
+	}
+	return rules
+}
+
+// ReadEnvSources reads the referenced secrets and config maps in the namespace
+// with the session credentials, so cluster RBAC still applies, and merges
+// their keys into one environment. Later references win on conflicting keys.
+// Only sources in the broker's configured list may be referenced.
+func (c *Client) ReadEnvSources(ctx context.Context, creds *SessionCredentials, namespace string, refs []string) (map[string]string, error) {
+	env := make(map[string]string)
+	if len(refs) == 0 {
+		return env, nil
+	}
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+	if err := creds.checkPod(namespace, ""); err != nil {
+		return nil, err
+	}
+
+	clientset, err := c.clientsetFor(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range refs {
+		kind, name, err := parseEnvSourceRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		if !c.envSources.allows(kind, name) {
+			return nil, fmt.Errorf("%w: %s", ErrEnvSourceNotAllowed, ref)
+		}
+
+		if kind == EnvSourceSecret {
+			secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+			}
+			for key, value := range secret.Data {
+				if envVarName.MatchString(key) {
+					env[key] = string(value)
+				}
+			}
+			continue
+		}
+
+		configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+		}
+		for key, value := range configMap.Data {
+			if envVarName.MatchString(key) {
+				env[key] = value
+			}
+		}
+	}
+	return env, nil
+}
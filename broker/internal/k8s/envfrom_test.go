@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClient_ReadEnvSources(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-creds", Namespace: "user-alice"},
+			Data:       map[string][]byte{"DB_PASSWORD": []byte("hunter2"), "not-an-env-var": []byte("x")},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "user-alice"},
+			Data:       map[string]string{"DB_HOST": "db", "DB_PASSWORD": "overridden"},
+		},
+	)
+	sources, err := parseEnvSources([]string{"secret/db-creds", "configmap/settings"})
+	if err != nil {
+		t.Fatalf("Expected no error parsing sources, got %v", err)
+	}
+	client := &Client{
+		clientset:  clientset,
+		envSources: sources,
+		sessionClientset: func(creds *SessionCredentials) (kubernetes.Interface, error) {
+			return clientset, nil
+		},
+	}
+	ctx := context.Background()
+
+	env, err := client.ReadEnvSources(ctx, &SessionCredentials{}, "user-alice", []string{"secret/db-creds", "configmap/settings"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(env) != 2 || env["DB_HOST"] != "db" || env["DB_PASSWORD"] != "overridden" {
+		t.Errorf("Expected later sources to win and invalid keys skipped, got %v", env)
+	}
+
+	if _, err := client.ReadEnvSources(ctx, &SessionCredentials{}, "user-alice", []string{"secret/other"}); !errors.Is(err, ErrEnvSourceNotAllowed) {
+		t.Errorf("Expected ErrEnvSourceNotAllowed, got %v", err)
+	}
+}
+
+func TestParseEnvSources(t *testing.T) {
+	for _, entry := range []string{"db-creds", "secret/", "pod/jupyter-alice"} {
+		if _, err := parseEnvSources([]string{entry}); err == nil {
+			t.Errorf("Expected %q to be rejected", entry)
+		}
+	}
+
+	sources, _ := parseEnvSources([]string{"secret/db-creds"})
+	rules := sources.roleRules()
+	if len(rules) != 1 || rules[0].Resources[0] != "secrets" || rules[0].ResourceNames[0] != "db-creds" {
+		t.Errorf("Expected a rule reading only secret db-creds, got %+v", rules)
+	}
+}
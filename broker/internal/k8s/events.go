@@ -0,0 +1,68 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// maxPodEvents caps the events returned for a pod, keeping the most recent
+const maxPodEvents = 20
+
+// GetPodEvents returns the pod's recent events, oldest first, such as
+// scheduling failures and image pull errors
+func (c *Client) GetPodEvents(ctx context.Context, namespace, name string) ([]types.PodEvent, error) {
+	selector := fields.Set{
+		"involvedObject.kind": "Pod",
+		"involvedObject.name": name,
+	}.AsSelector().String()
+
+	list, err := c.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events for pod %s/%s: %w", namespace, name, err)
+	}
+
+	events := make([]types.PodEvent, 0, len(list.Items))
+	for _, event := range list.Items {
+		// Field selectors are not applied by every client, so filter again
+		if event.InvolvedObject.Kind != "Pod" || event.InvolvedObject.Name != name {
+			continue
+		}
+		events = append(events, types.PodEvent{
+			Type:      event.Type,
+			Reason:    event.Reason,
+			Message:   event.Message,
+			Count:     event.Count,
+			Timestamp: eventTime(event),
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+	if len(events) > maxPodEvents {
+		events = events[len(events)-maxPodEvents:]
+	}
+	return events, nil
+}
+
+// eventTime returns when the event last occurred, falling back through the
+// fields set by different event sources
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testEvent(name, podName, reason string, at time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "user-alice"},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      "Pod",
+			Name:      podName,
+			Namespace: "user-alice",
+		},
+		Type:          corev1.EventTypeWarning,
+		Reason:        reason,
+		Message:       reason + " happened",
+		LastTimestamp: metav1.NewTime(at),
+	}
+}
+
+func TestClient_GetPodEvents(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	client := &Client{clientset: fake.NewSimpleClientset(
+		testEvent("e1", "jupyter-alice", "BackOff", now),
+		testEvent("e2", "jupyter-alice", "FailedScheduling", now.Add(-time.Minute)),
+		testEvent("e3", "jupyter-bob", "Pulled", now),
+	)}
+
+	events, err := client.GetPodEvents(context.Background(), "user-alice", "jupyter-alice")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events for the pod, got %+v", events)
+	}
+	if events[0].Reason != "FailedScheduling" || events[1].Reason != "BackOff" {
+		t.Errorf("Expected events oldest first, got %s then %s", events[0].Reason, events[1].Reason)
+	}
+	if events[1].Type != corev1.EventTypeWarning || events[1].Message != "BackOff happened" || !events[1].Timestamp.Equal(now) {
+		t.Errorf("Expected event details to be copied, got %+v", events[1])
+	}
+}
+
+func TestClient_GetPodEvents_KeepsMostRecent(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	clientset := fake.NewSimpleClientset()
+	for i := 0; i < maxPodEvents+5; i++ {
+		event := testEvent(fmt.Sprintf("e%d", i), "jupyter-alice", fmt.Sprintf("Reason%d", i), now.Add(time.Duration(i)*time.Second))
+		clientset.CoreV1().Events("user-alice").Create(context.Background(), event, metav1.CreateOptions{})
+	}
+	client := &Client{clientset: clientset}
+
+	events, err := client.GetPodEvents(context.Background(), "user-alice", "jupyter-alice")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events) != maxPodEvents {
+		t.Fatalf("Expected %d events, got %d", maxPodEvents, len(events))
+	}
+	if last := events[len(events)-1].Reason; last != fmt.Sprintf("Reason%d", maxPodEvents+4) {
+		t.Errorf("Expected most recent event last, got %s", last)
+	}
+}
@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// ExecOptions describes a command to run in a pod
+type ExecOptions struct {
+	Namespace string
+	Pod       string
+	Container string
+	Command   []string
+	Stdin     io.Reader
+	Stdout    io.Writer
+	Stderr    io.Writer
+	TTY       bool
+}
+
+// Exec runs a command in a pod using the session credentials, streaming its
+// input and output until the command exits or ctx is cancelled. A command that
+// exits non-zero returns an error whose code can be read with ExitCode.
+func (c *Client) Exec(ctx context.Context, creds *SessionCredentials, opts ExecOptions) error {
+	if err := c.checkNamespaceAllowed(opts.Namespace); err != nil {
+		return err
+	}
+	if err := creds.checkPod(opts.Namespace, opts.Pod); err != nil {
+		return err
+	}
+
+	req := c.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(opts.Namespace).
+		Name(opts.Pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: opts.Container,
+			Command:   opts.Command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil && !opts.TTY,
+			TTY:       opts.TTY,
+		}, scheme.ParameterCodec)
+
+	executor, err := c.newExecutor(c.RESTConfigFor(creds), req.URL())
+	if err != nil {
+		return fmt.Errorf("failed to create executor: %w", err)
+	}
+
+	streamOpts := remotecommand.StreamOptions{
+		Stdin:  opts.Stdin,
+		Stdout: opts.Stdout,
+		Tty:    opts.TTY,
+	}
+	if !opts.TTY {
+		streamOpts.Stderr = opts.Stderr
+	}
+
+	return executor.StreamWithContext(ctx, streamOpts)
+}
+
+// ExitCode returns the exit code of a command that ran to completion but
+// exited non-zero, and false for any other error
+func ExitCode(err error) (int, bool) {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
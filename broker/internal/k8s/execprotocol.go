@@ -0,0 +1,77 @@
+package k8s
+
+import (
+	"net/url"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// Exec protocols select how exec streams reach the API server
+const (
+	// ExecProtocolAuto uses the WebSocket protocol, falling back to SPDY
+	// once the API server refuses the WebSocket upgrade
+	ExecProtocolAuto = "auto"
+
+	// ExecProtocolWebSocket always uses the WebSocket protocol (v5.channel.k8s.io)
+	ExecProtocolWebSocket = "websocket"
+
+	// ExecProtocolSPDY always uses the legacy SPDY protocol
+	ExecProtocolSPDY = "spdy"
+)
+
+// executorFunc creates an executor for an exec request URL
+type executorFunc func(config *rest.Config, url *url.URL) (remotecommand.Executor, error)
+
+func newSPDYExecutor(config *rest.Config, url *url.URL) (remotecommand.Executor, error) {
+	return remotecommand.NewSPDYExecutor(config, "POST", url)
+}
+
+func newWebSocketExecutor(config *rest.Config, url *url.URL) (remotecommand.Executor, error) {
+	// The WebSocket upgrade is a GET, as kubectl sends it
+	return remotecommand.NewWebSocketExecutor(config, "GET", url.String())
+}
+
+// newExecutor creates an executor speaking the configured exec protocol. In
+// auto mode an API server that refuses the WebSocket upgrade is remembered,
+// so later execs go straight to SPDY instead of failing the upgrade each time.
+func (c *Client) newExecutor(config *rest.Config, url *url.URL) (remotecommand.Executor, error) {
+	spdy, websocket := c.spdyExecutor, c.websocketExecutor
+	if spdy == nil {
+		spdy = newSPDYExecutor
+	}
+	if websocket == nil {
+		websocket = newWebSocketExecutor
+	}
+
+	switch c.execProtocol {
+	case ExecProtocolSPDY:
+		return spdy(config, url)
+	case ExecProtocolWebSocket:
+		return websocket(config, url)
+	}
+
+	if c.websocketUnsupported.Load() {
+		return spdy(config, url)
+	}
+	primary, err := websocket(config, url)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := spdy(config, url)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewFallbackExecutor(primary, secondary, c.shouldFallbackToSPDY)
+}
+
+// shouldFallbackToSPDY reports whether a WebSocket exec failed at the upgrade,
+// before any input was read, so it can be retried over SPDY
+func (c *Client) shouldFallbackToSPDY(err error) bool {
+	if !httpstream.IsUpgradeFailure(err) {
+		return false
+	}
+	c.websocketUnsupported.Store(true)
+	return true
+}
@@ -0,0 +1,89 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// recordingExecutor records the protocol of each stream and fails with err
+type recordingExecutor struct {
+	protocol string
+	calls    *[]string
+	err      error
+}
+
+func (e *recordingExecutor) Stream(options remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), options)
+}
+
+func (e *recordingExecutor) StreamWithContext(ctx context.Context, options remotecommand.StreamOptions) error {
+	*e.calls = append(*e.calls, e.protocol)
+	return e.err
+}
+
+func newRecordingClient(protocol string, websocketErr error) (*Client, *[]string) {
+	calls := &[]string{}
+	client := &Client{
+		execProtocol: protocol,
+		spdyExecutor: func(*rest.Config, *url.URL) (remotecommand.Executor, error) {
+			return &recordingExecutor{protocol: ExecProtocolSPDY, calls: calls}, nil
+		},
+		websocketExecutor: func(*rest.Config, *url.URL) (remotecommand.Executor, error) {
+			return &recordingExecutor{protocol: ExecProtocolWebSocket, calls: calls, err: websocketErr}, nil
+		},
+	}
+	return client, calls
+}
+
+func TestClient_NewExecutor(t *testing.T) {
+	upgradeErr := &httpstream.UpgradeFailureError{Cause: errors.New("403 Forbidden")}
+	commandErr := errors.New("command terminated with exit code 1")
+
+	tests := []struct {
+		name         string
+		protocol     string
+		websocketErr error
+		want         []string
+	}{
+		{name: "auto uses websocket", protocol: ExecProtocolAuto, want: []string{"websocket", "websocket"}},
+		{name: "auto falls back once upgrade fails", protocol: ExecProtocolAuto, websocketErr: upgradeErr, want: []string{"websocket", "spdy", "spdy"}},
+		{name: "auto keeps websocket after command errors", protocol: ExecProtocolAuto, websocketErr: commandErr, want: []string{"websocket", "websocket"}},
+		{name: "websocket never falls back", protocol: ExecProtocolWebSocket, websocketErr: upgradeErr, want: []string{"websocket", "websocket"}},
+		{name: "spdy", protocol: ExecProtocolSPDY, want: []string{"spdy", "spdy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, calls := newRecordingClient(tt.protocol, tt.websocketErr)
+
+			for i := 0; i < 2; i++ {
+				executor, err := client.newExecutor(&rest.Config{}, &url.URL{})
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{})
+			}
+
+			if len(*calls) != len(tt.want) {
+				t.Fatalf("Expected streams %v, got %v", tt.want, *calls)
+			}
+			for i := range tt.want {
+				if (*calls)[i] != tt.want[i] {
+					t.Fatalf("Expected streams %v, got %v", tt.want, *calls)
+				}
+			}
+		})
+	}
+}
+
+func TestNewClient_InvalidExecProtocol(t *testing.T) {
+	if _, err := NewClient(ClientConfig{ExecProtocol: "http2"}); err == nil {
+		t.Fatal("Expected an unknown exec protocol to be rejected")
+	}
+}
@@ -0,0 +1,16 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+)
+
+// Ping checks that the API server is reachable and ready to serve requests.
+// /readyz is readable by any authenticated identity, so it needs no RBAC.
+func (c *Client) Ping(ctx context.Context) error {
+	err := c.clientset.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+	if err != nil {
+		return fmt.Errorf("kubernetes API is not ready: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// userQuotaName names the ResourceQuota created with a user namespace
+const userQuotaName = "vscode-user-quota"
+
+// namespaceTemplate describes the user namespaces the broker creates
+type namespaceTemplate struct {
+	labels map[string]string
+	quota  corev1.ResourceList
+}
+
+// parseNamespaceTemplate parses "key=value" labels and "resource=quantity"
+// quota limits
+func parseNamespaceTemplate(labels, quota []string) (namespaceTemplate, error) {
+	template := namespaceTemplate{labels: map[string]string{managedByLabel: managedByValue}}
+	for _, entry := range labels {
+		key, value, ok := strings.Cut(entry, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return namespaceTemplate{}, fmt.Errorf("invalid namespace label %q, expected key=value", entry)
+		}
+		template.labels[key] = strings.TrimSpace(value)
+	}
+
+	if len(quota) > 0 {
+		template.quota = corev1.ResourceList{}
+	}
+	for _, entry := range quota {
+		name, value, ok := strings.Cut(entry, "=")
+		if name = strings.TrimSpace(name); !ok || name == "" {
+			return namespaceTemplate{}, fmt.Errorf("invalid namespace quota %q, expected resource=quantity", entry)
+		}
+		quantity, err := resource.ParseQuantity(strings.TrimSpace(value))
+		if err != nil {
+			return namespaceTemplate{}, fmt.Errorf("invalid namespace quota %q: %w", entry, err)
+		}
+		template.quota[corev1.ResourceName(name)] = quantity
+	}
+	return template, nil
+}
+
+// ensureSessionNamespace returns an error if the namespace does not exist,
+// unless namespace creation is enabled and it can be created
+func (c *Client) ensureSessionNamespace(ctx context.Context, namespace string) error {
+	err := c.ensureNamespaceExists(ctx, namespace)
+	if !errors.Is(err, ErrNamespaceNotFound) {
+		return err
+	}
+	if !c.createNamespaces {
+		return fmt.Errorf("%w (namespace creation is disabled)", err)
+	}
+	return c.createNamespace(ctx, namespace)
+}
+
+// createNamespace creates a user namespace from the template with its quota.
+// A namespace or quota created concurrently, by another session or the
+// deployment's own tooling, is accepted as is.
+func (c *Client) createNamespace(ctx context.Context, namespace string) error {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   namespace,
+			Labels: c.namespaceTemplate.labels,
+		},
+	}
+	_, err := c.clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", namespace, err)
+	}
+
+	if len(c.namespaceTemplate.quota) == 0 {
+		return nil
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   userQuotaName,
+			Labels: map[string]string{managedByLabel: managedByValue},
+		},
+		Spec: corev1.ResourceQuotaSpec{Hard: c.namespaceTemplate.quota},
+	}
+	_, err = c.clientset.CoreV1().ResourceQuotas(namespace).Create(ctx, quota, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create resource quota in namespace %s: %w", namespace, err)
+	}
+	return nil
+}
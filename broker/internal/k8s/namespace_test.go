@@ -0,0 +1,63 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClient_EnsureSessionNamespace_Disabled(t *testing.T) {
+	client := &Client{clientset: fake.NewSimpleClientset()}
+
+	err := client.ensureSessionNamespace(context.Background(), "user-alice")
+	if !errors.Is(err, ErrNamespaceNotFound) || !strings.Contains(err.Error(), "creation is disabled") {
+		t.Fatalf("Expected ErrNamespaceNotFound explaining creation is disabled, got %v", err)
+	}
+}
+
+func TestClient_EnsureSessionNamespace_Create(t *testing.T) {
+	template, err := parseNamespaceTemplate(
+		[]string{"purdue-af.io/user=alice"}, []string{"requests.cpu=4", "limits.memory=16Gi"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	client := &Client{clientset: clientset, createNamespaces: true, namespaceTemplate: template}
+	ctx := context.Background()
+
+	// A second session finds the namespace already there
+	for i := 0; i < 2; i++ {
+		if err := client.ensureSessionNamespace(ctx, "user-alice"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, "user-alice", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected namespace to be created, got %v", err)
+	}
+	if ns.Labels["purdue-af.io/user"] != "alice" || ns.Labels[managedByLabel] != managedByValue {
+		t.Errorf("Expected template labels, got %v", ns.Labels)
+	}
+
+	quota, err := clientset.CoreV1().ResourceQuotas("user-alice").Get(ctx, userQuotaName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected resource quota to be created, got %v", err)
+	}
+	if cpu := quota.Spec.Hard["requests.cpu"]; cpu.String() != "4" {
+		t.Errorf("Expected 4 CPU quota, got %s", cpu.String())
+	}
+}
+
+func TestParseNamespaceTemplate_Invalid(t *testing.T) {
+	if _, err := parseNamespaceTemplate([]string{"no-value"}, nil); err == nil {
+		t.Error("Expected a label without a value to be rejected")
+	}
+	if _, err := parseNamespaceTemplate(nil, []string{"requests.cpu=lots"}); err == nil {
+		t.Error("Expected an invalid quantity to be rejected")
+	}
+}
@@ -0,0 +1,125 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrPodNotAllowed is returned when a pod lacks the labels or annotations the
+// broker requires before granting access to it
+var ErrPodNotAllowed = errors.New("pod does not meet access requirements")
+
+// ErrPodReassigned is returned when a session's pod no longer carries the
+// owner annotation of the user it was started for, such as after its
+// namespace was reused for another user
+var ErrPodReassigned = errors.New("pod no longer belongs to the session's user")
+
+// DefaultPodOwnerAnnotation is the annotation KubeSpawner sets to the
+// JupyterHub username a pod was started for
+const DefaultPodOwnerAnnotation = "hub.jupyter.org/username"
+
+// podRequirement is a required label or annotation, either "key" (present with
+// any value) or "key=value"
+type podRequirement struct {
+	key      string
+	value    string
+	hasValue bool
+}
+
+// parsePodRequirements parses "key" and "key=value" entries
+func parsePodRequirements(entries []string) ([]podRequirement, error) {
+	var requirements []podRequirement
+	for _, entry := range entries {
+		key, value, hasValue := strings.Cut(entry, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid pod requirement %q", entry)
+		}
+		requirements = append(requirements, podRequirement{
+			key:      key,
+			value:    strings.TrimSpace(value),
+			hasValue: hasValue,
+		})
+	}
+	return requirements, nil
+}
+
+// missingRequirement returns the first requirement not satisfied by values, if any
+func missingRequirement(requirements []podRequirement, values map[string]string) (podRequirement, bool) {
+	for _, req := range requirements {
+		value, ok := values[req.key]
+		if !ok || (req.hasValue && value != req.value) {
+			return req, true
+		}
+	}
+	return podRequirement{}, false
+}
+
+func (r podRequirement) String() string {
+	if r.hasValue {
+		return r.key + "=" + r.value
+	}
+	return r.key
+}
+
+// checkPodAllowed verifies the pod carries the required labels and annotations
+func (c *Client) checkPodAllowed(pod *corev1.Pod) error {
+	if req, missing := missingRequirement(c.requiredPodLabels, pod.Labels); missing {
+		return fmt.Errorf("%w: pod %s/%s is missing required label %s", ErrPodNotAllowed, pod.Namespace, pod.Name, req)
+	}
+	if req, missing := missingRequirement(c.requiredPodAnnotations, pod.Annotations); missing {
+		return fmt.Errorf("%w: pod %s/%s is missing required annotation %s", ErrPodNotAllowed, pod.Namespace, pod.Name, req)
+	}
+	return nil
+}
+
+// ensurePodAllowed fetches the pod and checks its access requirements, skipping
+// the lookup when none are configured
+func (c *Client) ensurePodAllowed(ctx context.Context, namespace, podName string) error {
+	if len(c.requiredPodLabels) == 0 && len(c.requiredPodAnnotations) == 0 {
+		return nil
+	}
+
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+	return c.checkPodAllowed(pod)
+}
+
+// VerifyPodOwner checks the pod is still annotated as belonging to owner, the
+// JupyterHub user a session was created for. The check is skipped when no
+// owner annotation is configured or the session predates recording owners.
+func (c *Client) VerifyPodOwner(ctx context.Context, namespace, name, owner string) error {
+	if c.podOwnerAnnotation == "" || owner == "" {
+		return nil
+	}
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return err
+	}
+
+	var pod *corev1.Pod
+	err := withTimeout(ctx, "getting pod", c.timeouts.GetPod, func(ctx context.Context) error {
+		var err error
+		pod, err = c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s/%s", ErrPodNotFound, namespace, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get pod: %w", err)
+	}
+
+	// The annotation's actual value names another user, so it is not reported
+	if pod.Annotations[c.podOwnerAnnotation] != owner {
+		return fmt.Errorf("%w: pod %s/%s is not annotated %s=%s", ErrPodReassigned, namespace, name, c.podOwnerAnnotation, owner)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(labels, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "jupyter-alice",
+			Namespace:   "user-alice",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestParsePodRequirements(t *testing.T) {
+	requirements, err := parsePodRequirements([]string{"hub.jupyter.org/username", "component=singleuser-server"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(requirements) != 2 {
+		t.Fatalf("Expected 2 requirements, got %d", len(requirements))
+	}
+	if requirements[0].hasValue || requirements[0].key != "hub.jupyter.org/username" {
+		t.Errorf("Expected presence requirement, got %+v", requirements[0])
+	}
+	if !requirements[1].hasValue || requirements[1].value != "singleuser-server" {
+		t.Errorf("Expected value requirement, got %+v", requirements[1])
+	}
+
+	if _, err := parsePodRequirements([]string{"=value"}); err == nil {
+		t.Error("Expected error for requirement without key")
+	}
+}
+
+func TestClient_CheckPodAllowed(t *testing.T) {
+	requirements, _ := parsePodRequirements([]string{"component=singleuser-server"})
+	annotationRequirements, _ := parsePodRequirements([]string{"hub.jupyter.org/username"})
+	client := &Client{
+		requiredPodLabels:      requirements,
+		requiredPodAnnotations: annotationRequirements,
+	}
+
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		allowed bool
+	}{
+		{
+			name: "all requirements met",
+			pod: testPod(map[string]string{"component": "singleuser-server"},
+				map[string]string{"hub.jupyter.org/username": "alice"}),
+			allowed: true,
+		},
+		{
+			name:    "missing label",
+			pod:     testPod(nil, map[string]string{"hub.jupyter.org/username": "alice"}),
+			allowed: false,
+		},
+		{
+			name: "wrong label value",
+			pod: testPod(map[string]string{"component": "hub"},
+				map[string]string{"hub.jupyter.org/username": "alice"}),
+			allowed: false,
+		},
+		{
+			name:    "missing annotation",
+			pod:     testPod(map[string]string{"component": "singleuser-server"}, nil),
+			allowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.checkPodAllowed(tt.pod)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected pod to be allowed, got %v", err)
+			}
+			if !tt.allowed && !errors.Is(err, ErrPodNotAllowed) {
+				t.Errorf("Expected ErrPodNotAllowed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_CreateSessionCredentials_RejectsDisallowedPod(t *testing.T) {
+	requirements, _ := parsePodRequirements([]string{"hub.jupyter.org/username"})
+	clientset := fake.NewSimpleClientset(testPod(map[string]string{"component": "kube-proxy"}, nil))
+	client := &Client{
+		clientset:         clientset,
+		roleMode:          RoleModePerSession,
+		accessMode:        AccessModeServiceAccount,
+		requiredPodLabels: requirements,
+	}
+
+	_, err := client.CreateSessionCredentials(context.Background(), "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if !errors.Is(err, ErrPodNotAllowed) {
+		t.Fatalf("Expected ErrPodNotAllowed, got %v", err)
+	}
+
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "create" {
+			t.Errorf("Expected no resources to be created, got %s %s", action.GetVerb(), action.GetResource().Resource)
+		}
+	}
+}
+
+func TestClient_VerifyPodOwner(t *testing.T) {
+	// The namespace was reused: alice's old pod name now runs carol's server
+	clientset := fake.NewSimpleClientset(testPod(nil, map[string]string{DefaultPodOwnerAnnotation: "carol"}))
+	client := &Client{clientset: clientset, podOwnerAnnotation: DefaultPodOwnerAnnotation}
+
+	if err := client.VerifyPodOwner(context.Background(), "user-alice", "jupyter-alice", "carol"); err != nil {
+		t.Errorf("Expected the owner to be accepted, got %v", err)
+	}
+	err := client.VerifyPodOwner(context.Background(), "user-alice", "jupyter-alice", "alice")
+	if !errors.Is(err, ErrPodReassigned) {
+		t.Fatalf("Expected ErrPodReassigned, got %v", err)
+	}
+	if strings.Contains(err.Error(), "carol") {
+		t.Errorf("Expected the error not to name the pod's owner, got %v", err)
+	}
+	if err := client.VerifyPodOwner(context.Background(), "user-alice", "jupyter-bob", "bob"); !errors.Is(err, ErrPodNotFound) {
+		t.Errorf("Expected ErrPodNotFound for a missing pod, got %v", err)
+	}
+
+	// Unrecorded owners and a disabled check are not verified
+	if err := client.VerifyPodOwner(context.Background(), "user-alice", "jupyter-alice", ""); err != nil {
+		t.Errorf("Expected sessions without an owner to pass, got %v", err)
+	}
+	client.podOwnerAnnotation = ""
+	if err := client.VerifyPodOwner(context.Background(), "user-alice", "jupyter-alice", "bob"); err != nil {
+		t.Errorf("Expected no check without an owner annotation, got %v", err)
+	}
+}
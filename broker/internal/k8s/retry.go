@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// Defaults for retrying API calls during session setup
+const (
+	DefaultRetryAttempts       = 4
+	DefaultRetryInitialBackoff = 200 * time.Millisecond
+	DefaultRetryMaxBackoff     = 2 * time.Second
+)
+
+// RetryConfig bounds retries of session setup API calls that fail transiently,
+// e.g. while the API server is overloaded or electing a leader
+type RetryConfig struct {
+	// Attempts is the total number of tries; 1 disables retries
+	Attempts int
+
+	// InitialBackoff is the wait before the first retry, doubling after each
+	// one up to MaxBackoff. A Retry-After from the server is honoured up to
+	// MaxBackoff as well.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// withDefaults fills in unset fields
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.Attempts <= 0 {
+		r.Attempts = DefaultRetryAttempts
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = DefaultRetryInitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	return r
+}
+
+// retry runs fn until it succeeds, fails with an error that is not retryable,
+// runs out of attempts or ctx is done. A zero config tries once.
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	backoff := c.retryConfig.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= c.retryConfig.Attempts || !isRetryable(err) {
+			return err
+		}
+
+		wait := backoff
+		if delay, ok := apierrors.SuggestsClientDelay(err); ok {
+			wait = max(wait, time.Duration(delay)*time.Second)
+		}
+		wait = min(wait, c.retryConfig.MaxBackoff)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, c.retryConfig.MaxBackoff)
+	}
+}
+
+// isRetryable reports whether an API error is likely transient: server-side
+// errors, throttling, conflicts and timeouts. Permission and validation errors
+// are returned at once.
+func isRetryable(err error) bool {
+	switch {
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err),
+		apierrors.IsNotFound(err), apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return false
+	case apierrors.IsConflict(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsServerTimeout(err), apierrors.IsTimeout(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err),
+		apierrors.IsUnexpectedServerError(err):
+		return true
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err)
+}
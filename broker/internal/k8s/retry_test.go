@@ -0,0 +1,115 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var testRetryConfig = RetryConfig{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+// failTokenRequests makes the first failures token requests fail with err
+func failTokenRequests(clientset *fake.Clientset, failures int, err error) *int {
+	attempts := 0
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		attempts++
+		if attempts <= failures {
+			return true, nil, err
+		}
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{Token: "minted"},
+		}, nil
+	})
+	return &attempts
+}
+
+func TestClient_MintToken_Retry(t *testing.T) {
+	resource := schema.GroupResource{Resource: "serviceaccounts"}
+
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		wantErr      bool
+		wantAttempts int
+	}{
+		{name: "succeeds first time", failures: 0, wantAttempts: 1},
+		{name: "retries unavailable", failures: 2, err: apierrors.NewServiceUnavailable("leader election"), wantAttempts: 3},
+		{name: "retries conflict", failures: 1, err: apierrors.NewConflict(resource, "sa", errors.New("conflict")), wantAttempts: 2},
+		{name: "retries throttling", failures: 1, err: apierrors.NewTooManyRequests("slow down", 0), wantAttempts: 2},
+		{name: "gives up after attempts", failures: 5, err: apierrors.NewInternalError(errors.New("etcd")), wantErr: true, wantAttempts: 3},
+		{name: "does not retry forbidden", failures: 5, err: apierrors.NewForbidden(resource, "sa", errors.New("denied")), wantErr: true, wantAttempts: 1},
+		{name: "does not retry not found", failures: 5, err: apierrors.NewNotFound(resource, "sa"), wantErr: true, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			attempts := failTokenRequests(clientset, tt.failures, tt.err)
+			client := &Client{clientset: clientset, retryConfig: testRetryConfig}
+
+			token, _, err := client.MintToken(context.Background(), "user-alice", "sa", time.Hour)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && token != "minted" {
+				t.Errorf("Expected minted token, got %q", token)
+			}
+			if *attempts != tt.wantAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.wantAttempts, *attempts)
+			}
+		})
+	}
+}
+
+func TestClient_MintToken_StopsWhenContextDone(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	attempts := failTokenRequests(clientset, 5, apierrors.NewServiceUnavailable("down"))
+	client := &Client{clientset: clientset, retryConfig: RetryConfig{Attempts: 5, InitialBackoff: time.Hour, MaxBackoff: time.Hour}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := client.MintToken(ctx, "user-alice", "sa", time.Hour); err == nil {
+		t.Fatal("Expected error")
+	}
+	if *attempts != 1 {
+		t.Errorf("Expected no retry after the context is done, got %d attempts", *attempts)
+	}
+}
+
+func TestClient_CreateServiceAccount_RetryAfterTimeout(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	attempts := 0
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts++
+		if attempts == 1 {
+			// The create lands, but the response times out
+			sa := action.(k8stesting.CreateAction).GetObject().(*corev1.ServiceAccount)
+			clientset.Tracker().Create(corev1.SchemeGroupVersion.WithResource("serviceaccounts"), sa, sa.Namespace)
+			return true, nil, apierrors.NewServerTimeout(schema.GroupResource{Resource: "serviceaccounts"}, "create", 0)
+		}
+		return false, nil, nil
+	})
+	client := &Client{clientset: clientset, retryConfig: testRetryConfig}
+
+	if err := client.CreateServiceAccount(context.Background(), "user-alice", "vscode-sess-1"); err != nil {
+		t.Fatalf("Expected retry to accept the existing ServiceAccount, got %v", err)
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts("user-alice").Get(context.Background(), "vscode-sess-1", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected ServiceAccount to exist, got %v", err)
+	}
+}
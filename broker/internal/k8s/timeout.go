@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultOperationTimeout bounds each API operation unless configured
+const DefaultOperationTimeout = 10 * time.Second
+
+// ErrTimeout is returned when an API operation exceeds its configured
+// timeout, meaning the cluster is slow rather than the request being wrong
+var ErrTimeout = errors.New("kubernetes API did not respond in time, try again")
+
+// TimeoutConfig bounds individual API operations, retries included. Unset
+// fields take DefaultOperationTimeout.
+type TimeoutConfig struct {
+	GetPod               time.Duration
+	CreateServiceAccount time.Duration
+	CreateRoleBinding    time.Duration
+	MintToken            time.Duration
+	DeleteServiceAccount time.Duration
+}
+
+// withDefaults fills in unset fields
+func (t TimeoutConfig) withDefaults() TimeoutConfig {
+	for _, d := range []*time.Duration{
+		&t.GetPod, &t.CreateServiceAccount, &t.CreateRoleBinding, &t.MintToken, &t.DeleteServiceAccount,
+	} {
+		if *d <= 0 {
+			*d = DefaultOperationTimeout
+		}
+	}
+	return t
+}
+
+// withTimeout runs fn with ctx limited to timeout, reporting ErrTimeout if
+// the limit rather than the caller's context ends it. The result is not waited
+// for past the deadline, in case the transport does not honour the context.
+// A zero timeout runs fn unbounded.
+func withTimeout(ctx context.Context, operation string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fn(opCtx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-opCtx.Done():
+		err = opCtx.Err()
+	}
+
+	if err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return fmt.Errorf("%w: %s took longer than %s: %w", ErrTimeout, operation, timeout, err)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// delayRequests makes every API request take delay before the fake answers it
+func delayRequests(clientset *fake.Clientset, delay time.Duration) {
+	clientset.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		time.Sleep(delay)
+		return false, nil, nil
+	})
+}
+
+func TestClient_OperationTimeouts(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	timeouts := TimeoutConfig{
+		GetPod:               timeout,
+		CreateServiceAccount: timeout,
+		CreateRoleBinding:    timeout,
+		MintToken:            timeout,
+		DeleteServiceAccount: timeout,
+	}
+
+	operations := []struct {
+		name string
+		run  func(ctx context.Context, client *Client) error
+	}{
+		{"GetPod", func(ctx context.Context, client *Client) error {
+			_, err := client.GetPod(ctx, "user-alice", "jupyter-alice")
+			return err
+		}},
+		{"CreateServiceAccount", func(ctx context.Context, client *Client) error {
+			return client.CreateServiceAccount(ctx, "user-alice", "vscode-sess-aaaa")
+		}},
+		{"CreateRoleBinding", func(ctx context.Context, client *Client) error {
+			return client.CreateRoleBinding(ctx, "user-alice", "vscode-sess-aaaa", "jupyter-alice")
+		}},
+		{"MintToken", func(ctx context.Context, client *Client) error {
+			_, _, err := client.MintToken(ctx, "user-alice", "vscode-sess-aaaa", time.Hour)
+			return err
+		}},
+		{"DeleteServiceAccount", func(ctx context.Context, client *Client) error {
+			return client.DeleteServiceAccount(ctx, "user-alice", "vscode-sess-aaaa")
+		}},
+	}
+
+	newClient := func(delay time.Duration) *Client {
+		clientset := fake.NewSimpleClientset(
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "user-alice"}},
+			&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "vscode-sess-aaaa", Namespace: "user-alice"}},
+			testPod(nil, nil),
+		)
+		if delay > 0 {
+			delayRequests(clientset, delay)
+		}
+		return &Client{clientset: clientset, roleMode: RoleModePerSession, timeouts: timeouts}
+	}
+
+	for _, op := range operations {
+		t.Run(op.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			start := time.Now()
+			err := op.run(ctx, newClient(200*time.Millisecond))
+			if !errors.Is(err, ErrTimeout) {
+				t.Fatalf("Expected ErrTimeout, got %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+				t.Errorf("Expected the operation to give up after its timeout, took %v", elapsed)
+			}
+
+			// A cancelled caller is not reported as a slow cluster
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			if err := op.run(cancelled, newClient(200*time.Millisecond)); errors.Is(err, ErrTimeout) {
+				t.Errorf("Expected caller cancellation not to be ErrTimeout, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutConfig_WithDefaults(t *testing.T) {
+	timeouts := TimeoutConfig{MintToken: time.Second}.withDefaults()
+	if timeouts.MintToken != time.Second {
+		t.Errorf("Expected configured timeout kept, got %v", timeouts.MintToken)
+	}
+	if timeouts.GetPod != DefaultOperationTimeout || timeouts.DeleteServiceAccount != DefaultOperationTimeout {
+		t.Errorf("Expected unset timeouts to default, got %+v", timeouts)
+	}
+}
@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lifetimes of minted ServiceAccount tokens
+const (
+	// DefaultTokenTTL is the lifetime requested for each minted token
+	DefaultTokenTTL = time.Hour
+
+	// DefaultMinTokenTTL matches kube-apiserver's default
+	// --service-account-min-token-expiration; the API server extends shorter
+	// requests to it
+	DefaultMinTokenTTL = 10 * time.Minute
+)
+
+// TokenTTLConfig sets the lifetime requested for minted tokens and the
+// cluster's bounds on it, so the broker never asks for a lifetime the API
+// server would silently change
+type TokenTTLConfig struct {
+	// TTL is requested for each token, DefaultTokenTTL if zero
+	TTL time.Duration
+
+	// Min and Max mirror the API server's --service-account-min-token-expiration
+	// and --service-account-max-token-expiration. Min is DefaultMinTokenTTL if
+	// zero; a zero Max sets no maximum.
+	Min time.Duration
+	Max time.Duration
+}
+
+// withDefaults fills in unset fields
+func (t TokenTTLConfig) withDefaults() TokenTTLConfig {
+	if t.TTL <= 0 {
+		t.TTL = DefaultTokenTTL
+	}
+	if t.Min <= 0 {
+		t.Min = DefaultMinTokenTTL
+	}
+	return t
+}
+
+// Validate reports bounds no lifetime can satisfy
+func (t TokenTTLConfig) Validate() error {
+	if t.TTL < 0 || t.Min < 0 || t.Max < 0 {
+		return fmt.Errorf("token lifetimes must not be negative")
+	}
+	t = t.withDefaults()
+	if t.Max > 0 && t.Max < t.Min {
+		return fmt.Errorf("maximum token lifetime %v is below the minimum %v", t.Max, t.Min)
+	}
+	return nil
+}
+
+// Clamp limits ttl to the configured bounds, reporting whether it changed.
+// Unset fields take their defaults.
+func (t TokenTTLConfig) Clamp(ttl time.Duration) (time.Duration, bool) {
+	t = t.withDefaults()
+	clamped := max(ttl, t.Min)
+	if t.Max > 0 {
+		clamped = min(clamped, t.Max)
+	}
+	return clamped, clamped != ttl
+}
+
+// requestTTL is the configured lifetime within its bounds
+func (t TokenTTLConfig) requestTTL() time.Duration {
+	ttl, _ := t.Clamp(t.withDefaults().TTL)
+	return ttl
+}
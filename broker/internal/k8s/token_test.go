@@ -0,0 +1,84 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestTokenTTLConfig_Clamp(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      TokenTTLConfig
+		ttl         time.Duration
+		want        time.Duration
+		wantClamped bool
+	}{
+		{name: "within bounds", ttl: time.Hour, want: time.Hour},
+		{name: "below default minimum", ttl: 5 * time.Minute, want: DefaultMinTokenTTL, wantClamped: true},
+		{name: "below configured minimum", config: TokenTTLConfig{Min: time.Hour}, ttl: 30 * time.Minute, want: time.Hour, wantClamped: true},
+		{name: "above maximum", config: TokenTTLConfig{Max: 2 * time.Hour}, ttl: 24 * time.Hour, want: 2 * time.Hour, wantClamped: true},
+		{name: "no maximum by default", ttl: 48 * time.Hour, want: 48 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clamped := tt.config.Clamp(tt.ttl)
+			if got != tt.want || clamped != tt.wantClamped {
+				t.Errorf("Expected %v (clamped %v), got %v (clamped %v)", tt.want, tt.wantClamped, got, clamped)
+			}
+		})
+	}
+
+	if err := (TokenTTLConfig{Min: time.Hour, Max: 30 * time.Minute}).Validate(); err == nil {
+		t.Error("Expected a maximum below the minimum to be rejected")
+	}
+	if err := (TokenTTLConfig{Max: 5 * time.Minute}).Validate(); err == nil {
+		t.Error("Expected a maximum below the default minimum to be rejected")
+	}
+}
+
+func TestClient_MintToken_Expiry(t *testing.T) {
+	// The API server extends lifetimes below its minimum, here to 15 minutes
+	apiServerExpiry := time.Now().Add(15 * time.Minute).Truncate(time.Second)
+	var requested int64
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		request := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		requested = *request.Spec.ExpirationSeconds
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               "minted",
+				ExpirationTimestamp: metav1.NewTime(apiServerExpiry),
+			},
+		}, nil
+	})
+	client := &Client{clientset: clientset, tokenTTL: TokenTTLConfig{Min: 15 * time.Minute}}
+
+	_, expiresAt, err := client.MintToken(context.Background(), "user-alice", "sa", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if requested != 900 {
+		t.Errorf("Expected the lifetime to be clamped to 900s, requested %ds", requested)
+	}
+	if !expiresAt.Equal(apiServerExpiry) {
+		t.Errorf("Expected the API server's expiry %v, got %v", apiServerExpiry, expiresAt)
+	}
+
+	creds, err := client.RefreshSessionCredentials(context.Background(), "user-alice",
+		&SessionCredentials{ServiceAccount: "sa", Token: "old"})
+	if err != nil || creds.Token != "minted" || !creds.ExpiresAt.Equal(apiServerExpiry) {
+		t.Errorf("Expected refreshed credentials expiring at %v, got %+v (%v)", apiServerExpiry, creds, err)
+	}
+}
@@ -0,0 +1,110 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podMetrics is the part of a metrics.k8s.io/v1beta1 PodMetrics the broker
+// reads, decoded by hand to avoid depending on the metrics client
+type podMetrics struct {
+	Timestamp  metav1.Time     `json:"timestamp"`
+	Window     metav1.Duration `json:"window"`
+	Containers []struct {
+		Name  string              `json:"name"`
+		Usage corev1.ResourceList `json:"usage"`
+	} `json:"containers"`
+}
+
+// metricsUnavailableReason is reported when metrics-server cannot answer
+const metricsUnavailableReason = "metrics unavailable: metrics-server is not installed or has no data for the pod yet"
+
+// GetPodResourceUsage returns the pod's current CPU and memory usage from
+// metrics-server with the requests and limits of its containers. Without
+// metrics-server, or before it has sampled the pod, usage is reported as
+// unavailable rather than failing, so clients can still show the limits.
+func (c *Client) GetPodResourceUsage(ctx context.Context, namespace, name string) (*types.ResourceUsage, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrPodNotFound, namespace, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	if err := c.checkPodAllowed(pod); err != nil {
+		return nil, err
+	}
+
+	data, err := c.clientset.Discovery().RESTClient().Get().
+		AbsPath("/apis/metrics.k8s.io/v1beta1/namespaces", namespace, "pods", name).
+		DoRaw(ctx)
+	switch {
+	// An unregistered or unreachable metrics API and a pod not sampled yet
+	case apierrors.IsNotFound(err), apierrors.IsServiceUnavailable(err):
+		return podResourceUsage(pod, nil, metricsUnavailableReason), nil
+	case apierrors.IsForbidden(err):
+		return podResourceUsage(pod, nil, "metrics unavailable: the broker may not read pod metrics"), nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to get pod metrics: %w", err)
+	}
+
+	var metrics podMetrics
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return nil, fmt.Errorf("failed to decode pod metrics: %w", err)
+	}
+	return podResourceUsage(pod, &metrics, ""), nil
+}
+
+// podResourceUsage combines the pod's container requests and limits with
+// their usage in metrics, if available
+func podResourceUsage(pod *corev1.Pod, metrics *podMetrics, reason string) *types.ResourceUsage {
+	usage := &types.ResourceUsage{Available: metrics != nil, Reason: reason}
+
+	used := make(map[string]corev1.ResourceList)
+	if metrics != nil {
+		timestamp := metrics.Timestamp.Time
+		usage.Timestamp = &timestamp
+		usage.WindowSeconds = metrics.Window.Duration.Seconds()
+		for _, container := range metrics.Containers {
+			used[container.Name] = container.Usage
+		}
+	}
+
+	usage.Containers = make([]types.ContainerResourceUsage, 0, len(pod.Spec.Containers))
+	for _, container := range pod.Spec.Containers {
+		report := types.ContainerResourceUsage{
+			Name:                 container.Name,
+			CPURequestMillicores: container.Resources.Requests.Cpu().MilliValue(),
+			CPULimitMillicores:   container.Resources.Limits.Cpu().MilliValue(),
+			MemoryRequestBytes:   container.Resources.Requests.Memory().Value(),
+			MemoryLimitBytes:     container.Resources.Limits.Memory().Value(),
+		}
+		if list, sampled := used[container.Name]; sampled {
+			report.CPUMillicores = quantityValue(list, corev1.ResourceCPU, (*resource.Quantity).MilliValue)
+			report.MemoryBytes = quantityValue(list, corev1.ResourceMemory, (*resource.Quantity).Value)
+		}
+		usage.Containers = append(usage.Containers, report)
+	}
+	return usage
+}
+
+// quantityValue returns a resource's amount in list, or nil if absent
+func quantityValue(list corev1.ResourceList, name corev1.ResourceName, value func(*resource.Quantity) int64) *int64 {
+	quantity, exists := list[name]
+	if !exists {
+		return nil
+	}
+	amount := value(&quantity)
+	return &amount
+}
@@ -0,0 +1,57 @@
+package k8s
+
+import (
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPodResourceUsage(t *testing.T) {
+	pod := testPod(nil, nil)
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "notebook", Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("4Gi"),
+			},
+		}},
+		{Name: "sidecar"},
+	}
+
+	var metrics podMetrics
+	data := `{"timestamp": "2024-05-01T12:00:00Z", "window": "30s", "containers": [
+		{"name": "notebook", "usage": {"cpu": "1250000000n", "memory": "1048576Ki"}}]}`
+	if err := json.Unmarshal([]byte(data), &metrics); err != nil {
+		t.Fatalf("Expected pod metrics to decode, got %v", err)
+	}
+
+	usage := podResourceUsage(pod, &metrics, "")
+	if !usage.Available || usage.WindowSeconds != 30 || usage.Timestamp == nil {
+		t.Fatalf("Expected a sampled usage report, got %+v", usage)
+	}
+	notebook := usage.Containers[0]
+	if notebook.CPUMillicores == nil || *notebook.CPUMillicores != 1250 ||
+		notebook.MemoryBytes == nil || *notebook.MemoryBytes != 1<<30 {
+		t.Errorf("Expected 1250m CPU and 1Gi memory used, got %+v", notebook)
+	}
+	if notebook.CPURequestMillicores != 500 || notebook.CPULimitMillicores != 2000 ||
+		notebook.MemoryRequestBytes != 0 || notebook.MemoryLimitBytes != 4<<30 {
+		t.Errorf("Expected the container's requests and limits, got %+v", notebook)
+	}
+	if sidecar := usage.Containers[1]; sidecar.CPUMillicores != nil || sidecar.MemoryBytes != nil {
+		t.Errorf("Expected no usage for an unsampled container, got %+v", sidecar)
+	}
+
+	// Without metrics the limits are still reported
+	usage = podResourceUsage(pod, nil, metricsUnavailableReason)
+	if usage.Available || usage.Reason == "" || usage.Timestamp != nil {
+		t.Errorf("Expected usage reported as unavailable, got %+v", usage)
+	}
+	if len(usage.Containers) != 2 || usage.Containers[0].CPULimitMillicores != 2000 ||
+		usage.Containers[0].CPUMillicores != nil {
+		t.Errorf("Expected limits without usage, got %+v", usage.Containers)
+	}
+}
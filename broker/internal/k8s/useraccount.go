@@ -0,0 +1,228 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+)
+
+// ServiceAccount modes control how many ServiceAccounts back a user's sessions
+const (
+	// ServiceAccountModePerSession creates and deletes a ServiceAccount for
+	// every session
+	ServiceAccountModePerSession = "per-session"
+
+	// ServiceAccountModePerUser keeps one ServiceAccount per user and namespace
+	// while any of the user's sessions are open, minting a token per session.
+	// Sessions of the same user share the ServiceAccount's pod access.
+	ServiceAccountModePerUser = "per-user"
+
+	// userAnnotation records the user a per-user ServiceAccount belongs to
+	userAnnotation = "purdue-af.io/user"
+
+	// lastUsedAnnotation records when a session last acquired a per-user
+	// ServiceAccount. The account outlives any single session, so stale
+	// resource cleanup measures its age from here rather than from creation.
+	lastUsedAnnotation = "purdue-af.io/last-used"
+)
+
+// userServiceAccounts reference-counts per-user ServiceAccounts
+type userServiceAccounts struct {
+	mutex   sync.Mutex
+	entries map[string]*userServiceAccount
+}
+
+// userServiceAccount tracks one per-user ServiceAccount. refs is guarded by
+// userServiceAccounts.mutex; mutex serializes creating and deleting the
+// cluster resources.
+type userServiceAccount struct {
+	mutex   sync.Mutex
+	refs    int
+	created bool
+	pods    map[string]bool
+}
+
+// userServiceAccountName derives a stable, DNS-safe ServiceAccount name for a user
+func userServiceAccountName(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return "vscode-user-" + hex.EncodeToString(sum[:])[:12]
+}
+
+// acquire takes a reference on the entry for key, creating the entry if needed
+func (u *userServiceAccounts) acquire(key string) *userServiceAccount {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if u.entries == nil {
+		u.entries = make(map[string]*userServiceAccount)
+	}
+	entry, exists := u.entries[key]
+	if !exists {
+		entry = &userServiceAccount{pods: make(map[string]bool)}
+		u.entries[key] = entry
+	}
+	entry.refs++
+	return entry
+}
+
+// release drops a reference and reports whether it was the last one
+func (u *userServiceAccounts) release(key string) (*userServiceAccount, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	entry, exists := u.entries[key]
+	if !exists {
+		return nil, false
+	}
+	entry.refs--
+	return entry, entry.refs == 0
+}
+
+// inUse reports whether the entry has references
+func (u *userServiceAccounts) inUse(entry *userServiceAccount) bool {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	return entry.refs > 0
+}
+
+// forget removes the entry for key if it is still unreferenced
+func (u *userServiceAccounts) forget(key string, entry *userServiceAccount) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	if entry.refs == 0 && u.entries[key] == entry {
+		delete(u.entries, key)
+	}
+}
+
+// acquireUserServiceAccount returns the user's ServiceAccount with access to
+// podName and a freshly minted token, creating the ServiceAccount on first use
+func (c *Client) acquireUserServiceAccount(ctx context.Context, namespace, podName, userID string) (*SessionCredentials, error) {
+	if err := c.ensureSessionNamespace(ctx, namespace); err != nil {
+		return nil, err
+	}
+
+	saName := userServiceAccountName(userID)
+	key := namespace + "/" + saName
+	entry := c.userAccounts.acquire(key)
+
+	// Setup may fail because ctx expired, so release with a detached context
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), partialCleanupTimeout)
+	defer cancel()
+
+	if err := c.ensureUserServiceAccount(ctx, entry, namespace, saName, podName, userID); err != nil {
+		c.releaseUserServiceAccount(releaseCtx, namespace, saName)
+		return nil, err
+	}
+	if err := c.touchUserServiceAccount(ctx, namespace, saName); err != nil {
+		c.releaseUserServiceAccount(releaseCtx, namespace, saName)
+		return nil, err
+	}
+
+	token, expiresAt, err := c.MintToken(ctx, namespace, saName, c.tokenTTL.requestTTL())
+	if err != nil {
+		c.releaseUserServiceAccount(releaseCtx, namespace, saName)
+		return nil, fmt.Errorf("failed to mint token: %w", err)
+	}
+
+	return &SessionCredentials{ServiceAccount: saName, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// ensureUserServiceAccount creates the ServiceAccount and RoleBinding if needed
+// and makes sure the bound Role grants access to podName
+func (c *Client) ensureUserServiceAccount(ctx context.Context, entry *userServiceAccount, namespace, saName, podName, userID string) error {
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if entry.created && entry.pods[podName] {
+		return nil
+	}
+
+	if !entry.created {
+		sa := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        saName,
+				Namespace:   namespace,
+				Labels:      sessionLabels(saName),
+				Annotations: map[string]string{userAnnotation: userID},
+			},
+		}
+		_, err := c.clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, sa, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create service account: %w", err)
+		}
+	}
+
+	// The user's own Role, which only ever grants the user's pods
+	roleName := sessionRoleName(saName)
+	if err := c.ensureRolePod(ctx, namespace, roleName, sessionLabels(saName), podName); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	if !entry.created {
+		if err := c.ensureRoleBinding(ctx, namespace, roleName, saName, roleName); err != nil {
+			return err
+		}
+		if err := c.ensureSharedRoleBinding(ctx, namespace, saName); err != nil {
+			return err
+		}
+	}
+
+	entry.created = true
+	entry.pods[podName] = true
+	return nil
+}
+
+// touchUserServiceAccount stamps the ServiceAccount's last-used annotation, so
+// cleanup on another broker does not reclaim it while this session is open
+func (c *Client) touchUserServiceAccount(ctx context.Context, namespace, saName string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{lastUsedAnnotation: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.clientset.CoreV1().ServiceAccounts(namespace).Patch(ctx, saName, apitypes.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to record service account use: %w", err)
+	}
+	return nil
+}
+
+// releaseUserServiceAccount drops a session's reference, deleting the
+// ServiceAccount and its RBAC once the user's last session has ended
+func (c *Client) releaseUserServiceAccount(ctx context.Context, namespace, saName string) error {
+	key := namespace + "/" + saName
+	entry, last := c.userAccounts.release(key)
+	if !last {
+		return nil
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	// A new session may have taken a reference while we waited for the lock.
+	// The entry stays in the map until deletion finishes, so sessions arriving
+	// meanwhile wait on its lock and recreate the ServiceAccount afterwards.
+	if c.userAccounts.inUse(entry) {
+		return nil
+	}
+	defer c.userAccounts.forget(key, entry)
+
+	// Also reached when creation failed part way, so remove whatever exists
+	entry.created = false
+	entry.pods = make(map[string]bool)
+	return c.DeleteServiceAccount(ctx, namespace, saName)
+}
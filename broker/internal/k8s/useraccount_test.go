@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newPerUserTestClient returns a per-user client whose fake API server mints tokens
+func newPerUserTestClient() *Client {
+	clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "user-alice"}})
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{Token: "k8s-token"},
+		}, nil
+	})
+
+	return &Client{
+		clientset:          clientset,
+		roleMode:           RoleModePerSession,
+		accessMode:         AccessModeServiceAccount,
+		serviceAccountMode: ServiceAccountModePerUser,
+	}
+}
+
+func TestClient_PerUserServiceAccount_ReferenceCounting(t *testing.T) {
+	client := newPerUserTestClient()
+	ctx := context.Background()
+
+	first, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if first.ServiceAccount != second.ServiceAccount {
+		t.Fatalf("Expected sessions to share a ServiceAccount, got %s and %s", first.ServiceAccount, second.ServiceAccount)
+	}
+	if first.Token == "" || second.Token == "" {
+		t.Error("Expected a token minted for each session")
+	}
+
+	serviceAccounts := client.clientset.CoreV1().ServiceAccounts("user-alice")
+	if err := client.ReleaseSessionCredentials(ctx, "user-alice", first); err != nil {
+		t.Fatalf("Expected no error releasing credentials, got %v", err)
+	}
+	if _, err := serviceAccounts.Get(ctx, first.ServiceAccount, metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected ServiceAccount to remain while a session is open, got %v", err)
+	}
+
+	if err := client.ReleaseSessionCredentials(ctx, "user-alice", second); err != nil {
+		t.Fatalf("Expected no error releasing credentials, got %v", err)
+	}
+	if _, err := serviceAccounts.Get(ctx, first.ServiceAccount, metav1.GetOptions{}); err == nil {
+		t.Error("Expected ServiceAccount to be deleted after the last session")
+	}
+	if _, err := client.clientset.RbacV1().RoleBindings("user-alice").Get(
+		ctx, sessionRoleName(first.ServiceAccount), metav1.GetOptions{}); err == nil {
+		t.Error("Expected RoleBinding to be deleted after the last session")
+	}
+
+	// The next session recreates the ServiceAccount
+	third, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := serviceAccounts.Get(ctx, third.ServiceAccount, metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected ServiceAccount to be recreated, got %v", err)
+	}
+}
+
+func TestClient_PerUserServiceAccount_Concurrent(t *testing.T) {
+	client := newPerUserTestClient()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			creds, err := client.CreateSessionCredentials(ctx, "user-alice", "jupyter-alice", "alice@purdue.edu")
+			if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+				return
+			}
+			client.ReleaseSessionCredentials(ctx, "user-alice", creds)
+		}()
+	}
+	wg.Wait()
+
+	serviceAccounts, err := client.clientset.CoreV1().ServiceAccounts("user-alice").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error listing service accounts, got %v", err)
+	}
+	if len(serviceAccounts.Items) != 0 {
+		t.Errorf("Expected no ServiceAccounts after all sessions ended, got %d", len(serviceAccounts.Items))
+	}
+	if len(client.userAccounts.entries) != 0 {
+		t.Errorf("Expected no reference-counted entries, got %d", len(client.userAccounts.entries))
+	}
+}
@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// defaultContainerAnnotation names the container kubectl and exec use when
+// none is given
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// serviceAccountMountPrefix holds injected API credentials, never user data
+const serviceAccountMountPrefix = "/var/run/secrets/"
+
+// GetVolumeMounts returns the volumes mounted into the pod's default
+// container, the one exec requests run in. Service account credential mounts
+// are left out.
+func (c *Client) GetVolumeMounts(ctx context.Context, namespace, name string) ([]types.VolumeMount, error) {
+	if err := c.checkNamespaceAllowed(namespace); err != nil {
+		return nil, err
+	}
+
+	pod, err := c.clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s/%s", ErrPodNotFound, namespace, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod: %w", err)
+	}
+	if err := c.checkPodAllowed(pod); err != nil {
+		return nil, err
+	}
+
+	container := defaultContainer(pod)
+	if container == nil {
+		return nil, errors.New("pod has no containers")
+	}
+
+	claims := make(map[string]string)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+		}
+	}
+
+	mounts := []types.VolumeMount{}
+	for _, mount := range container.VolumeMounts {
+		if strings.HasPrefix(mount.MountPath, serviceAccountMountPrefix) {
+			continue
+		}
+		mounts = append(mounts, types.VolumeMount{
+			Name:      mount.Name,
+			MountPath: mount.MountPath,
+			ReadOnly:  mount.ReadOnly,
+			ClaimName: claims[mount.Name],
+		})
+	}
+	return mounts, nil
+}
+
+// defaultContainer returns the container named by the default container
+// annotation, or the first container
+func defaultContainer(pod *corev1.Pod) *corev1.Container {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == name {
+				return &pod.Spec.Containers[i]
+			}
+		}
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return nil
+	}
+	return &pod.Spec.Containers[0]
+}
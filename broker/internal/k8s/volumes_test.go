@@ -0,0 +1,43 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestClient_GetVolumeMounts(t *testing.T) {
+	pod := testPod(nil, map[string]string{defaultContainerAnnotation: "notebook"})
+	pod.Spec.Volumes = []corev1.Volume{{
+		Name: "home",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "claim-alice"},
+		},
+	}}
+	pod.Spec.Containers = []corev1.Container{
+		{Name: "sidecar", VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/logs"}}},
+		{Name: "notebook", VolumeMounts: []corev1.VolumeMount{
+			{Name: "home", MountPath: "/home/jovyan"},
+			{Name: "datasets", MountPath: "/data", ReadOnly: true},
+			{Name: "kube-api-access-x7k2p", MountPath: "/var/run/secrets/kubernetes.io/serviceaccount", ReadOnly: true},
+		}},
+	}
+
+	client := &Client{clientset: fake.NewSimpleClientset(pod)}
+	mounts, err := client.GetVolumeMounts(context.Background(), "user-alice", "jupyter-alice")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(mounts) != 2 {
+		t.Fatalf("Expected the notebook container's 2 data mounts, got %+v", mounts)
+	}
+	if mounts[0].MountPath != "/home/jovyan" || mounts[0].ClaimName != "claim-alice" {
+		t.Errorf("Expected home mount backed by claim-alice, got %+v", mounts[0])
+	}
+	if mounts[1].MountPath != "/data" || !mounts[1].ReadOnly || mounts[1].ClaimName != "" {
+		t.Errorf("Expected read-only data mount, got %+v", mounts[1])
+	}
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "broker"
+
+var (
+	// ActiveTunnels is the number of tunnels currently open on this broker
+	ActiveTunnels = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_tunnels",
+		Help:      "Number of tunnels currently open.",
+	})
+
+	// MaxTunnels is the configured broker-wide tunnel limit, 0 when unlimited
+	MaxTunnels = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "max_tunnels",
+		Help:      "Configured maximum number of concurrent tunnels, 0 when unlimited.",
+	})
+
+	// Sessions is the number of sessions held in the session store,
+	// including expired ones not yet cleaned up
+	Sessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sessions",
+		Help:      "Number of sessions held in the session store.",
+	})
+
+	// MaxSessions is the configured session store limit, 0 when unlimited
+	MaxSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "max_sessions",
+		Help:      "Configured maximum number of stored sessions, 0 when unlimited.",
+	})
+
+	// SessionsEvicted counts live sessions evicted to make room for new ones
+	SessionsEvicted = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_evicted_total",
+		Help:      "Live sessions evicted because the session store was full.",
+	})
+
+	// TunnelsRejected counts tunnel connections refused, by reason
+	TunnelsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnels_rejected_total",
+		Help:      "Tunnel connections refused, by reason.",
+	}, []string{"reason"})
+
+	// UpgradeFailures counts WebSocket upgrades that failed, by reason
+	UpgradeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_upgrade_failures_total",
+		Help:      "WebSocket upgrades that failed, by reason.",
+	}, []string{"reason"})
+
+	// AuthPendingFlows is the number of logins started and waiting for their
+	// callback
+	AuthPendingFlows = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "auth_pending_flows",
+		Help:      "Logins started and waiting for their callback.",
+	})
+
+	// NamespaceRelays is the number of relays open in each namespace, across
+	// all of its sessions' tunnels
+	NamespaceRelays = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "namespace_relays",
+		Help:      "Relays currently open, by namespace.",
+	}, []string{"namespace"})
+
+	// AuthDuration observes OIDC provider call latency, by operation and outcome
+	AuthDuration = newAuthDuration(DefaultAuthBuckets)
+)
+
+// DefaultAuthBuckets spans fast token validations to slow interactive exchanges, in seconds
+var DefaultAuthBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+func newAuthDuration(buckets []float64) *prometheus.HistogramVec {
+	return promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "auth_duration_seconds",
+		Help:      "Duration of OIDC provider calls, by operation and outcome.",
+		Buckets:   buckets,
+	}, []string{"operation", "outcome"})
+}
+
+// SetAuthBuckets replaces the AuthDuration buckets. It must be called at
+// startup, before any observations.
+func SetAuthBuckets(buckets []float64) {
+	prometheus.Unregister(AuthDuration)
+	AuthDuration = newAuthDuration(buckets)
+}
@@ -4,35 +4,214 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
 // InMemoryStore implements Store using in-memory storage
 type InMemoryStore struct {
-	sessions  map[string]*types.Session
-	tokens    map[string]string // token -> sessionID mapping
-	mutex     sync.RWMutex
-	ttl       time.Duration
-	jwtSecret string
+	sessions map[string]*types.Session
+	tokens   map[string]string // token -> sessionID mapping
+	mutex    sync.RWMutex
+	ttl      time.Duration
+	maxTTL   time.Duration
+	signer   *Signer
+	leeway   time.Duration
+
+	tokenTTL     time.Duration
+	renewalGrace time.Duration
+	retired      map[string]time.Time // renewed token -> end of its grace window
+
+	handoffTTL time.Duration
+	handoffs   map[string]handoff // one-time handoff code -> session
+
+	idempotencyTTL time.Duration
+	idempotency    map[string]idempotentRequest
+
+	maxSessions    int
+	evictionPolicy string
+	onEvict        func(session *types.Session)
+
+	cleanupInterval time.Duration
+}
+
+// idempotentRequest is a session creation recorded under an idempotency key;
+// sessionID is empty while the request is in progress. fingerprint identifies
+// the request, so the key cannot be reused for a different one.
+type idempotentRequest struct {
+	sessionID   string
+	fingerprint string
+	expiresAt   time.Time
+}
+
+// handoff is an unclaimed code for moving a session to another device
+type handoff struct {
+	sessionID string
+	expiresAt time.Time
+}
+
+// DefaultCleanupInterval is how often expired sessions are removed
+const DefaultCleanupInterval = 5 * time.Minute
+
+// DefaultMaxTTL is the longest session lifetime allowed unless configured
+const DefaultMaxTTL = 7 * 24 * time.Hour
+
+// DefaultTokenTTL is the lifetime of each session token unless configured
+const DefaultTokenTTL = 15 * time.Minute
+
+// DefaultHandoffTTL is how long a handoff code can be claimed unless configured
+const DefaultHandoffTTL = 2 * time.Minute
+
+// DefaultIdempotencyTTL is how long idempotency keys are remembered unless configured
+const DefaultIdempotencyTTL = 10 * time.Minute
+
+// Policies for creating a session when the store holds MaxSessions. Expired
+// sessions are always removed first to make room.
+const (
+	// EvictionReject fails the new session with ErrStoreFull
+	EvictionReject = "reject"
+
+	// EvictionOldest removes the oldest live session
+	EvictionOldest = "evict-oldest"
+)
+
+// cleanupBatchSize bounds how many entries are deleted per write lock, so a
+// large cleanup does not block session lookups for its whole duration
+const cleanupBatchSize = 500
+
+// StoreConfig represents session store configuration
+type StoreConfig struct {
+	TTL       string
+	JWTSecret string
+
+	// MaxTTL is a hard cap on session lifetime, DefaultMaxTTL if zero. TTL is
+	// clamped to it, and no session outlives it from creation even if its
+	// expiry is later extended.
+	MaxTTL time.Duration
+
+	// Signer signs session tokens, HS256 with JWTSecret if nil
+	Signer *Signer
+
+	// ClockSkewLeeway is how long past its expiry a session or session token is
+	// still accepted, to tolerate clock drift between the broker, its replicas and
+	// clients. A larger leeway avoids spurious rejections at the boundary at the
+	// cost of keeping expired credentials usable for that much longer.
+	ClockSkewLeeway time.Duration
+
+	// TokenTTL limits each session token to this lifetime, renewed over the
+	// tunnel, DefaultTokenTTL if zero
+	TokenTTL time.Duration
+
+	// TokenRenewalGrace is how long a token stays valid after being renewed
+	TokenRenewalGrace time.Duration
+
+	// HandoffTTL is how long a code for continuing a session on another
+	// device can be claimed, DefaultHandoffTTL if zero
+	HandoffTTL time.Duration
+
+	// IdempotencyTTL is how long a session creation is remembered under its
+	// idempotency key, DefaultIdempotencyTTL if zero. It also bounds how long
+	// a stalled request keeps its key reserved.
+	IdempotencyTTL time.Duration
+
+	// CleanupInterval is how often expired sessions are removed,
+	// DefaultCleanupInterval if zero
+	CleanupInterval time.Duration
+
+	// MaxSessions caps the sessions held at once, 0 for no limit. Creating a
+	// session beyond it removes expired sessions, then applies EvictionPolicy.
+	MaxSessions int
+
+	// EvictionPolicy is EvictionReject (the default) or EvictionOldest
+	EvictionPolicy string
+
+	// OnEvict, if set, is called with each live session evicted to make room,
+	// once it is removed, to tear it down like a deleted session
+	OnEvict func(session *types.Session)
 }
 
 // NewInMemoryStore creates a new in-memory session store
 func NewInMemoryStore(ttlStr, jwtSecret string) *InMemoryStore {
-	ttl, _ := time.ParseDuration(ttlStr)
+	return NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:       ttlStr,
+		JWTSecret: jwtSecret,
+	})
+}
+
+// NewInMemoryStoreWithConfig creates a new in-memory session store from config
+func NewInMemoryStoreWithConfig(config StoreConfig) *InMemoryStore {
+	ttl, _ := time.ParseDuration(config.TTL)
 	if ttl == 0 {
 		ttl = 24 * time.Hour
 	}
 
+	maxTTL := config.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxTTL
+	}
+	ttl = min(ttl, maxTTL)
+
+	handoffTTL := config.HandoffTTL
+	if handoffTTL <= 0 {
+		handoffTTL = DefaultHandoffTTL
+	}
+
+	idempotencyTTL := config.IdempotencyTTL
+	if idempotencyTTL <= 0 {
+		idempotencyTTL = DefaultIdempotencyTTL
+	}
+
+	tokenTTL := config.TokenTTL
+	if tokenTTL <= 0 {
+		tokenTTL = DefaultTokenTTL
+	}
+
+	cleanupInterval := config.CleanupInterval
+	if cleanupInterval <= 0 {
+		cleanupInterval = DefaultCleanupInterval
+	}
+
+	signer := config.Signer
+	if signer == nil {
+		signer = newHMACSigner(config.JWTSecret)
+	}
+
+	evictionPolicy := config.EvictionPolicy
+	if evictionPolicy == "" {
+		evictionPolicy = EvictionReject
+	}
+	metrics.MaxSessions.Set(float64(config.MaxSessions))
+
 	store := &InMemoryStore{
-		sessions:  make(map[string]*types.Session),
-		tokens:    make(map[string]string),
-		ttl:       ttl,
-		jwtSecret: jwtSecret,
+		sessions: make(map[string]*types.Session),
+		tokens:   make(map[string]string),
+		ttl:      ttl,
+		maxTTL:   maxTTL,
+		signer:   signer,
+		leeway:   config.ClockSkewLeeway,
+
+		tokenTTL:     tokenTTL,
+		renewalGrace: config.TokenRenewalGrace,
+		retired:      make(map[string]time.Time),
+
+		handoffTTL: handoffTTL,
+		handoffs:   make(map[string]handoff),
+
+		idempotencyTTL: idempotencyTTL,
+		idempotency:    make(map[string]idempotentRequest),
+
+		maxSessions:    config.MaxSessions,
+		evictionPolicy: evictionPolicy,
+		onEvict:        config.OnEvict,
+
+		cleanupInterval: cleanupInterval,
 	}
 
 	// Start cleanup goroutine
@@ -44,27 +223,87 @@ func NewInMemoryStore(ttlStr, jwtSecret string) *InMemoryStore {
 // Create creates a new session
 func (s *InMemoryStore) Create(ctx context.Context, req CreateRequest) (*types.Session, error) {
 	sessionID := generateSessionID()
-	sessionToken := s.generateSessionToken(sessionID, req.UserID)
+	now := time.Now()
+	expiresAt := now.Add(s.ttl)
+	sessionToken := s.generateSessionToken(sessionID, req.UserID, now, s.tokenExpiry(now, expiresAt))
 
 	session := &types.Session{
 		ID:           sessionID,
 		UserID:       req.UserID,
 		Token:        sessionToken,
 		PodInfo:      req.PodInfo,
-		CreatedAt:    time.Now(),
-		ExpiresAt:    time.Now().Add(s.ttl),
+		CreatedAt:    now,
+		ExpiresAt:    expiresAt,
 		RefreshToken: req.RefreshToken,
 	}
+	if len(req.Metadata) > 0 {
+		session.Metadata = make(map[string]string, len(req.Metadata))
+		for key, value := range req.Metadata {
+			session.Metadata[key] = value
+		}
+	}
 
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
 
+	// Session IDs are random, but should one ever be reused the replaced
+	// session's tokens must not keep resolving to the new session
+	var evicted *types.Session
+	if existing, exists := s.sessions[sessionID]; exists {
+		s.removeTokens(sessionID, existing)
+	} else if s.maxSessions > 0 && len(s.sessions) >= s.maxSessions {
+		var err error
+		if evicted, err = s.makeRoom(now); err != nil {
+			s.mutex.Unlock()
+			return nil, err
+		}
+	}
 	s.sessions[sessionID] = session
 	s.tokens[sessionToken] = sessionID
+	s.recordCount()
+	s.mutex.Unlock()
 
+	if evicted != nil && s.onEvict != nil {
+		s.onEvict(evicted)
+	}
 	return session, nil
 }
 
+// makeRoom frees a slot in a full store by removing expired sessions, or
+// else the oldest live one if the eviction policy allows, returning it.
+// Callers must hold the write lock.
+func (s *InMemoryStore) makeRoom(now time.Time) (*types.Session, error) {
+	var oldest *types.Session
+	for sessionID, session := range s.sessions {
+		if s.isExpired(session, now) {
+			delete(s.sessions, sessionID)
+			s.removeTokens(sessionID, session)
+			continue
+		}
+		if oldest == nil || session.CreatedAt.Before(oldest.CreatedAt) {
+			oldest = session
+		}
+	}
+	if len(s.sessions) < s.maxSessions {
+		return nil, nil
+	}
+
+	if s.evictionPolicy != EvictionOldest {
+		return nil, ErrStoreFull
+	}
+	log.Printf("Session store is full, evicting session %s of user %s created at %s",
+		oldest.ID, oldest.UserID, oldest.CreatedAt.Format(time.RFC3339))
+	delete(s.sessions, oldest.ID)
+	s.removeTokens(oldest.ID, oldest)
+	metrics.SessionsEvicted.Inc()
+	return oldest, nil
+}
+
+// recordCount exports the number of stored sessions. Callers must hold the
+// write lock.
+func (s *InMemoryStore) recordCount() {
+	metrics.Sessions.Set(float64(len(s.sessions)))
+}
+
 // Get retrieves a session by ID
 func (s *InMemoryStore) Get(ctx context.Context, sessionID string) (*types.Session, error) {
 	s.mutex.RLock()
@@ -72,11 +311,11 @@ func (s *InMemoryStore) Get(ctx context.Context, sessionID string) (*types.Sessi
 
 	session, exists := s.sessions[sessionID]
 	if !exists {
-		return nil, fmt.Errorf("session not found")
+		return nil, ErrSessionNotFound
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		return nil, fmt.Errorf("session expired")
+	if s.isExpired(session, time.Now()) {
+		return nil, ErrSessionExpired
 	}
 
 	return session, nil
@@ -84,21 +323,29 @@ func (s *InMemoryStore) Get(ctx context.Context, sessionID string) (*types.Sessi
 
 // GetByToken retrieves a session by token
 func (s *InMemoryStore) GetByToken(ctx context.Context, token string) (*types.Session, error) {
+	claims, err := s.verifySessionToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	sessionID, exists := s.tokens[token]
-	if !exists {
+	if !exists || sessionID != claims["session_id"] {
 		return nil, fmt.Errorf("invalid token")
 	}
+	if graceUntil, renewed := s.retired[token]; renewed && time.Now().After(graceUntil) {
+		return nil, fmt.Errorf("token has been renewed")
+	}
 
 	session, exists := s.sessions[sessionID]
 	if !exists {
-		return nil, fmt.Errorf("session not found")
+		return nil, ErrSessionNotFound
 	}
 
-	if time.Now().After(session.ExpiresAt) {
-		return nil, fmt.Errorf("session expired")
+	if s.isExpired(session, time.Now()) {
+		return nil, ErrSessionExpired
 	}
 
 	return session, nil
@@ -111,77 +358,375 @@ func (s *InMemoryStore) Delete(ctx context.Context, sessionID string) error {
 
 	session, exists := s.sessions[sessionID]
 	if !exists {
-		return fmt.Errorf("session not found")
+		return ErrSessionNotFound
 	}
 
-	// Remove from both maps
 	delete(s.sessions, sessionID)
-	delete(s.tokens, session.Token)
+	s.removeTokens(sessionID, session)
+	s.recordCount()
 
+	// An expired session is removed all the same, but reported so the client
+	// knows it had already timed out
+	if s.isExpired(session, time.Now()) {
+		return ErrSessionExpired
+	}
 	return nil
 }
 
-// CleanupExpired removes expired sessions
-func (s *InMemoryStore) CleanupExpired(ctx context.Context) error {
+// RenewToken issues a new session token. The previous token stays valid for
+// the renewal grace window so in-flight reconnects are not rejected.
+func (s *InMemoryStore) RenewToken(ctx context.Context, sessionID string) (*types.Session, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+
 	now := time.Now()
-	for sessionID, session := range s.sessions {
-		if now.After(session.ExpiresAt) {
-			delete(s.tokens, session.Token)
-			delete(s.sessions, sessionID)
+	if s.isExpired(session, now) {
+		return nil, ErrSessionExpired
+	}
+
+	// Replace rather than mutate the session, which callers may hold
+	renewed := *session
+	renewed.Token = s.generateSessionToken(sessionID, session.UserID, now, s.tokenExpiry(now, session.ExpiresAt))
+
+	s.retired[session.Token] = now.Add(s.renewalGrace)
+	s.tokens[renewed.Token] = sessionID
+	s.sessions[sessionID] = &renewed
+
+	return &renewed, nil
+}
+
+// SetTunnelOwner records which broker instance holds the session's tunnel
+func (s *InMemoryStore) SetTunnelOwner(ctx context.Context, sessionID, owner string) error {
+	return s.updateTunnelOwner(sessionID, func(string) string { return owner })
+}
+
+// ClearTunnelOwner forgets the tunnel owner if it is still owner
+func (s *InMemoryStore) ClearTunnelOwner(ctx context.Context, sessionID, owner string) error {
+	return s.updateTunnelOwner(sessionID, func(current string) string {
+		if current == owner {
+			return ""
 		}
+		return current
+	})
+}
+
+func (s *InMemoryStore) updateTunnelOwner(sessionID string, update func(current string) string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return ErrSessionNotFound
 	}
 
+	// Replace rather than mutate the session, which callers may hold
+	updated := *session
+	updated.TunnelOwner = update(session.TunnelOwner)
+	s.sessions[sessionID] = &updated
 	return nil
 }
 
-// Helper functions
+// CreateHandoff issues a one-time code for continuing the session on another
+// device. Codes are not tied to a token, so renewals do not invalidate them.
+func (s *InMemoryStore) CreateHandoff(ctx context.Context, sessionID string) (string, time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-func generateSessionID() string {
-	bytes := make([]byte, 16)
-	rand.Read(bytes)
-	return hex.EncodeToString(bytes)
+	session, exists := s.sessions[sessionID]
+	if !exists {
+		return "", time.Time{}, ErrSessionNotFound
+	}
+
+	now := time.Now()
+	if s.isExpired(session, now) {
+		return "", time.Time{}, ErrSessionExpired
+	}
+
+	code := generateSessionID()
+	expiresAt := now.Add(s.handoffTTL)
+	s.handoffs[code] = handoff{sessionID: sessionID, expiresAt: expiresAt}
+
+	return code, expiresAt, nil
 }
 
-func (s *InMemoryStore) generateSessionToken(sessionID, userID string) string {
-	claims := jwt.MapClaims{
-		"session_id": sessionID,
-		"user_id":    userID,
-		"exp":        time.Now().Add(15 * time.Minute).Unix(), // Short-lived session token
-		"iat":        time.Now().Unix(),
+// ClaimHandoff invalidates the code and returns its session. A code is
+// removed on any claim attempt, so it cannot be retried once presented.
+func (s *InMemoryStore) ClaimHandoff(ctx context.Context, code string) (*types.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pending, exists := s.handoffs[code]
+	if !exists {
+		return nil, ErrHandoffInvalid
 	}
+	delete(s.handoffs, code)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, _ := token.SignedString([]byte(s.jwtSecret))
-	return tokenString
+	now := time.Now()
+	if now.After(pending.expiresAt) {
+		return nil, ErrHandoffInvalid
+	}
+
+	session, exists := s.sessions[pending.sessionID]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	if s.isExpired(session, now) {
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
 }
 
-func (s *InMemoryStore) cleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// ReserveIdempotencyKey claims key for a session being created
+func (s *InMemoryStore) ReserveIdempotencyKey(ctx context.Context, key, fingerprint string) (*types.Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	for range ticker.C {
-		ctx := context.Background()
-		s.CleanupExpired(ctx)
+	now := time.Now()
+	if request, exists := s.idempotency[key]; exists && now.Before(request.expiresAt) {
+		if request.fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if request.sessionID == "" {
+			return nil, ErrRequestInProgress
+		}
+		session, exists := s.sessions[request.sessionID]
+		if !exists {
+			return nil, ErrSessionNotFound
+		}
+		if s.isExpired(session, now) {
+			return nil, ErrSessionExpired
+		}
+		return session, nil
 	}
+
+	s.idempotency[key] = idempotentRequest{fingerprint: fingerprint, expiresAt: now.Add(s.idempotencyTTL)}
+	return nil, nil
 }
 
+// CompleteIdempotencyKey records the session created under a reserved key
+func (s *InMemoryStore) CompleteIdempotencyKey(ctx context.Context, key, sessionID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	request := s.idempotency[key]
+	request.sessionID = sessionID
+	request.expiresAt = time.Now().Add(s.idempotencyTTL)
+	s.idempotency[key] = request
+	return nil
+}
 
+// ReleaseIdempotencyKey frees a reserved key whose request failed
+func (s *InMemoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
+	if request, exists := s.idempotency[key]; exists && request.sessionID == "" {
+		delete(s.idempotency, key)
+	}
+	return nil
+}
 
+// CleanupExpired removes expired sessions. Expired entries are collected under
+// the read lock, then deleted in batches so session operations can proceed
+// between them.
+func (s *InMemoryStore) CleanupExpired(ctx context.Context) error {
+	now := time.Now()
 
+	s.mutex.RLock()
+	var expiredSessions, expiredTokens []string
+	for sessionID, session := range s.sessions {
+		if s.isExpired(session, now) {
+			expiredSessions = append(expiredSessions, sessionID)
+		}
+	}
+	for token, graceUntil := range s.retired {
+		if now.After(graceUntil) {
+			expiredTokens = append(expiredTokens, token)
+		}
+	}
+	var expiredHandoffs []string
+	for code, pending := range s.handoffs {
+		if _, exists := s.sessions[pending.sessionID]; !exists || now.After(pending.expiresAt) {
+			expiredHandoffs = append(expiredHandoffs, code)
+		}
+	}
+	// Token entries should never outlive their session; sweep any that do
+	// so a missed path cannot leave a token resolving to a reused ID
+	var orphanedTokens []string
+	for token, sessionID := range s.tokens {
+		if _, exists := s.sessions[sessionID]; !exists {
+			orphanedTokens = append(orphanedTokens, token)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for start := 0; start < len(expiredSessions); start += cleanupBatchSize {
+		end := min(start+cleanupBatchSize, len(expiredSessions))
+
+		s.mutex.Lock()
+		for _, sessionID := range expiredSessions[start:end] {
+			// Look the session up again, its token may have been renewed since
+			if session, exists := s.sessions[sessionID]; exists && s.isExpired(session, now) {
+				delete(s.sessions, sessionID)
+				s.removeTokens(sessionID, session)
+			}
+		}
+		s.recordCount()
+		s.mutex.Unlock()
+	}
 
+	for start := 0; start < len(expiredTokens); start += cleanupBatchSize {
+		end := min(start+cleanupBatchSize, len(expiredTokens))
 
+		s.mutex.Lock()
+		for _, token := range expiredTokens[start:end] {
+			delete(s.tokens, token)
+			delete(s.retired, token)
+		}
+		s.mutex.Unlock()
+	}
 
+	for start := 0; start < len(orphanedTokens); start += cleanupBatchSize {
+		end := min(start+cleanupBatchSize, len(orphanedTokens))
 
+		s.mutex.Lock()
+		for _, token := range orphanedTokens[start:end] {
+			if _, exists := s.sessions[s.tokens[token]]; !exists {
+				delete(s.tokens, token)
+				delete(s.retired, token)
+			}
+		}
+		s.mutex.Unlock()
+	}
 
+	if len(expiredHandoffs) > 0 {
+		s.mutex.Lock()
+		for _, code := range expiredHandoffs {
+			delete(s.handoffs, code)
+		}
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	for key, request := range s.idempotency {
+		if now.After(request.expiresAt) {
+			delete(s.idempotency, key)
+		}
+	}
+	s.mutex.Unlock()
 
+	return nil
+}
 
+// removeTokens drops the session's current token and any renewed tokens still
+// in their grace window. Callers must hold the write lock.
+func (s *InMemoryStore) removeTokens(sessionID string, session *types.Session) {
+	delete(s.tokens, session.Token)
+	delete(s.retired, session.Token)
+	for token := range s.retired {
+		if s.tokens[token] == sessionID {
+			delete(s.tokens, token)
+			delete(s.retired, token)
+		}
+	}
+}
 
+// Helper functions
 
+func generateSessionID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
 
+// isExpired reports whether the session is past its expiry or the maximum
+// TTL, allowing for clock skew
+func (s *InMemoryStore) isExpired(session *types.Session, now time.Time) bool {
+	expiresAt := session.ExpiresAt
+	if deadline := session.CreatedAt.Add(s.maxTTL); deadline.Before(expiresAt) {
+		expiresAt = deadline
+	}
+	return now.After(expiresAt.Add(s.leeway))
+}
 
+// ValidateTTL checks that a configured session TTL parses and does not
+// exceed maxTTL (DefaultMaxTTL if zero)
+func ValidateTTL(ttl string, maxTTL time.Duration) error {
+	if maxTTL <= 0 {
+		maxTTL = DefaultMaxTTL
+	}
 
+	parsed, err := time.ParseDuration(ttl)
+	if err != nil {
+		return fmt.Errorf("invalid session TTL %q: %w", ttl, err)
+	}
+	if parsed <= 0 {
+		return fmt.Errorf("session TTL must be positive, got %s", ttl)
+	}
+	if parsed > maxTTL {
+		return fmt.Errorf("session TTL %s exceeds the maximum of %s", parsed, maxTTL)
+	}
+	return nil
+}
+
+// tokenExpiry returns when a token issued now expires, never after the session
+func (s *InMemoryStore) tokenExpiry(now, sessionExpiresAt time.Time) time.Time {
+	if now.Add(s.tokenTTL).Before(sessionExpiresAt) {
+		return now.Add(s.tokenTTL)
+	}
+	return sessionExpiresAt
+}
+
+func (s *InMemoryStore) generateSessionToken(sessionID, userID string, issuedAt, expiresAt time.Time) string {
+	claims := jwt.MapClaims{
+		"session_id": sessionID,
+		"user_id":    userID,
+		"exp":        expiresAt.Unix(), // Short-lived, never past the session's expiry
+		"iat":        issuedAt.Unix(),
+		"jti":        generateSessionID(), // Keeps tokens renewed within a second distinct
+	}
+
+	tokenString, _ := s.signer.Sign(claims)
+	return tokenString
+}
+
+// verifySessionToken checks the token signature and expiry, allowing for clock skew
+func (s *InMemoryStore) verifySessionToken(tokenString string) (jwt.MapClaims, error) {
+	if err := checkTokenStructure(tokenString); err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	err := s.signer.Parse(tokenString, claims,
+		jwt.WithLeeway(s.leeway),
+		jwt.WithExpirationRequired(),
+	)
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return nil, fmt.Errorf("%w: %v", ErrTokenMalformed, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return nil, ErrTokenUnknownKey
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrTokenExpired
+	case err != nil:
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (s *InMemoryStore) cleanupLoop() {
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx := context.Background()
+		s.CleanupExpired(ctx)
+	}
+}
@@ -2,6 +2,9 @@ package session
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -119,7 +122,643 @@ func TestInMemoryStore_SessionExpiry(t *testing.T) {
 
 	// Verify session is expired
 	_, err = store.Get(context.Background(), session.ID)
-	if err == nil {
-		t.Fatal("Expected error retrieving expired session")
+	if !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected ErrSessionExpired retrieving expired session, got %v", err)
+	}
+
+	// An unknown ID is reported differently from a timed out session
+	_, err = store.Get(context.Background(), "non-existent")
+	if !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound for an unknown session, got %v", err)
+	}
+
+	// Deleting the expired session removes it but still reports the expiry
+	if err := store.Delete(context.Background(), session.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Fatalf("Expected ErrSessionExpired deleting expired session, got %v", err)
+	}
+	if err := store.Delete(context.Background(), session.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound after deletion, got %v", err)
+	}
+}
+
+func TestInMemoryStore_MaxTTL(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:       "24h",
+		MaxTTL:    time.Hour,
+		JWTSecret: "test-secret",
+	})
+
+	session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+	if ttl := session.ExpiresAt.Sub(session.CreatedAt); ttl != time.Hour {
+		t.Errorf("Expected TTL capped at 1h, got %v", ttl)
+	}
+
+	// An expiry extended past the cap does not keep the session alive
+	extended := *session
+	extended.CreatedAt = time.Now().Add(-2 * time.Hour)
+	extended.ExpiresAt = time.Now().Add(time.Hour)
+	store.mutex.Lock()
+	store.sessions[session.ID] = &extended
+	store.mutex.Unlock()
+
+	if _, err := store.Get(context.Background(), session.ID); err == nil {
+		t.Fatal("Expected session past the maximum TTL to be expired")
+	}
+}
+
+func TestValidateTTL(t *testing.T) {
+	tests := []struct {
+		ttl     string
+		maxTTL  time.Duration
+		wantErr bool
+	}{
+		{ttl: "24h"},
+		{ttl: "168h"},
+		{ttl: "169h", wantErr: true},
+		{ttl: "2h", maxTTL: time.Hour, wantErr: true},
+		{ttl: "0s", wantErr: true},
+		{ttl: "forever", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ttl, func(t *testing.T) {
+			err := ValidateTTL(tt.ttl, tt.maxTTL)
+			if tt.wantErr != (err != nil) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestInMemoryStore_SessionExpiryWithinLeeway(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:             "10ms",
+		JWTSecret:       "test-secret",
+		ClockSkewLeeway: time.Hour,
+	})
+
+	req := CreateRequest{
+		UserID:       "test-user",
+		RefreshToken: "test-refresh-token",
+		PodInfo: types.PodInfo{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+			Status:    "Running",
+		},
+	}
+
+	session, err := store.Create(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	// Wait until the session is past its expiry but within the leeway
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := store.Get(context.Background(), session.ID); err != nil {
+		t.Fatalf("Expected session within leeway to be accepted, got %v", err)
+	}
+
+	if _, err := store.GetByToken(context.Background(), session.Token); err != nil {
+		t.Fatalf("Expected token within leeway to be accepted, got %v", err)
+	}
+}
+
+func TestInMemoryStore_TokenExpirySkewBoundary(t *testing.T) {
+	tests := []struct {
+		name    string
+		leeway  time.Duration
+		expired time.Duration
+		valid   bool
+	}{
+		{name: "expired within leeway", leeway: 30 * time.Second, expired: 10 * time.Second, valid: true},
+		{name: "expired beyond leeway", leeway: 30 * time.Second, expired: time.Minute, valid: false},
+		{name: "expired without leeway", leeway: 0, expired: 10 * time.Second, valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewInMemoryStoreWithConfig(StoreConfig{
+				TTL:             "1h",
+				JWTSecret:       "test-secret",
+				ClockSkewLeeway: tt.leeway,
+			})
+
+			session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user"})
+			if err != nil {
+				t.Fatalf("Expected no error creating session, got %v", err)
+			}
+
+			// Swap in a token whose exp claim is already in the past
+			now := time.Now()
+			token := store.generateSessionToken(session.ID, session.UserID,
+				now.Add(-time.Hour), now.Add(-tt.expired))
+			store.mutex.Lock()
+			delete(store.tokens, session.Token)
+			store.tokens[token] = session.ID
+			store.mutex.Unlock()
+
+			_, err = store.GetByToken(context.Background(), token)
+			if tt.valid && err != nil {
+				t.Errorf("Expected token to be accepted, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected token to be rejected")
+			}
+		})
+	}
+}
+
+func TestInMemoryStore_DefaultTokenTTL(t *testing.T) {
+	store := NewInMemoryStore("24h", "test-secret")
+
+	session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	claims, err := store.verifySessionToken(session.Token)
+	if err != nil {
+		t.Fatalf("Expected a valid token, got %v", err)
+	}
+	expiresAt, _ := claims.GetExpirationTime()
+	if lifetime := time.Until(expiresAt.Time); lifetime > DefaultTokenTTL {
+		t.Errorf("Expected the token to last at most %s, got %s", DefaultTokenTTL, lifetime)
+	}
+}
+
+func TestInMemoryStore_GetByTokenRejectsForgedToken(t *testing.T) {
+	store := NewInMemoryStore("1h", "test-secret")
+	other := NewInMemoryStore("1h", "other-secret")
+
+	session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	now := time.Now()
+	forged := other.generateSessionToken(session.ID, session.UserID, now, now.Add(time.Hour))
+	store.mutex.Lock()
+	store.tokens[forged] = session.ID
+	store.mutex.Unlock()
+
+	if _, err := store.GetByToken(context.Background(), forged); err == nil {
+		t.Fatal("Expected token signed with another secret to be rejected")
+	}
+}
+
+func TestInMemoryStore_RenewToken(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:               "1h",
+		JWTSecret:         "test-secret",
+		TokenTTL:          time.Minute,
+		TokenRenewalGrace: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	session, err := store.Create(ctx, CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	oldToken := session.Token
+
+	renewed, err := store.RenewToken(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Expected no error renewing token, got %v", err)
+	}
+	if renewed.Token == oldToken {
+		t.Fatal("Expected a new token")
+	}
+	if session.Token != oldToken {
+		t.Error("Expected previously returned session to be left unchanged")
+	}
+
+	claims, err := store.verifySessionToken(renewed.Token)
+	if err != nil {
+		t.Fatalf("Expected renewed token to verify, got %v", err)
+	}
+	exp, _ := claims.GetExpirationTime()
+	if exp.After(time.Now().Add(time.Minute + time.Second)) {
+		t.Errorf("Expected token limited to the token TTL, expires %v", exp)
+	}
+
+	// The old token works during the grace window only
+	if _, err := store.GetByToken(ctx, oldToken); err != nil {
+		t.Errorf("Expected old token valid during grace window, got %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := store.GetByToken(ctx, oldToken); err == nil {
+		t.Error("Expected old token rejected after grace window")
+	}
+	if _, err := store.GetByToken(ctx, renewed.Token); err != nil {
+		t.Errorf("Expected renewed token valid, got %v", err)
+	}
+
+	store.CleanupExpired(ctx)
+	if _, exists := store.tokens[oldToken]; exists {
+		t.Error("Expected cleanup to drop the retired token")
+	}
+}
+
+func TestInMemoryStore_CleanupExpired(t *testing.T) {
+	store := NewInMemoryStore("1h", "test-secret")
+	ctx := context.Background()
+
+	live, _ := store.Create(ctx, CreateRequest{UserID: "live-user"})
+	expired := addExpiredSessions(store, cleanupBatchSize*2+1)
+
+	if err := store.CleanupExpired(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := store.Get(ctx, live.ID); err != nil {
+		t.Errorf("Expected live session to survive cleanup, got %v", err)
+	}
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if len(store.sessions) != 1 || len(store.tokens) != 1 {
+		t.Errorf("Expected only the live session to remain, got %d sessions and %d tokens",
+			len(store.sessions), len(store.tokens))
+	}
+	if _, exists := store.sessions[expired[0]]; exists {
+		t.Error("Expected expired session to be removed")
+	}
+}
+
+func TestInMemoryStore_TokenMapConsistency(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:               "1h",
+		JWTSecret:         "test-secret",
+		TokenRenewalGrace: time.Minute,
+	})
+	ctx := context.Background()
+
+	// Deleting a session drops renewed tokens still in their grace window
+	session, _ := store.Create(ctx, CreateRequest{UserID: "test-user"})
+	renewed, err := store.RenewToken(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Expected no error renewing token, got %v", err)
+	}
+	if err := store.Delete(ctx, session.ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, token := range []string{session.Token, renewed.Token} {
+		if _, exists := store.tokens[token]; exists {
+			t.Error("Expected delete to drop every token of the session")
+		}
+	}
+	if len(store.retired) != 0 {
+		t.Errorf("Expected no retired tokens left, got %d", len(store.retired))
+	}
+
+	// Cleanup sweeps token entries whose session no longer exists
+	store.mutex.Lock()
+	store.tokens["orphaned-token"] = "missing-session"
+	store.retired["orphaned-token"] = time.Now().Add(time.Hour)
+	store.mutex.Unlock()
+	if err := store.CleanupExpired(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, exists := store.tokens["orphaned-token"]; exists {
+		t.Error("Expected cleanup to drop the orphaned token")
+	}
+	if _, exists := store.retired["orphaned-token"]; exists {
+		t.Error("Expected cleanup to drop the orphaned retired entry")
+	}
+}
+
+// addExpiredSessions inserts already-expired sessions directly into the store
+func addExpiredSessions(store *InMemoryStore, count int) []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	ids := make([]string, 0, count)
+	expiredAt := time.Now().Add(-time.Hour)
+	for i := 0; i < count; i++ {
+		id := generateSessionID()
+		token := "token-" + id
+		store.sessions[id] = &types.Session{ID: id, Token: token, ExpiresAt: expiredAt}
+		store.tokens[token] = id
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// BenchmarkInMemoryStore_GetDuringCleanup measures session lookups made while
+// a cleanup removes half of a large store, reporting the slowest as max-get-ns
+func BenchmarkInMemoryStore_GetDuringCleanup(b *testing.B) {
+	for _, size := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("sessions=%d", size), func(b *testing.B) {
+			store := NewInMemoryStore("1h", "test-secret")
+			ctx := context.Background()
+
+			var liveIDs []string
+			for i := 0; i < size/2; i++ {
+				session, _ := store.Create(ctx, CreateRequest{UserID: "user"})
+				liveIDs = append(liveIDs, session.ID)
+			}
+
+			var maxGet time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				addExpiredSessions(store, size/2)
+				b.StartTimer()
+
+				done := make(chan struct{})
+				go func() {
+					store.CleanupExpired(ctx)
+					close(done)
+				}()
+
+				for j := 0; ; j++ {
+					select {
+					case <-done:
+					default:
+						start := time.Now()
+						store.Get(ctx, liveIDs[j%len(liveIDs)])
+						maxGet = max(maxGet, time.Since(start))
+						continue
+					}
+					break
+				}
+			}
+			b.ReportMetric(float64(maxGet.Nanoseconds()), "max-get-ns")
+		})
+	}
+}
+
+func TestInMemoryStore_TunnelOwner(t *testing.T) {
+	store := NewInMemoryStore("1h", "test-secret")
+	ctx := context.Background()
+
+	session, err := store.Create(ctx, CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	if err := store.SetTunnelOwner(ctx, session.ID, "http://broker-0:8080"); err != nil {
+		t.Fatalf("Expected no error setting owner, got %v", err)
+	}
+	if session.TunnelOwner != "" {
+		t.Fatal("Expected previously returned session to be left unchanged")
+	}
+
+	// Another replica took the session over, so the first cannot clear it
+	store.SetTunnelOwner(ctx, session.ID, "http://broker-1:8080")
+	store.ClearTunnelOwner(ctx, session.ID, "http://broker-0:8080")
+	got, _ := store.Get(ctx, session.ID)
+	if got.TunnelOwner != "http://broker-1:8080" {
+		t.Fatalf("Expected owner http://broker-1:8080, got %q", got.TunnelOwner)
+	}
+
+	store.ClearTunnelOwner(ctx, session.ID, "http://broker-1:8080")
+	got, _ = store.Get(ctx, session.ID)
+	if got.TunnelOwner != "" {
+		t.Fatalf("Expected owner cleared, got %q", got.TunnelOwner)
+	}
+
+	if err := store.SetTunnelOwner(ctx, "non-existent", "http://broker-0:8080"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestInMemoryStore_Handoff(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:        "1h",
+		JWTSecret:  "test-secret",
+		HandoffTTL: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	session, _ := store.Create(ctx, CreateRequest{UserID: "test-user"})
+
+	code, expiresAt, err := store.CreateHandoff(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expiresAt.After(time.Now().Add(50 * time.Millisecond)) {
+		t.Errorf("Expected code limited to the handoff TTL, expires %v", expiresAt)
+	}
+
+	claimed, err := store.ClaimHandoff(ctx, code)
+	if err != nil {
+		t.Fatalf("Expected no error claiming code, got %v", err)
+	}
+	if claimed.ID != session.ID || claimed.Token != session.Token {
+		t.Errorf("Expected the handed off session, got %+v", claimed)
+	}
+
+	// Codes are one-time
+	if _, err := store.ClaimHandoff(ctx, code); !errors.Is(err, ErrHandoffInvalid) {
+		t.Errorf("Expected ErrHandoffInvalid for a spent code, got %v", err)
+	}
+
+	// and short-lived
+	code, _, _ = store.CreateHandoff(ctx, session.ID)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := store.ClaimHandoff(ctx, code); !errors.Is(err, ErrHandoffInvalid) {
+		t.Errorf("Expected ErrHandoffInvalid for an expired code, got %v", err)
+	}
+
+	// A deleted session cannot be claimed, and cleanup drops its codes
+	code, _, _ = store.CreateHandoff(ctx, session.ID)
+	store.Delete(ctx, session.ID)
+	store.CleanupExpired(ctx)
+	if _, err := store.ClaimHandoff(ctx, code); !errors.Is(err, ErrHandoffInvalid) {
+		t.Errorf("Expected ErrHandoffInvalid after the session was deleted, got %v", err)
+	}
+
+	if _, _, err := store.CreateHandoff(ctx, "missing"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
+
+func TestInMemoryStore_IdempotencyKeys(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:            "1h",
+		JWTSecret:      "test-secret",
+		IdempotencyTTL: 50 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	if existing, err := store.ReserveIdempotencyKey(ctx, "key-1", "request"); existing != nil || err != nil {
+		t.Fatalf("Expected a free key to be reserved, got %v, %v", existing, err)
+	}
+	if _, err := store.ReserveIdempotencyKey(ctx, "key-1", "request"); !errors.Is(err, ErrRequestInProgress) {
+		t.Errorf("Expected ErrRequestInProgress while reserved, got %v", err)
+	}
+
+	session, _ := store.Create(ctx, CreateRequest{UserID: "test-user"})
+	store.CompleteIdempotencyKey(ctx, "key-1", session.ID)
+	existing, err := store.ReserveIdempotencyKey(ctx, "key-1", "request")
+	if err != nil || existing == nil || existing.ID != session.ID {
+		t.Errorf("Expected the completed session for a repeated key, got %v, %v", existing, err)
+	}
+	if _, err := store.ReserveIdempotencyKey(ctx, "key-1", "other-request"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused for a different request, got %v", err)
+	}
+
+	// A failed request frees its key for the retry
+	store.ReserveIdempotencyKey(ctx, "key-2", "request")
+	store.ReleaseIdempotencyKey(ctx, "key-2")
+	if existing, err := store.ReserveIdempotencyKey(ctx, "key-2", "request"); existing != nil || err != nil {
+		t.Errorf("Expected a released key to be reserved again, got %v, %v", existing, err)
+	}
+
+	// Keys are forgotten after the TTL
+	time.Sleep(100 * time.Millisecond)
+	store.CleanupExpired(ctx)
+	if len(store.idempotency) != 0 {
+		t.Errorf("Expected expired keys to be cleaned up, got %d", len(store.idempotency))
+	}
+}
+
+func TestInMemoryStore_MaxSessionsReject(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{TTL: "1h", JWTSecret: "test-secret", MaxSessions: 2})
+	ctx := context.Background()
+
+	first, _ := store.Create(ctx, CreateRequest{UserID: "alice"})
+	if _, err := store.Create(ctx, CreateRequest{UserID: "bob"}); err != nil {
+		t.Fatalf("Expected no error below the limit, got %v", err)
+	}
+	if _, err := store.Create(ctx, CreateRequest{UserID: "carol"}); !errors.Is(err, ErrStoreFull) {
+		t.Fatalf("Expected ErrStoreFull, got %v", err)
+	}
+
+	// An expired session makes room even when new sessions are rejected
+	expired := *first
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	store.mutex.Lock()
+	store.sessions[first.ID] = &expired
+	store.mutex.Unlock()
+
+	if _, err := store.Create(ctx, CreateRequest{UserID: "carol"}); err != nil {
+		t.Fatalf("Expected the expired session to be replaced, got %v", err)
+	}
+	if _, err := store.GetByToken(ctx, first.Token); err == nil {
+		t.Error("Expected the removed session's token to stop working")
+	}
+}
+
+func TestInMemoryStore_MaxSessionsEvictOldest(t *testing.T) {
+	var evicted []string
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:            "1h",
+		JWTSecret:      "test-secret",
+		MaxSessions:    2,
+		EvictionPolicy: EvictionOldest,
+		OnEvict: func(session *types.Session) {
+			evicted = append(evicted, session.ID)
+		},
+	})
+	ctx := context.Background()
+
+	oldest, _ := store.Create(ctx, CreateRequest{UserID: "alice"})
+	second, _ := store.Create(ctx, CreateRequest{UserID: "bob"})
+	store.mutex.Lock()
+	backdated := *oldest
+	backdated.CreatedAt = time.Now().Add(-time.Minute)
+	store.sessions[oldest.ID] = &backdated
+	store.mutex.Unlock()
+
+	third, err := store.Create(ctx, CreateRequest{UserID: "carol"})
+	if err != nil {
+		t.Fatalf("Expected the oldest session to be evicted, got %v", err)
+	}
+	if _, err := store.Get(ctx, oldest.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the oldest session evicted, got %v", err)
+	}
+	for _, kept := range []*types.Session{second, third} {
+		if _, err := store.GetByToken(ctx, kept.Token); err != nil {
+			t.Errorf("Expected session %s to be kept, got %v", kept.UserID, err)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != oldest.ID {
+		t.Errorf("Expected the evicted session to be handed over for teardown, got %v", evicted)
+	}
+}
+
+// checkTokenMaps fails unless every token resolves to a stored session, every
+// session's current token resolves to it and every retired token is still
+// mapped until it is swept
+func checkTokenMaps(t *testing.T, store *InMemoryStore) {
+	t.Helper()
+
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, sessionID := range store.tokens {
+		if _, exists := store.sessions[sessionID]; !exists {
+			t.Errorf("Token for session %s outlived its session", sessionID)
+		}
+	}
+	for sessionID, session := range store.sessions {
+		if store.tokens[session.Token] != sessionID {
+			t.Errorf("Session %s lost its current token", sessionID)
+		}
+	}
+	for token := range store.retired {
+		if _, exists := store.tokens[token]; !exists {
+			t.Error("Retired token has no session mapping")
+		}
+	}
+}
+
+func TestInMemoryStore_ConcurrentCleanupAndDelete(t *testing.T) {
+	store := NewInMemoryStoreWithConfig(StoreConfig{
+		TTL:               "20ms",
+		JWTSecret:         "test-secret",
+		TokenRenewalGrace: 5 * time.Millisecond,
+		MaxSessions:       50,
+		EvictionPolicy:    EvictionOldest,
+	})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				session, err := store.Create(ctx, CreateRequest{UserID: "test-user"})
+				if err != nil {
+					t.Errorf("Expected no error creating session, got %v", err)
+					return
+				}
+				store.RenewToken(ctx, session.ID)
+				if i%3 == 0 {
+					store.Delete(ctx, session.ID)
+				}
+				if i%10 == 0 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			store.CleanupExpired(ctx)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	checkTokenMaps(t, store)
+
+	// Once everything has expired, cleanup leaves nothing behind
+	time.Sleep(30 * time.Millisecond)
+	if err := store.CleanupExpired(ctx); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	checkTokenMaps(t, store)
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if len(store.sessions) != 0 || len(store.tokens) != 0 || len(store.retired) != 0 {
+		t.Errorf("Expected an empty store, got %d sessions, %d tokens and %d retired tokens",
+			len(store.sessions), len(store.tokens), len(store.retired))
 	}
 }
@@ -0,0 +1,38 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Limits on client-supplied session metadata, which is stored for the
+// session's lifetime and echoed in every session response
+const (
+	MaxMetadataEntries     = 16
+	MaxMetadataKeyLength   = 63
+	MaxMetadataValueLength = 256
+)
+
+// ErrInvalidMetadata is returned for session metadata outside the limits
+var ErrInvalidMetadata = errors.New("invalid session metadata")
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// ValidateMetadata checks client metadata such as workspace name, client
+// version and platform against the size limits
+func ValidateMetadata(metadata map[string]string) error {
+	if len(metadata) > MaxMetadataEntries {
+		return fmt.Errorf("%w: %d entries, at most %d allowed", ErrInvalidMetadata, len(metadata), MaxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if len(key) > MaxMetadataKeyLength || !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: key %q must be at most %d letters, digits, '.', '_' or '-'",
+				ErrInvalidMetadata, key, MaxMetadataKeyLength)
+		}
+		if len(value) > MaxMetadataValueLength {
+			return fmt.Errorf("%w: value of %q is longer than %d bytes", ErrInvalidMetadata, key, MaxMetadataValueLength)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateMetadata(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxMetadataEntries; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "value"
+	}
+
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		wantErr  bool
+	}{
+		{name: "none", metadata: nil},
+		{name: "client details", metadata: map[string]string{"workspace": "my-ml-project", "client_version": "1.4.2", "platform": "darwin-arm64"}},
+		{name: "too many entries", metadata: tooMany, wantErr: true},
+		{name: "empty key", metadata: map[string]string{"": "value"}, wantErr: true},
+		{name: "invalid key", metadata: map[string]string{"work space": "value"}, wantErr: true},
+		{name: "long key", metadata: map[string]string{strings.Repeat("k", MaxMetadataKeyLength+1): "value"}, wantErr: true},
+		{name: "long value", metadata: map[string]string{"workspace": strings.Repeat("v", MaxMetadataValueLength+1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetadata(tt.metadata)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidMetadata) {
+				t.Errorf("Expected ErrInvalidMetadata, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInMemoryStore_CreateMetadata(t *testing.T) {
+	store := NewInMemoryStore("1h", "test-secret")
+
+	metadata := map[string]string{"workspace": "my-ml-project", "platform": "darwin-arm64"}
+	session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user", Metadata: metadata})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	metadata["workspace"] = "changed"
+
+	stored, err := store.Get(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stored.Metadata["workspace"] != "my-ml-project" || stored.Metadata["platform"] != "darwin-arm64" {
+		t.Errorf("Expected metadata to be stored unchanged, got %v", stored.Metadata)
+	}
+}
@@ -0,0 +1,273 @@
+package session
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Session token signing algorithms
+const (
+	// SigningHS256 signs with a shared HMAC secret
+	SigningHS256 = "HS256"
+
+	// SigningRS256 signs with an RSA private key; the public key is published as a JWKS
+	SigningRS256 = "RS256"
+
+	// SigningES256 signs with a P-256 ECDSA private key; the public key is published as a JWKS
+	SigningES256 = "ES256"
+)
+
+// SigningConfig represents session token signing configuration
+type SigningConfig struct {
+	// Algorithm is SigningHS256 (the default), SigningRS256 or SigningES256
+	Algorithm string
+
+	// Secret is the HMAC secret for SigningHS256
+	Secret string
+
+	// PrivateKeyFile is a PEM private key for the asymmetric algorithms
+	PrivateKeyFile string
+
+	// PreviousSecrets are earlier HS256 secrets still accepted when verifying
+	// tokens, so a secret can be rotated without invalidating live sessions.
+	// New tokens are always signed with Secret.
+	PreviousSecrets []string
+}
+
+// maxTokenLength bounds the session tokens accepted for verification. The
+// broker's own tokens are well under 1 KiB.
+const maxTokenLength = 4096
+
+// checkTokenStructure rejects a string that cannot be a signed JWT before it
+// is decoded or verified: one longer than maxTokenLength, without three
+// dot-separated segments, or with characters outside base64url. An empty
+// signature is left for verification to reject.
+func checkTokenStructure(token string) error {
+	if len(token) > maxTokenLength {
+		return fmt.Errorf("%w: longer than %d bytes", ErrTokenMalformed, maxTokenLength)
+	}
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 || segments[0] == "" || segments[1] == "" {
+		return fmt.Errorf("%w: not a JWT", ErrTokenMalformed)
+	}
+	for _, segment := range segments {
+		if strings.IndexFunc(segment, isNotBase64URL) >= 0 {
+			return fmt.Errorf("%w: not base64url encoded", ErrTokenMalformed)
+		}
+	}
+	return nil
+}
+
+func isNotBase64URL(r rune) bool {
+	return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
+
+// Signer signs and verifies session tokens with the configured algorithm
+type Signer struct {
+	method     jwt.SigningMethod
+	signKey    interface{}
+	verifyKeys []interface{} // the current key first, then previous ones
+	keyID      string
+	jwk        map[string]string
+}
+
+// JWKS is a JSON Web Key Set publishing the signer's public key
+type JWKS struct {
+	Keys []map[string]string `json:"keys"`
+}
+
+// NewSigner creates a signer, checking the key material matches the algorithm
+func NewSigner(config SigningConfig) (*Signer, error) {
+	algorithm := config.Algorithm
+	if algorithm == "" {
+		algorithm = SigningHS256
+	}
+
+	if algorithm == SigningHS256 {
+		if config.PrivateKeyFile != "" {
+			return nil, fmt.Errorf("%s uses a shared secret, not a private key file", algorithm)
+		}
+		if config.Secret == "" {
+			return nil, fmt.Errorf("%s requires a secret", algorithm)
+		}
+		signer := newHMACSigner(config.Secret)
+		for _, secret := range config.PreviousSecrets {
+			if secret != "" && secret != config.Secret {
+				signer.verifyKeys = append(signer.verifyKeys, []byte(secret))
+			}
+		}
+		return signer, nil
+	}
+
+	if len(config.PreviousSecrets) > 0 {
+		return nil, fmt.Errorf("previous secrets only apply to %s", SigningHS256)
+	}
+
+	if algorithm != SigningRS256 && algorithm != SigningES256 {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if config.PrivateKeyFile == "" {
+		return nil, fmt.Errorf("%s requires a private key file", algorithm)
+	}
+
+	key, err := loadPrivateKey(config.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != SigningRS256 {
+			return nil, fmt.Errorf("%s requires an ECDSA key, got RSA", algorithm)
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key must be at least 2048 bits, got %d", key.N.BitLen())
+		}
+		return newSigner(jwt.SigningMethodRS256, key, &key.PublicKey, map[string]string{
+			"kty": "RSA",
+			"n":   encodeBigInt(key.N, 0),
+			"e":   encodeBigInt(big.NewInt(int64(key.E)), 0),
+		}), nil
+	case *ecdsa.PrivateKey:
+		if algorithm != SigningES256 {
+			return nil, fmt.Errorf("%s requires an RSA key, got ECDSA", algorithm)
+		}
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s requires a P-256 key, got %s", algorithm, key.Curve.Params().Name)
+		}
+		return newSigner(jwt.SigningMethodES256, key, &key.PublicKey, map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   encodeBigInt(key.X, 32),
+			"y":   encodeBigInt(key.Y, 32),
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+func newHMACSigner(secret string) *Signer {
+	return &Signer{
+		method:     jwt.SigningMethodHS256,
+		signKey:    []byte(secret),
+		verifyKeys: []interface{}{[]byte(secret)},
+	}
+}
+
+func newSigner(method jwt.SigningMethod, signKey, verifyKey interface{}, jwk map[string]string) *Signer {
+	keyID := thumbprint(jwk)
+	jwk["kid"] = keyID
+	jwk["alg"] = method.Alg()
+	jwk["use"] = "sig"
+
+	return &Signer{
+		method:     method,
+		signKey:    signKey,
+		verifyKeys: []interface{}{verifyKey},
+		keyID:      keyID,
+		jwk:        jwk,
+	}
+}
+
+// Algorithm returns the signing algorithm name
+func (s *Signer) Algorithm() string {
+	return s.method.Alg()
+}
+
+// Sign returns a signed token for the claims
+func (s *Signer) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.keyID != "" {
+		token.Header["kid"] = s.keyID
+	}
+	return token.SignedString(s.signKey)
+}
+
+// Parse verifies the token with the signer's algorithm into claims, trying
+// the current key then any previous ones. A token declaring another
+// algorithm fails with ErrTokenInvalidSignature before any key is tried, and
+// one no key verifies fails with jwt.ErrTokenSignatureInvalid.
+func (s *Signer) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) error {
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if errors.Is(err, jwt.ErrTokenMalformed) {
+		return err
+	}
+	if alg, _ := token.Header["alg"].(string); alg != s.method.Alg() {
+		return fmt.Errorf("%w: signed with %q, expected %s", ErrTokenInvalidSignature, alg, s.method.Alg())
+	}
+
+	options = append(options, jwt.WithValidMethods([]string{s.method.Alg()}))
+
+	for _, key := range s.verifyKeys {
+		_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			return key, nil
+		}, options...)
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return err
+		}
+	}
+	return err
+}
+
+// JWKS returns the public key set, and false for symmetric algorithms which
+// have nothing to publish
+func (s *Signer) JWKS() (JWKS, bool) {
+	if s.jwk == nil {
+		return JWKS{}, false
+	}
+	return JWKS{Keys: []map[string]string{s.jwk}}, true
+}
+
+// loadPrivateKey reads a PKCS#1, SEC 1 or PKCS#8 PEM private key
+func loadPrivateKey(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key file is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in private key file", block.Type)
+	}
+}
+
+// encodeBigInt base64url encodes an integer, left-padded to size bytes
+func encodeBigInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// thumbprint computes the RFC 7638 key ID over the key's required members
+func thumbprint(jwk map[string]string) string {
+	// encoding/json sorts map keys, giving the canonical member order
+	canonical, _ := json.Marshal(jwk)
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,227 @@
+package session
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKeyFile writes key as a PKCS#8 PEM file
+func writeKeyFile(t *testing.T, key crypto.PrivateKey) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Expected no error marshalling key, got %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Expected no error writing key, got %v", err)
+	}
+	return path
+}
+
+func TestNewSigner(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaFile := writeKeyFile(t, rsaKey)
+	ecFile := writeKeyFile(t, ecKey)
+
+	tests := []struct {
+		name    string
+		config  SigningConfig
+		wantErr bool
+		jwks    bool
+	}{
+		{name: "default HS256", config: SigningConfig{Secret: "secret"}},
+		{name: "HS256 without secret", config: SigningConfig{Algorithm: SigningHS256}, wantErr: true},
+		{name: "HS256 with key file", config: SigningConfig{Secret: "secret", PrivateKeyFile: rsaFile}, wantErr: true},
+		{name: "RS256", config: SigningConfig{Algorithm: SigningRS256, PrivateKeyFile: rsaFile}, jwks: true},
+		{name: "ES256", config: SigningConfig{Algorithm: SigningES256, PrivateKeyFile: ecFile}, jwks: true},
+		{name: "RS256 with EC key", config: SigningConfig{Algorithm: SigningRS256, PrivateKeyFile: ecFile}, wantErr: true},
+		{name: "ES256 with RSA key", config: SigningConfig{Algorithm: SigningES256, PrivateKeyFile: rsaFile}, wantErr: true},
+		{name: "ES256 with P-384 key", config: SigningConfig{Algorithm: SigningES256, PrivateKeyFile: writeKeyFile(t, p384Key)}, wantErr: true},
+		{name: "RS256 without key file", config: SigningConfig{Algorithm: SigningRS256}, wantErr: true},
+		{name: "HS256 with previous secrets", config: SigningConfig{Secret: "secret", PreviousSecrets: []string{"old"}}},
+		{name: "RS256 with previous secrets", config: SigningConfig{Algorithm: SigningRS256, PrivateKeyFile: rsaFile, PreviousSecrets: []string{"old"}}, wantErr: true},
+		{name: "unsupported algorithm", config: SigningConfig{Algorithm: "none", Secret: "secret"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := NewSigner(tt.config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected error for inconsistent configuration")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			token, err := signer.Sign(jwt.MapClaims{"session_id": "abc"})
+			if err != nil {
+				t.Fatalf("Expected no error signing, got %v", err)
+			}
+			claims := jwt.MapClaims{}
+			if err := signer.Parse(token, claims); err != nil || claims["session_id"] != "abc" {
+				t.Errorf("Expected token to verify, got %v (%v)", err, claims)
+			}
+
+			jwks, ok := signer.JWKS()
+			if ok != tt.jwks {
+				t.Fatalf("Expected JWKS published %v, got %v", tt.jwks, ok)
+			}
+			if ok && (len(jwks.Keys) != 1 || jwks.Keys[0]["alg"] != signer.Algorithm() || jwks.Keys[0]["kid"] == "") {
+				t.Errorf("Expected one key for %s with a kid, got %v", signer.Algorithm(), jwks.Keys)
+			}
+		})
+	}
+}
+
+func TestSigner_RejectsOtherAlgorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signer, err := NewSigner(SigningConfig{Algorithm: SigningRS256, PrivateKeyFile: writeKeyFile(t, rsaKey)})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// An HS256 token keyed with the public key must not verify
+	publicDER, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"session_id": "abc"}).SignedString(publicDER)
+	if err := signer.Parse(forged, jwt.MapClaims{}); err == nil {
+		t.Error("Expected HS256 token to be rejected by an RS256 signer")
+	}
+}
+
+func TestInMemoryStore_AsymmetricTokens(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signer, err := NewSigner(SigningConfig{Algorithm: SigningES256, PrivateKeyFile: writeKeyFile(t, ecKey)})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	store := NewInMemoryStoreWithConfig(StoreConfig{TTL: "1h", Signer: signer})
+
+	session, err := store.Create(context.Background(), CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	// A proxy holding only the public key can verify the token
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(session.Token, claims, func(*jwt.Token) (interface{}, error) {
+		return &ecKey.PublicKey, nil
+	}); err != nil {
+		t.Fatalf("Expected token to verify with the public key, got %v", err)
+	}
+
+	if _, err := store.GetByToken(context.Background(), session.Token); err != nil {
+		t.Errorf("Expected store to accept its token, got %v", err)
+	}
+}
+
+func TestSigner_PreviousSecrets(t *testing.T) {
+	old, _ := NewSigner(SigningConfig{Secret: "old-secret"})
+	rotated, err := NewSigner(SigningConfig{Secret: "new-secret", PreviousSecrets: []string{"old-secret"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	replaced, _ := NewSigner(SigningConfig{Secret: "new-secret"})
+
+	token, _ := old.Sign(jwt.MapClaims{"session_id": "abc"})
+	if err := rotated.Parse(token, jwt.MapClaims{}); err != nil {
+		t.Errorf("Expected token signed with a previous secret to verify, got %v", err)
+	}
+	if err := replaced.Parse(token, jwt.MapClaims{}); !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		t.Errorf("Expected signature error without the previous secret, got %v", err)
+	}
+
+	// New tokens are signed with the current secret only
+	token, _ = rotated.Sign(jwt.MapClaims{"session_id": "abc"})
+	if err := old.Parse(token, jwt.MapClaims{}); err == nil {
+		t.Error("Expected new token not to verify with the previous secret")
+	}
+}
+
+func TestInMemoryStore_GetByTokenErrors(t *testing.T) {
+	ctx := context.Background()
+	before := NewInMemoryStore("1h", "old-secret")
+	session, err := before.Create(ctx, CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	// The secret changes, e.g. across a restart with a persistent store
+	after := NewInMemoryStore("1h", "new-secret")
+	after.sessions = before.sessions
+	after.tokens = before.tokens
+	if _, err := after.GetByToken(ctx, session.Token); !errors.Is(err, ErrTokenUnknownKey) {
+		t.Errorf("Expected ErrTokenUnknownKey after a secret change, got %v", err)
+	}
+
+	now := time.Now()
+	expired := before.generateSessionToken(session.ID, session.UserID, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	if _, err := before.GetByToken(ctx, expired); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestInMemoryStore_GetByTokenMalformed(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryStore("1h", "test-secret")
+	session, err := store.Create(ctx, CreateRequest{UserID: "test-user"})
+	if err != nil {
+		t.Fatalf("Expected no error creating session, got %v", err)
+	}
+
+	malformed := map[string]string{
+		"random string":       "not-a-jwt-at-all",
+		"empty":               "",
+		"two segments":        "eyJhbGciOiJIUzI1NiJ9.eyJzZXNzaW9uX2lkIjoiYWJjIn0",
+		"invalid characters":  "eyJhbGciOiJIUzI1NiJ9.eyJzZXNzaW9uX2lkIjoiYWJjIn0.sig+nat/ure=",
+		"oversized":           session.Token + strings.Repeat("A", maxTokenLength),
+		"undecodable segment": "e30.e.e30",
+	}
+	for name, token := range malformed {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.GetByToken(ctx, token); !errors.Is(err, ErrTokenMalformed) {
+				t.Errorf("Expected ErrTokenMalformed, got %v", err)
+			}
+		})
+	}
+
+	// Well-formed tokens with another algorithm have an invalid signature,
+	// not a malformed token or one from an unknown key
+	claims := jwt.MapClaims{
+		"session_id": session.ID,
+		"user_id":    session.UserID,
+		"exp":        time.Now().Add(time.Hour).Unix(),
+	}
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := store.GetByToken(ctx, unsigned); !errors.Is(err, ErrTokenInvalidSignature) {
+		t.Errorf("Expected an alg none token to have an invalid signature, got %v", err)
+	}
+	otherAlgorithm, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, claims).SignedString([]byte("test-secret"))
+	if _, err := store.GetByToken(ctx, otherAlgorithm); !errors.Is(err, ErrTokenInvalidSignature) {
+		t.Errorf("Expected an HS512 token to have an invalid signature, got %v", err)
+	}
+
+	if _, err := store.GetByToken(ctx, session.Token); err != nil {
+		t.Errorf("Expected the session's own token to verify, got %v", err)
+	}
+}
@@ -2,10 +2,59 @@ package session
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
+// Session lookup errors, so callers can tell a bad ID from a timed out session
+var (
+	// ErrSessionNotFound means no session exists with the given ID or token
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrSessionExpired means the session existed but is past its expiry or
+	// maximum TTL; the client must log in and create a new session
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// Session token errors returned by GetByToken
+var (
+	// ErrTokenExpired means the session token is past its expiry
+	ErrTokenExpired = errors.New("session token expired")
+
+	// ErrTokenUnknownKey means no current or previous signing key verifies
+	// the token, typically because the signing secret changed since it was
+	// issued. Retrying cannot succeed; the client must create a new session.
+	ErrTokenUnknownKey = errors.New("session token signed with an unknown key")
+
+	// ErrTokenMalformed means the token is not a JWT at all, such as a
+	// truncated, garbled or oversized string; it was rejected before any
+	// signature verification
+	ErrTokenMalformed = errors.New("session token is malformed")
+
+	// ErrTokenInvalidSignature means the token is not signed with the
+	// broker's algorithm, such as one declaring alg none, so the broker never
+	// issued it; unlike ErrTokenUnknownKey no key change explains it
+	ErrTokenInvalidSignature = errors.New("session token signature is invalid")
+)
+
+// ErrHandoffInvalid means a handoff code was never issued, has expired or
+// has already been claimed
+var ErrHandoffInvalid = errors.New("handoff code is invalid or expired")
+
+// ErrRequestInProgress means another request with the same idempotency key
+// is still creating its session
+var ErrRequestInProgress = errors.New("a request with this idempotency key is still in progress")
+
+// ErrIdempotencyKeyReused means an idempotency key was sent again with a
+// different request than the one it was first used for
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// ErrStoreFull means the store holds its maximum number of live sessions and
+// its eviction policy rejects new ones
+var ErrStoreFull = errors.New("session store is full")
+
 // Store defines the interface for session storage
 type Store interface {
 	// Create creates a new session
@@ -20,8 +69,40 @@ type Store interface {
 	// Delete removes a session
 	Delete(ctx context.Context, sessionID string) error
 
+	// RenewToken issues a new session token, keeping the previous one valid
+	// for a grace window
+	RenewToken(ctx context.Context, sessionID string) (*types.Session, error)
+
 	// CleanupExpired removes expired sessions
 	CleanupExpired(ctx context.Context) error
+
+	// SetTunnelOwner records which broker instance holds the session's tunnel
+	SetTunnelOwner(ctx context.Context, sessionID, owner string) error
+
+	// ClearTunnelOwner forgets the tunnel owner, unless another instance has
+	// claimed the session since
+	ClearTunnelOwner(ctx context.Context, sessionID, owner string) error
+
+	// CreateHandoff issues a short-lived one-time code another device can
+	// claim the session with, returning the code and its expiry
+	CreateHandoff(ctx context.Context, sessionID string) (string, time.Time, error)
+
+	// ClaimHandoff invalidates the code and returns the session it was issued for
+	ClaimHandoff(ctx context.Context, code string) (*types.Session, error)
+
+	// ReserveIdempotencyKey claims key for a session being created from a
+	// request with the given fingerprint, returning nil if it was free. A key
+	// already completed returns its session, a key still reserved returns
+	// ErrRequestInProgress, and a key used with another fingerprint returns
+	// ErrIdempotencyKeyReused.
+	ReserveIdempotencyKey(ctx context.Context, key, fingerprint string) (*types.Session, error)
+
+	// CompleteIdempotencyKey records the session created under a reserved key
+	CompleteIdempotencyKey(ctx context.Context, key, sessionID string) error
+
+	// ReleaseIdempotencyKey frees a reserved key whose request failed, so a
+	// retry can try again
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
 }
 
 // CreateRequest represents session creation request
@@ -29,6 +110,8 @@ type CreateRequest struct {
 	UserID       string
 	RefreshToken string
 	PodInfo      types.PodInfo
+	// Metadata is client-supplied, checked with ValidateMetadata
+	Metadata map[string]string
 }
 
 
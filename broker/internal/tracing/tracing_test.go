@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+// recordSpans installs a tracer provider recording spans for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	if _, err := Setup(context.Background(), Config{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestMiddleware_ContinuesIncomingTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/session/:id", func(c *gin.Context) {
+		SetAttributes(c.Request.Context(), SessionIDKey.String(c.Param("id")))
+		c.Status(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/session/abc", nil)
+	req.Header.Set("traceparent", "00-"+testTraceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "GET /session/:id" {
+		t.Errorf("Expected span named after the route, got %q", span.Name())
+	}
+	if span.SpanContext().TraceID().String() != testTraceID {
+		t.Errorf("Expected span in the incoming trace, got %s", span.SpanContext().TraceID())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("Expected 5xx to mark the span as an error, got %v", span.Status())
+	}
+
+	found := false
+	for _, attr := range span.Attributes() {
+		if attr.Key == SessionIDKey && attr.Value.AsString() == "abc" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected session ID attribute, got %v", span.Attributes())
+	}
+}
+
+func TestTransport_PropagatesTraceContext(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/hub/api/users/alice", nil)
+	resp, err := (&http.Client{Transport: Transport(nil)}).Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	resp.Body.Close()
+	End(parent, nil)
+
+	if req.Header.Get("traceparent") != "" {
+		t.Error("Expected the caller's request not to be modified")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected client and parent spans, got %d", len(spans))
+	}
+	client := spans[0]
+	if client.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("Expected client span to be a child of the caller's span")
+	}
+	want := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("Expected traceparent %q, got %q", want, traceparent)
+	}
+}
+
+func TestEnd_RecordsError(t *testing.T) {
+	recorder := recordSpans(t)
+
+	_, span := Start(context.Background(), "k8s.check_pod")
+	End(span, errors.New("pod does not exist"))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Status().Code != codes.Error || len(spans[0].Events()) != 1 {
+		t.Fatalf("Expected span with error status and event, got %+v", spans)
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("Expected no-op shutdown, got %v", err)
+	}
+}
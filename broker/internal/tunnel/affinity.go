@@ -0,0 +1,49 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// TunnelOwners records which broker instance holds each session's tunnel,
+// implemented by session.Store. With a store shared between replicas, a
+// request for a session whose tunnel lives elsewhere can be forwarded to it.
+type TunnelOwners interface {
+	SetTunnelOwner(ctx context.Context, sessionID, owner string) error
+	ClearTunnelOwner(ctx context.Context, sessionID, owner string) error
+}
+
+// ownershipTimeout bounds recording or clearing a tunnel's owner
+const ownershipTimeout = 5 * time.Second
+
+// claimOwnership records this instance as the owner of the tunnel's session.
+// Failures are logged, the tunnel works regardless and only cross-instance
+// requests for it are affected.
+func (m *Manager) claimOwnership(tunnel *Tunnel) {
+	if m.tunnelOwners == nil || m.instanceURL == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(tunnel.ctx), ownershipTimeout)
+	defer cancel()
+
+	if err := m.tunnelOwners.SetTunnelOwner(ctx, tunnel.ID, m.instanceURL); err != nil {
+		log.Printf("Failed to record tunnel owner for session %s: %v", tunnel.ID, err)
+	}
+}
+
+// releaseOwnership clears this instance as the tunnel's owner, unless another
+// instance has taken the session over since
+func (m *Manager) releaseOwnership(tunnel *Tunnel) {
+	if m.tunnelOwners == nil || m.instanceURL == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ownershipTimeout)
+	defer cancel()
+
+	if err := m.tunnelOwners.ClearTunnelOwner(ctx, tunnel.ID, m.instanceURL); err != nil {
+		log.Printf("Failed to clear tunnel owner for session %s: %v", tunnel.ID, err)
+	}
+}
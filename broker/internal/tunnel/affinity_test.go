@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// fakeTunnelOwners records tunnel owners in memory
+type fakeTunnelOwners struct {
+	mutex  sync.Mutex
+	owners map[string]string
+}
+
+func (f *fakeTunnelOwners) SetTunnelOwner(ctx context.Context, sessionID, owner string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.owners[sessionID] = owner
+	return nil
+}
+
+func (f *fakeTunnelOwners) ClearTunnelOwner(ctx context.Context, sessionID, owner string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.owners[sessionID] == owner {
+		delete(f.owners, sessionID)
+	}
+	return nil
+}
+
+func (f *fakeTunnelOwners) owner(sessionID string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.owners[sessionID]
+}
+
+func TestManager_RecordsTunnelOwner(t *testing.T) {
+	owners := &fakeTunnelOwners{owners: make(map[string]string)}
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{
+		InstanceURL:  "http://broker-0:8080",
+		TunnelOwners: owners,
+	})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return owners.owner(testSession().ID) == "http://broker-0:8080" })
+
+	conn.Close()
+	waitFor(t, func() bool { return owners.owner(testSession().ID) == "" })
+}
+
+func TestManager_KeepsOwnerClaimedByAnotherInstance(t *testing.T) {
+	owners := &fakeTunnelOwners{owners: make(map[string]string)}
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{
+		InstanceURL:  "http://broker-0:8080",
+		TunnelOwners: owners,
+	})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return owners.owner(testSession().ID) != "" })
+
+	// The client reconnected through another replica before this tunnel ended
+	owners.SetTunnelOwner(context.Background(), testSession().ID, "http://broker-1:8080")
+
+	conn.Close()
+	waitFor(t, func() bool { return !manager.hasTunnel(testSession().ID) })
+	if owner := owners.owner(testSession().ID); owner != "http://broker-1:8080" {
+		t.Fatalf("Expected the other replica to stay the owner, got %q", owner)
+	}
+}
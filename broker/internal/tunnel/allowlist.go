@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// newCommandAllowlist builds the set of commands requests may run, nil to
+// allow any command
+func newCommandAllowlist(commands []string) map[string]bool {
+	if len(commands) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool, len(commands))
+	for _, command := range commands {
+		allowed[command] = true
+	}
+	return allowed
+}
+
+// checkCommandAllowed refuses a command missing from the allowlist, if one is
+// configured. Commands must be requested exactly as listed, so allowing
+// "python" does not allow "/tmp/python". Refusals are audit logged.
+func (m *Manager) checkCommandAllowed(tunnel *Tunnel, command string) error {
+	if m.allowedCommands == nil || m.allowedCommands[command] {
+		return nil
+	}
+	log.Printf("Audit: user %s refused command %q in pod %s/%s: not in the command allowlist",
+		tunnel.Session.UserID, command, tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name)
+	return fmt.Errorf("command %q is not allowed on this broker", command)
+}
+
+// checkExecAllowed applies the allowlist to an exec request. A shell script
+// or an interactive shell can run anything, so it needs the shell itself to
+// be allowed.
+func (m *Manager) checkExecAllowed(tunnel *Tunnel, req types.ExecRequest) error {
+	command := req.Command
+	if req.Shell || (req.TTY && req.Command == "") {
+		command = m.execShell
+	}
+	return m.checkCommandAllowed(tunnel, command)
+}
@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_CheckExecAllowed(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{AllowedCommands: []string{"python", "git"}})
+
+	tests := []struct {
+		name    string
+		req     types.ExecRequest
+		allowed bool
+	}{
+		{name: "listed command", req: types.ExecRequest{Command: "python", Args: []string{"train.py"}}, allowed: true},
+		{name: "unlisted command", req: types.ExecRequest{Command: "curl"}},
+		{name: "listed name at another path", req: types.ExecRequest{Command: "/tmp/python"}},
+		{name: "shell script", req: types.ExecRequest{Command: "python", Shell: true}},
+		{name: "interactive shell", req: types.ExecRequest{TTY: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := manager.checkExecAllowed(testTunnel(), tt.req)
+			if tt.allowed && err != nil {
+				t.Errorf("Expected the request to be allowed, got %v", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("Expected the request to be refused")
+			}
+		})
+	}
+
+	unrestricted := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	if err := unrestricted.checkExecAllowed(testTunnel(), types.ExecRequest{Command: "curl", Shell: true}); err != nil {
+		t.Errorf("Expected any command without an allowlist, got %v", err)
+	}
+}
+
+func TestManager_ExecRefusedByAllowlist(t *testing.T) {
+	var mutex sync.Mutex
+	var commands [][]string
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		mutex.Lock()
+		commands = append(commands, opts.Command)
+		mutex.Unlock()
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{AllowedCommands: []string{"python"}})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: types.ExecRequest{Command: "curl", Args: []string{"http://example.org"}, Stdout: true},
+	})
+	if payload := readMessageOfType(t, conn, "error"); !strings.Contains(payload["error"].(string), "not allowed") {
+		t.Errorf("Expected the command to be refused, got %v", payload)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, command := range commands {
+		if strings.Contains(strings.Join(command, " "), "curl") {
+			t.Errorf("Expected the refused command not to run, got %q", command)
+		}
+	}
+}
+
+func TestManager_KillProcessRefusedByAllowlist(t *testing.T) {
+	executed := false
+	client := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		executed = true
+		return nil
+	}}
+	manager := NewManager(client, ManagerConfig{AllowedCommands: []string{"python"}})
+
+	if err := manager.killProcess(context.Background(), testTunnel(), 412, "TERM"); err == nil {
+		t.Fatal("Expected kill to be refused without kill in the allowlist")
+	}
+	if executed {
+		t.Error("Expected no exec")
+	}
+}
+
+func TestManager_RunAsRefusedByAllowlist(t *testing.T) {
+	var mutex sync.Mutex
+	var commands [][]string
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		mutex.Lock()
+		commands = append(commands, opts.Command)
+		mutex.Unlock()
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{RunAsMechanism: RunAsRunuser, AllowedCommands: []string{"python"}})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: types.ExecRequest{Command: "curl", RunAsUser: "jovyan", Stdout: true},
+	})
+	if payload := readMessageOfType(t, conn, "error"); !strings.Contains(payload["error"].(string), "not allowed") {
+		t.Errorf("Expected the command to be refused, got %v", payload)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, command := range commands {
+		if command[0] == RunAsRunuser || strings.Contains(strings.Join(command, " "), runAsProbe) {
+			t.Errorf("Expected no user switch for a refused command, got %q", command)
+		}
+	}
+}
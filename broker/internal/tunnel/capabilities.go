@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// probedTools are the pod binaries tunnel features rely on: tar for file
+// reads and writes, stat for list and stat, ps and kill for processes, socat
+// for reverse port forwarding and inotifywait for file watching
+var probedTools = []string{"tar", "stat", "ps", "kill", "socat", "inotifywait"}
+
+// probeScript prints each of its arguments found on the PATH
+const probeScript = `for t in "$@"; do command -v "$t" >/dev/null 2>&1 && echo "$t"; done; true`
+
+// probeTimeout bounds the capability probe at tunnel setup
+const probeTimeout = 10 * time.Second
+
+// capabilityCache keeps each session's probe result so reconnects skip the
+// exec. Entries are keyed by session and pod, and dropped once the session
+// has expired.
+type capabilityCache struct {
+	mutex   sync.Mutex
+	entries map[string]capabilityEntry
+}
+
+type capabilityEntry struct {
+	capabilities map[string]bool
+	expiresAt    time.Time
+}
+
+func capabilityKey(session *types.Session) string {
+	return session.ID + "/" + session.PodInfo.Namespace + "/" + session.PodInfo.Name
+}
+
+func (c *capabilityCache) get(session *types.Session) (map[string]bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[capabilityKey(session)]
+	return entry.capabilities, ok
+}
+
+func (c *capabilityCache) put(session *types.Session, capabilities map[string]bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]capabilityEntry)
+	}
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[capabilityKey(session)] = capabilityEntry{capabilities: capabilities, expiresAt: session.ExpiresAt}
+}
+
+// capabilities returns which probed tools, and tools of the exec resource
+// wrappers, the session's pod has, probing it once per session
+func (m *Manager) capabilities(tunnel *Tunnel) (map[string]bool, error) {
+	if capabilities, ok := m.capabilityCache.get(tunnel.Session); ok {
+		return capabilities, nil
+	}
+
+	ctx, cancel := context.WithTimeout(tunnel.ctx, probeTimeout)
+	defer cancel()
+
+	tools := append(append([]string(nil), probedTools...), m.resourceTools()...)
+	var stdout bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   append([]string{"sh", "-c", probeScript, "sh"}, tools...),
+		Stdout:    &stdout,
+	})
+	// A pod without a shell cannot run any of the shell-based features either
+	if _, exited := k8s.ExitCode(err); err != nil && !exited {
+		return nil, err
+	}
+
+	capabilities := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		capabilities[tool] = false
+	}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if _, probed := capabilities[line]; probed {
+			capabilities[line] = true
+		}
+	}
+
+	m.capabilityCache.put(tunnel.Session, capabilities)
+	return capabilities, nil
+}
+
+// sendReady tells the client the tunnel is set up, with the pod's
+// capabilities so it can disable features whose tools are missing. The exec
+// resource wrappers are set up from the same probe.
+func (m *Manager) sendReady(tunnel *Tunnel) {
+	ready := types.TunnelReady{SessionID: tunnel.Session.ID, ResumeToken: tunnel.resumeToken}
+
+	capabilities, err := m.capabilities(tunnel)
+	if err != nil {
+		log.Printf("Capability probe for session %s failed: %v", tunnel.Session.ID, err)
+	} else {
+		ready.Capabilities = capabilities
+	}
+	m.setupResourceWrappers(tunnel, capabilities)
+
+	m.sendMessage(tunnel, types.TunnelMessage{Type: "ready", Payload: ready})
+}
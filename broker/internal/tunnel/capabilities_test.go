@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_ReadyReportsCapabilities(t *testing.T) {
+	var probes atomic.Int32
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			if isCapabilityProbe(opts) {
+				probes.Add(1)
+				fmt.Fprintln(opts.Stdout, "tar")
+				fmt.Fprintln(opts.Stdout, "ps")
+			}
+			return nil
+		},
+	}
+	manager := NewManager(client, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+
+	for i := 0; i < 2; i++ {
+		conn := dialTestServer(t, server)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		var msg struct {
+			Type    string            `json:"type"`
+			Payload types.TunnelReady `json:"payload"`
+		}
+		if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ready" {
+			t.Fatalf("Expected ready message, got %+v (%v)", msg, err)
+		}
+
+		capabilities := msg.Payload.Capabilities
+		if !capabilities["tar"] || !capabilities["ps"] || capabilities["socat"] {
+			t.Errorf("Expected tar and ps without socat, got %v", capabilities)
+		}
+		if _, ok := capabilities["inotifywait"]; !ok {
+			t.Errorf("Expected every probed tool to be reported, got %v", capabilities)
+		}
+		conn.Close()
+		waitFor(t, func() bool { return !manager.hasTunnel(testSession().ID) })
+	}
+
+	if n := probes.Load(); n != 1 {
+		t.Errorf("Expected the probe result to be cached for the session, got %d probes", n)
+	}
+}
+
+func TestManager_ReadyWithoutCapabilitiesOnProbeFailure(t *testing.T) {
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			return fmt.Errorf("container not found")
+		},
+	}
+	manager := NewManager(client, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialTestServer(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var msg struct {
+		Type    string            `json:"type"`
+		Payload types.TunnelReady `json:"payload"`
+	}
+	if err := conn.ReadJSON(&msg); err != nil || msg.Type != "ready" {
+		t.Fatalf("Expected ready message, got %+v (%v)", msg, err)
+	}
+	if msg.Payload.Capabilities != nil {
+		t.Errorf("Expected no capabilities after a failed probe, got %v", msg.Payload.Capabilities)
+	}
+	if _, cached := manager.capabilityCache.get(testSession()); cached {
+		t.Error("Expected a failed probe not to be cached")
+	}
+}
+
+func TestCapabilityCache_DropsExpiredSessions(t *testing.T) {
+	var cache capabilityCache
+
+	expired := testSession()
+	expired.ID = "expired"
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	cache.put(expired, map[string]bool{"tar": true})
+
+	active := testSession()
+	active.ExpiresAt = time.Now().Add(time.Hour)
+	cache.put(active, map[string]bool{"tar": true})
+
+	if _, ok := cache.get(expired); ok {
+		t.Error("Expected expired session's entry to be dropped")
+	}
+	if _, ok := cache.get(active); !ok {
+		t.Error("Expected active session's entry to be kept")
+	}
+}
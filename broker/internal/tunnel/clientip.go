@@ -0,0 +1,28 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"net/http"
+)
+
+type clientIPKey struct{}
+
+// WithClientIP records the client's address as resolved by the HTTP layer,
+// which knows the trusted proxies, for the tunnel to log
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// clientIP returns the address recorded with WithClientIP, falling back to
+// the host of the connection's remote address
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,21 @@
+package tunnel
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/tunnel/session-1", nil)
+	r.RemoteAddr = "10.0.0.5:51234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	if ip := clientIP(r); ip != "10.0.0.5" {
+		t.Errorf("Expected the remote address without a resolved IP, got %s", ip)
+	}
+
+	r = r.WithContext(WithClientIP(r.Context(), "203.0.113.7"))
+	if ip := clientIP(r); ip != "203.0.113.7" {
+		t.Errorf("Expected the resolved client IP, got %s", ip)
+	}
+}
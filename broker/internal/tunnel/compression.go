@@ -0,0 +1,29 @@
+package tunnel
+
+import (
+	"compress/flate"
+	"fmt"
+)
+
+// DefaultCompressionLevel is the flate level of permessage-deflate, trading
+// CPU for bandwidth between BestSpeed (1) and BestCompression (9)
+const DefaultCompressionLevel = 3
+
+// DefaultCompressionThreshold is the smallest message compressed, so small
+// control messages do not pay for it
+const DefaultCompressionThreshold = 1 << 10
+
+// ValidateCompressionLevel checks that level is a flate level from BestSpeed
+// to BestCompression
+func ValidateCompressionLevel(level int) error {
+	if level < flate.BestSpeed || level > flate.BestCompression {
+		return fmt.Errorf("compression level must be from %d to %d, got %d", flate.BestSpeed, flate.BestCompression, level)
+	}
+	return nil
+}
+
+// compressMessage reports whether a message of size bytes is worth compressing
+// on connections that negotiated permessage-deflate
+func (m *Manager) compressMessage(size int) bool {
+	return size >= m.compressionThreshold
+}
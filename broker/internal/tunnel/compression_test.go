@@ -0,0 +1,123 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_Compression(t *testing.T) {
+	tests := []struct {
+		name           string
+		disabled       bool
+		clientEnables  bool
+		wantNegotiated bool
+	}{
+		{name: "negotiated", clientEnables: true, wantNegotiated: true},
+		{name: "client without compression", clientEnables: false},
+		{name: "disabled", disabled: true, clientEnables: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManager(&fakeK8sClient{}, ManagerConfig{DisableCompression: tt.disabled, CompressionLevel: 9})
+			server := startTestServer(t, manager, testSession())
+
+			dialer := websocket.Dialer{EnableCompression: tt.clientEnables}
+			conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				t.Fatalf("Expected no error dialing tunnel, got %v", err)
+			}
+			defer conn.Close()
+
+			negotiated := strings.Contains(resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+			if negotiated != tt.wantNegotiated {
+				t.Fatalf("Expected permessage-deflate negotiated %v, got header %q",
+					tt.wantNegotiated, resp.Header.Get("Sec-Websocket-Extensions"))
+			}
+
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			var ready types.TunnelMessage
+			if err := conn.ReadJSON(&ready); err != nil || ready.Type != "ready" {
+				t.Fatalf("Expected ready message, got %+v (%v)", ready, err)
+			}
+
+			// Large enough to be compressed when negotiated
+			command := "echo " + strings.Repeat("compressible ", 100)
+			conn.WriteJSON(types.TunnelMessage{Type: "exec", Payload: map[string]interface{}{"command": command, "stdout": true}})
+			var response types.TunnelMessage
+			if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+				t.Fatalf("Expected exec_response, got %+v (%v)", response, err)
+			}
+			payload, _ := response.Payload.(map[string]interface{})
+			if stdout, _ := payload["stdout"].(string); !strings.Contains(stdout, command) {
+				t.Errorf("Expected the output to round trip, got %d bytes", len(stdout))
+			}
+		})
+	}
+}
+
+func TestManager_CompressionDefaults(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{CompressionLevel: 12})
+	if manager.compressionLevel != DefaultCompressionLevel {
+		t.Errorf("Expected an invalid level to fall back to %d, got %d", DefaultCompressionLevel, manager.compressionLevel)
+	}
+	if manager.compressMessage(DefaultCompressionThreshold - 1) {
+		t.Error("Expected messages below the threshold to be sent uncompressed")
+	}
+	if !manager.compressMessage(DefaultCompressionThreshold) {
+		t.Error("Expected messages at the threshold to be compressed")
+	}
+}
+
+// compressionWorkloads are typical tunnel messages for BenchmarkCompressionLevels
+func compressionWorkloads() map[string][]byte {
+	var buildLog strings.Builder
+	for i := 0; buildLog.Len() < 32<<10; i++ {
+		fmt.Fprintf(&buildLog, "[%3d%%] Building CXX object src/analysis/CMakeFiles/analysis.dir/selector_%d.cxx.o\n", i%100, i)
+	}
+
+	var source strings.Builder
+	for i := 0; source.Len() < 256<<10; i++ {
+		fmt.Fprintf(&source, "def histogram_%d(events, bins=%d):\n    counts, edges = np.histogram(events['pt'], bins=bins)\n    return counts / counts.sum(), edges\n\n", i, 50+i%50)
+	}
+
+	binary := make([]byte, 256<<10)
+	rand.New(rand.NewSource(1)).Read(binary)
+
+	return map[string][]byte{
+		"exec_output": []byte(buildLog.String()),
+		"file_text":   []byte(source.String()),
+		"file_binary": binary,
+	}
+}
+
+// BenchmarkCompressionLevels measures the throughput and compression ratio of
+// each flate level on typical messages, to choose TUNNEL_COMPRESSION_LEVEL:
+//
+//	go test ./internal/tunnel -run '^$' -bench CompressionLevels
+func BenchmarkCompressionLevels(b *testing.B) {
+	for name, data := range compressionWorkloads() {
+		for _, level := range []int{flate.BestSpeed, DefaultCompressionLevel, 6, flate.BestCompression} {
+			b.Run(fmt.Sprintf("%s/level=%d", name, level), func(b *testing.B) {
+				var out bytes.Buffer
+				writer, _ := flate.NewWriter(&out, level)
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					out.Reset()
+					writer.Reset(&out)
+					writer.Write(data)
+					writer.Flush()
+				}
+				b.ReportMetric(float64(len(data))/float64(out.Len()), "ratio")
+			})
+		}
+	}
+}
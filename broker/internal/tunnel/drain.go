@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// drainPollInterval is how often CloseAll checks whether the closed tunnels'
+// handlers have finished
+const drainPollInterval = 50 * time.Millisecond
+
+// drainState records a drain of the broker, guarded by Manager.mutex
+type drainState struct {
+	started    bool
+	shutdownAt time.Time
+}
+
+// Drain prepares the broker for shutdown: new tunnels are refused with
+// CloseDraining, and open ones are sent a server_draining message announcing
+// shutdown after leadTime, so clients can save state and reconnect to another
+// replica. Open tunnels keep working until CloseAll. Draining again keeps the
+// first deadline, which is returned.
+func (m *Manager) Drain(leadTime time.Duration) time.Time {
+	m.mutex.Lock()
+	if m.drain.started {
+		shutdownAt := m.drain.shutdownAt
+		m.mutex.Unlock()
+		return shutdownAt
+	}
+	shutdownAt := time.Now().Add(leadTime)
+	m.drain = drainState{started: true, shutdownAt: shutdownAt}
+	tunnels := make([]*Tunnel, 0, len(m.tunnels))
+	for _, tunnel := range m.tunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	m.mutex.Unlock()
+
+	log.Printf("Draining: refusing new tunnels and warning %d open ones of shutdown in %v", len(tunnels), leadTime)
+
+	// A client that stopped reading must not hold up the others' warning
+	var wg sync.WaitGroup
+	for _, tunnel := range tunnels {
+		wg.Add(1)
+		go func(tunnel *Tunnel) {
+			defer wg.Done()
+			m.sendDraining(tunnel, shutdownAt)
+		}(tunnel)
+	}
+	wg.Wait()
+	return shutdownAt
+}
+
+// isDraining reports whether Drain has been called
+func (m *Manager) isDraining() bool {
+	_, draining := m.drainDeadline()
+	return draining
+}
+
+// drainDeadline returns the announced shutdown time, if draining
+func (m *Manager) drainDeadline() (time.Time, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.drain.shutdownAt, m.drain.started
+}
+
+func (m *Manager) sendDraining(tunnel *Tunnel, shutdownAt time.Time) {
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type: "server_draining",
+		Payload: types.ServerDraining{
+			ShutdownAt:  shutdownAt.UTC(),
+			RemainingMs: max(time.Until(shutdownAt), 0).Milliseconds(),
+		},
+	})
+}
+
+// CloseAll closes every open tunnel with CloseDraining, telling clients to
+// reconnect, and waits until their handlers have released or scheduled the
+// release of their credentials, or ctx is done
+func (m *Manager) CloseAll(ctx context.Context) {
+	m.mutex.RLock()
+	tunnels := make([]*Tunnel, 0, len(m.tunnels))
+	for _, tunnel := range m.tunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	m.mutex.RUnlock()
+
+	for _, tunnel := range tunnels {
+		tunnel.cancel()
+		m.closeWithCode(tunnel.Conn, CloseDraining, "draining")
+		tunnel.Conn.Close()
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for m.Stats().ActiveTunnels > 0 {
+		select {
+		case <-ctx.Done():
+			log.Printf("Gave up waiting for %d tunnels to close: %v", m.Stats().ActiveTunnels, ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
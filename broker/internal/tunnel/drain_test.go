@@ -0,0 +1,83 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_Drain(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+
+	shutdownAt := manager.Drain(time.Minute)
+	if again := manager.Drain(time.Hour); !again.Equal(shutdownAt) {
+		t.Errorf("Expected draining again to keep the first deadline %v, got %v", shutdownAt, again)
+	}
+	if !manager.Stats().Draining {
+		t.Error("Expected stats to report draining")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var warning struct {
+		Type    string               `json:"type"`
+		Payload types.ServerDraining `json:"payload"`
+	}
+	if err := conn.ReadJSON(&warning); err != nil || warning.Type != "server_draining" {
+		t.Fatalf("Expected server_draining, got %+v (%v)", warning, err)
+	}
+	if !warning.Payload.ShutdownAt.Equal(shutdownAt) {
+		t.Errorf("Expected shutdown at %v, got %v", shutdownAt, warning.Payload.ShutdownAt)
+	}
+	if remaining := warning.Payload.RemainingMs; remaining <= 50000 || remaining > 60000 {
+		t.Errorf("Expected about a minute remaining, got %dms", remaining)
+	}
+
+	// New tunnels are sent elsewhere while existing ones keep working
+	refused := dialTestServer(t, server)
+	if reason := readCloseReason(t, refused, CloseDraining); reason.Reason != "draining" || !reason.Reconnect {
+		t.Errorf("Expected new tunnels to be refused with a reconnect hint, got %+v", reason)
+	}
+	conn.WriteJSON(types.TunnelMessage{Type: "ping", Payload: map[string]interface{}{}})
+	var pong types.TunnelMessage
+	if err := conn.ReadJSON(&pong); err != nil || pong.Type != "pong" {
+		t.Fatalf("Expected the open tunnel to keep working, got %+v (%v)", pong, err)
+	}
+	if created, _ := k8sClient.counts(); created != 1 {
+		t.Errorf("Expected no credentials issued for the refused tunnel, got %d issued", created)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	manager.CloseAll(ctx)
+	if reason := readCloseReason(t, conn, CloseDraining); !reason.Reconnect {
+		t.Errorf("Expected closed tunnels to be told to reconnect, got %+v", reason)
+	}
+	if active := manager.Stats().ActiveTunnels; active != 0 {
+		t.Errorf("Expected CloseAll to wait for tunnels to end, %d still active", active)
+	}
+}
+
+func TestManager_DrainDuringSetup(t *testing.T) {
+	k8sClient := &fakeK8sClient{createDelay: 200 * time.Millisecond}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialTestServer(t, server)
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 1 })
+	manager.Drain(time.Minute)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ready, warning types.TunnelMessage
+	if err := conn.ReadJSON(&ready); err != nil || ready.Type != "ready" {
+		t.Fatalf("Expected the tunnel being set up to open, got %+v (%v)", ready, err)
+	}
+	if err := conn.ReadJSON(&warning); err != nil || warning.Type != "server_draining" {
+		t.Fatalf("Expected server_draining after ready, got %+v (%v)", warning, err)
+	}
+}
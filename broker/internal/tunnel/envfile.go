@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+)
+
+// Values read from env_from sources may be secrets. Exec arguments travel as
+// pods/exec query parameters, ending up in the API server's audit log and the
+// pod's /proc/*/cmdline, so the values are instead written to a file only the
+// container's user can read, through the exec's stdin, and the command loads
+// and removes it before it starts.
+const (
+	// envFileDir holds the environment files
+	envFileDir = "/tmp"
+
+	// writeEnvFileScript writes stdin to a new file ($0) private to the
+	// container's user, refusing to follow or replace an existing one
+	writeEnvFileScript = `umask 077 && set -C && cat > "$0"`
+
+	// loadEnvFileScript exports the variables in $0, removes it and runs the
+	// command in "$@"
+	loadEnvFileScript = `. "$0"; status=$?; rm -f -- "$0"; [ "$status" -eq 0 ] && exec "$@"`
+
+	// envFileCleanupTimeout bounds removing a file left behind by a command
+	// that never started
+	envFileCleanupTimeout = 10 * time.Second
+)
+
+// envFileContents renders env as a script exporting each variable, sorted by
+// name. Values are single quoted, so the shell never interprets them.
+func envFileContents(env map[string]string) []byte {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "export %s='%s'\n", key, strings.ReplaceAll(env[key], "'", `'\''`))
+	}
+	return buf.Bytes()
+}
+
+// writeEnvFile writes env to a new environment file in the pod, returning its
+// path, or "" when env is empty
+func (m *Manager) writeEnvFile(ctx context.Context, tunnel *Tunnel, container string, env map[string]string) (string, error) {
+	if len(env) == 0 {
+		return "", nil
+	}
+
+	path := envFileDir + "/.vscode-exec-env-" + uuid.New().String()
+	var stderr bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Container: container,
+		Command:   []string{"sh", "-c", writeEnvFileScript, path},
+		Stdin:     bytes.NewReader(envFileContents(env)),
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return path, nil
+}
+
+// removeEnvFile removes an environment file the command did not get to
+func (m *Manager) removeEnvFile(tunnel *Tunnel, container, path string) {
+	ctx, cancel := context.WithTimeout(context.Background(), envFileCleanupTimeout)
+	defer cancel()
+
+	m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Container: container,
+		Command:   []string{"rm", "-f", "--", path},
+	})
+}
+
+// envFileCommand wraps argv to load the environment file at path first. It
+// runs as the container's user, who owns the file, ahead of any user switch.
+func (m *Manager) envFileCommand(path string, argv []string) []string {
+	return append([]string{m.execShell, "-c", loadEnvFileScript, path}, argv...)
+}
@@ -0,0 +1,373 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultExecShell runs the exec prelude and interactive shells
+const DefaultExecShell = "/bin/sh"
+
+// execWithArgs runs the command passed as $0 with its arguments as "$@", so
+// neither needs quoting when embedded in a shell script
+const execWithArgs = `exec "$0" "$@"`
+
+// execCommand builds the argv run in the pod for an exec request.
+//
+// Non-TTY commands run after the prelude, if any, in the same shell so that
+// sourced profiles and activated environments apply to them. TTY requests run
+// the command, or the shell when none is given, optionally as a login shell.
+// Requests may override both the prelude and the login shell setting. Only
+// shell requests have their command interpreted by the shell. The
+// request's environment is set with env(1) ahead of everything else, after
+// switching to the requested user, if any.
+func (m *Manager) execCommand(req types.ExecRequest) []string {
+	command := m.envCommand(req)
+	if req.RunAsUser == "" {
+		return command
+	}
+	return m.runAsCommand(req.RunAsUser, command)
+}
+
+// envCommand builds the argv for an exec request with its environment
+func (m *Manager) envCommand(req types.ExecRequest) []string {
+	command := m.shellCommand(req)
+	if len(req.Env) == 0 {
+		return command
+	}
+
+	keys := make([]string, 0, len(req.Env))
+	for key := range req.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	argv := []string{"env"}
+	for _, key := range keys {
+		argv = append(argv, key+"="+req.Env[key])
+	}
+	return append(argv, command...)
+}
+
+// envVarName matches valid environment variable names, which also keeps them
+// from being taken for env(1) options
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateExecEnv checks the names of the environment variables requested
+func validateExecEnv(env map[string]string) error {
+	for key := range env {
+		if !envVarName.MatchString(key) {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	return nil
+}
+
+// shellCommand builds the argv for an exec request, without its environment
+func (m *Manager) shellCommand(req types.ExecRequest) []string {
+	if req.Shell {
+		return m.scriptCommand(req)
+	}
+	if req.TTY {
+		loginShell := m.execLoginShell
+		if req.LoginShell != nil {
+			loginShell = *req.LoginShell
+		}
+
+		if req.Command == "" {
+			if loginShell {
+				return []string{m.execShell, "-l"}
+			}
+			return []string{m.execShell}
+		}
+		if loginShell {
+			return append([]string{m.execShell, "-l", "-c", execWithArgs, req.Command}, req.Args...)
+		}
+		return append([]string{req.Command}, req.Args...)
+	}
+
+	prelude := m.requestPrelude(req)
+	if prelude == "" {
+		return append([]string{req.Command}, req.Args...)
+	}
+
+	return append([]string{m.execShell, "-c", prelude + " && " + execWithArgs, req.Command}, req.Args...)
+}
+
+// scriptCommand builds the argv running a shell request's command as a
+// script, with its arguments as "$@". The prelude runs first in the same
+// shell for non-TTY requests, which fail with its status if it fails.
+func (m *Manager) scriptCommand(req types.ExecRequest) []string {
+	if req.TTY {
+		loginShell := m.execLoginShell
+		if req.LoginShell != nil {
+			loginShell = *req.LoginShell
+		}
+		if loginShell {
+			return append([]string{m.execShell, "-l", "-c", req.Command, m.execShell}, req.Args...)
+		}
+		return append([]string{m.execShell, "-c", req.Command, m.execShell}, req.Args...)
+	}
+
+	prelude := m.requestPrelude(req)
+	script := req.Command
+	if prelude != "" {
+		script = prelude + " || exit\n" + req.Command
+	}
+	return append([]string{m.execShell, "-c", script, m.execShell}, req.Args...)
+}
+
+// requestPrelude returns the prelude for a non-TTY request. A verbatim
+// request's prelude writes its output to stderr, leaving stdout to the command.
+func (m *Manager) requestPrelude(req types.ExecRequest) string {
+	prelude := m.execPrelude
+	if req.Prelude != nil {
+		prelude = *req.Prelude
+	}
+	if prelude == "" || !req.Verbatim {
+		return prelude
+	}
+	return "{ " + prelude + "\n} >&2"
+}
+
+// execMode describes how an exec request's command is run, for audit logs
+func execMode(req types.ExecRequest) string {
+	if req.Shell {
+		return "shell"
+	}
+	return "argv"
+}
+
+// execSet tracks the exec streams running on a tunnel
+type execSet struct {
+	mutex   sync.Mutex
+	streams map[string]*execStream
+	limit   int // most streams running at once, 0 for no limit
+}
+
+// execStream is a single running exec, cancelled through its context
+type execStream struct {
+	info      types.ExecStreamInfo
+	cancel    context.CancelFunc
+	cancelled bool
+	stdin     *execStdin // nil unless the request asked for stdin
+}
+
+// add registers a stream and returns the context it must run under
+func (s *execSet) add(parent context.Context, streamID string, command []string) (context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.streams == nil {
+		s.streams = make(map[string]*execStream)
+	}
+	if _, exists := s.streams[streamID]; exists {
+		return nil, fmt.Errorf("exec stream %s already running", streamID)
+	}
+	if s.limit > 0 && len(s.streams) >= s.limit {
+		return nil, fmt.Errorf("too many exec streams running, at most %d", s.limit)
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	s.streams[streamID] = &execStream{
+		info: types.ExecStreamInfo{
+			StreamID:  streamID,
+			Command:   command,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+	}
+	return ctx, nil
+}
+
+// remove unregisters a finished stream, reporting whether it was cancelled
+func (s *execSet) remove(streamID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream, exists := s.streams[streamID]
+	if !exists {
+		return false
+	}
+	delete(s.streams, streamID)
+	stream.cancel()
+	return stream.cancelled
+}
+
+// cancel terminates a stream, returning false if it is not running
+func (s *execSet) cancel(streamID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream, exists := s.streams[streamID]
+	if !exists {
+		return false
+	}
+	stream.cancelled = true
+	stream.cancel()
+	return true
+}
+
+// list returns the running streams, oldest first
+func (s *execSet) list() []types.ExecStreamInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	streams := make([]types.ExecStreamInfo, 0, len(s.streams))
+	for _, stream := range s.streams {
+		streams = append(streams, stream.info)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].StartedAt.Before(streams[j].StartedAt)
+	})
+	return streams
+}
+
+// runExec runs an exec stream and reports its result. A stream terminated by
+// exec_cancel is reported with exec_cancelled once it has stopped.
+func (m *Manager) runExec(ctx context.Context, tunnel *Tunnel, streamID string, req types.ExecRequest, stdin io.Reader) {
+	defer m.recoverHandler(tunnel, "exec")
+	// Frees the stream if the run panics; a no-op once it has been removed.
+	defer tunnel.execs.remove(streamID)
+
+	sourced, err := m.resolveExecEnv(ctx, tunnel, req)
+	if err != nil {
+		tunnel.execs.remove(streamID)
+		m.sendError(tunnel, fmt.Sprintf("Failed to read exec environment: %v", err))
+		return
+	}
+	req, err = m.resolveRunAsUser(ctx, tunnel, req)
+	if err != nil {
+		tunnel.execs.remove(streamID)
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	envFile, err := m.writeEnvFile(ctx, tunnel, req.Container, sourced)
+	if err != nil {
+		tunnel.execs.remove(streamID)
+		m.sendError(tunnel, fmt.Sprintf("Failed to pass exec environment: %v", err))
+		return
+	}
+	log.Printf("Audit: user %s exec in pod %s/%s in %s mode: %s",
+		tunnel.Session.UserID, tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name, execMode(req), req.Command)
+
+	stdout := &cappedBuffer{limit: m.execMaxOutputBytes}
+	stderr := &cappedBuffer{limit: m.execMaxOutputBytes}
+	var stdoutWriter, stderrWriter io.Writer = stdout, stderr
+	var flushers []*outputFlusher
+	if req.Stream {
+		flushers = []*outputFlusher{
+			m.newOutputFlusher(tunnel, req, streamID, "stdout"),
+			m.newOutputFlusher(tunnel, req, streamID, "stderr"),
+		}
+		stdoutWriter, stderrWriter = flushers[0], flushers[1]
+	}
+
+	exitCode, err := m.executeCommand(ctx, tunnel, req, envFile, stdin, stdoutWriter, stderrWriter)
+	if err != nil && envFile != "" {
+		// The command never started to remove the file itself
+		m.removeEnvFile(tunnel, req.Container, envFile)
+	}
+	// Streamed output must reach the client before the exit code
+	slowReader := false
+	for _, flusher := range flushers {
+		flusher.Close()
+		slowReader = slowReader || flusher.slowReader
+	}
+	if req.Stream {
+		m.drainOutput(tunnel, streamID)
+	}
+	if tunnel.execs.remove(streamID) {
+		cancelled := map[string]string{"stream_id": streamID}
+		if slowReader {
+			cancelled["reason"] = "slow_reader"
+		}
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type:    "exec_cancelled",
+			Payload: cancelled,
+		})
+		return
+	}
+	if stdout.exceeded || stderr.exceeded {
+		m.sendError(tunnel, fmt.Sprintf("Command output exceeded %d bytes and the command was stopped; use \"stream\": true for large output", m.execMaxOutputBytes))
+		return
+	}
+	if err != nil {
+		m.sendError(tunnel, fmt.Sprintf("Command execution failed: %v", err))
+		return
+	}
+
+	result := &types.ExecResponse{ExitCode: exitCode}
+	if !req.Stream {
+		result.Stdout = stdout.String()
+		result.Stderr = stderr.String()
+		m.encodeExecOutput(req, result)
+	}
+	result.StreamID = streamID
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type:    "exec_response",
+		Payload: result,
+	})
+}
+
+// resolveExecEnv reads the environment sources referenced by the request,
+// leaving out the keys its Env sets, which take precedence. The values may be
+// secrets, so only the source names and keys are logged.
+func (m *Manager) resolveExecEnv(ctx context.Context, tunnel *Tunnel, req types.ExecRequest) (map[string]string, error) {
+	if len(req.EnvFrom) == 0 {
+		return nil, nil
+	}
+
+	env, err := m.k8sClient.ReadEnvSources(ctx, tunnel.credentials(), tunnel.Session.PodInfo.Namespace, req.EnvFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.Printf("Audit: user %s exec in pod %s/%s with environment from %v (keys %v, values redacted)",
+		tunnel.Session.UserID, tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name, req.EnvFrom, keys)
+
+	for key := range req.Env {
+		delete(env, key)
+	}
+	return env, nil
+}
+
+// handleExecCancel terminates an exec stream by ID
+func (m *Manager) handleExecCancel(tunnel *Tunnel, payload interface{}) {
+	var req types.ExecCancel
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid exec_cancel request format")
+		return
+	}
+
+	if !tunnel.execs.cancel(req.StreamID) {
+		m.sendError(tunnel, fmt.Sprintf("Unknown exec stream: %s", req.StreamID))
+	}
+}
+
+// handleExecList reports the exec streams running on the tunnel
+func (m *Manager) handleExecList(tunnel *Tunnel) {
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type:    "exec_list_response",
+		Payload: map[string]interface{}{"streams": tunnel.execs.list()},
+	})
+}
+
+// newStreamID generates an ID for exec streams the client did not name
+func newStreamID() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,455 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	utilexec "k8s.io/utils/exec"
+)
+
+func TestManager_ExecCommand(t *testing.T) {
+	prelude := "source /opt/conda/etc/profile.d/conda.sh && conda activate base"
+	empty := ""
+	noLogin := false
+
+	tests := []struct {
+		name   string
+		config ManagerConfig
+		req    types.ExecRequest
+		want   []string
+	}{
+		{
+			name: "no prelude",
+			req:  types.ExecRequest{Command: "ls", Args: []string{"-la"}},
+			want: []string{"ls", "-la"},
+		},
+		{
+			name:   "prelude prepended",
+			config: ManagerConfig{ExecPrelude: prelude, ExecShell: "/bin/bash"},
+			req:    types.ExecRequest{Command: "python", Args: []string{"-c", "print('a b')"}},
+			want:   []string{"/bin/bash", "-c", prelude + ` && exec "$0" "$@"`, "python", "-c", "print('a b')"},
+		},
+		{
+			name:   "prelude overridden per request",
+			config: ManagerConfig{ExecPrelude: prelude},
+			req:    types.ExecRequest{Command: "ls", Prelude: &empty},
+			want:   []string{"ls"},
+		},
+		{
+			name:   "prelude skipped for TTY",
+			config: ManagerConfig{ExecPrelude: prelude},
+			req:    types.ExecRequest{Command: "top", TTY: true},
+			want:   []string{"top"},
+		},
+		{
+			name:   "TTY login shell",
+			config: ManagerConfig{ExecLoginShell: true},
+			req:    types.ExecRequest{TTY: true},
+			want:   []string{DefaultExecShell, "-l"},
+		},
+		{
+			name:   "TTY command in login shell",
+			config: ManagerConfig{ExecLoginShell: true},
+			req:    types.ExecRequest{Command: "htop", Args: []string{"-d", "10"}, TTY: true},
+			want:   []string{DefaultExecShell, "-l", "-c", `exec "$0" "$@"`, "htop", "-d", "10"},
+		},
+		{
+			name:   "login shell overridden per request",
+			config: ManagerConfig{ExecLoginShell: true},
+			req:    types.ExecRequest{TTY: true, LoginShell: &noLogin},
+			want:   []string{DefaultExecShell},
+		},
+		{
+			name: "pipeline run by the shell",
+			req:  types.ExecRequest{Command: `grep -c "$1" log | tee count`, Args: []string{"error"}, Shell: true},
+			want: []string{DefaultExecShell, "-c", `grep -c "$1" log | tee count`, DefaultExecShell, "error"},
+		},
+		{
+			name:   "shell script after the prelude",
+			config: ManagerConfig{ExecPrelude: prelude, ExecShell: "/bin/bash"},
+			req:    types.ExecRequest{Command: "make 2>&1 | tail", Shell: true},
+			want:   []string{"/bin/bash", "-c", prelude + " || exit\nmake 2>&1 | tail", "/bin/bash"},
+		},
+		{
+			name:   "shell script in TTY login shell",
+			config: ManagerConfig{ExecLoginShell: true},
+			req:    types.ExecRequest{Command: "watch -n1 'ls | wc -l'", TTY: true, Shell: true},
+			want:   []string{DefaultExecShell, "-l", "-c", "watch -n1 'ls | wc -l'", DefaultExecShell},
+		},
+		{
+			name: "metacharacters not interpreted without shell",
+			req:  types.ExecRequest{Command: "echo", Args: []string{"a; rm -rf ~"}},
+			want: []string{"echo", "a; rm -rf ~"},
+		},
+		{
+			name: "environment set ahead of the command",
+			req:  types.ExecRequest{Command: "make", Env: map[string]string{"GOFLAGS": "-mod=vendor", "CC": "clang"}},
+			want: []string{"env", "CC=clang", "GOFLAGS=-mod=vendor", "make"},
+		},
+		{
+			name:   "environment set ahead of the prelude",
+			config: ManagerConfig{ExecPrelude: prelude},
+			req:    types.ExecRequest{Command: "ls", Env: map[string]string{"LANG": "C"}},
+			want:   []string{"env", "LANG=C", DefaultExecShell, "-c", prelude + ` && exec "$0" "$@"`, "ls"},
+		},
+		{
+			name:   "verbatim prelude output sent to stderr",
+			config: ManagerConfig{ExecPrelude: prelude},
+			req:    types.ExecRequest{Command: "kubectl", Args: []string{"get", "pods", "-o", "json"}, Verbatim: true},
+			want:   []string{DefaultExecShell, "-c", "{ " + prelude + "\n} >&2" + ` && exec "$0" "$@"`, "kubectl", "get", "pods", "-o", "json"},
+		},
+		{
+			name:   "verbatim shell script prelude output sent to stderr",
+			config: ManagerConfig{ExecPrelude: prelude},
+			req:    types.ExecRequest{Command: "pip list --format json", Shell: true, Verbatim: true},
+			want:   []string{DefaultExecShell, "-c", "{ " + prelude + "\n} >&2 || exit\npip list --format json", DefaultExecShell},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManager(&fakeK8sClient{}, tt.config)
+			if got := manager.execCommand(tt.req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExecSet(t *testing.T) {
+	var execs execSet
+	parent := context.Background()
+
+	termCtx, err := execs.add(parent, "terminal", []string{"/bin/sh"})
+	if err != nil {
+		t.Fatalf("Expected no error adding stream, got %v", err)
+	}
+	if _, err := execs.add(parent, "terminal", []string{"/bin/sh"}); err == nil {
+		t.Fatal("Expected error adding duplicate stream")
+	}
+	time.Sleep(time.Millisecond)
+	lspCtx, err := execs.add(parent, "lsp", []string{"pylsp"})
+	if err != nil {
+		t.Fatalf("Expected no error adding stream, got %v", err)
+	}
+
+	streams := execs.list()
+	if len(streams) != 2 || streams[0].StreamID != "terminal" || streams[1].StreamID != "lsp" {
+		t.Fatalf("Expected terminal and lsp streams oldest first, got %+v", streams)
+	}
+
+	if !execs.cancel("lsp") {
+		t.Fatal("Expected running stream to be cancelled")
+	}
+	if lspCtx.Err() == nil {
+		t.Error("Expected cancelled stream's context to be done")
+	}
+	if termCtx.Err() != nil {
+		t.Error("Expected other streams to keep running")
+	}
+	if !execs.remove("lsp") {
+		t.Error("Expected removed stream to report cancellation")
+	}
+	if execs.cancel("lsp") {
+		t.Error("Expected cancelling a finished stream to fail")
+	}
+
+	if execs.remove("terminal") {
+		t.Error("Expected finished stream not to report cancellation")
+	}
+	if termCtx.Err() == nil {
+		t.Error("Expected finished stream's context to be released")
+	}
+	if streams := execs.list(); len(streams) != 0 {
+		t.Errorf("Expected no streams, got %+v", streams)
+	}
+}
+
+func TestManager_ExecuteCommand(t *testing.T) {
+	var got k8s.ExecOptions
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		got = opts
+		if opts.Stdout != nil {
+			io.WriteString(opts.Stdout, "out")
+		}
+		if opts.Stderr != nil {
+			io.WriteString(opts.Stderr, "No such file or directory")
+		}
+		return utilexec.CodeExitError{Err: errors.New("command terminated with exit code 2"), Code: 2}
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	tunnel := testTunnel()
+
+	var stdout, stderr bytes.Buffer
+	req := types.ExecRequest{Command: "ls", Args: []string{"missing"}, Stderr: true, Container: "notebook"}
+	exitCode, err := manager.executeCommand(tunnel.ctx, tunnel, req, "", strings.NewReader("ignored"), &stdout, &stderr)
+	if err != nil || exitCode != 2 {
+		t.Fatalf("Expected exit code 2 without error, got %d (%v)", exitCode, err)
+	}
+	if got.Namespace != "test-namespace" || got.Pod != "test-pod" || got.Container != "notebook" ||
+		!reflect.DeepEqual(got.Command, []string{"ls", "missing"}) {
+		t.Errorf("Expected ls to run in the session's pod, got %+v", got)
+	}
+	if got.Stdin != nil || got.Stdout != nil || stdout.Len() != 0 || stderr.String() != "No such file or directory" {
+		t.Errorf("Expected only stderr attached, got stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+
+	k8sClient.execFunc = func(ctx context.Context, opts k8s.ExecOptions) error {
+		return errors.New("pods \"test-pod\" is forbidden")
+	}
+	if _, err := manager.executeCommand(tunnel.ctx, tunnel, req, "", nil, &stdout, &stderr); err == nil {
+		t.Error("Expected a failure to run the command to be an error")
+	}
+}
+
+func TestManager_VerbatimExec(t *testing.T) {
+	// The prelude's chatter must not reach stdout, even when it ends in a comment
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{ExecPrelude: "echo activating # conda"})
+	for _, req := range []types.ExecRequest{
+		{Command: "printf", Args: []string{`{"a": "\\u00e9"}\r\n\001`}, Verbatim: true},
+		{Command: `printf '{"a": "\\u00e9"}\r\n\001'`, Shell: true, Verbatim: true},
+	} {
+		argv := manager.execCommand(req)
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(argv[0], argv[1:]...)
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Expected %q to run, got %v", argv, err)
+		}
+		if want := "{\"a\": \"\\u00e9\"}\r\n\x01"; stdout.String() != want {
+			t.Errorf("Expected stdout %q, got %q", want, stdout.String())
+		}
+		if stderr.String() != "activating\n" {
+			t.Errorf("Expected the prelude's output on stderr, got %q", stderr.String())
+		}
+	}
+
+	server := startTestServer(t, NewManager(&fakeK8sClient{}, ManagerConfig{SanitizeExecOutput: true}), testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{Type: "exec", Payload: map[string]interface{}{"command": "jq\x01", "stdout": true, "verbatim": true}})
+	var response struct {
+		Type    string             `json:"type"`
+		Payload types.ExecResponse `json:"payload"`
+	}
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+		t.Fatalf("Expected exec_response, got %+v (%v)", response, err)
+	}
+	stdout, err := base64.StdEncoding.DecodeString(response.Payload.Stdout)
+	if err != nil || response.Payload.Encoding != EncodingBase64 || string(stdout) != "Executed: jq\x01" {
+		t.Errorf("Expected unsanitized base64 output, got %+v", response.Payload)
+	}
+
+	for _, mode := range []string{"tty", "stream"} {
+		conn.WriteJSON(types.TunnelMessage{Type: "exec", Payload: map[string]interface{}{"command": "jq", "verbatim": true, mode: true}})
+		var rejected types.TunnelMessage
+		if err := conn.ReadJSON(&rejected); err != nil || rejected.Type != "error" {
+			t.Errorf("Expected verbatim with %s to be rejected, got %+v (%v)", mode, rejected, err)
+		}
+	}
+}
+
+func TestManager_ExecStreams(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var response types.TunnelMessage
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "ls", "stream_id": "task-1"},
+	})
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected exec_response, got %v", err)
+	}
+	payload, _ := response.Payload.(map[string]interface{})
+	if response.Type != "exec_response" || payload["stream_id"] != "task-1" {
+		t.Fatalf("Expected exec_response for task-1, got %s %v", response.Type, response.Payload)
+	}
+
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected exec_list_response, got %v", err)
+	}
+	payload, _ = response.Payload.(map[string]interface{})
+	if streams, _ := payload["streams"].([]interface{}); response.Type != "exec_list_response" || len(streams) != 0 {
+		t.Fatalf("Expected no running streams, got %s %v", response.Type, response.Payload)
+	}
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_cancel",
+		Payload: map[string]interface{}{"stream_id": "task-1"},
+	})
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected error response, got %v", err)
+	}
+	if response.Type != "error" {
+		t.Errorf("Expected error cancelling finished stream, got %s", response.Type)
+	}
+}
+
+func TestManager_ExecEnvFrom(t *testing.T) {
+	var mutex sync.Mutex
+	var commands [][]string
+	var envFile string
+	k8sClient := &fakeK8sClient{
+		envSources: map[string]map[string]string{
+			"secret/db-creds": {"DB_PASSWORD": "it's hunter2", "DB_USER": "app"},
+		},
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			commands = append(commands, opts.Command)
+			if opts.Stdin != nil {
+				data, _ := io.ReadAll(opts.Stdin)
+				envFile = string(data)
+			}
+			return nil
+		},
+	}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type: "exec",
+		Payload: map[string]interface{}{
+			"command":  "psql",
+			"env":      map[string]string{"DB_USER": "admin"},
+			"env_from": []string{"secret/db-creds"},
+		},
+	})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+		t.Fatalf("Expected exec_response, got %s %v (%v)", response.Type, response.Payload, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, command := range commands {
+		if strings.Contains(strings.Join(command, " "), "hunter2") {
+			t.Errorf("Expected no secret value in the exec command, got %q", command)
+		}
+	}
+	if want := "export DB_PASSWORD='it'\\''s hunter2'\n"; envFile != want {
+		t.Errorf("Expected the sourced values in the env file, without those env overrides, got %q", envFile)
+	}
+	// The tunnel probes the pod's tools first
+	if len(commands) < 2 || !reflect.DeepEqual(commands[len(commands)-2][:3], []string{"sh", "-c", writeEnvFileScript}) {
+		t.Fatalf("Expected the env file to be written before the command, got %q", commands)
+	}
+	write, run := commands[len(commands)-2], commands[len(commands)-1]
+	want := []string{DefaultExecShell, "-c", loadEnvFileScript, write[3], "env", "DB_USER=admin", "psql"}
+	if !reflect.DeepEqual(run, want) {
+		t.Errorf("Expected %q, got %q", want, run)
+	}
+
+	if _, err := manager.resolveExecEnv(context.Background(), testTunnel(), types.ExecRequest{
+		Command: "env",
+		EnvFrom: []string{"secret/other"},
+	}); err == nil {
+		t.Error("Expected error for an unknown environment source")
+	}
+}
+
+func TestValidateExecEnv(t *testing.T) {
+	if err := validateExecEnv(map[string]string{"PATH": "/bin", "_X1": ""}); err != nil {
+		t.Errorf("Expected valid names, got %v", err)
+	}
+	for _, name := range []string{"", "A=B", "-i", "1X", "A B"} {
+		if err := validateExecEnv(map[string]string{name: "x"}); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestManager_ExecOutputLimit(t *testing.T) {
+	stopped := make(chan error, 1)
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		if opts.Command[0] != "yes" {
+			return echoExec(ctx, opts)
+		}
+		// Like yes, writes until the output fails
+		for {
+			if _, err := io.WriteString(opts.Stdout, "y\n"); err != nil {
+				stopped <- err
+				return err
+			}
+		}
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{ExecMaxOutputBytes: 1024})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "yes", "stdout": true},
+	})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "error" {
+		t.Fatalf("Expected an error for output past the limit, got %s %v (%v)", response.Type, response.Payload, err)
+	}
+	if message, _ := response.Payload.(map[string]interface{})["error"].(string); !strings.Contains(message, "1024 bytes") {
+		t.Errorf("Expected the limit in the error, got %v", response.Payload)
+	}
+	if err := <-stopped; !errors.Is(err, errOutputLimit) {
+		t.Errorf("Expected the command's writes to fail at the limit, got %v", err)
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	buf := &cappedBuffer{limit: 5}
+	if n, err := buf.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Expected a write within the limit to succeed, got %d (%v)", n, err)
+	}
+	if n, err := buf.Write([]byte("defg")); n != 2 || !errors.Is(err, errOutputLimit) {
+		t.Errorf("Expected a partial write and errOutputLimit, got %d (%v)", n, err)
+	}
+	if buf.String() != "abcde" || !buf.exceeded {
+		t.Errorf("Expected the output truncated at the limit, got %q", buf.String())
+	}
+}
+
+func TestManager_RecoversFromExecPanic(t *testing.T) {
+	k8sClient := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			if isCapabilityProbe(opts) {
+				return nil
+			}
+			panic("exec failed")
+		},
+	}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "ls", "stream_id": "task-1"},
+	})
+	if payload := readMessageOfType(t, conn, "internal_error"); payload["message_type"] != "exec" {
+		t.Errorf("Expected the panic to be reported for exec, got %v", payload)
+	}
+
+	// The panicked stream is freed and the tunnel keeps serving messages
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+	payload := readMessageOfType(t, conn, "exec_list_response")
+	if streams, _ := payload["streams"].([]interface{}); len(streams) != 0 {
+		t.Errorf("Expected no running streams, got %v", payload)
+	}
+}
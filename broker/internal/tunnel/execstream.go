@@ -0,0 +1,156 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// Defaults for batching streamed exec output into exec_output messages
+const (
+	// DefaultExecFlushInterval favours fewer, larger messages for bulk output
+	DefaultExecFlushInterval = 50 * time.Millisecond
+
+	// DefaultExecTTYFlushInterval keeps terminals responsive to keystrokes
+	DefaultExecTTYFlushInterval = 5 * time.Millisecond
+
+	// DefaultExecFlushBufferSize is the most output held before a flush
+	DefaultExecFlushBufferSize = 32 << 10
+)
+
+// outputFlusher sends a streamed exec's output to the client in exec_output
+// messages. Output accumulates until the buffer fills or the flush interval
+// has passed since the first unsent byte, whichever comes first.
+type outputFlusher struct {
+	m        *Manager
+	tunnel   *Tunnel
+	req      types.ExecRequest
+	streamID string
+	stream   string // "stdout" or "stderr"
+	size     int
+	interval time.Duration
+
+	mutex sync.Mutex
+	buf   []byte
+	timer *time.Timer
+
+	// slowReader is set once the client fell so far behind that the stream
+	// was cancelled
+	slowReader bool
+}
+
+// errSlowReader fails writes to a stream cancelled for its slow client
+var errSlowReader = errors.New("client is not reading the exec output")
+
+// newOutputFlusher returns a flusher for one output stream of an exec, using
+// the TTY flush interval for terminals
+func (m *Manager) newOutputFlusher(tunnel *Tunnel, req types.ExecRequest, streamID, stream string) *outputFlusher {
+	interval := m.execFlushInterval
+	if req.TTY {
+		interval = m.execTTYFlushInterval
+	}
+	return &outputFlusher{
+		m:        m,
+		tunnel:   tunnel,
+		req:      req,
+		streamID: streamID,
+		stream:   stream,
+		size:     m.execFlushBufferSize,
+		interval: interval,
+	}
+}
+
+// Write buffers output, flushing whole buffers at once
+func (f *outputFlusher) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.slowReader {
+		return 0, errSlowReader
+	}
+	f.buf = append(f.buf, p...)
+	if len(f.buf) >= f.size {
+		f.flushLocked(false)
+		return len(p), nil
+	}
+	if f.timer == nil && len(f.buf) > 0 {
+		f.timer = time.AfterFunc(f.interval, f.flush)
+	}
+	return len(p), nil
+}
+
+// Close sends any remaining output; call it before reporting the exit code
+func (f *outputFlusher) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.flushLocked(true)
+	return nil
+}
+
+func (f *outputFlusher) flush() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.flushLocked(false)
+}
+
+// flushLocked sends the buffered output. A UTF-8 sequence split across
+// writes is held back for the next flush unless final, so text chunks never
+// carry half a character.
+func (f *outputFlusher) flushLocked(final bool) {
+	if f.timer != nil {
+		f.timer.Stop()
+		f.timer = nil
+	}
+
+	data := f.buf
+	if !final && !f.req.Binary {
+		data = data[:completeUTF8(data)]
+	}
+	if len(data) == 0 {
+		return
+	}
+	rest := append([]byte(nil), f.buf[len(data):]...)
+
+	output := types.ExecOutput{StreamID: f.streamID, Stream: f.stream}
+	switch {
+	case f.req.Binary:
+		output.Data = base64.StdEncoding.EncodeToString(data)
+		output.Encoding = EncodingBase64
+	case !f.req.TTY && f.m.sanitizeExecOutput:
+		output.Data = sanitizeOutput(string(data))
+	default:
+		output.Data = string(data)
+	}
+	if !f.m.queueOutput(f.tunnel, output, f.req.TTY) {
+		f.slowReader = true
+		f.buf = nil
+		f.tunnel.execs.cancel(f.streamID)
+		return
+	}
+
+	f.buf = rest
+	if len(f.buf) > 0 {
+		f.timer = time.AfterFunc(f.interval, f.flush)
+	}
+}
+
+// completeUTF8 returns the length of data without a trailing incomplete
+// UTF-8 sequence
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(data[i]) {
+			continue
+		}
+		if utf8.FullRune(data[i:]) {
+			return len(data)
+		}
+		return i
+	}
+	return len(data)
+}
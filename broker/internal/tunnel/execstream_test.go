@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestCompleteUTF8(t *testing.T) {
+	tests := []struct {
+		data string
+		want int
+	}{
+		{"", 0},
+		{"plain", 5},
+		{"café", 5},
+		{"caf\xc3", 3},
+		{"\xe2\x82", 0},
+		{"ok\xe2\x82\xac", 5},
+		{"bad\xff", 4},
+	}
+
+	for _, tt := range tests {
+		if got := completeUTF8([]byte(tt.data)); got != tt.want {
+			t.Errorf("completeUTF8(%q) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestManager_ExecStreamOutput(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "ls", "stream_id": "task-1", "stdout": true, "stream": true},
+	})
+
+	var output strings.Builder
+	for {
+		var response types.TunnelMessage
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Fatalf("Expected exec output, got %v", err)
+		}
+		payload, _ := response.Payload.(map[string]interface{})
+		if payload["stream_id"] != "task-1" {
+			t.Fatalf("Expected messages for task-1, got %s %v", response.Type, response.Payload)
+		}
+		if response.Type == "exec_response" {
+			if payload["stdout"] != nil && payload["stdout"] != "" {
+				t.Errorf("Expected no buffered stdout when streaming, got %v", payload["stdout"])
+			}
+			break
+		}
+		if response.Type != "exec_output" || payload["stream"] != "stdout" {
+			t.Fatalf("Expected exec_output on stdout, got %s %v", response.Type, response.Payload)
+		}
+		data, _ := payload["data"].(string)
+		output.WriteString(data)
+	}
+
+	if output.String() != "Executed: ls" {
+		t.Errorf("Expected streamed output to reassemble, got %q", output.String())
+	}
+}
+
+// flusherForTest returns a flusher on the test server's tunnel
+func flusherForTest(t *testing.T, config ManagerConfig) (*outputFlusher, *websocket.Conn) {
+	t.Helper()
+
+	manager := NewManager(&fakeK8sClient{}, config)
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	return manager.newOutputFlusher(serverTunnel(t, manager), types.ExecRequest{}, "task-1", "stdout"), conn
+}
+
+func TestOutputFlusher_BufferFull(t *testing.T) {
+	flusher, conn := flusherForTest(t, ManagerConfig{ExecFlushBufferSize: 4, ExecFlushInterval: time.Hour})
+
+	flusher.Write([]byte("abc"))
+	flusher.Write([]byte("de"))
+
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected output once the buffer filled, got %v", err)
+	}
+	payload, _ := response.Payload.(map[string]interface{})
+	if response.Type != "exec_output" || payload["data"] != "abcde" {
+		t.Errorf("Expected buffered writes in one message, got %s %v", response.Type, response.Payload)
+	}
+}
+
+func TestOutputFlusher_Interval(t *testing.T) {
+	flusher, conn := flusherForTest(t, ManagerConfig{ExecFlushInterval: 10 * time.Millisecond})
+	flusher.Write([]byte("partial caf\xc3"))
+
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected output after the flush interval, got %v", err)
+	}
+	payload, _ := response.Payload.(map[string]interface{})
+	if response.Type != "exec_output" || payload["data"] != "partial caf" {
+		t.Fatalf("Expected complete characters to be flushed, got %s %v", response.Type, response.Payload)
+	}
+
+	flusher.Write([]byte("\xa9"))
+	flusher.Close()
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected remaining output on close, got %v", err)
+	}
+	payload, _ = response.Payload.(map[string]interface{})
+	if payload["data"] != "é" {
+		t.Errorf("Expected the held back character to be sent whole, got %v", payload["data"])
+	}
+}
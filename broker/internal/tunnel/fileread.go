@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultFileCompressionThreshold is the smallest file read that is gzipped
+// when the client asks for compression
+const DefaultFileCompressionThreshold = 8 << 10
+
+// compressedExtensions are file types that gain little from gzip
+var compressedExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".zip": true, ".7z": true, ".rar": true, ".jar": true, ".whl": true,
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+	".mp3": true, ".mp4": true, ".mkv": true, ".webm": true, ".pdf": true,
+	".parquet": true, ".h5": true, ".hdf5": true, ".npz": true, ".root": true,
+}
+
+// compressedMagic are signatures of compressed formats, for files whose
+// extension does not give them away
+var compressedMagic = [][]byte{
+	{0x1f, 0x8b},               // gzip
+	{0x42, 0x5a, 0x68},         // bzip2
+	{0xfd, '7', 'z', 'X', 'Z'}, // xz
+	{0x28, 0xb5, 0x2f, 0xfd},   // zstd
+	{'P', 'K', 0x03, 0x04},     // zip
+	{0x89, 'P', 'N', 'G'},      // png
+	{0xff, 0xd8, 0xff},         // jpeg
+}
+
+// isCompressed reports whether the file is already in a compressed format
+func isCompressed(path string, content []byte) bool {
+	if compressedExtensions[strings.ToLower(filepath.Ext(path))] {
+		return true
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(content, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileReadResponse builds the response for a file read. When the client asked
+// for compression and the file is large enough and not already compressed, the
+// content is gzipped and base64 encoded and Compressed is set. Otherwise binary
+// content is base64 encoded with Encoding set.
+func (m *Manager) fileReadResponse(req types.FileOperation, content []byte) *types.FileOperationResponse {
+	if !req.Compress || len(content) < m.fileCompressionThreshold || isCompressed(req.Path, content) {
+		return plainFileResponse(content)
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(content); err != nil {
+		return plainFileResponse(content)
+	}
+	if err := writer.Close(); err != nil {
+		return plainFileResponse(content)
+	}
+
+	return &types.FileOperationResponse{
+		Success:    true,
+		Content:    base64.StdEncoding.EncodeToString(buf.Bytes()),
+		Compressed: true,
+	}
+}
+
+// plainFileResponse sends text content as is and binary content as base64
+func plainFileResponse(content []byte) *types.FileOperationResponse {
+	if utf8.Valid(content) {
+		return &types.FileOperationResponse{Success: true, Content: string(content)}
+	}
+	return &types.FileOperationResponse{
+		Success:  true,
+		Content:  base64.StdEncoding.EncodeToString(content),
+		Encoding: EncodingBase64,
+	}
+}
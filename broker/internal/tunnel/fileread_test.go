@@ -0,0 +1,93 @@
+package tunnel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_FileReadResponse(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{FileCompressionThreshold: 64})
+	large := []byte(strings.Repeat("import numpy as np\n", 20))
+
+	tests := []struct {
+		name       string
+		req        types.FileOperation
+		content    []byte
+		compressed bool
+	}{
+		{
+			name:       "compressed on request",
+			req:        types.FileOperation{Operation: "read", Path: "analysis.py", Compress: true},
+			content:    large,
+			compressed: true,
+		},
+		{
+			name:    "not requested",
+			req:     types.FileOperation{Operation: "read", Path: "analysis.py"},
+			content: large,
+		},
+		{
+			name:    "below threshold",
+			req:     types.FileOperation{Operation: "read", Path: "analysis.py", Compress: true},
+			content: []byte("print('hi')\n"),
+		},
+		{
+			name:    "compressed extension",
+			req:     types.FileOperation{Operation: "read", Path: "events.ROOT", Compress: true},
+			content: large,
+		},
+		{
+			name:    "compressed magic bytes",
+			req:     types.FileOperation{Operation: "read", Path: "data.bin", Compress: true},
+			content: append([]byte{0x1f, 0x8b}, large...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := manager.fileReadResponse(tt.req, tt.content)
+			if !resp.Success {
+				t.Fatalf("Expected success, got %+v", resp)
+			}
+			if resp.Compressed != tt.compressed {
+				t.Fatalf("Expected compressed %v, got %v", tt.compressed, resp.Compressed)
+			}
+
+			content := []byte(resp.Content)
+			if resp.Compressed {
+				content = gunzipContent(t, resp.Content)
+			} else if resp.Encoding == EncodingBase64 {
+				content, _ = base64.StdEncoding.DecodeString(resp.Content)
+			}
+			if !bytes.Equal(content, tt.content) {
+				t.Errorf("Expected content to round-trip, got %q", content)
+			}
+		})
+	}
+}
+
+func gunzipContent(t *testing.T, content string) []byte {
+	t.Helper()
+
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		t.Fatalf("Expected base64 content, got %v", err)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected gzip content, got %v", err)
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Expected to decompress content, got %v", err)
+	}
+	return decoded
+}
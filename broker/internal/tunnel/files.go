@@ -0,0 +1,183 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// defaultFileMode is used for written files when the request sets no mode
+const defaultFileMode = 0644
+
+// DefaultMaxFileSize bounds the files read or written in one file operation
+const DefaultMaxFileSize = 32 << 20
+
+// DefaultFileOperationTimeout bounds one file operation, long enough to copy
+// a file of DefaultMaxFileSize over a slow link
+const DefaultFileOperationTimeout = 2 * time.Minute
+
+// readFile copies a file out of the pod as a tar stream, which is safe for
+// binary content. A symlink is reported with its target instead of content.
+// The archive is read as the pod writes it, and a file over the size limit
+// stops the copy before its content is read.
+func (m *Manager) readFile(ctx context.Context, tunnel *Tunnel, req types.FileOperation) *types.FileOperationResponse {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	reader, writer := io.Pipe()
+	copied := make(chan error, 1)
+	go func() {
+		err := m.k8sClient.CopyFromPod(ctx, tunnel.credentials(), copyOptions(tunnel), req.Path, writer)
+		writer.CloseWithError(err)
+		copied <- err
+	}()
+
+	file, err := k8s.ReadFileArchive(reader, m.maxFileSize)
+	if err != nil {
+		reader.CloseWithError(err)
+		cancel()
+		// A failed copy, such as of a missing file, explains the cut-short
+		// archive better than the archive's error does
+		if copyErr := <-copied; copyErr != nil && !errors.Is(err, k8s.ErrFileTooLarge) && !errors.Is(err, k8s.ErrNotRegularFile) {
+			return fileError(copyErr)
+		}
+		return fileError(err)
+	}
+
+	// Read the end of the archive so tar exits cleanly
+	io.Copy(io.Discard, reader)
+	if err := <-copied; err != nil {
+		return fileError(err)
+	}
+	if file.LinkTarget != "" {
+		return &types.FileOperationResponse{
+			Success:    true,
+			Mode:       formatMode(file.Mode),
+			LinkTarget: file.LinkTarget,
+		}
+	}
+
+	resp := m.fileReadResponse(req, file.Content)
+	resp.Mode = formatMode(file.Mode)
+	return resp
+}
+
+// writeFile copies a file into the pod as a tar stream, creating or replacing
+// it with the requested mode
+func (m *Manager) writeFile(ctx context.Context, tunnel *Tunnel, req types.FileOperation) *types.FileOperationResponse {
+	content := []byte(req.Content)
+	if req.Encoding == EncodingBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(req.Content)
+		if err != nil {
+			return fileError(fmt.Errorf("invalid base64 content: %w", err))
+		}
+		content = decoded
+	}
+
+	mode := int64(defaultFileMode)
+	if req.Mode != "" {
+		parsed, err := strconv.ParseUint(req.Mode, 8, 12)
+		if err != nil {
+			return fileError(fmt.Errorf("invalid mode %q", req.Mode))
+		}
+		mode = int64(parsed)
+	}
+
+	if m.maxFileSize > 0 && int64(len(content)) > m.maxFileSize {
+		return fileError(fmt.Errorf("%w: %d bytes, the limit is %d", k8s.ErrFileTooLarge, len(content), m.maxFileSize))
+	}
+
+	// The archive is written as the pod reads it. Closing the reader once the
+	// copy returns stops the writer if tar exited early.
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(k8s.WriteFileArchive(writer, k8s.ArchivedFile{
+			Name:    path.Base(req.Path),
+			Mode:    mode,
+			Content: content,
+		}))
+	}()
+	err := m.k8sClient.CopyToPod(ctx, tunnel.credentials(), copyOptions(tunnel), path.Dir(req.Path), reader)
+	reader.Close()
+	if err != nil {
+		return fileError(err)
+	}
+	return &types.FileOperationResponse{Success: true, Mode: formatMode(mode)}
+}
+
+// createSymlink creates a symlink at the request's path pointing to its
+// target. The target may be relative and need not exist; an existing file at
+// the path is never replaced, and a directory there is not linked into.
+func (m *Manager) createSymlink(ctx context.Context, tunnel *Tunnel, req types.FileOperation) *types.FileOperationResponse {
+	linkPath, err := m.validateSymlink(req.Path, req.Target)
+	if err != nil {
+		return fileError(err)
+	}
+
+	var stderr bytes.Buffer
+	err = m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"ln", "-sTn", "--", req.Target, linkPath},
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fileError(fmt.Errorf("failed to create symlink %s: %s", req.Path, msg))
+		}
+		return fileError(fmt.Errorf("failed to create symlink %s: %w", req.Path, err))
+	}
+	return &types.FileOperationResponse{Success: true, LinkTarget: req.Target}
+}
+
+// validateSymlink checks a symlink's absolute path and its target, resolved
+// against the link's directory if relative, both lie within the permissions
+// root, returning the cleaned path
+func (m *Manager) validateSymlink(linkPath, target string) (string, error) {
+	if linkPath == "" || target == "" {
+		return "", fmt.Errorf("symlink requires a path and a target")
+	}
+	if strings.ContainsRune(linkPath, 0) || strings.ContainsRune(target, 0) {
+		return "", fmt.Errorf("symlink path and target must not contain NUL bytes")
+	}
+
+	linkPath, err := m.confinePermissionPath(linkPath)
+	if err != nil {
+		return "", err
+	}
+	resolved := target
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(path.Dir(linkPath), resolved)
+	}
+	if _, err := m.confinePermissionPath(resolved); err != nil {
+		return "", fmt.Errorf("symlink target: %w", err)
+	}
+	return linkPath, nil
+}
+
+// copyOptions targets the tunnel's pod for file copies
+func copyOptions(tunnel *Tunnel) k8s.CopyOptions {
+	return k8s.CopyOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+	}
+}
+
+// formatMode renders permission bits in octal, e.g. 0644
+func formatMode(mode int64) string {
+	return fmt.Sprintf("%04o", mode&0o7777)
+}
+
+func fileError(err error) *types.FileOperationResponse {
+	return &types.FileOperationResponse{Success: false, Error: err.Error()}
+}
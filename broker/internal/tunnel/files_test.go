@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func testTunnel() *Tunnel {
+	return &Tunnel{ID: testSession().ID, Session: testSession(), ctx: context.Background()}
+}
+
+func TestManager_WriteAndReadFile(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	tunnel := testTunnel()
+	binary := []byte{0x7f, 'E', 'L', 'F', 0x00, 0xff}
+
+	resp := manager.writeFile(context.Background(), tunnel, types.FileOperation{
+		Operation: "write",
+		Path:      "/home/alice/bin/tool",
+		Content:   base64.StdEncoding.EncodeToString(binary),
+		Encoding:  EncodingBase64,
+		Mode:      "0755",
+	})
+	if !resp.Success {
+		t.Fatalf("Expected write to succeed, got %s", resp.Error)
+	}
+
+	resp = manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/bin/tool"})
+	if !resp.Success {
+		t.Fatalf("Expected read to succeed, got %s", resp.Error)
+	}
+	if resp.Mode != "0755" {
+		t.Errorf("Expected mode 0755 to be preserved, got %s", resp.Mode)
+	}
+	if resp.Encoding != EncodingBase64 {
+		t.Fatalf("Expected binary content to be base64 encoded, got %q", resp.Encoding)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(resp.Content); string(decoded) != string(binary) {
+		t.Errorf("Expected binary content to round-trip, got %q", decoded)
+	}
+
+	resp = manager.writeFile(context.Background(), tunnel, types.FileOperation{Operation: "write", Path: "/home/alice/notes.md", Content: "# Notes\n"})
+	if !resp.Success || resp.Mode != "0644" {
+		t.Fatalf("Expected text write with default mode, got %+v", resp)
+	}
+	resp = manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/notes.md"})
+	if resp.Content != "# Notes\n" || resp.Encoding != "" {
+		t.Errorf("Expected text content as is, got %+v", resp)
+	}
+}
+
+func TestManager_ReadFileSymlinkAndErrors(t *testing.T) {
+	k8sClient := &fakeK8sClient{files: map[string]k8s.ArchivedFile{
+		"/home/alice/data": {Name: "data", Mode: 0777, LinkTarget: "/scratch/alice/data"},
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	tunnel := testTunnel()
+
+	resp := manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/data"})
+	if !resp.Success || resp.LinkTarget != "/scratch/alice/data" || resp.Content != "" {
+		t.Errorf("Expected symlink target without content, got %+v", resp)
+	}
+
+	resp = manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/missing"})
+	if resp.Success || !strings.Contains(resp.Error, "No such file") {
+		t.Errorf("Expected missing file error from the pod, got %+v", resp)
+	}
+
+	resp = manager.writeFile(context.Background(), tunnel, types.FileOperation{Operation: "write", Path: "/home/alice/x", Mode: "999"})
+	if resp.Success {
+		t.Error("Expected invalid mode to be rejected")
+	}
+}
+
+func TestManager_FileSizeLimit(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{MaxFileSize: 8})
+	tunnel := testTunnel()
+
+	resp := manager.writeFile(context.Background(), tunnel, types.FileOperation{Operation: "write", Path: "/home/alice/big.txt", Content: "123456789"})
+	if resp.Success || !strings.Contains(resp.Error, "file too large") {
+		t.Errorf("Expected a write over the limit to fail, got %+v", resp)
+	}
+
+	if resp := manager.writeFile(context.Background(), tunnel, types.FileOperation{Operation: "write", Path: "/home/alice/small.txt", Content: "12345678"}); !resp.Success {
+		t.Fatalf("Expected a write at the limit to succeed, got %s", resp.Error)
+	}
+	if resp := manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/small.txt"}); !resp.Success || resp.Content != "12345678" {
+		t.Errorf("Expected a read at the limit to succeed, got %+v", resp)
+	}
+
+	// A file grown in the pod past the limit is refused on read
+	client := manager.k8sClient.(*fakeK8sClient)
+	client.files["/home/alice/grown.txt"] = k8s.ArchivedFile{Name: "grown.txt", Mode: 0644, Content: []byte("123456789")}
+	resp = manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/grown.txt"})
+	if resp.Success || !strings.Contains(resp.Error, "file too large") {
+		t.Errorf("Expected a read over the limit to fail, got %+v", resp)
+	}
+
+	resp = manager.readFile(context.Background(), tunnel, types.FileOperation{Operation: "read", Path: "/home/alice/missing.txt"})
+	if resp.Success || !strings.Contains(resp.Error, "No such file") {
+		t.Errorf("Expected the pod's error for a missing file, got %+v", resp)
+	}
+}
@@ -0,0 +1,373 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultMaxBatchOperations bounds the file operations in one batch message
+const DefaultMaxBatchOperations = 100
+
+// DefaultMaxConcurrentFileOps bounds the file and batch messages running at
+// once on a tunnel
+const DefaultMaxConcurrentFileOps = 4
+
+// DefaultMaxListEntries bounds the entries in one page of a directory listing
+const DefaultMaxListEntries = 1000
+
+// statFormat is the stat -c format of a record: type, octal and symbolic
+// mode, size, modification time and name
+const statFormat = "%F\t%a\t%A\t%s\t%Y\t%n"
+
+// statScript prints stat records for each operation, path, offset and limit
+// passed as arguments: the path itself for stat, a page of its entries for
+// list. Each operation's output starts with a "=" line; a failure is reported
+// as a "!" line, and a "+" line ends a page with more entries after it. A
+// symlink's record is followed by an "@" line with the type of what it points
+// to, or "broken", and its target. Paths are arguments, never part of the
+// script, so they need no quoting. Entries are in the C locale's glob order,
+// so pages of an unchanged directory are consistent, and skipped entries are
+// never stat'ed. It relies only on POSIX sh, stat -c and readlink, which both
+// GNU coreutils and busybox provide.
+const statScript = `export LC_ALL=C
+fmt='` + statFormat + `'
+entry() {
+	stat -c "$fmt" -- "$1" || return
+	[ -L "$1" ] || return 0
+	if [ -d "$1" ]; then t=directory
+	elif [ -f "$1" ]; then t=file
+	elif [ -e "$1" ]; then t=other
+	else t=broken
+	fi
+	printf '@%s\t%s\n' "$t" "$(readlink -- "$1")"
+}
+list() {
+	i=0; n=0
+	for f in "$1"/* "$1"/.[!.]* "$1"/..?*; do
+		if [ -e "$f" ] || [ -L "$f" ]; then
+			i=$((i + 1))
+			[ "$i" -le "$2" ] && continue
+			if [ "$n" -ge "$3" ]; then echo +; return; fi
+			n=$((n + 1))
+			entry "$f"
+		fi
+	done
+}
+while [ $# -gt 3 ]; do
+	op=$1; p=$2; offset=$3; limit=$4; shift 4
+	echo =
+	if [ ! -e "$p" ] && [ ! -L "$p" ]; then echo '!No such file or directory'
+	elif [ "$op" = stat ]; then entry "$p" 2>/dev/null || echo '!Permission denied'
+	elif [ ! -d "$p" ]; then echo '!Not a directory'
+	elif [ ! -r "$p" ] || [ ! -x "$p" ]; then echo '!Permission denied'
+	else list "$p" "$offset" "$limit" 2>/dev/null
+	fi
+done`
+
+// statFiles runs list and stat operations together in a single exec,
+// returning their results in order
+func (m *Manager) statFiles(ctx context.Context, tunnel *Tunnel, ops []types.FileOperation) []*types.FileOperationResponse {
+	results := make([]*types.FileOperationResponse, len(ops))
+
+	command := []string{"sh", "-c", statScript, "sh"}
+	pages := make([]listPage, len(ops))
+	var indexes []int
+	for i, op := range ops {
+		page, err := m.listPage(op)
+		if err != nil {
+			results[i] = fileError(err)
+			continue
+		}
+		pages[i] = page
+		indexes = append(indexes, i)
+		command = append(command, op.Operation, op.Path, strconv.Itoa(page.offset), strconv.Itoa(page.limit))
+	}
+	if len(indexes) == 0 {
+		return results
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   command,
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+	})
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		for _, i := range indexes {
+			results[i] = fileError(fmt.Errorf("failed to stat files in pod: %w", err))
+		}
+		return results
+	}
+
+	sections := parseStatOutput(stdout.String())
+	for j, i := range indexes {
+		op := ops[i]
+		if j >= len(sections) {
+			results[i] = fileError(fmt.Errorf("no output for %s", op.Path))
+			continue
+		}
+		section := sections[j]
+		switch {
+		case section.err != "":
+			results[i] = fileError(fmt.Errorf("%s: %s", op.Path, section.err))
+		case op.Operation == "stat" && len(section.entries) == 1:
+			results[i] = &types.FileOperationResponse{Success: true, Stat: &section.entries[0]}
+		case op.Operation == "stat":
+			results[i] = fileError(fmt.Errorf("unexpected stat output for %s", op.Path))
+		default:
+			results[i] = &types.FileOperationResponse{Success: true, Entries: section.entries}
+			if section.hasMore {
+				results[i].HasMore = true
+				results[i].Continue = encodeListToken(op.Path, pages[i].offset+len(section.entries))
+			}
+		}
+	}
+	return results
+}
+
+// listPage is the range of directory entries a list operation returns
+type listPage struct {
+	offset int
+	limit  int
+}
+
+// listPage resolves a list operation's continuation token or offset and its
+// limit, capped at the manager's maximum. Stat operations ignore both.
+func (m *Manager) listPage(op types.FileOperation) (listPage, error) {
+	if op.Operation != "list" {
+		return listPage{}, nil
+	}
+
+	page := listPage{offset: op.Offset, limit: m.maxListEntries}
+	if op.Continue != "" {
+		offset, err := decodeListToken(op.Path, op.Continue)
+		if err != nil {
+			return listPage{}, err
+		}
+		page.offset = offset
+	}
+	if page.offset < 0 {
+		return listPage{}, fmt.Errorf("invalid offset %d", page.offset)
+	}
+	if op.Limit > 0 && op.Limit < page.limit {
+		page.limit = op.Limit
+	}
+	return page, nil
+}
+
+// encodeListToken returns the continuation token for the listing of dir
+// starting at offset. Tokens are opaque to clients and only valid for the
+// directory they were issued for.
+func encodeListToken(dir string, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset) + "\x00" + dir))
+}
+
+// decodeListToken returns the offset a continuation token for dir resumes at
+func decodeListToken(dir, token string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.New("invalid continuation token")
+	}
+	offset, tokenDir, ok := strings.Cut(string(decoded), "\x00")
+	if !ok || tokenDir != dir {
+		return 0, fmt.Errorf("continuation token is not for %s", dir)
+	}
+	value, err := strconv.Atoi(offset)
+	if err != nil || value < 0 {
+		return 0, errors.New("invalid continuation token")
+	}
+	return value, nil
+}
+
+// statSection is the output of statScript for one operation
+type statSection struct {
+	entries []types.FileInfo
+	err     string
+	hasMore bool
+}
+
+// parseStatOutput splits statScript output into per-operation sections
+func parseStatOutput(output string) []statSection {
+	var sections []statSection
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case line == "=":
+			sections = append(sections, statSection{entries: []types.FileInfo{}})
+		case len(sections) == 0 || line == "":
+			continue
+		case strings.HasPrefix(line, "!"):
+			sections[len(sections)-1].err = line[1:]
+		case line == "+":
+			sections[len(sections)-1].hasMore = true
+		case strings.HasPrefix(line, "@"):
+			current := &sections[len(sections)-1]
+			if n := len(current.entries); n > 0 {
+				parseLinkLine(&current.entries[n-1], line[1:])
+			}
+		default:
+			if info, ok := parseStatLine(line); ok {
+				current := &sections[len(sections)-1]
+				current.entries = append(current.entries, info)
+			}
+		}
+	}
+	return sections
+}
+
+// parseStatLine parses a statFormat record
+func parseStatLine(line string) (types.FileInfo, bool) {
+	fields := strings.SplitN(line, "\t", 6)
+	if len(fields) != 6 {
+		return types.FileInfo{}, false
+	}
+
+	mode, err := strconv.ParseInt(fields[1], 8, 64)
+	if err != nil {
+		return types.FileInfo{}, false
+	}
+	size, _ := strconv.ParseInt(fields[3], 10, 64)
+	mtime, _ := strconv.ParseInt(fields[4], 10, 64)
+
+	return types.FileInfo{
+		Name:         path.Base(fields[5]),
+		Type:         fileType(fields[0]),
+		Mode:         formatMode(mode),
+		ModeSymbolic: fields[2],
+		Size:         size,
+		ModTime:      time.Unix(mtime, 0).UTC(),
+	}, true
+}
+
+// parseLinkLine records a symlink's "type\ttarget" line on its entry. The
+// type is what the link resolves to, so a link to a directory can be browsed.
+func parseLinkLine(info *types.FileInfo, line string) {
+	if info.Type != types.FileTypeSymlink {
+		return
+	}
+	targetType, target, _ := strings.Cut(line, "\t")
+	info.LinkTarget = target
+	if targetType == "broken" {
+		info.Broken = true
+		return
+	}
+	info.TargetType = targetType
+}
+
+// fileType maps stat's %F description to a file type
+func fileType(description string) string {
+	switch description {
+	case "regular file", "regular empty file":
+		return types.FileTypeFile
+	case "directory":
+		return types.FileTypeDirectory
+	case "symbolic link":
+		return types.FileTypeSymlink
+	default:
+		return types.FileTypeOther
+	}
+}
+
+// fileOpSet counts the batches running on a tunnel
+type fileOpSet struct {
+	mutex   sync.Mutex
+	running int
+	limit   int // most batches running at once, 0 for no limit
+}
+
+// start claims a slot for a batch, reporting false if none is free
+func (s *fileOpSet) start() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.limit > 0 && s.running >= s.limit {
+		return false
+	}
+	s.running++
+	return true
+}
+
+// done frees a finished batch's slot
+func (s *fileOpSet) done() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.running--
+}
+
+// handleBatchRequest runs several file operations from one message, returning
+// their results in request order. List and stat operations share one exec.
+// The batch runs off the message loop, so other messages are handled while
+// it waits on the pod; a tunnel runs at most its batch limit at once.
+func (m *Manager) handleBatchRequest(tunnel *Tunnel, payload interface{}) {
+	var req types.FileBatchRequest
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid batch request format")
+		return
+	}
+
+	if len(req.Operations) > m.maxBatchOperations {
+		m.sendError(tunnel, fmt.Sprintf("Batch has %d operations, the limit is %d",
+			len(req.Operations), m.maxBatchOperations))
+		return
+	}
+
+	if !tunnel.fileOps.start() {
+		m.sendError(tunnel, fmt.Sprintf("Too many file operations running, at most %d", tunnel.fileOps.limit))
+		return
+	}
+	go func() {
+		defer tunnel.fileOps.done()
+		defer m.recoverHandler(tunnel, "batch")
+
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type: "batch_response",
+			Payload: types.FileBatchResponse{
+				BatchID: req.BatchID,
+				Results: m.executeBatch(tunnel, req.Operations),
+			},
+		})
+	}()
+}
+
+// executeBatch runs the operations, grouping list and stat into a single exec
+func (m *Manager) executeBatch(tunnel *Tunnel, ops []types.FileOperation) []*types.FileOperationResponse {
+	results := make([]*types.FileOperationResponse, len(ops))
+
+	var statOps []types.FileOperation
+	var statIndexes []int
+	for i, op := range ops {
+		if op.Operation == "list" || op.Operation == "stat" {
+			statOps = append(statOps, op)
+			statIndexes = append(statIndexes, i)
+			continue
+		}
+		results[i], _ = m.executeFileOperation(tunnel, op)
+	}
+
+	if len(statOps) > 0 {
+		// The shared exec gets one operation's time limit
+		ctx, cancel := context.WithTimeout(tunnel.ctx, m.fileOperationTimeout)
+		defer cancel()
+		for j, result := range m.statFiles(ctx, tunnel, statOps) {
+			results[statIndexes[j]] = result
+		}
+	}
+	return results
+}
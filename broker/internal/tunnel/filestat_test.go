@@ -0,0 +1,364 @@
+package tunnel
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// localExec runs exec commands on the test host, so the stat script is
+// exercised against a real shell and stat
+func localExec(t *testing.T) *fakeK8sClient {
+	if _, err := exec.LookPath("stat"); err != nil {
+		t.Skip("stat not available")
+	}
+	return &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+			cmd.Stdout = opts.Stdout
+			cmd.Stderr = opts.Stderr
+			return cmd.Run()
+		},
+	}
+}
+
+func TestManager_ExecuteBatch(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".hidden"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("data.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(localExec(t), ManagerConfig{})
+	results := manager.executeBatch(testTunnel(), []types.FileOperation{
+		{Operation: "list", Path: dir},
+		{Operation: "stat", Path: filepath.Join(dir, "data.txt")},
+		{Operation: "stat", Path: filepath.Join(dir, "missing")},
+		{Operation: "list", Path: filepath.Join(dir, "sub dir")},
+		{Operation: "delete", Path: dir},
+	})
+
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+
+	listing := map[string]types.FileInfo{}
+	for _, entry := range results[0].Entries {
+		listing[entry.Name] = entry
+	}
+	if !results[0].Success || len(listing) != 4 {
+		t.Fatalf("Expected 4 entries, got %+v", results[0])
+	}
+	if listing["sub dir"].Type != types.FileTypeDirectory || listing["link"].Type != types.FileTypeSymlink {
+		t.Fatalf("Expected directory and symlink types, got %+v", listing)
+	}
+	if _, ok := listing[".hidden"]; !ok {
+		t.Fatalf("Expected hidden files to be listed, got %+v", listing)
+	}
+
+	stat := results[1].Stat
+	if stat == nil || stat.Name != "data.txt" || stat.Size != 5 || stat.Mode != "0640" || stat.Type != types.FileTypeFile {
+		t.Fatalf("Expected stat of data.txt, got %+v", results[1])
+	}
+
+	if results[2].Success || results[2].Error == "" {
+		t.Fatalf("Expected missing file to fail, got %+v", results[2])
+	}
+
+	if !results[3].Success || len(results[3].Entries) != 0 {
+		t.Fatalf("Expected empty listing, got %+v", results[3])
+	}
+
+	if results[4].Success {
+		t.Fatalf("Expected unsupported operation to fail, got %+v", results[4])
+	}
+}
+
+func TestManager_ExecuteBatch_SingleExec(t *testing.T) {
+	execs := 0
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			execs++
+			return nil
+		},
+	}
+
+	manager := NewManager(client, ManagerConfig{})
+	results := manager.executeBatch(testTunnel(), []types.FileOperation{
+		{Operation: "list", Path: "/a"},
+		{Operation: "stat", Path: "/b"},
+		{Operation: "list", Path: "/c"},
+	})
+
+	if execs != 1 {
+		t.Fatalf("Expected one exec for the batch, got %d", execs)
+	}
+	for i, result := range results {
+		if result == nil || result.Success {
+			t.Fatalf("Expected result %d to report missing output, got %+v", i, result)
+		}
+	}
+}
+
+func TestParseStatOutput(t *testing.T) {
+	output := "=\nregular file\t644\t-rw-r--r--\t12\t1700000000\t/home/a.txt\ndirectory\t755\tdrwxr-xr-x\t4096\t1700000000\t/home/b\n+\n=\n!Not a directory\n=\n" +
+		"=\nsymbolic link\t777\tlrwxrwxrwx\t7\t1700000000\t/home/env\n@directory\t/opt/env\nsymbolic link\t777\tlrwxrwxrwx\t4\t1700000000\t/home/old\n@broken\tgone\n"
+
+	sections := parseStatOutput(output)
+	if len(sections) != 4 {
+		t.Fatalf("Expected 4 sections, got %d", len(sections))
+	}
+	if len(sections[0].entries) != 2 || sections[0].entries[0].Name != "a.txt" || sections[0].entries[0].Mode != "0644" {
+		t.Fatalf("Expected two entries, got %+v", sections[0])
+	}
+	if mode := sections[0].entries[1].ModeSymbolic; mode != "drwxr-xr-x" {
+		t.Errorf("Expected symbolic mode drwxr-xr-x, got %q", mode)
+	}
+	if !sections[0].hasMore || sections[2].hasMore {
+		t.Fatalf("Expected only the first section to have more entries, got %+v", sections)
+	}
+	if sections[1].err != "Not a directory" {
+		t.Fatalf("Expected error, got %+v", sections[1])
+	}
+	if sections[2].err != "" || len(sections[2].entries) != 0 {
+		t.Fatalf("Expected empty section, got %+v", sections[2])
+	}
+	links := sections[3].entries
+	if len(links) != 2 || links[0].LinkTarget != "/opt/env" || links[0].TargetType != types.FileTypeDirectory {
+		t.Fatalf("Expected a symlink to a directory, got %+v", links)
+	}
+	if !links[1].Broken || links[1].LinkTarget != "gone" {
+		t.Fatalf("Expected a broken symlink, got %+v", links[1])
+	}
+}
+
+func TestManager_ListPagination(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	manager := NewManager(localExec(t), ManagerConfig{MaxListEntries: 3})
+	tunnel := testTunnel()
+
+	var names []string
+	op := types.FileOperation{Operation: "list", Path: dir, Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatalf("Expected listing to end after 3 pages, got %v", names)
+		}
+		result := manager.executeBatch(tunnel, []types.FileOperation{op})[0]
+		if !result.Success {
+			t.Fatalf("Expected page to succeed, got %+v", result)
+		}
+		for _, entry := range result.Entries {
+			names = append(names, entry.Name)
+		}
+		if !result.HasMore {
+			break
+		}
+		op.Continue = result.Continue
+	}
+	if strings.Join(names, ",") != "a,b,c,d,e" {
+		t.Fatalf("Expected every entry once in order, got %v", names)
+	}
+
+	// Limits above the maximum are capped, and offsets are accepted directly
+	result := manager.executeBatch(tunnel, []types.FileOperation{{Operation: "list", Path: dir, Offset: 1, Limit: 10}})[0]
+	if len(result.Entries) != 3 || result.Entries[0].Name != "b" || !result.HasMore {
+		t.Fatalf("Expected a capped page from b, got %+v", result)
+	}
+
+	// A token only resumes the listing it was issued for
+	result = manager.executeBatch(tunnel, []types.FileOperation{{Operation: "list", Path: "/tmp", Continue: result.Continue}})[0]
+	if result.Success {
+		t.Fatalf("Expected a token for another directory to be rejected, got %+v", result)
+	}
+}
+
+func TestManager_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "envs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(localExec(t), ManagerConfig{})
+	tunnel := testTunnel()
+	results := manager.executeBatch(tunnel, []types.FileOperation{
+		{Operation: "symlink", Path: filepath.Join(dir, "file-link"), Target: "data.txt"},
+		{Operation: "symlink", Path: filepath.Join(dir, "dir-link"), Target: filepath.Join(dir, "envs")},
+		{Operation: "symlink", Path: filepath.Join(dir, "broken-link"), Target: "missing"},
+		{Operation: "symlink", Path: filepath.Join(dir, "data.txt"), Target: "envs"},
+		{Operation: "symlink", Path: filepath.Join(dir, "no-target")},
+	})
+	for i, result := range results[:3] {
+		if !result.Success {
+			t.Fatalf("Expected symlink %d to be created, got %+v", i, result)
+		}
+	}
+	if results[3].Success || !strings.Contains(results[3].Error, "data.txt") {
+		t.Errorf("Expected an existing file not to be replaced, got %+v", results[3])
+	}
+	if results[4].Success {
+		t.Errorf("Expected a symlink without a target to be refused, got %+v", results[4])
+	}
+
+	results = manager.executeBatch(tunnel, []types.FileOperation{
+		{Operation: "list", Path: dir},
+		{Operation: "stat", Path: filepath.Join(dir, "dir-link")},
+	})
+	listing := map[string]types.FileInfo{}
+	for _, entry := range results[0].Entries {
+		listing[entry.Name] = entry
+	}
+	if link := listing["file-link"]; link.Type != types.FileTypeSymlink || link.LinkTarget != "data.txt" || link.TargetType != types.FileTypeFile {
+		t.Errorf("Expected a symlink to a file, got %+v", link)
+	}
+	if link := listing["broken-link"]; !link.Broken || link.TargetType != "" || link.LinkTarget != "missing" {
+		t.Errorf("Expected a broken symlink, got %+v", link)
+	}
+	if entry := listing["data.txt"]; entry.LinkTarget != "" || entry.Broken {
+		t.Errorf("Expected no link details for a regular file, got %+v", entry)
+	}
+	if stat := results[1].Stat; stat == nil || stat.TargetType != types.FileTypeDirectory || stat.LinkTarget != filepath.Join(dir, "envs") {
+		t.Errorf("Expected stat of a symlink to a directory, got %+v", results[1])
+	}
+}
+
+func TestManager_SymlinkValidation(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "envs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	manager := NewManager(localExec(t), ManagerConfig{PermissionsRoot: root})
+	tunnel := testTunnel()
+
+	tests := []struct {
+		name    string
+		path    string
+		target  string
+		wantErr string
+	}{
+		{name: "relative path", path: "link", target: "envs", wantErr: "must be absolute"},
+		{name: "NUL in path", path: filepath.Join(root, "li\x00nk"), target: "envs", wantErr: "NUL"},
+		{name: "NUL in target", path: filepath.Join(root, "link"), target: "en\x00vs", wantErr: "NUL"},
+		{name: "path outside root", path: filepath.Join(outside, "link"), target: filepath.Join(root, "envs"), wantErr: "outside"},
+		{name: "absolute target outside root", path: filepath.Join(root, "link"), target: outside, wantErr: "outside"},
+		{name: "relative target escaping root", path: filepath.Join(root, "link"), target: "../../etc/passwd", wantErr: "outside"},
+		{name: "existing directory", path: filepath.Join(root, "envs"), target: "envs", wantErr: "envs"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := manager.createSymlink(context.Background(), tunnel, types.FileOperation{Operation: "symlink", Path: tt.path, Target: tt.target})
+			if resp.Success || !strings.Contains(resp.Error, tt.wantErr) {
+				t.Fatalf("Expected error containing %q, got %+v", tt.wantErr, resp)
+			}
+		})
+	}
+
+	// ln -T refuses to create the link inside the existing directory
+	if _, err := os.Lstat(filepath.Join(root, "envs", "envs")); !os.IsNotExist(err) {
+		t.Errorf("Expected no link inside the directory, got %v", err)
+	}
+
+	resp := manager.createSymlink(context.Background(), tunnel, types.FileOperation{Operation: "symlink", Path: filepath.Join(root, "link"), Target: "envs"})
+	if !resp.Success {
+		t.Fatalf("Expected a link within the root to be created, got %+v", resp)
+	}
+}
+
+func TestManager_BatchRunsOffMessageLoop(t *testing.T) {
+	release := make(chan struct{})
+	client := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		if strings.Contains(strings.Join(opts.Command, " "), statFormat) {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(client, ManagerConfig{MaxConcurrentFileOps: 2})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	for _, id := range []string{"first", "second", "third"} {
+		conn.WriteJSON(types.TunnelMessage{
+			Type:    "batch",
+			Payload: types.FileBatchRequest{BatchID: id, Operations: []types.FileOperation{{Operation: "stat", Path: "/home/alice"}}},
+		})
+	}
+
+	// The third batch is over the limit, and the loop still answers while
+	// the others wait on the pod
+	if payload := readMessageOfType(t, conn, "error"); !strings.Contains(payload["error"].(string), "Too many file operations") {
+		t.Errorf("Expected the third batch to be refused, got %v", payload)
+	}
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+	readMessageOfType(t, conn, "exec_list_response")
+
+	close(release)
+	finished := map[interface{}]bool{}
+	for len(finished) < 2 {
+		finished[readMessageOfType(t, conn, "batch_response")["batch_id"]] = true
+	}
+	if !finished["first"] || !finished["second"] {
+		t.Errorf("Expected both running batches to answer with their IDs, got %v", finished)
+	}
+}
+
+func TestManager_FileOperationRunsOffMessageLoop(t *testing.T) {
+	client := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		if strings.Contains(strings.Join(opts.Command, " "), statFormat) {
+			// A hung pod: only the operation's timeout ends it
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(client, ManagerConfig{FileOperationTimeout: 100 * time.Millisecond})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "file",
+		Payload: types.FileOperation{RequestID: "stat-1", Operation: "stat", Path: "/home/alice"},
+	})
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+
+	// The loop answers while the operation waits on the pod
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var first types.TunnelMessage
+	if err := conn.ReadJSON(&first); err != nil || first.Type != "exec_list_response" {
+		t.Fatalf("Expected exec_list_response before the file operation finished, got %s %v", first.Type, err)
+	}
+
+	payload := readMessageOfType(t, conn, "file_response")
+	if payload["request_id"] != "stat-1" || payload["success"] != false {
+		t.Errorf("Expected the timed out operation to fail with its request ID, got %v", payload)
+	}
+}
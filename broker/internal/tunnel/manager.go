@@ -1,13 +1,22 @@
 package tunnel
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+	"github.com/purdue-af/vscode-k8s-connector/internal/tracing"
 	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
@@ -18,100 +27,817 @@ type ManagerInterface interface {
 
 	// CloseTunnel closes a tunnel for a session
 	CloseTunnel(sessionID string) error
+
+	// Stats returns tunnel usage statistics
+	Stats() Stats
 }
 
+// WebSocket close codes sent to clients, in the private-use range
+const (
+	// CloseCapacity is sent when the broker is at its tunnel limit
+	CloseCapacity = 4001
+
+	// CloseWriteTimeout is sent when a write to the client times out
+	CloseWriteTimeout = 4002
+
+	// CloseSetupTimeout is sent when issuing k8s credentials takes too long
+	CloseSetupTimeout = 4003
+
+	// CloseSessionBusy is sent when the session already has a tunnel and
+	// duplicates are rejected
+	CloseSessionBusy = 4004
+
+	// CloseReplaced is sent on a tunnel closed because its session reconnected
+	CloseReplaced = 4005
+
+	// CloseSessionClosed is sent on a tunnel closed because its session was
+	// deleted
+	CloseSessionClosed = 4006
+
+	// CloseMaxLifetime is sent on a tunnel closed because its credentials
+	// reached the maximum tunnel lifetime
+	CloseMaxLifetime = 4007
+
+	// ClosePodReassigned is sent when the session's pod no longer belongs to
+	// its user
+	ClosePodReassigned = 4008
+
+	// CloseDraining is sent when the broker is shutting down, on open tunnels
+	// and on new ones refused while draining
+	CloseDraining = 4009
+)
+
+// Policies for a second tunnel opened for a session that already has one
+const (
+	// DuplicateTunnelReplace closes the existing tunnel, e.g. a dead connection
+	// the client reconnected from before it was detected
+	DuplicateTunnelReplace = "replace"
+
+	// DuplicateTunnelReject refuses the new connection
+	DuplicateTunnelReject = "reject"
+)
+
+// DefaultWriteTimeout bounds a single WebSocket write; it leaves room for large
+// frames on slow links while still detecting dead connections
+const DefaultWriteTimeout = 30 * time.Second
+
+// DefaultSetupTimeout bounds issuing k8s credentials after the upgrade
+const DefaultSetupTimeout = 30 * time.Second
+
+// DefaultHandshakeTimeout bounds completing the WebSocket upgrade
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// DefaultMaxTunnelLifetime bounds how long one set of k8s credentials serves
+// tunnels, however active they are
+const DefaultMaxTunnelLifetime = 12 * time.Hour
+
+// releaseTimeout bounds releasing a tunnel's k8s credentials once it ends
+const releaseTimeout = 30 * time.Second
+
+// pingPodTimeout bounds the pod lookup answering a ping
+const pingPodTimeout = 5 * time.Second
+
 // Manager implements the tunnel.ManagerInterface interface
 type Manager struct {
-	k8sClient k8s.ClientInterface
-	upgrader  websocket.Upgrader
-	tunnels   map[string]*Tunnel
-	mutex     sync.RWMutex
+	k8sClient    k8s.ClientInterface
+	upgrader     websocket.Upgrader
+	tunnels      map[string]*Tunnel
+	mutex        sync.RWMutex
+	maxTunnels   int
+	active       int
+	drain        drainState
+	writeTimeout time.Duration
+	setupTimeout time.Duration
+
+	reconnectBackoff time.Duration
+
+	duplicateTunnels string
+
+	execPrelude    string
+	execShell      string
+	execLoginShell bool
+
+	sanitizeExecOutput bool
+
+	execResourceWrappers [][]string
+
+	compressionLevel     int
+	compressionThreshold int
+
+	fileCompressionThreshold int
+	maxBatchOperations       int
+	maxFileSize              int64
+	maxConcurrentFileOps     int
+	fileOperationTimeout     time.Duration
+	maxListEntries           int
+	workspaceRoot            string
+	permissionsRoot          string
+	allowChown               bool
+	processColumns           []string
+
+	execFlushInterval    time.Duration
+	execTTYFlushInterval time.Duration
+	execFlushBufferSize  int
+	execTTYWeight        int
+	execMaxOutputBytes   int
+	maxExecStreams       int
+
+	execOutputQueueLength int
+	execSlowReaderTimeout time.Duration
+
+	capabilityCache capabilityCache
+
+	tokenRenewer         TokenRenewer
+	tokenRenewalInterval time.Duration
+
+	instanceURL  string
+	tunnelOwners TunnelOwners
+
+	releaseGrace        time.Duration
+	releases            releaseSet
+	disableResumeTokens bool
+
+	maxTunnelLifetime time.Duration
+
+	logMessageTypes bool
+
+	namespaceRelays namespaceRelays
+
+	runAsMechanism string
+
+	allowedCommands map[string]bool
+}
+
+// ManagerConfig represents tunnel manager configuration
+type ManagerConfig struct {
+	// MaxTotalTunnels caps concurrent tunnels across all users, 0 for no limit
+	MaxTotalTunnels int
+
+	// WriteTimeout bounds each write to the client, DefaultWriteTimeout if zero.
+	// A write that times out tears down the tunnel.
+	WriteTimeout time.Duration
+
+	// ReconnectBackoff is the delay close frames suggest before reconnecting
+	// after a transient close, DefaultReconnectBackoff if zero
+	ReconnectBackoff time.Duration
+
+	// SetupTimeout bounds issuing k8s credentials for a new tunnel,
+	// DefaultSetupTimeout if zero
+	SetupTimeout time.Duration
+
+	// HandshakeTimeout bounds completing the WebSocket upgrade, so a client
+	// stalling it cannot hold the connection, DefaultHandshakeTimeout if zero
+	HandshakeTimeout time.Duration
+
+	// DuplicateTunnels is DuplicateTunnelReplace (the default) or
+	// DuplicateTunnelReject
+	DuplicateTunnels string
+
+	// ExecPrelude is a shell script run before non-TTY exec commands, such as
+	// sourcing a profile or activating a conda environment
+	ExecPrelude string
+
+	// ExecShell runs the prelude and TTY shells, DefaultExecShell if empty
+	ExecShell string
+
+	// ExecLoginShell runs TTY shells as login shells
+	ExecLoginShell bool
+
+	// SanitizeExecOutput makes non-TTY, non-binary exec output valid UTF-8 and
+	// strips stray control characters
+	SanitizeExecOutput bool
+
+	// ExecResourceWrappers are commands, such as "nice -n 10" or
+	// "ionice -c 3", chained in front of exec commands so they cannot starve
+	// the notebook. Each is used in pods where its first word is installed.
+	ExecResourceWrappers []string
+
+	// DisableCompression stops negotiating permessage-deflate with clients
+	DisableCompression bool
+
+	// CompressionLevel is the flate level, 1 to 9, of messages compressed
+	// with permessage-deflate, DefaultCompressionLevel if zero.
+	// CompressionThreshold is the smallest message compressed,
+	// DefaultCompressionThreshold if zero.
+	CompressionLevel     int
+	CompressionThreshold int
+
+	// FileCompressionThreshold is the smallest file read gzipped on request,
+	// DefaultFileCompressionThreshold if zero
+	FileCompressionThreshold int
+
+	// MaxFileSize bounds the files read or written in one file operation, in
+	// bytes, DefaultMaxFileSize if zero
+	MaxFileSize int64
+
+	// MaxBatchOperations bounds the operations in a batch message,
+	// DefaultMaxBatchOperations if zero
+	MaxBatchOperations int
+
+	// MaxConcurrentFileOps bounds the file and batch messages running at once
+	// on a tunnel, DefaultMaxConcurrentFileOps if zero
+	MaxConcurrentFileOps int
+
+	// FileOperationTimeout bounds each file operation, including one in a
+	// batch, DefaultFileOperationTimeout if zero
+	FileOperationTimeout time.Duration
+
+	// MaxListEntries bounds the entries in one page of a list operation,
+	// DefaultMaxListEntries if zero
+	MaxListEntries int
+
+	// ExecFlushInterval and ExecTTYFlushInterval bound how long streamed exec
+	// output is held before an exec_output message is sent, for bulk and
+	// TTY execs; ExecFlushBufferSize is the most output held. Defaults apply
+	// when zero.
+	ExecFlushInterval    time.Duration
+	ExecTTYFlushInterval time.Duration
+	ExecFlushBufferSize  int
+
+	// ExecMaxOutputBytes bounds each of stdout and stderr collected for a
+	// non-streamed exec, DefaultExecMaxOutputBytes if zero. A command writing
+	// more is stopped.
+	ExecMaxOutputBytes int
+
+	// ExecTTYWeight is how many exec_output messages a TTY stream may send
+	// for each one of a bulk stream, DefaultExecTTYWeight if zero
+	ExecTTYWeight int
+
+	// ExecOutputQueueLength is how many exec_output messages a stream may
+	// have waiting to be sent before its command is slowed down, and
+	// ExecSlowReaderTimeout how long it may stay slowed down before it is
+	// cancelled with reason slow_reader. Defaults apply when zero.
+	ExecOutputQueueLength int
+	ExecSlowReaderTimeout time.Duration
+
+	// MaxExecStreams caps the exec streams running at once on a tunnel, 0 for
+	// no limit
+	MaxExecStreams int
+
+	// WorkspaceRoot is the project directory reported by workspace_info,
+	// such as /home/jovyan/work
+	WorkspaceRoot string
+
+	// PermissionsRoot confines chmod, chown and symlink to a directory in the pod,
+	// WorkspaceRoot if empty; with neither, any absolute path is allowed
+	PermissionsRoot string
+
+	// AllowChown enables the chown file operation, which usually needs the
+	// container to run as root
+	AllowChown bool
+
+	// ProcessColumns are the extra ps columns reported by processlist,
+	// DefaultProcessColumns if empty
+	ProcessColumns []string
+
+	// TokenRenewer, with a positive TokenRenewalInterval, renews the session
+	// token while the tunnel is in use and sends it to the client in a
+	// token_renewed message
+	TokenRenewer         TokenRenewer
+	TokenRenewalInterval time.Duration
+
+	// InstanceURL is this broker's address for other replicas. With
+	// TunnelOwners it is recorded as the owner of each tunnel opened here.
+	InstanceURL  string
+	TunnelOwners TunnelOwners
+
+	// CredentialReleaseGrace keeps a closed tunnel's k8s credentials this long
+	// so a reconnect of the same session reuses them instead of issuing new
+	// ones; 0 releases them immediately
+	CredentialReleaseGrace time.Duration
+
+	// DisableResumeTokens lets any reconnect of a session reuse its kept
+	// credentials. Otherwise only a reconnect presenting the single-use resume
+	// token from the closed tunnel's ready message in ResumeTokenHeader does.
+	DisableResumeTokens bool
+
+	// MaxTunnelLifetime bounds how long a tunnel's k8s credentials are used,
+	// counted from when they were issued so reconnects reusing them count
+	// too, DefaultMaxTunnelLifetime if zero. A tunnel reaching it is closed
+	// with CloseMaxLifetime and its session token is no longer renewed.
+	MaxTunnelLifetime time.Duration
+
+	// LogMessageTypes logs the type and stream ID of every message sent and
+	// received, never its payload, for debugging client issues
+	LogMessageTypes bool
+
+	// MaxNamespaceRelays caps the reverse port forward relays open at once in
+	// a namespace, across all sessions' tunnels; 0 for no limit. Rejected
+	// relays get an error with code namespace_limit.
+	MaxNamespaceRelays int
+
+	// RunAsMechanism is the tool, RunAsRunuser, RunAsSu or RunAsSetpriv, used
+	// to run exec requests with run_as_user as that user. Such requests are
+	// refused if empty.
+	RunAsMechanism string
+
+	// AllowedCommands, if set, are the only commands exec and process_kill
+	// requests may run, matched exactly. Shell scripts and interactive shells
+	// need ExecShell listed.
+	AllowedCommands []string
+}
+
+// TokenRenewer issues fresh session tokens, implemented by session.Store
+type TokenRenewer interface {
+	RenewToken(ctx context.Context, sessionID string) (*types.Session, error)
+}
+
+// Stats represents tunnel usage statistics
+type Stats struct {
+	ActiveTunnels int  `json:"active_tunnels"`
+	MaxTunnels    int  `json:"max_tunnels"`
+	Draining      bool `json:"draining,omitempty"`
 }
 
 // Tunnel represents an active WebSocket tunnel
 type Tunnel struct {
-	ID       string
-	Session  *types.Session
-	Conn     *websocket.Conn
-	K8sToken string
-	Done     chan struct{}
-	mutex    sync.RWMutex
+	ID             string
+	Session        *types.Session
+	Conn           *websocket.Conn
+	K8sToken       string
+	K8sCredentials *k8s.SessionCredentials
+	Done           chan struct{}
+	mutex          sync.RWMutex
+
+	// credsMutex guards K8sCredentials and K8sToken, which are replaced
+	// whenever the token is re-minted
+	credsMutex sync.RWMutex
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	relays  relaySet
+	execs   execSet
+	fileOps fileOpSet
+	output  outputQueue
+
+	// tokenRenewedAt is only accessed by the message loop
+	tokenRenewedAt time.Time
+
+	// credentialsIssuedAt is when K8sCredentials were issued, which may be
+	// before the tunnel opened if they were reused
+	credentialsIssuedAt time.Time
+
+	// execPrefix wraps exec commands with the resource wrappers available in
+	// the pod; it is set up before the message loop starts
+	execPrefix []string
+
+	// resumeToken lets one reconnect reuse the tunnel's credentials once it
+	// has closed
+	resumeToken string
 }
 
 // NewManager creates a new tunnel manager
-func NewManager(k8sClient k8s.ClientInterface) *Manager {
+func NewManager(k8sClient k8s.ClientInterface, config ManagerConfig) *Manager {
+	metrics.MaxTunnels.Set(float64(config.MaxTotalTunnels))
+
+	writeTimeout := config.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
+
+	reconnectBackoff := config.ReconnectBackoff
+	if reconnectBackoff <= 0 {
+		reconnectBackoff = DefaultReconnectBackoff
+	}
+
+	setupTimeout := config.SetupTimeout
+	if setupTimeout <= 0 {
+		setupTimeout = DefaultSetupTimeout
+	}
+
+	handshakeTimeout := config.HandshakeTimeout
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = DefaultHandshakeTimeout
+	}
+
+	duplicateTunnels := config.DuplicateTunnels
+	if duplicateTunnels == "" {
+		duplicateTunnels = DuplicateTunnelReplace
+	}
+
+	execShell := config.ExecShell
+	if execShell == "" {
+		execShell = DefaultExecShell
+	}
+
+	compressionLevel := config.CompressionLevel
+	if ValidateCompressionLevel(compressionLevel) != nil {
+		compressionLevel = DefaultCompressionLevel
+	}
+	compressionThreshold := config.CompressionThreshold
+	if compressionThreshold <= 0 {
+		compressionThreshold = DefaultCompressionThreshold
+	}
+
+	fileCompressionThreshold := config.FileCompressionThreshold
+	if fileCompressionThreshold <= 0 {
+		fileCompressionThreshold = DefaultFileCompressionThreshold
+	}
+
+	maxFileSize := config.MaxFileSize
+	if maxFileSize <= 0 {
+		maxFileSize = DefaultMaxFileSize
+	}
+
+	maxBatchOperations := config.MaxBatchOperations
+	if maxBatchOperations <= 0 {
+		maxBatchOperations = DefaultMaxBatchOperations
+	}
+
+	maxConcurrentFileOps := config.MaxConcurrentFileOps
+	if maxConcurrentFileOps <= 0 {
+		maxConcurrentFileOps = DefaultMaxConcurrentFileOps
+	}
+
+	fileOperationTimeout := config.FileOperationTimeout
+	if fileOperationTimeout <= 0 {
+		fileOperationTimeout = DefaultFileOperationTimeout
+	}
+
+	maxListEntries := config.MaxListEntries
+	if maxListEntries <= 0 {
+		maxListEntries = DefaultMaxListEntries
+	}
+
+	execFlushInterval := config.ExecFlushInterval
+	if execFlushInterval <= 0 {
+		execFlushInterval = DefaultExecFlushInterval
+	}
+	execTTYFlushInterval := config.ExecTTYFlushInterval
+	if execTTYFlushInterval <= 0 {
+		execTTYFlushInterval = DefaultExecTTYFlushInterval
+	}
+	execFlushBufferSize := config.ExecFlushBufferSize
+	if execFlushBufferSize <= 0 {
+		execFlushBufferSize = DefaultExecFlushBufferSize
+	}
+
+	execMaxOutputBytes := config.ExecMaxOutputBytes
+	if execMaxOutputBytes <= 0 {
+		execMaxOutputBytes = DefaultExecMaxOutputBytes
+	}
+
+	execTTYWeight := config.ExecTTYWeight
+	if execTTYWeight <= 0 {
+		execTTYWeight = DefaultExecTTYWeight
+	}
+	execOutputQueueLength := config.ExecOutputQueueLength
+	if execOutputQueueLength <= 0 {
+		execOutputQueueLength = DefaultExecOutputQueueLength
+	}
+	execSlowReaderTimeout := config.ExecSlowReaderTimeout
+	if execSlowReaderTimeout <= 0 {
+		execSlowReaderTimeout = DefaultExecSlowReaderTimeout
+	}
+
+	permissionsRootDir := config.PermissionsRoot
+	if permissionsRootDir == "" {
+		permissionsRootDir = config.WorkspaceRoot
+	}
+
+	processColumns := config.ProcessColumns
+	if len(processColumns) == 0 {
+		processColumns = DefaultProcessColumns
+	}
+
+	maxTunnelLifetime := config.MaxTunnelLifetime
+	if maxTunnelLifetime <= 0 {
+		maxTunnelLifetime = DefaultMaxTunnelLifetime
+	}
+
 	return &Manager{
 		k8sClient: k8sClient,
 		upgrader: websocket.Upgrader{
+			HandshakeTimeout:  handshakeTimeout,
+			EnableCompression: !config.DisableCompression,
 			CheckOrigin: func(r *http.Request) bool {
 				return true // In production, validate origin
 			},
 		},
-		tunnels: make(map[string]*Tunnel),
+		tunnels:      make(map[string]*Tunnel),
+		maxTunnels:   config.MaxTotalTunnels,
+		writeTimeout: writeTimeout,
+		setupTimeout: setupTimeout,
+
+		reconnectBackoff: reconnectBackoff,
+
+		duplicateTunnels: duplicateTunnels,
+
+		execPrelude:    config.ExecPrelude,
+		execShell:      execShell,
+		execLoginShell: config.ExecLoginShell,
+
+		sanitizeExecOutput: config.SanitizeExecOutput,
+
+		execResourceWrappers: parseResourceWrappers(config.ExecResourceWrappers),
+
+		compressionLevel:     compressionLevel,
+		compressionThreshold: compressionThreshold,
+
+		fileCompressionThreshold: fileCompressionThreshold,
+		maxBatchOperations:       maxBatchOperations,
+		maxFileSize:              maxFileSize,
+		maxConcurrentFileOps:     maxConcurrentFileOps,
+		fileOperationTimeout:     fileOperationTimeout,
+		maxListEntries:           maxListEntries,
+		workspaceRoot:            config.WorkspaceRoot,
+		permissionsRoot:          permissionsRoot(permissionsRootDir),
+		allowChown:               config.AllowChown,
+		processColumns:           processColumns,
+
+		execFlushInterval:    execFlushInterval,
+		execTTYFlushInterval: execTTYFlushInterval,
+		execFlushBufferSize:  execFlushBufferSize,
+		execTTYWeight:        execTTYWeight,
+		execMaxOutputBytes:   execMaxOutputBytes,
+		maxExecStreams:       config.MaxExecStreams,
+
+		execOutputQueueLength: execOutputQueueLength,
+		execSlowReaderTimeout: execSlowReaderTimeout,
+
+		tokenRenewer:         config.TokenRenewer,
+		tokenRenewalInterval: config.TokenRenewalInterval,
+
+		instanceURL:  config.InstanceURL,
+		tunnelOwners: config.TunnelOwners,
+
+		releaseGrace:        config.CredentialReleaseGrace,
+		disableResumeTokens: config.DisableResumeTokens,
+
+		maxTunnelLifetime: maxTunnelLifetime,
+
+		logMessageTypes: config.LogMessageTypes,
+
+		namespaceRelays: namespaceRelays{limit: config.MaxNamespaceRelays},
+
+		runAsMechanism: config.RunAsMechanism,
+
+		allowedCommands: newCommandAllowlist(config.AllowedCommands),
 	}
 }
 
 // HandleConnection handles WebSocket upgrade and tunnel creation
 func (m *Manager) HandleConnection(w http.ResponseWriter, r *http.Request, session *types.Session) {
-	conn, err := m.upgrader.Upgrade(w, r, nil)
+	// A failed upgrade has already been answered and logged
+	conn, err := m.upgrade(w, r, session)
 	if err != nil {
-		http.Error(w, "Failed to upgrade to WebSocket", http.StatusBadRequest)
 		return
 	}
 	defer conn.Close()
 
-	// Create ServiceAccount and get token for this session
-	k8sToken, err := m.k8sClient.CreateSessionServiceAccount(
-		r.Context(), session.PodInfo.Namespace, session.PodInfo.Name)
-	if err != nil {
-		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"error": "Failed to create k8s credentials: %v"}`, err)))
+	// Only used if the client negotiated permessage-deflate; the level was
+	// validated by NewManager
+	conn.SetCompressionLevel(m.compressionLevel)
+
+	if m.isDraining() {
+		metrics.TunnelsRejected.WithLabelValues("draining").Inc()
+		m.closeWithCode(conn, CloseDraining, "draining")
+		return
+	}
+
+	// Refuse early to avoid issuing credentials; registration checks again
+	if m.duplicateTunnels == DuplicateTunnelReject && m.hasTunnel(session.ID) {
+		m.rejectDuplicate(conn, session.ID)
+		return
+	}
+
+	if !m.acquireSlot() {
+		metrics.TunnelsRejected.WithLabelValues("capacity").Inc()
+		m.closeWithCode(conn, CloseCapacity, "capacity")
+		return
+	}
+	// Release the slot on every exit path, including a panic in the tunnel
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Tunnel for session %s panicked: %v", session.ID, r)
+		}
+		m.releaseSlot()
+	}()
+
+	// A pod reassigned since the session was created, such as after its
+	// namespace was reused for another user, must not be reached through it
+	if !m.verifyPodOwner(r, conn, session) {
 		return
 	}
 
+	// Reuse the credentials of the session's last tunnel if it closed within
+	// the release grace period and the client presented its resume token,
+	// otherwise issue new ones. The client partially cleans up after itself
+	// when setup fails, including when the timeout expires.
+	creds, issuedAt := m.reclaimCredentials(session, resumeToken(r))
+	if creds == nil {
+		issuedAt = time.Now()
+		setupCtx, setupCancel := context.WithTimeout(r.Context(), m.setupTimeout)
+		setupCtx, span := tracing.Start(setupCtx, "tunnel.setup",
+			tracing.SessionIDKey.String(session.ID), tracing.UserIDKey.String(session.UserID))
+		creds, err = m.k8sClient.CreateSessionCredentials(
+			setupCtx, session.PodInfo.Namespace, session.PodInfo.Name, session.UserID)
+		// A single slow API call reports ErrTimeout before the setup deadline
+		timedOut := setupCtx.Err() == context.DeadlineExceeded || errors.Is(err, k8s.ErrTimeout)
+		tracing.End(span, err)
+		setupCancel()
+		if err != nil {
+			conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"error": "Failed to create k8s credentials: %v"}`, err)))
+			if timedOut {
+				log.Printf("Tunnel setup for session %s timed out: %v", session.ID, err)
+				metrics.TunnelsRejected.WithLabelValues("setup_timeout").Inc()
+				m.closeWithCode(conn, CloseSetupTimeout, "setup_timeout")
+			}
+			return
+		}
+	}
+
 	// Create tunnel
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	tunnel := &Tunnel{
-		ID:       session.ID,
-		Session:  session,
-		Conn:     conn,
-		K8sToken: k8sToken,
-		Done:     make(chan struct{}),
-	}
+		ID:             session.ID,
+		Session:        session,
+		Conn:           conn,
+		K8sToken:       creds.Token,
+		K8sCredentials: creds,
+		Done:           make(chan struct{}),
+		ctx:            ctx,
+		cancel:         cancel,
+		execs:          execSet{limit: m.maxExecStreams},
+		fileOps:        fileOpSet{limit: m.maxConcurrentFileOps},
+		tokenRenewedAt: time.Now(),
 
-	m.mutex.Lock()
-	m.tunnels[session.ID] = tunnel
-	m.mutex.Unlock()
+		credentialsIssuedAt: issuedAt,
+	}
+	if m.releaseGrace > 0 && !m.disableResumeTokens {
+		tunnel.resumeToken = newResumeToken()
+	}
 
+	// Each connection releases the credentials it was issued, once, whether it
+	// ends normally, is rejected as a duplicate or is replaced. The latest
+	// token is kept for a reconnect to reuse.
 	defer func() {
-		m.mutex.Lock()
-		delete(m.tunnels, session.ID)
-		m.mutex.Unlock()
-
-		// Cleanup ServiceAccount
-		m.k8sClient.DeleteServiceAccount(r.Context(), session.PodInfo.Namespace,
-			fmt.Sprintf("vscode-sess-%s", session.ID[:8]))
+		m.releaseCredentials(r.Context(), session, tunnel.credentials(), issuedAt, tunnel.resumeToken)
 	}()
 
+	if !m.registerTunnel(tunnel) {
+		m.rejectDuplicate(conn, session.ID)
+		return
+	}
+	defer m.unregisterTunnel(tunnel)
+
+	m.claimOwnership(tunnel)
+	defer m.releaseOwnership(tunnel)
+
+	lifetime := time.AfterFunc(time.Until(issuedAt.Add(m.maxTunnelLifetime)), func() {
+		m.expireTunnel(tunnel)
+	})
+	defer lifetime.Stop()
+
+	go m.remintTokens(tunnel)
+
+	log.Printf("Tunnel for session %s (user %s) connected from %s", session.ID, session.UserID, clientIP(r))
+
+	m.sendReady(tunnel)
+	// A drain that started during setup did not reach this tunnel
+	if shutdownAt, draining := m.drainDeadline(); draining {
+		m.sendDraining(tunnel, shutdownAt)
+	}
+
 	// Handle WebSocket messages
 	m.handleTunnelMessages(tunnel)
 }
 
-// CloseTunnel closes a tunnel for a session
-func (m *Manager) CloseTunnel(sessionID string) error {
+// verifyPodOwner checks the session's pod still belongs to its user before
+// credentials are issued or reused for it, answering the client if not
+func (m *Manager) verifyPodOwner(r *http.Request, conn *websocket.Conn, session *types.Session) bool {
+	ctx, cancel := context.WithTimeout(r.Context(), m.setupTimeout)
+	defer cancel()
+
+	err := m.k8sClient.VerifyPodOwner(ctx, session.PodInfo.Namespace, session.PodInfo.Name, session.PodInfo.Owner)
+	if errors.Is(err, k8s.ErrPodReassigned) {
+		log.Printf("Audit: refused tunnel for session %s (user %s): %v", session.ID, session.UserID, err)
+		metrics.TunnelsRejected.WithLabelValues("pod_reassigned").Inc()
+		conn.WriteJSON(map[string]string{"error": err.Error(), "code": "pod_reassigned"})
+		m.closeWithCode(conn, ClosePodReassigned, "pod_reassigned")
+		return false
+	}
+	if err != nil {
+		conn.WriteJSON(map[string]string{"error": fmt.Sprintf("Failed to verify pod: %v", err)})
+		return false
+	}
+	return true
+}
+
+// hasTunnel reports whether the session has an active tunnel
+func (m *Manager) hasTunnel(sessionID string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	_, exists := m.tunnels[sessionID]
+	return exists
+}
+
+// registerTunnel makes tunnel its session's active tunnel. An existing tunnel
+// for the session is closed when replacing duplicates, otherwise registration
+// fails.
+func (m *Manager) registerTunnel(tunnel *Tunnel) bool {
+	m.mutex.Lock()
+	existing, exists := m.tunnels[tunnel.ID]
+	if exists && m.duplicateTunnels == DuplicateTunnelReject {
+		m.mutex.Unlock()
+		return false
+	}
+	m.tunnels[tunnel.ID] = tunnel
+	m.mutex.Unlock()
+
+	if exists {
+		// The old connection's handler exits and releases its own credentials
+		log.Printf("Session %s reconnected, closing its previous tunnel", tunnel.ID)
+		m.closeWithCode(existing.Conn, CloseReplaced, "replaced")
+		existing.cancel()
+		existing.Conn.Close()
+	}
+	return true
+}
+
+// unregisterTunnel removes tunnel unless a newer connection replaced it
+func (m *Manager) unregisterTunnel(tunnel *Tunnel) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if m.tunnels[tunnel.ID] == tunnel {
+		delete(m.tunnels, tunnel.ID)
+	}
+}
+
+// rejectDuplicate closes a connection for a session that already has a tunnel
+func (m *Manager) rejectDuplicate(conn *websocket.Conn, sessionID string) {
+	log.Printf("Rejecting duplicate tunnel for session %s", sessionID)
+	metrics.TunnelsRejected.WithLabelValues("session_busy").Inc()
+	m.closeWithCode(conn, CloseSessionBusy, "session_busy")
+}
+
+// CloseTunnel closes a tunnel for a session
+func (m *Manager) CloseTunnel(sessionID string) error {
+	m.mutex.Lock()
 	tunnel, exists := m.tunnels[sessionID]
 	if !exists {
+		m.mutex.Unlock()
 		return fmt.Errorf("tunnel not found")
 	}
+	delete(m.tunnels, sessionID)
+	m.mutex.Unlock()
 
 	close(tunnel.Done)
+	tunnel.cancel()
+	m.closeWithCode(tunnel.Conn, CloseSessionClosed, "session_closed")
 	tunnel.Conn.Close()
-	delete(m.tunnels, sessionID)
 
 	return nil
 }
 
+// expireTunnel closes a tunnel whose credentials reached the maximum tunnel
+// lifetime, so the user has to reconnect to keep using the pod
+func (m *Manager) expireTunnel(tunnel *Tunnel) {
+	log.Printf("Tunnel for session %s (user %s) reached the maximum lifetime of %v, closing it",
+		tunnel.Session.ID, tunnel.Session.UserID, m.maxTunnelLifetime)
+	tunnel.cancel()
+	m.closeWithCode(tunnel.Conn, CloseMaxLifetime, "max_lifetime_reached")
+	tunnel.Conn.Close()
+}
+
+// Stats returns tunnel usage statistics
+func (m *Manager) Stats() Stats {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return Stats{
+		ActiveTunnels: m.active,
+		MaxTunnels:    m.maxTunnels,
+		Draining:      m.drain.started,
+	}
+}
+
+// acquireSlot reserves capacity for a new tunnel, reporting false when at the limit
+func (m *Manager) acquireSlot() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.maxTunnels > 0 && m.active >= m.maxTunnels {
+		return false
+	}
+	m.active++
+	metrics.ActiveTunnels.Set(float64(m.active))
+	return true
+}
+
+// releaseSlot returns capacity reserved by acquireSlot
+func (m *Manager) releaseSlot() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.active--
+	metrics.ActiveTunnels.Set(float64(m.active))
+}
+
 // handleTunnelMessages processes WebSocket messages
 func (m *Manager) handleTunnelMessages(tunnel *Tunnel) {
 	for {
@@ -126,6 +852,7 @@ func (m *Manager) handleTunnelMessages(tunnel *Tunnel) {
 				}
 				return
 			}
+			m.logMessage(tunnel, messageInbound, message)
 
 			var tunnelMsg types.TunnelMessage
 			if err := json.Unmarshal(message, &tunnelMsg); err != nil {
@@ -133,20 +860,104 @@ func (m *Manager) handleTunnelMessages(tunnel *Tunnel) {
 				continue
 			}
 
-			switch tunnelMsg.Type {
-			case "exec":
-				m.handleExecRequest(tunnel, tunnelMsg.Payload)
-			case "portforward":
-				m.handlePortForwardRequest(tunnel, tunnelMsg.Payload)
-			case "file":
-				m.handleFileRequest(tunnel, tunnelMsg.Payload)
-			default:
-				m.sendError(tunnel, fmt.Sprintf("Unknown message type: %s", tunnelMsg.Type))
-			}
+			m.dispatchMessage(tunnel, tunnelMsg)
+			m.renewTokenIfDue(tunnel)
 		}
 	}
 }
 
+// renewTokenIfDue sends the client a renewed session token once the renewal
+// interval has passed since the tunnel opened or the token was last renewed.
+// The client uses the latest token when reconnecting.
+func (m *Manager) renewTokenIfDue(tunnel *Tunnel) {
+	if m.tokenRenewer == nil || m.tokenRenewalInterval <= 0 ||
+		time.Since(tunnel.tokenRenewedAt) < m.tokenRenewalInterval {
+		return
+	}
+	// A tunnel past its lifetime is closing and must not extend the session
+	if time.Since(tunnel.credentialsIssuedAt) >= m.maxTunnelLifetime {
+		return
+	}
+
+	// A failed renewal is retried after another interval
+	tunnel.tokenRenewedAt = time.Now()
+
+	session, err := m.tokenRenewer.RenewToken(tunnel.ctx, tunnel.Session.ID)
+	if err != nil {
+		log.Printf("Failed to renew token for session %s: %v", tunnel.Session.ID, err)
+		return
+	}
+
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type: "token_renewed",
+		Payload: map[string]string{
+			"session_token": session.Token,
+		},
+	})
+}
+
+// dispatchMessage routes a message to its handler. A panicking handler is
+// reported to the client as an internal error without tearing down the tunnel.
+func (m *Manager) dispatchMessage(tunnel *Tunnel, tunnelMsg types.TunnelMessage) {
+	defer m.recoverHandler(tunnel, tunnelMsg.Type)
+
+	switch tunnelMsg.Type {
+	case "exec":
+		m.handleExecRequest(tunnel, tunnelMsg.Payload)
+	case "exec_cancel":
+		m.handleExecCancel(tunnel, tunnelMsg.Payload)
+	case "exec_stdin":
+		m.handleExecStdin(tunnel, tunnelMsg.Payload)
+	case "exec_stdin_close":
+		m.handleExecStdinClose(tunnel, tunnelMsg.Payload)
+	case "exec_list":
+		m.handleExecList(tunnel)
+	case "portforward":
+		m.handlePortForwardRequest(tunnel, tunnelMsg.Payload)
+	case "reverse_portforward":
+		m.handleReversePortForwardRequest(tunnel, tunnelMsg.Payload)
+	case "reverse_portforward_data":
+		m.handleReversePortForwardData(tunnel, tunnelMsg.Payload)
+	case "reverse_portforward_close":
+		m.handleReversePortForwardClose(tunnel, tunnelMsg.Payload)
+	case "file":
+		m.handleFileRequest(tunnel, tunnelMsg.Payload)
+	case "batch":
+		m.handleBatchRequest(tunnel, tunnelMsg.Payload)
+	case "processlist":
+		m.handleProcessList(tunnel, tunnelMsg.Payload)
+	case "process_kill":
+		m.handleProcessKill(tunnel, tunnelMsg.Payload)
+	case "debug":
+		m.handleDebugRequest(tunnel, tunnelMsg.Payload)
+	case "ping":
+		m.handlePing(tunnel, tunnelMsg.Payload)
+	case "workspace_info":
+		m.handleWorkspaceInfo(tunnel)
+	case "resource_usage":
+		m.handleResourceUsage(tunnel)
+	default:
+		m.sendError(tunnel, fmt.Sprintf("Unknown message type: %s", tunnelMsg.Type))
+	}
+}
+
+// recoverHandler, deferred by a message handler or a goroutine it starts,
+// reports a panic to the client as an internal error instead of crashing the
+// broker
+func (m *Manager) recoverHandler(tunnel *Tunnel, messageType string) {
+	if r := recover(); r != nil {
+		log.Printf("Panic handling %q message for session %s (user %s): %v\n%s",
+			messageType, tunnel.Session.ID, tunnel.Session.UserID, r, debug.Stack())
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type: "internal_error",
+			Payload: map[string]string{
+				"error":        "internal error while handling message",
+				"message_type": messageType,
+			},
+		})
+	}
+}
+
 // handleExecRequest handles command execution requests
 func (m *Manager) handleExecRequest(tunnel *Tunnel, payload interface{}) {
 	payloadBytes, err := json.Marshal(payload)
@@ -160,21 +971,41 @@ func (m *Manager) handleExecRequest(tunnel *Tunnel, payload interface{}) {
 		m.sendError(tunnel, "Invalid exec request format")
 		return
 	}
-
-	// Execute command in pod
-	result, err := m.executeCommand(tunnel, execReq)
-	if err != nil {
-		m.sendError(tunnel, fmt.Sprintf("Command execution failed: %v", err))
+	if err := validateExecEnv(execReq.Env); err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	if err := m.validateRunAsUser(execReq.RunAsUser); err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	if err := validateVerbatim(execReq); err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	if err := m.checkExecAllowed(tunnel, execReq); err != nil {
+		m.sendError(tunnel, err.Error())
 		return
 	}
 
-	// Send result back
-	response := types.TunnelMessage{
-		Type:    "exec_response",
-		Payload: result,
+	streamID := execReq.StreamID
+	if streamID == "" {
+		streamID = newStreamID()
 	}
 
-	m.sendMessage(tunnel, response)
+	// Run the command as a stream so the message loop keeps serving the
+	// tunnel, and exec_cancel can terminate it
+	ctx, err := tunnel.execs.add(tunnel.ctx, streamID, m.execCommand(execReq))
+	if err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	// A nil *execStdin must not become a non-nil io.Reader
+	var stdin io.Reader
+	if execReq.Stdin {
+		stdin = tunnel.execs.openStdin(ctx, streamID, execReq.TTY)
+	}
+	go m.runExec(ctx, tunnel, streamID, execReq, stdin)
 }
 
 // handlePortForwardRequest handles port forwarding requests
@@ -195,6 +1026,68 @@ func (m *Manager) handlePortForwardRequest(tunnel *Tunnel, payload interface{})
 	go m.startPortForward(tunnel, pfReq.Port)
 }
 
+// handlePing answers an application-level ping with the pod's phase, read
+// from the API server without exec'ing in the pod. The lookup runs off the
+// message loop, so a slow API server does not hold up the tunnel.
+func (m *Manager) handlePing(tunnel *Tunnel, payload interface{}) {
+	var req types.Ping
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid ping format")
+		return
+	}
+
+	go func() {
+		defer m.recoverHandler(tunnel, "ping")
+
+		pong := types.Pong{Data: req.Data}
+
+		ctx, cancel := context.WithTimeout(tunnel.ctx, pingPodTimeout)
+		pod, err := m.k8sClient.GetPod(ctx, tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name)
+		cancel()
+		if err != nil {
+			pong.PodError = err.Error()
+		} else {
+			pong.PodPhase = pod.Status
+		}
+		pong.ServerTime = time.Now()
+
+		m.sendMessage(tunnel, types.TunnelMessage{Type: "pong", Payload: pong})
+	}()
+}
+
+// handleDebugRequest adds an ephemeral debug container to the session pod.
+// Starting it can take a while, so the response is sent asynchronously.
+func (m *Manager) handleDebugRequest(tunnel *Tunnel, payload interface{}) {
+	var debugReq types.DebugRequest
+	if err := decodePayload(payload, &debugReq); err != nil {
+		m.sendError(tunnel, "Invalid debug request format")
+		return
+	}
+
+	go m.startDebugContainer(tunnel, debugReq)
+}
+
+// startDebugContainer creates the debug container and reports its name, which
+// exec requests pass as their container
+func (m *Manager) startDebugContainer(tunnel *Tunnel, req types.DebugRequest) {
+	defer m.recoverHandler(tunnel, "debug")
+
+	name, err := m.k8sClient.CreateDebugContainer(tunnel.ctx, tunnel.credentials(),
+		tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name, req.TargetContainer)
+	if err != nil {
+		m.sendError(tunnel, fmt.Sprintf("Failed to start debug container: %v", err))
+		return
+	}
+
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type: "debug_response",
+		Payload: map[string]interface{}{
+			"container": name,
+			"status":    "running",
+		},
+	})
+}
+
 // handleFileRequest handles file operation requests
 func (m *Manager) handleFileRequest(tunnel *Tunnel, payload interface{}) {
 	payloadBytes, err := json.Marshal(payload)
@@ -209,37 +1102,69 @@ func (m *Manager) handleFileRequest(tunnel *Tunnel, payload interface{}) {
 		return
 	}
 
-	// Execute file operation
-	result, err := m.executeFileOperation(tunnel, fileReq)
-	if err != nil {
-		m.sendError(tunnel, fmt.Sprintf("File operation failed: %v", err))
+	// Copies can take a while, so run off the message loop like batches
+	if !tunnel.fileOps.start() {
+		m.sendError(tunnel, fmt.Sprintf("Too many file operations running, at most %d", tunnel.fileOps.limit))
 		return
 	}
+	go func() {
+		defer tunnel.fileOps.done()
+		defer m.recoverHandler(tunnel, "file")
 
-	// Send result back
-	response := types.TunnelMessage{
-		Type:    "file_response",
-		Payload: result,
-	}
+		result, err := m.executeFileOperation(tunnel, fileReq)
+		if err != nil {
+			m.sendError(tunnel, fmt.Sprintf("File operation failed: %v", err))
+			return
+		}
+		result.RequestID = fileReq.RequestID
 
-	m.sendMessage(tunnel, response)
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type:    "file_response",
+			Payload: result,
+		})
+	}()
 }
 
-// executeCommand executes a command in the pod
-func (m *Manager) executeCommand(tunnel *Tunnel, req types.ExecRequest) (*types.ExecResponse, error) {
-	// This is a simplified implementation
-	// In practice, you'd use k8s.io/client-go/tools/remotecommand
+// executeCommand runs the request's command in the session's pod with the
+// tunnel's credentials, connecting only the streams the request asks for, and
+// returns its exit code. A command exiting non-zero is not an error. The
+// command first loads and removes envFile, if set.
+func (m *Manager) executeCommand(ctx context.Context, tunnel *Tunnel, req types.ExecRequest, envFile string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	command := m.execCommand(req)
+	if envFile != "" {
+		command = m.envFileCommand(envFile, command)
+	}
+	opts := k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Container: req.Container,
+		Command:   m.resourceCommand(tunnel, command),
+		TTY:       req.TTY,
+	}
+	if req.Stdin {
+		opts.Stdin = stdin
+	}
+	if req.Stdout {
+		opts.Stdout = stdout
+	}
+	if req.Stderr {
+		opts.Stderr = stderr
+	}
 
-	// For now, return a mock response
-	return &types.ExecResponse{
-		ExitCode: 0,
-		Stdout:   fmt.Sprintf("Executed: %s %v", req.Command, req.Args),
-		Stderr:   "",
-	}, nil
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), opts)
+	if exitCode, exited := k8s.ExitCode(err); exited {
+		return exitCode, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
 }
 
 // startPortForward starts port forwarding
 func (m *Manager) startPortForward(tunnel *Tunnel, port int) {
+	defer m.recoverHandler(tunnel, "portforward")
+
 	// This is a simplified implementation
 	// In practice, you'd use k8s.io/client-go/tools/portforward
 
@@ -257,20 +1182,22 @@ func (m *Manager) startPortForward(tunnel *Tunnel, port int) {
 
 // executeFileOperation executes a file operation
 func (m *Manager) executeFileOperation(tunnel *Tunnel, req types.FileOperation) (*types.FileOperationResponse, error) {
-	// This is a simplified implementation
-	// In practice, you'd use kubectl exec with appropriate commands
+	ctx, cancel := context.WithTimeout(tunnel.ctx, m.fileOperationTimeout)
+	defer cancel()
 
 	switch req.Operation {
 	case "read":
-		return &types.FileOperationResponse{
-			Success: true,
-			Content: fmt.Sprintf("Content of %s", req.Path),
-		}, nil
-	case "list":
-		return &types.FileOperationResponse{
-			Success: true,
-			Content: fmt.Sprintf("Directory listing of %s", req.Path),
-		}, nil
+		return m.readFile(ctx, tunnel, req), nil
+	case "write":
+		return m.writeFile(ctx, tunnel, req), nil
+	case "symlink":
+		return m.createSymlink(ctx, tunnel, req), nil
+	case "chmod":
+		return m.changeMode(ctx, tunnel, req), nil
+	case "chown":
+		return m.changeOwner(ctx, tunnel, req), nil
+	case "list", "stat":
+		return m.statFiles(ctx, tunnel, []types.FileOperation{req})[0], nil
 	default:
 		return &types.FileOperationResponse{
 			Success: false,
@@ -289,8 +1216,19 @@ func (m *Manager) sendMessage(tunnel *Tunnel, msg types.TunnelMessage) {
 	if err != nil {
 		return
 	}
+	m.logMessage(tunnel, messageOutbound, messageBytes)
 
-	tunnel.Conn.WriteMessage(websocket.TextMessage, messageBytes)
+	tunnel.Conn.SetWriteDeadline(time.Now().Add(m.writeTimeout))
+	tunnel.Conn.EnableWriteCompression(m.compressMessage(len(messageBytes)))
+	if err := tunnel.Conn.WriteMessage(websocket.TextMessage, messageBytes); err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			// The peer stopped reading; treat the connection as dead
+			log.Printf("Write to tunnel for session %s timed out after %v, closing", tunnel.ID, m.writeTimeout)
+			m.closeWithCode(tunnel.Conn, CloseWriteTimeout, "write_timeout")
+			tunnel.cancel()
+			tunnel.Conn.Close()
+		}
+	}
 }
 
 func (m *Manager) sendError(tunnel *Tunnel, errorMsg string) {
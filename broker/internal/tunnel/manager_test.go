@@ -0,0 +1,605 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// fakeK8sClient implements k8s.ClientInterface for tunnel tests
+type fakeK8sClient struct {
+	mutex         sync.Mutex
+	panicOnCreate bool
+	createDelay   time.Duration
+	created       int
+	released      []string
+	releaseErrs   []error // ctx.Err() seen by each release
+	execFunc      func(ctx context.Context, opts k8s.ExecOptions) error
+	files         map[string]k8s.ArchivedFile
+	envSources    map[string]map[string]string // reference -> keys
+	volumeMounts  []types.VolumeMount
+	resourceUsage *types.ResourceUsage
+	podOwner      string        // owner annotation of the pod, checked if set
+	tokenLifetime time.Duration // lifetime of minted tokens; they never expire if zero
+	refreshes     []time.Time
+	podLookups    chan struct{} // GetPod waits for a value on it, if set
+}
+
+func (f *fakeK8sClient) CreateServiceAccount(ctx context.Context, namespace, name string) error {
+	return nil
+}
+
+func (f *fakeK8sClient) CreateRoleBinding(ctx context.Context, namespace, saName, podName string) error {
+	return nil
+}
+
+func (f *fakeK8sClient) MintToken(ctx context.Context, namespace, saName string, ttl time.Duration) (string, time.Time, error) {
+	return "k8s-token", time.Now().Add(ttl), nil
+}
+
+func (f *fakeK8sClient) DeleteServiceAccount(ctx context.Context, namespace, name string) error {
+	return nil
+}
+
+func (f *fakeK8sClient) GetPod(ctx context.Context, namespace, name string) (*types.PodInfo, error) {
+	if f.podLookups != nil {
+		select {
+		case <-f.podLookups:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return &types.PodInfo{Name: name, Namespace: namespace, Status: "Running"}, nil
+}
+
+func (f *fakeK8sClient) CreateSessionServiceAccount(ctx context.Context, namespace, podName string) (string, error) {
+	return "k8s-token", nil
+}
+
+func (f *fakeK8sClient) CreateSessionCredentials(ctx context.Context, namespace, podName, userID string) (*k8s.SessionCredentials, error) {
+	if f.panicOnCreate {
+		panic("simulated panic")
+	}
+	if f.createDelay > 0 {
+		select {
+		case <-time.After(f.createDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f.mutex.Lock()
+	f.created++
+	f.mutex.Unlock()
+	return &k8s.SessionCredentials{ServiceAccount: "vscode-sess-test", Token: "k8s-token", ExpiresAt: f.tokenExpiry()}, nil
+}
+
+func (f *fakeK8sClient) RefreshSessionCredentials(ctx context.Context, namespace string, creds *k8s.SessionCredentials) (*k8s.SessionCredentials, error) {
+	f.mutex.Lock()
+	f.refreshes = append(f.refreshes, time.Now())
+	token := fmt.Sprintf("k8s-token-%d", len(f.refreshes))
+	f.mutex.Unlock()
+
+	return &k8s.SessionCredentials{ServiceAccount: creds.ServiceAccount, Token: token, ExpiresAt: f.tokenExpiry()}, nil
+}
+
+// tokenExpiry is when a token minted now expires
+func (f *fakeK8sClient) tokenExpiry() time.Time {
+	if f.tokenLifetime == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(f.tokenLifetime)
+}
+
+// refreshTimes returns when credentials were refreshed
+func (f *fakeK8sClient) refreshTimes() []time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]time.Time(nil), f.refreshes...)
+}
+
+func (f *fakeK8sClient) ReleaseSessionCredentials(ctx context.Context, namespace string, creds *k8s.SessionCredentials) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if creds != nil {
+		f.released = append(f.released, creds.ServiceAccount)
+		f.releaseErrs = append(f.releaseErrs, ctx.Err())
+	}
+	return nil
+}
+
+func (f *fakeK8sClient) Exec(ctx context.Context, creds *k8s.SessionCredentials, opts k8s.ExecOptions) error {
+	if f.execFunc != nil {
+		return f.execFunc(ctx, opts)
+	}
+	return echoExec(ctx, opts)
+}
+
+// echoExec stands in for a pod: it reports the command it was asked to run
+// and echoes its input like cat until it is closed
+func echoExec(ctx context.Context, opts k8s.ExecOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Stdout == nil {
+		return nil
+	}
+	fmt.Fprintf(opts.Stdout, "Executed: %s", strings.Join(opts.Command, " "))
+	if opts.Stdin != nil {
+		if _, err := io.Copy(opts.Stdout, opts.Stdin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeK8sClient) CopyToPod(ctx context.Context, creds *k8s.SessionCredentials, opts k8s.CopyOptions, destDir string, archive io.Reader) error {
+	file, err := k8s.ReadFileArchive(archive, 0)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.files == nil {
+		f.files = make(map[string]k8s.ArchivedFile)
+	}
+	f.files[path.Join(destDir, file.Name)] = *file
+	return nil
+}
+
+func (f *fakeK8sClient) CopyFromPod(ctx context.Context, creds *k8s.SessionCredentials, opts k8s.CopyOptions, srcPath string, archive io.Writer) error {
+	f.mutex.Lock()
+	file, exists := f.files[srcPath]
+	f.mutex.Unlock()
+	if !exists {
+		return fmt.Errorf("tar failed in pod: %s: No such file or directory", path.Base(srcPath))
+	}
+	return k8s.WriteFileArchive(archive, file)
+}
+
+func (f *fakeK8sClient) GetPodEvents(ctx context.Context, namespace, name string) ([]types.PodEvent, error) {
+	return nil, nil
+}
+
+func (f *fakeK8sClient) ListNamespaces(ctx context.Context, labelSelector string) ([]string, error) {
+	return nil, nil
+}
+
+func (f *fakeK8sClient) FindUserPod(ctx context.Context, namespace, selector string) (*types.PodInfo, error) {
+	return &types.PodInfo{Name: "test-pod", Namespace: namespace, Status: "Running"}, nil
+}
+
+func (f *fakeK8sClient) ReadEnvSources(ctx context.Context, creds *k8s.SessionCredentials, namespace string, refs []string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, ref := range refs {
+		values, ok := f.envSources[ref]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", k8s.ErrEnvSourceNotAllowed, ref)
+		}
+		for key, value := range values {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+func (f *fakeK8sClient) GetVolumeMounts(ctx context.Context, namespace, name string) ([]types.VolumeMount, error) {
+	return f.volumeMounts, nil
+}
+
+func (f *fakeK8sClient) GetPodResourceUsage(ctx context.Context, namespace, name string) (*types.ResourceUsage, error) {
+	if f.resourceUsage == nil {
+		return nil, fmt.Errorf("%w: %s/%s", k8s.ErrPodNotFound, namespace, name)
+	}
+	return f.resourceUsage, nil
+}
+
+func (f *fakeK8sClient) VerifyPodOwner(ctx context.Context, namespace, name, owner string) error {
+	if f.podOwner != "" && owner != "" && owner != f.podOwner {
+		return fmt.Errorf("%w: %s/%s", k8s.ErrPodReassigned, namespace, name)
+	}
+	return nil
+}
+
+func (f *fakeK8sClient) CreateDebugContainer(ctx context.Context, creds *k8s.SessionCredentials, namespace, podName, targetContainer string) (string, error) {
+	return "debugger-test", nil
+}
+
+func testSession() *types.Session {
+	return &types.Session{
+		ID:     "0123456789abcdef",
+		UserID: "test-user",
+		PodInfo: types.PodInfo{
+			Name:      "test-pod",
+			Namespace: "test-namespace",
+			Status:    "Running",
+		},
+	}
+}
+
+// startTestServer serves tunnels for the given session through the manager
+func startTestServer(t *testing.T, manager *Manager, session *types.Session) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager.HandleConnection(w, r, session)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func dialTestServer(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("Expected no error dialing tunnel, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// dialReadyTunnel dials a tunnel and reads its ready message
+func dialReadyTunnel(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+
+	conn := dialTestServer(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ready types.TunnelMessage
+	if err := conn.ReadJSON(&ready); err != nil || ready.Type != "ready" {
+		t.Fatalf("Expected ready message, got %+v (%v)", ready, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn
+}
+
+// isCapabilityProbe reports whether an exec is the probe run at tunnel setup
+func isCapabilityProbe(opts k8s.ExecOptions) bool {
+	return len(opts.Command) > 2 && opts.Command[2] == probeScript
+}
+
+// waitFor polls cond until it holds or the timeout elapses
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for condition")
+}
+
+func TestManager_MaxTotalTunnels(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{MaxTotalTunnels: 1})
+	server := startTestServer(t, manager, testSession())
+
+	first := dialTestServer(t, server)
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 1 })
+
+	second := dialTestServer(t, server)
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := second.ReadMessage()
+	if !websocket.IsCloseError(err, CloseCapacity) {
+		t.Fatalf("Expected capacity close code, got %v", err)
+	}
+
+	if stats := manager.Stats(); stats.ActiveTunnels != 1 || stats.MaxTunnels != 1 {
+		t.Errorf("Expected 1/1 tunnels, got %d/%d", stats.ActiveTunnels, stats.MaxTunnels)
+	}
+
+	first.Close()
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 0 })
+}
+
+func TestManager_DuplicateTunnelReplacesExisting(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+
+	first := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+
+	second := dialTestServer(t, server)
+	first.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := first.ReadMessage()
+	if !websocket.IsCloseError(err, CloseReplaced) {
+		t.Fatalf("Expected replaced close code on first tunnel, got %v", err)
+	}
+
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 1 })
+	k8sClient.mutex.Lock()
+	released := len(k8sClient.released)
+	k8sClient.mutex.Unlock()
+	if released != 1 {
+		t.Fatalf("Expected first tunnel's credentials released once, got %d releases", released)
+	}
+	if !manager.hasTunnel(testSession().ID) {
+		t.Fatal("Expected replacing tunnel to stay registered after the first exits")
+	}
+
+	second.Close()
+	waitFor(t, func() bool {
+		k8sClient.mutex.Lock()
+		defer k8sClient.mutex.Unlock()
+		return len(k8sClient.released) == 2
+	})
+	waitFor(t, func() bool { return !manager.hasTunnel(testSession().ID) })
+}
+
+func TestManager_ReleasesCredentialsAfterRequestCancelled(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{})
+
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	defer cancelReq()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		manager.HandleConnection(w, r.WithContext(reqCtx), testSession())
+	}))
+	t.Cleanup(server.Close)
+
+	conn := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+
+	// The request context goes away while the tunnel keeps running
+	cancelReq()
+	conn.Close()
+
+	waitFor(t, func() bool {
+		k8sClient.mutex.Lock()
+		defer k8sClient.mutex.Unlock()
+		return len(k8sClient.released) == 1
+	})
+	k8sClient.mutex.Lock()
+	defer k8sClient.mutex.Unlock()
+	if err := k8sClient.releaseErrs[0]; err != nil {
+		t.Fatalf("Expected credentials released with a live context, got %v", err)
+	}
+}
+
+func TestManager_DuplicateTunnelRejected(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{DuplicateTunnels: DuplicateTunnelReject})
+	server := startTestServer(t, manager, testSession())
+
+	dialTestServer(t, server)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+
+	second := dialTestServer(t, server)
+	second.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := second.ReadMessage()
+	if !websocket.IsCloseError(err, CloseSessionBusy) {
+		t.Fatalf("Expected session_busy close code, got %v", err)
+	}
+
+	k8sClient.mutex.Lock()
+	defer k8sClient.mutex.Unlock()
+	if len(k8sClient.released) != 0 {
+		t.Errorf("Expected existing tunnel's credentials to be kept, got %v released", k8sClient.released)
+	}
+}
+
+func TestManager_ReleasesSlotOnPanic(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{panicOnCreate: true}, ManagerConfig{MaxTotalTunnels: 1})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialTestServer(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	conn.ReadMessage()
+
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 0 })
+}
+
+func TestManager_RecoversFromHandlerPanic(t *testing.T) {
+	k8sClient := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			if isCapabilityProbe(opts) {
+				return nil
+			}
+			// Simulate an unguarded assertion deep in a handler
+			var payload interface{} = opts.Command
+			_ = payload.(map[string]interface{})
+			return nil
+		},
+	}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// A valid processlist reaches the panicking exec
+	if err := conn.WriteJSON(types.TunnelMessage{
+		Type:    "processlist",
+		Payload: map[string]interface{}{},
+	}); err != nil {
+		t.Fatalf("Expected no error writing message, got %v", err)
+	}
+
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected internal_error response, got %v", err)
+	}
+	if response.Type != "internal_error" {
+		t.Fatalf("Expected internal_error message, got %s", response.Type)
+	}
+
+	// The read loop keeps serving messages after the panic
+	if err := conn.WriteJSON(types.TunnelMessage{Type: "unknown"}); err != nil {
+		t.Fatalf("Expected no error writing message, got %v", err)
+	}
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected tunnel to stay open after panic, got %v", err)
+	}
+	if response.Type != "error" {
+		t.Errorf("Expected error message for unknown type, got %s", response.Type)
+	}
+}
+
+func TestManager_WriteTimeoutClosesTunnel(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{WriteTimeout: 50 * time.Millisecond})
+	session := testSession()
+	server := startTestServer(t, manager, session)
+
+	// The client never reads, so the server's writes eventually block
+	dialTestServer(t, server)
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 1 })
+
+	manager.mutex.RLock()
+	tunnel := manager.tunnels[session.ID]
+	manager.mutex.RUnlock()
+
+	payload := strings.Repeat("x", 1<<20)
+	go func() {
+		for i := 0; i < 256 && tunnel.ctx.Err() == nil; i++ {
+			manager.sendMessage(tunnel, types.TunnelMessage{Type: "exec_response", Payload: payload})
+		}
+	}()
+
+	waitFor(t, func() bool { return tunnel.ctx.Err() != nil })
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 0 })
+}
+
+func TestManager_SetupTimeout(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{createDelay: 5 * time.Second}, ManagerConfig{SetupTimeout: 50 * time.Millisecond})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialTestServer(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected setup error message, got %v", err)
+	}
+	if !strings.Contains(string(message), "Failed to create k8s credentials") {
+		t.Errorf("Expected credentials error, got %s", message)
+	}
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, CloseSetupTimeout) {
+		t.Fatalf("Expected setup_timeout close code, got %v", err)
+	}
+
+	waitFor(t, func() bool { return manager.Stats().ActiveTunnels == 0 })
+}
+
+// fakeTokenRenewer hands out numbered tokens
+type fakeTokenRenewer struct {
+	mutex    sync.Mutex
+	renewals int
+}
+
+func (f *fakeTokenRenewer) RenewToken(ctx context.Context, sessionID string) (*types.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.renewals++
+	session := testSession()
+	session.Token = fmt.Sprintf("renewed-%d", f.renewals)
+	return session, nil
+}
+
+func TestManager_RenewsTokenOnMessages(t *testing.T) {
+	renewer := &fakeTokenRenewer{}
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{
+		TokenRenewer:         renewer,
+		TokenRenewalInterval: 20 * time.Millisecond,
+	})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// Messages before the interval elapses get no renewal
+	conn.WriteJSON(types.TunnelMessage{Type: "unknown"})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "error" {
+		t.Fatalf("Expected error response, got %s (%v)", response.Type, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	conn.WriteJSON(types.TunnelMessage{Type: "unknown"})
+	conn.ReadJSON(&response)
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected token_renewed message, got %v", err)
+	}
+	if response.Type != "token_renewed" {
+		t.Fatalf("Expected token_renewed message, got %s", response.Type)
+	}
+	payload := response.Payload.(map[string]interface{})
+	if payload["session_token"] != "renewed-1" {
+		t.Errorf("Expected renewed-1, got %v", payload["session_token"])
+	}
+}
+
+func TestManager_PingRunsOffMessageLoop(t *testing.T) {
+	client := &fakeK8sClient{podLookups: make(chan struct{})}
+	manager := NewManager(client, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{Type: "ping", Payload: map[string]interface{}{}})
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+
+	// The loop answers while the ping waits on the API server
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var first types.TunnelMessage
+	if err := conn.ReadJSON(&first); err != nil || first.Type != "exec_list_response" {
+		t.Fatalf("Expected exec_list_response before the pong, got %s %v", first.Type, err)
+	}
+
+	client.podLookups <- struct{}{}
+	if payload := readMessageOfType(t, conn, "pong"); payload["pod_phase"] != "Running" {
+		t.Errorf("Expected the pong once the lookup finished, got %v", payload)
+	}
+}
+
+func TestManager_PingReportsPodPhase(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	sent := time.Now()
+	if err := conn.WriteJSON(types.TunnelMessage{
+		Type:    "ping",
+		Payload: map[string]interface{}{"data": map[string]interface{}{"seq": 7}},
+	}); err != nil {
+		t.Fatalf("Expected no error writing ping, got %v", err)
+	}
+
+	var response struct {
+		Type    string     `json:"type"`
+		Payload types.Pong `json:"payload"`
+	}
+	if err := conn.ReadJSON(&response); err != nil {
+		t.Fatalf("Expected pong, got %v", err)
+	}
+	if response.Type != "pong" {
+		t.Fatalf("Expected pong message, got %s", response.Type)
+	}
+	if response.Payload.PodPhase != "Running" || response.Payload.PodError != "" {
+		t.Errorf("Expected Running pod phase, got %+v", response.Payload)
+	}
+	if data, _ := response.Payload.Data.(map[string]interface{}); data["seq"] != float64(7) {
+		t.Errorf("Expected ping data to be echoed, got %v", response.Payload.Data)
+	}
+	if response.Payload.ServerTime.Before(sent.Add(-time.Second)) {
+		t.Errorf("Expected current server time, got %v", response.Payload.ServerTime)
+	}
+}
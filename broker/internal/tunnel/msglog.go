@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Message directions in the message type log
+const (
+	messageInbound  = "recv"
+	messageOutbound = "send"
+)
+
+// logMessage logs the type and stream ID of a tunnel message when message
+// logging is enabled, for tracing the protocol flow of a session. Payloads
+// are never logged, they can carry file contents and secrets.
+func (m *Manager) logMessage(tunnel *Tunnel, direction string, raw []byte) {
+	if !m.logMessageTypes {
+		return
+	}
+
+	// Only the envelope is decoded; payloads that are not objects have no stream
+	var envelope struct {
+		Type    string `json:"type"`
+		Payload struct {
+			StreamID string `json:"stream_id"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil && envelope.Type == "" {
+		log.Printf("Tunnel message: session %s %s undecodable (%d bytes)", tunnel.ID, direction, len(raw))
+		return
+	}
+
+	if envelope.Payload.StreamID != "" {
+		log.Printf("Tunnel message: session %s %s %s stream=%s", tunnel.ID, direction, envelope.Type, envelope.Payload.StreamID)
+		return
+	}
+	log.Printf("Tunnel message: session %s %s %s", tunnel.ID, direction, envelope.Type)
+}
@@ -0,0 +1,41 @@
+package tunnel
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestManager_LogMessage(t *testing.T) {
+	var output bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&output)
+
+	tunnel := &Tunnel{ID: "0123456789abcdef"}
+	message := []byte(`{"type":"exec_output","payload":{"stream_id":"s1","data":"secret-file-contents"}}`)
+
+	(&Manager{}).logMessage(tunnel, messageOutbound, message)
+	if output.Len() != 0 {
+		t.Fatalf("Expected nothing logged when disabled, got %q", output.String())
+	}
+
+	manager := &Manager{logMessageTypes: true}
+	manager.logMessage(tunnel, messageOutbound, message)
+	manager.logMessage(tunnel, messageInbound, []byte(`{"type":"ping","payload":"not an object"}`))
+	manager.logMessage(tunnel, messageInbound, []byte(`not json`))
+
+	logged := output.String()
+	for _, want := range []string{
+		"session 0123456789abcdef send exec_output stream=s1",
+		"session 0123456789abcdef recv ping\n",
+		"session 0123456789abcdef recv undecodable",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("Expected log to contain %q, got %q", want, logged)
+		}
+	}
+	if strings.Contains(logged, "secret-file-contents") {
+		t.Errorf("Expected payload never to be logged, got %q", logged)
+	}
+}
@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// codeNamespaceLimit is sent with errors rejecting a relay because its
+// namespace already has the maximum open across all sessions
+const codeNamespaceLimit = "namespace_limit"
+
+// namespaceRelays counts relays open per namespace, so sessions sharing a
+// namespace cannot together exhaust its pods' or nodes' file descriptors
+type namespaceRelays struct {
+	mutex  sync.Mutex
+	limit  int // 0 for no limit
+	counts map[string]int
+}
+
+// acquire reserves a relay in namespace, reporting false if it is saturated
+func (r *namespaceRelays) acquire(namespace string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.limit > 0 && r.counts[namespace] >= r.limit {
+		return false
+	}
+	if r.counts == nil {
+		r.counts = make(map[string]int)
+	}
+	r.counts[namespace]++
+	metrics.NamespaceRelays.WithLabelValues(namespace).Set(float64(r.counts[namespace]))
+	return true
+}
+
+// release frees a relay reserved with acquire
+func (r *namespaceRelays) release(namespace string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.counts[namespace]--
+	if r.counts[namespace] > 0 {
+		metrics.NamespaceRelays.WithLabelValues(namespace).Set(float64(r.counts[namespace]))
+		return
+	}
+	// Drop idle namespaces so the map and metric only cover busy ones
+	delete(r.counts, namespace)
+	metrics.NamespaceRelays.DeleteLabelValues(namespace)
+}
+
+// count returns the relays open in namespace
+func (r *namespaceRelays) count(namespace string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.counts[namespace]
+}
+
+// sendNamespaceLimitError tells the client its namespace has no relays left
+func (m *Manager) sendNamespaceLimitError(tunnel *Tunnel) {
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type: "error",
+		Payload: map[string]string{
+			"error": fmt.Sprintf("Namespace %s has reached its limit of %d relays, close one and retry",
+				tunnel.Session.PodInfo.Namespace, m.namespaceRelays.limit),
+			"code": codeNamespaceLimit,
+		},
+	})
+}
@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_NamespaceRelayLimit(t *testing.T) {
+	k8sClient := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			// Relays wait for a connection until closed
+			if opts.Command[0] == relayBinary {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}
+	manager := NewManager(k8sClient, ManagerConfig{MaxNamespaceRelays: 1})
+
+	// Two users' sessions share the namespace
+	alice, bob := testSession(), testSession()
+	bob.ID, bob.UserID = "fedcba9876543210", "other-user"
+	aliceConn := dialReadyTunnel(t, startTestServer(t, manager, alice))
+	bobConn := dialReadyTunnel(t, startTestServer(t, manager, bob))
+
+	readReply := func(conn *websocket.Conn) types.TunnelMessage {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg types.TunnelMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("Expected a reply, got %v", err)
+		}
+		return msg
+	}
+	forward := types.TunnelMessage{Type: "reverse_portforward", Payload: types.ReversePortForwardRequest{Port: 5678}}
+
+	aliceConn.WriteJSON(forward)
+	if reply := readReply(aliceConn); reply.Type != "reverse_portforward_response" {
+		t.Fatalf("Expected the first relay to start, got %+v", reply)
+	}
+
+	bobConn.WriteJSON(forward)
+	reply := readReply(bobConn)
+	payload, _ := reply.Payload.(map[string]interface{})
+	if reply.Type != "error" || payload["code"] != codeNamespaceLimit {
+		t.Fatalf("Expected namespace_limit error, got %+v", reply)
+	}
+
+	// Closing a relay frees the namespace for other sessions
+	aliceConn.WriteJSON(types.TunnelMessage{Type: "reverse_portforward_close", Payload: types.ReversePortForwardClose{Port: 5678}})
+	waitFor(t, func() bool { return manager.namespaceRelays.count(alice.PodInfo.Namespace) == 0 })
+
+	bobConn.WriteJSON(forward)
+	if reply := readReply(bobConn); reply.Type != "reverse_portforward_response" {
+		t.Fatalf("Expected the relay to start once the namespace had room, got %+v", reply)
+	}
+}
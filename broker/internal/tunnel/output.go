@@ -0,0 +1,103 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// EncodingBase64 marks exec output sent as base64 for binary and verbatim
+// requests
+const EncodingBase64 = "base64"
+
+// DefaultExecMaxOutputBytes bounds each of stdout and stderr collected for a
+// non-streamed exec_response
+const DefaultExecMaxOutputBytes = 16 << 20
+
+// errOutputLimit stops a non-streamed command whose output outgrew its buffer
+var errOutputLimit = errors.New("exec output limit exceeded")
+
+// cappedBuffer collects a non-streamed command's output, failing writes past
+// its limit so the command is stopped rather than held in memory
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+}
+
+// Write buffers p, or as much of it as fits
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.exceeded = true
+		return max(room, 0), errOutputLimit
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// validateVerbatim checks a verbatim request can return its stdout byte for
+// byte: a TTY translates line endings and echoes input, and streaming splits
+// the output across messages
+func validateVerbatim(req types.ExecRequest) error {
+	if !req.Verbatim {
+		return nil
+	}
+	if req.TTY {
+		return fmt.Errorf("verbatim exec cannot use a TTY")
+	}
+	if req.Stream {
+		return fmt.Errorf("verbatim exec cannot be streamed")
+	}
+	return nil
+}
+
+// encodeExecOutput prepares exec output for the JSON transport. Binary and
+// verbatim requests get their bytes base64 encoded untouched. Other non-TTY output is made valid
+// UTF-8 with stray control characters removed when sanitizing is enabled; TTY
+// output is left for the client's terminal to interpret.
+func (m *Manager) encodeExecOutput(req types.ExecRequest, resp *types.ExecResponse) {
+	switch {
+	case req.Binary || req.Verbatim:
+		resp.Stdout = base64.StdEncoding.EncodeToString([]byte(resp.Stdout))
+		resp.Stderr = base64.StdEncoding.EncodeToString([]byte(resp.Stderr))
+		resp.Encoding = EncodingBase64
+	case !req.TTY && m.sanitizeExecOutput:
+		resp.Stdout = sanitizeOutput(resp.Stdout)
+		resp.Stderr = sanitizeOutput(resp.Stderr)
+	}
+}
+
+// sanitizeOutput replaces invalid UTF-8 with U+FFFD and drops control
+// characters other than tab, newline, carriage return and escape, which
+// terminals and output panes handle
+func sanitizeOutput(output string) string {
+	if utf8.ValidString(output) && !strings.ContainsFunc(output, isStrayControl) {
+		return output
+	}
+
+	var b strings.Builder
+	b.Grow(len(output))
+	for _, r := range strings.ToValidUTF8(output, string(utf8.RuneError)) {
+		if !isStrayControl(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isStrayControl(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r', '\x1b':
+		return false
+	}
+	return r < 0x20 || r == 0x7f
+}
@@ -0,0 +1,80 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestSanitizeOutput(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{name: "valid output unchanged", output: "héllo\tworld\r\n\x1b[31mred\x1b[0m", want: "héllo\tworld\r\n\x1b[31mred\x1b[0m"},
+		{name: "invalid bytes replaced", output: "caf\xe9 ok", want: "caf� ok"},
+		{name: "truncated sequence replaced", output: "snow \xe2\x98", want: "snow �"},
+		{name: "latin-1 run replaced once", output: "\xff\xfe\xfd!", want: "�!"},
+		{name: "control characters dropped", output: "a\x00b\x07c\x7fd", want: "abcd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeOutput(tt.output)
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("Expected valid UTF-8, got %q", got)
+			}
+		})
+	}
+}
+
+func TestManager_EncodeExecOutput(t *testing.T) {
+	invalid := "data \xc3\x28 \x00end"
+
+	tests := []struct {
+		name     string
+		sanitize bool
+		req      types.ExecRequest
+		want     string
+		encoding string
+	}{
+		{name: "sanitized", sanitize: true, req: types.ExecRequest{}, want: "data �( end"},
+		{name: "sanitizing disabled", req: types.ExecRequest{}, want: invalid},
+		{name: "tty untouched", sanitize: true, req: types.ExecRequest{TTY: true}, want: invalid},
+		{
+			name:     "binary passed through",
+			sanitize: true,
+			req:      types.ExecRequest{Binary: true},
+			want:     base64.StdEncoding.EncodeToString([]byte(invalid)),
+			encoding: EncodingBase64,
+		},
+		{
+			name:     "verbatim passed through",
+			sanitize: true,
+			req:      types.ExecRequest{Verbatim: true},
+			want:     base64.StdEncoding.EncodeToString([]byte(invalid)),
+			encoding: EncodingBase64,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManager(&fakeK8sClient{}, ManagerConfig{SanitizeExecOutput: tt.sanitize})
+			resp := &types.ExecResponse{Stdout: invalid, Stderr: invalid}
+
+			manager.encodeExecOutput(tt.req, resp)
+			if resp.Stdout != tt.want || resp.Stderr != tt.want {
+				t.Errorf("Expected %q, got stdout %q and stderr %q", tt.want, resp.Stdout, resp.Stderr)
+			}
+			if resp.Encoding != tt.encoding {
+				t.Errorf("Expected encoding %q, got %q", tt.encoding, resp.Encoding)
+			}
+		})
+	}
+}
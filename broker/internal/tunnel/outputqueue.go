@@ -0,0 +1,180 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultExecTTYWeight is how many exec_output messages a TTY stream may send
+// for each one of a bulk stream when both have output waiting
+const DefaultExecTTYWeight = 4
+
+// DefaultExecOutputQueueLength is how many exec_output messages a stream may
+// have waiting. A stream with a full queue blocks until the writer catches
+// up, slowing its command rather than buffering its output without bound.
+const DefaultExecOutputQueueLength = 4
+
+// DefaultExecSlowReaderTimeout is how long a stream's queue may stay full
+// before the stream is cancelled, so a client that stops reading its output
+// does not hold the command and its exec connection open indefinitely
+const DefaultExecSlowReaderTimeout = 30 * time.Second
+
+// outputQueue holds a tunnel's streamed exec output until it is sent. A single
+// writer visits the streams with waiting output in turn, so one stream
+// producing output faster than the client reads cannot hold back the others.
+// On each visit a TTY stream may send up to the TTY weight of messages and a
+// bulk stream one, keeping terminals responsive next to a build's logs.
+type outputQueue struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	streams map[string]*queuedStream
+	ready   []string // streams with waiting output, in visiting order
+	running bool
+	stopped bool
+}
+
+// queuedStream is one exec stream's waiting output
+type queuedStream struct {
+	weight   int
+	messages []types.ExecOutput
+	sending  bool
+}
+
+// queueOutput adds a stream's output to the tunnel's queue, starting the
+// writer on first use. It blocks while the stream's queue is full and drops
+// the output once the tunnel has closed. A queue that stays full for the slow
+// reader timeout has its output discarded, and false is returned so the
+// stream can be cancelled.
+func (m *Manager) queueOutput(tunnel *Tunnel, output types.ExecOutput, tty bool) bool {
+	q := &tunnel.output
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.running {
+		q.running = true
+		q.cond = sync.NewCond(&q.mutex)
+		q.streams = make(map[string]*queuedStream)
+		context.AfterFunc(tunnel.ctx, func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+
+			q.stopped = true
+			q.cond.Broadcast()
+		})
+		go m.writeOutput(tunnel)
+	}
+
+	stream, exists := q.streams[output.StreamID]
+	if !exists {
+		stream = &queuedStream{weight: 1}
+		if tty {
+			stream.weight = m.execTTYWeight
+		}
+		q.streams[output.StreamID] = stream
+	}
+
+	if len(stream.messages) >= m.execOutputQueueLength {
+		deadline := time.Now().Add(m.execSlowReaderTimeout)
+		wake := time.AfterFunc(m.execSlowReaderTimeout, func() {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+			q.cond.Broadcast()
+		})
+		defer wake.Stop()
+
+		for len(stream.messages) >= m.execOutputQueueLength && !q.stopped {
+			if !time.Now().Before(deadline) {
+				log.Printf("Client of session %s read no output of exec stream %s for %v, discarding it",
+					tunnel.ID, output.StreamID, m.execSlowReaderTimeout)
+				q.discard(output.StreamID)
+				return false
+			}
+			q.cond.Wait()
+		}
+	}
+	if q.stopped {
+		return true
+	}
+
+	if len(stream.messages) == 0 && !stream.sending {
+		q.ready = append(q.ready, output.StreamID)
+	}
+	stream.messages = append(stream.messages, output)
+	q.cond.Broadcast()
+	return true
+}
+
+// discard drops a stream's waiting output; a batch being sent still goes out
+func (q *outputQueue) discard(streamID string) {
+	q.streams[streamID].messages = nil
+	ready := q.ready[:0]
+	for _, id := range q.ready {
+		if id != streamID {
+			ready = append(ready, id)
+		}
+	}
+	q.ready = ready
+	q.cond.Broadcast()
+}
+
+// drainOutput waits until a finished stream's output has been sent, so its
+// exit code cannot overtake it, and forgets the stream
+func (m *Manager) drainOutput(tunnel *Tunnel, streamID string) {
+	q := &tunnel.output
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if !q.running {
+		return
+	}
+	for !q.stopped {
+		stream, exists := q.streams[streamID]
+		if !exists || (len(stream.messages) == 0 && !stream.sending) {
+			break
+		}
+		q.cond.Wait()
+	}
+	delete(q.streams, streamID)
+}
+
+// writeOutput sends queued output until the tunnel closes, taking up to its
+// weight of messages from each ready stream in turn
+func (m *Manager) writeOutput(tunnel *Tunnel) {
+	q := &tunnel.output
+	for {
+		q.mutex.Lock()
+		for len(q.ready) == 0 && !q.stopped {
+			q.cond.Wait()
+		}
+		if q.stopped {
+			q.mutex.Unlock()
+			return
+		}
+
+		streamID := q.ready[0]
+		q.ready = q.ready[1:]
+		stream := q.streams[streamID]
+		n := min(stream.weight, len(stream.messages))
+		batch := append([]types.ExecOutput(nil), stream.messages[:n]...)
+		stream.messages = stream.messages[n:]
+		stream.sending = true
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+
+		for _, output := range batch {
+			m.sendMessage(tunnel, types.TunnelMessage{Type: "exec_output", Payload: output})
+		}
+
+		q.mutex.Lock()
+		stream.sending = false
+		if len(stream.messages) > 0 {
+			q.ready = append(q.ready, streamID)
+		}
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+	}
+}
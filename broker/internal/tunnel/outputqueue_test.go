@@ -0,0 +1,194 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// serverTunnel returns the tunnel the test server registered for testSession
+func serverTunnel(t *testing.T, manager *Manager) *Tunnel {
+	t.Helper()
+
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	tunnel := manager.tunnels[testSession().ID]
+	if tunnel == nil {
+		t.Fatal("Expected tunnel to be registered")
+	}
+	return tunnel
+}
+
+func TestOutputQueue_RoundRobin(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{ExecTTYWeight: 2})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	tunnel := serverTunnel(t, manager)
+
+	// Hold writes until all output is queued
+	tunnel.mutex.Lock()
+	for _, data := range []string{"b1", "b2", "b3"} {
+		manager.queueOutput(tunnel, types.ExecOutput{StreamID: "build", Data: data}, false)
+	}
+	for _, data := range []string{"t1", "t2", "t3"} {
+		manager.queueOutput(tunnel, types.ExecOutput{StreamID: "terminal", Data: data}, true)
+	}
+	manager.queueOutput(tunnel, types.ExecOutput{StreamID: "lsp", Data: "l1"}, false)
+	tunnel.mutex.Unlock()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []string
+	for len(got) < 7 {
+		var response types.TunnelMessage
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Fatalf("Expected exec_output, got %v", err)
+		}
+		payload, _ := response.Payload.(map[string]interface{})
+		data, _ := payload["data"].(string)
+		got = append(got, data)
+	}
+
+	want := []string{"b1", "t1", "t2", "l1", "b2", "t3", "b3"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected streams to take turns as %v, got %v", want, got)
+		}
+	}
+}
+
+func TestOutputQueue_Backpressure(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	tunnel := serverTunnel(t, manager)
+
+	tunnel.mutex.Lock()
+	manager.queueOutput(tunnel, types.ExecOutput{StreamID: "build", Data: "sending"}, false)
+	waitFor(t, func() bool {
+		tunnel.output.mutex.Lock()
+		defer tunnel.output.mutex.Unlock()
+		return tunnel.output.streams["build"].sending
+	})
+	for i := 0; i < manager.execOutputQueueLength; i++ {
+		manager.queueOutput(tunnel, types.ExecOutput{StreamID: "build", Data: "queued"}, false)
+	}
+
+	queued := make(chan struct{})
+	go func() {
+		manager.queueOutput(tunnel, types.ExecOutput{StreamID: "build", Data: "blocked"}, false)
+		close(queued)
+	}()
+	select {
+	case <-queued:
+		t.Fatal("Expected output to block while the stream's queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tunnel.mutex.Unlock()
+	select {
+	case <-queued:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected output to be queued once the writer caught up")
+	}
+
+	manager.drainOutput(tunnel, "build")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for i := 0; i < manager.execOutputQueueLength+2; i++ {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("Expected all queued output to be sent, got %v", err)
+		}
+	}
+}
+
+func TestExecSet_Limit(t *testing.T) {
+	execs := execSet{limit: 1}
+	if _, err := execs.add(context.Background(), "terminal", []string{"/bin/sh"}); err != nil {
+		t.Fatalf("Expected first stream to start, got %v", err)
+	}
+	if _, err := execs.add(context.Background(), "build", []string{"make"}); err == nil {
+		t.Fatal("Expected a stream beyond the limit to be refused")
+	}
+
+	execs.remove("terminal")
+	if _, err := execs.add(context.Background(), "build", []string{"make"}); err != nil {
+		t.Errorf("Expected a stream to start after another finished, got %v", err)
+	}
+}
+
+func TestOutputQueue_SlowReader(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{
+		ExecFlushBufferSize:   1,
+		ExecOutputQueueLength: 1,
+		ExecSlowReaderTimeout: 100 * time.Millisecond,
+	})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	tunnel := serverTunnel(t, manager)
+
+	queued := func(want int, sending bool) func() bool {
+		return func() bool {
+			tunnel.output.mutex.Lock()
+			defer tunnel.output.mutex.Unlock()
+			stream := tunnel.output.streams["cat"]
+			return stream != nil && len(stream.messages) == want && stream.sending == sending
+		}
+	}
+
+	// The client stops reading: nothing is written to it until unlocked
+	tunnel.mutex.Lock()
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "cat", "stream_id": "cat", "stdin": true, "stdout": true, "stream": true},
+	})
+	waitFor(t, queued(0, true))
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_stdin", Payload: types.ExecStdin{StreamID: "cat", Data: "discarded"}})
+	waitFor(t, queued(1, true))
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_stdin", Payload: types.ExecStdin{StreamID: "cat", Data: "blocked"}})
+
+	// The stream is cancelled once its queue has stayed full for the timeout
+	waitFor(t, func() bool {
+		tunnel.execs.mutex.Lock()
+		defer tunnel.execs.mutex.Unlock()
+		stream := tunnel.execs.streams["cat"]
+		return stream != nil && stream.cancelled
+	})
+	waitFor(t, queued(0, true))
+	tunnel.mutex.Unlock()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []string
+	for {
+		var response types.TunnelMessage
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Fatalf("Expected exec_cancelled, got %v", err)
+		}
+		payload, _ := response.Payload.(map[string]interface{})
+		if response.Type == "exec_cancelled" {
+			if payload["stream_id"] != "cat" || payload["reason"] != "slow_reader" {
+				t.Errorf("Expected cat cancelled as a slow reader, got %v", payload)
+			}
+			break
+		}
+		if response.Type != "exec_output" {
+			t.Fatalf("Expected exec_output, got %s %v", response.Type, response.Payload)
+		}
+		data, _ := payload["data"].(string)
+		got = append(got, data)
+	}
+	if len(got) != 1 || got[0] != "Executed: cat" {
+		t.Errorf("Expected only the output sent before the client stalled, got %q", got)
+	}
+
+	// Other streams keep working
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "ls", "stream_id": "ls", "stdout": true},
+	})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+		t.Errorf("Expected exec_response, got %s %v", response.Type, err)
+	}
+}
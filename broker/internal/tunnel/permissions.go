@@ -0,0 +1,139 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+var (
+	// octalModePattern matches chmod's octal modes, e.g. 755 or 0644
+	octalModePattern = regexp.MustCompile(`^[0-7]{1,4}$`)
+
+	// symbolicModePattern matches chmod's symbolic modes, e.g. u+x or go-w,a+rX
+	symbolicModePattern = regexp.MustCompile(`^[ugoa]*([-+=]([rwxXst]*|[ugo]))+(,[ugoa]*([-+=]([rwxXst]*|[ugo]))+)*$`)
+
+	// ownerPattern matches chown's user[:group] and :group, by name or ID
+	ownerPattern = regexp.MustCompile(`^([A-Za-z0-9_][A-Za-z0-9_.-]*)?(:([A-Za-z0-9_][A-Za-z0-9_.-]*)?)?$`)
+)
+
+// permissionScript runs chmod or chown with its argument on a path and prints
+// the path's stat record afterwards. With a root, the path must resolve,
+// symlinks included, to the root or beneath it. Paths are arguments, never
+// part of the script, so they need no quoting.
+const permissionScript = `export LC_ALL=C
+op=$1; root=$2; p=$3; arg=$4
+if [ -n "$root" ]; then
+	r=$(realpath -- "$p") && root=$(realpath -- "$root") || exit 1
+	case "$r" in
+	"$root"|"$root"/*) ;;
+	*) echo "$p resolves outside $root" >&2; exit 1 ;;
+	esac
+fi
+"$op" -- "$arg" "$p" && stat -c '` + statFormat + `' -- "$p"`
+
+// changeMode runs chmod on the request's path with its octal or symbolic mode
+func (m *Manager) changeMode(ctx context.Context, tunnel *Tunnel, req types.FileOperation) *types.FileOperationResponse {
+	if !octalModePattern.MatchString(req.Mode) && !symbolicModePattern.MatchString(req.Mode) {
+		return fileError(fmt.Errorf("invalid mode %q, expected octal such as 755 or symbolic such as u+x", req.Mode))
+	}
+	return m.changePermissions(ctx, tunnel, "chmod", req.Path, req.Mode)
+}
+
+// changeOwner runs chown on the request's path, if enabled. Ownership
+// changes usually need the container to run as root, and every attempt is
+// audit logged.
+func (m *Manager) changeOwner(ctx context.Context, tunnel *Tunnel, req types.FileOperation) *types.FileOperationResponse {
+	if !m.allowChown {
+		return fileError(fmt.Errorf("chown is disabled on this broker"))
+	}
+	if req.Owner == "" || req.Owner == ":" || !ownerPattern.MatchString(req.Owner) {
+		return fileError(fmt.Errorf("invalid owner %q, expected user, user:group or :group", req.Owner))
+	}
+
+	resp := m.changePermissions(ctx, tunnel, "chown", req.Path, req.Owner)
+	outcome := "ok"
+	if !resp.Success {
+		outcome = "failed: " + resp.Error
+	}
+	log.Printf("Audit: user %s chown %s to %s in pod %s/%s: %s",
+		tunnel.Session.UserID, req.Path, req.Owner,
+		tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name, outcome)
+	return resp
+}
+
+// changePermissions runs permissionScript for op and reports the path's
+// resulting stat record
+func (m *Manager) changePermissions(ctx context.Context, tunnel *Tunnel, op, filePath, arg string) *types.FileOperationResponse {
+	filePath, err := m.confinePermissionPath(filePath)
+	if err != nil {
+		return fileError(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"sh", "-c", permissionScript, "sh", op, m.permissionsRoot, filePath, arg},
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if isPermissionDenied(msg) {
+			hint := ""
+			if op == "chown" {
+				hint = "; changing ownership usually requires the container to run as root"
+			}
+			return fileError(fmt.Errorf("permission denied running %s on %s: %s%s", op, filePath, msg, hint))
+		}
+		if msg != "" {
+			return fileError(fmt.Errorf("failed to %s %s: %s", op, filePath, msg))
+		}
+		return fileError(fmt.Errorf("failed to %s %s: %w", op, filePath, err))
+	}
+
+	info, ok := parseStatLine(strings.TrimSpace(stdout.String()))
+	if !ok {
+		return fileError(fmt.Errorf("unexpected stat output for %s", filePath))
+	}
+	return &types.FileOperationResponse{Success: true, Mode: info.Mode, Stat: &info}
+}
+
+// confinePermissionPath cleans an absolute path and checks it lies within
+// the permissions root, if one is set. The pod checks again once symlinks
+// are resolved.
+func (m *Manager) confinePermissionPath(filePath string) (string, error) {
+	if !path.IsAbs(filePath) {
+		return "", fmt.Errorf("path %q must be absolute", filePath)
+	}
+	filePath = path.Clean(filePath)
+	if m.permissionsRoot != "" && filePath != m.permissionsRoot && !strings.HasPrefix(filePath, m.permissionsRoot+"/") {
+		return "", fmt.Errorf("path %s is outside %s", filePath, m.permissionsRoot)
+	}
+	return filePath, nil
+}
+
+// isPermissionDenied reports whether a chmod or chown error means the
+// container's user may not change the file
+func isPermissionDenied(msg string) bool {
+	return strings.Contains(msg, "Operation not permitted") || strings.Contains(msg, "Permission denied")
+}
+
+// permissionsRoot cleans the configured root, where "/" confines nothing
+func permissionsRoot(root string) string {
+	if root == "" {
+		return ""
+	}
+	if root = path.Clean(root); root == "/" {
+		return ""
+	}
+	return root
+}
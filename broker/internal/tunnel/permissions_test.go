@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_ChangeMode(t *testing.T) {
+	root := t.TempDir()
+	script := filepath.Join(root, "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManager(localExec(t), ManagerConfig{PermissionsRoot: root})
+	tunnel := testTunnel()
+
+	tests := []struct {
+		name         string
+		path         string
+		mode         string
+		wantMode     string
+		wantSymbolic string
+		wantErr      string
+	}{
+		{name: "octal", path: script, mode: "755", wantMode: "0755", wantSymbolic: "-rwxr-xr-x"},
+		{name: "symbolic", path: script, mode: "go-rx,u-w", wantMode: "0500", wantSymbolic: "-r-x------"},
+		{name: "invalid mode", path: script, mode: "u+q", wantErr: "invalid mode"},
+		{name: "mode out of range", path: script, mode: "999", wantErr: "invalid mode"},
+		{name: "option injection", path: script, mode: "--reference=/etc/passwd", wantErr: "invalid mode"},
+		{name: "relative path", path: "run.sh", mode: "755", wantErr: "must be absolute"},
+		{name: "outside root", path: filepath.Join(outside, "x"), mode: "755", wantErr: "outside"},
+		{name: "traversal", path: root + "/../x", mode: "755", wantErr: "outside"},
+		{name: "symlink out of root", path: filepath.Join(root, "escape"), mode: "777", wantErr: "resolves outside"},
+		{name: "missing", path: filepath.Join(root, "missing"), mode: "755", wantErr: "No such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := manager.executeFileOperation(tunnel, types.FileOperation{Operation: "chmod", Path: tt.path, Mode: tt.mode})
+			if tt.wantErr != "" {
+				if resp.Success || !strings.Contains(resp.Error, tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %+v", tt.wantErr, resp)
+				}
+				return
+			}
+			if !resp.Success {
+				t.Fatalf("Expected chmod to succeed, got %s", resp.Error)
+			}
+			if resp.Mode != tt.wantMode || resp.Stat == nil || resp.Stat.ModeSymbolic != tt.wantSymbolic {
+				t.Errorf("Expected mode %s (%s), got %+v", tt.wantMode, tt.wantSymbolic, resp.Stat)
+			}
+		})
+	}
+
+	if info, err := os.Stat(outside); err != nil || info.Mode().Perm() == 0777 {
+		t.Errorf("Expected the directory behind the symlink to be left alone, got %v", info.Mode())
+	}
+}
+
+func TestManager_ChangeOwner(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "data.txt")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tunnel := testTunnel()
+	owner := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+
+	disabled := NewManager(localExec(t), ManagerConfig{})
+	resp, _ := disabled.executeFileOperation(tunnel, types.FileOperation{Operation: "chown", Path: file, Owner: owner})
+	if resp.Success || !strings.Contains(resp.Error, "disabled") {
+		t.Errorf("Expected chown to be disabled by default, got %+v", resp)
+	}
+
+	manager := NewManager(localExec(t), ManagerConfig{AllowChown: true})
+	for _, invalid := range []string{"", ":", "alice;rm", "-R", "alice:bob:carol"} {
+		resp, _ := manager.executeFileOperation(tunnel, types.FileOperation{Operation: "chown", Path: file, Owner: invalid})
+		if resp.Success || !strings.Contains(resp.Error, "invalid owner") {
+			t.Errorf("Expected owner %q to be rejected, got %+v", invalid, resp)
+		}
+	}
+
+	resp, _ = manager.executeFileOperation(tunnel, types.FileOperation{Operation: "chown", Path: file, Owner: owner})
+	if !resp.Success || resp.Mode != "0644" {
+		t.Errorf("Expected chown to its own owner to succeed, got %+v", resp)
+	}
+}
+
+func TestManager_ChangePermissionsDenied(t *testing.T) {
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		io.WriteString(opts.Stderr, fmt.Sprintf("%s: changing ownership of '/etc/hosts': Operation not permitted\n", opts.Command[4]))
+		return errors.New("command terminated with exit code 1")
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{AllowChown: true})
+
+	resp := manager.changeOwner(context.Background(), testTunnel(), types.FileOperation{Operation: "chown", Path: "/etc/hosts", Owner: "jovyan"})
+	if resp.Success || !strings.Contains(resp.Error, "permission denied") || !strings.Contains(resp.Error, "root") {
+		t.Errorf("Expected a permission denied error suggesting root, got %+v", resp)
+	}
+
+	resp = manager.changeMode(context.Background(), testTunnel(), types.FileOperation{Operation: "chmod", Path: "/etc/hosts", Mode: "600"})
+	if resp.Success || !strings.Contains(resp.Error, "permission denied running chmod") {
+		t.Errorf("Expected a permission denied error, got %+v", resp)
+	}
+}
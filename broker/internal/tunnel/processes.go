@@ -0,0 +1,222 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultProcessColumns are the extra ps columns reported for each process
+var DefaultProcessColumns = []string{"user", "rss", "etime"}
+
+// processColumns are the ps columns that may be requested in addition to
+// pid, pcpu, pmem and args. Each prints a single field without spaces, so
+// the output splits on whitespace with args last.
+var processColumns = map[string]bool{
+	"user": true, "uid": true, "group": true, "ppid": true, "pgid": true,
+	"rss": true, "vsz": true, "etime": true, "time": true, "stat": true,
+	"nice": true, "pri": true, "tty": true, "nlwp": true, "psr": true,
+}
+
+// processSignals are the signals process_kill may send
+var processSignals = map[string]bool{
+	"TERM": true, "KILL": true, "INT": true, "HUP": true, "QUIT": true,
+	"STOP": true, "CONT": true, "USR1": true, "USR2": true,
+}
+
+// processTimeout bounds the exec listing or signalling processes
+const processTimeout = 30 * time.Second
+
+// psScript runs ps with its arguments, exiting 127 when ps is not installed
+const psScript = `command -v ps >/dev/null || exit 127; exec ps "$@"`
+
+// ValidateProcessColumns checks the columns can be requested from ps
+func ValidateProcessColumns(columns []string) error {
+	for _, column := range columns {
+		if !processColumns[column] {
+			return fmt.Errorf("unsupported process column %q", column)
+		}
+	}
+	return nil
+}
+
+// handleProcessList lists the processes running in the pod. ps runs off the
+// message loop, so a slow pod does not hold up the tunnel's other messages.
+func (m *Manager) handleProcessList(tunnel *Tunnel, payload interface{}) {
+	var req types.ProcessListRequest
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid processlist request format")
+		return
+	}
+
+	columns := req.Columns
+	if len(columns) == 0 {
+		columns = m.processColumns
+	}
+	if err := ValidateProcessColumns(columns); err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+
+	go func() {
+		defer m.recoverHandler(tunnel, "processlist")
+
+		ctx, cancel := context.WithTimeout(tunnel.ctx, processTimeout)
+		defer cancel()
+		processes, err := m.listProcesses(ctx, tunnel, columns)
+		if err != nil {
+			m.sendError(tunnel, err.Error())
+			return
+		}
+
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type:    "processlist_response",
+			Payload: types.ProcessListResponse{Processes: processes},
+		})
+	}()
+}
+
+// listProcesses runs ps in the pod and parses its output
+func (m *Manager) listProcesses(ctx context.Context, tunnel *Tunnel, columns []string) ([]types.ProcessInfo, error) {
+	format := []string{"pid=", "pcpu=", "pmem="}
+	for _, column := range columns {
+		format = append(format, column+"=")
+	}
+	format = append(format, "args=")
+
+	var stdout, stderr bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"sh", "-c", psScript, "sh", "-eo", strings.Join(format, ",")},
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+	})
+	if code, exited := k8s.ExitCode(err); exited && code == 127 {
+		return nil, fmt.Errorf("listing processes requires ps in the pod, but it is not installed")
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("ps failed in pod: %s", msg)
+		}
+		return nil, fmt.Errorf("ps failed in pod: %w", err)
+	}
+
+	return parseProcesses(stdout.String(), columns), nil
+}
+
+// parseProcesses parses header-less ps output of pid, pcpu, pmem, the extra
+// columns and args
+func parseProcesses(output string, columns []string) []types.ProcessInfo {
+	processes := []types.ProcessInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3+len(columns) {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		cpu, _ := strconv.ParseFloat(fields[1], 64)
+		memory, _ := strconv.ParseFloat(fields[2], 64)
+
+		process := types.ProcessInfo{
+			PID:     pid,
+			CPU:     cpu,
+			Memory:  memory,
+			Command: strings.Join(fields[3+len(columns):], " "),
+		}
+		if len(columns) > 0 {
+			process.Columns = make(map[string]string, len(columns))
+			for i, column := range columns {
+				process.Columns[column] = fields[3+i]
+			}
+		}
+		processes = append(processes, process)
+	}
+	return processes
+}
+
+// handleProcessKill sends a signal to a process in the pod, off the message
+// loop. Every attempt is logged with the user, pod and outcome.
+func (m *Manager) handleProcessKill(tunnel *Tunnel, payload interface{}) {
+	var req types.ProcessKillRequest
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid process_kill request format")
+		return
+	}
+
+	signal := strings.TrimPrefix(strings.ToUpper(req.Signal), "SIG")
+	if signal == "" {
+		signal = "TERM"
+	}
+
+	go func() {
+		defer m.recoverHandler(tunnel, "process_kill")
+
+		ctx, cancel := context.WithTimeout(tunnel.ctx, processTimeout)
+		defer cancel()
+		err := m.killProcess(ctx, tunnel, req.PID, signal)
+		log.Printf("Audit: user %s sent SIG%s to pid %d in pod %s/%s: %v",
+			tunnel.Session.UserID, signal, req.PID,
+			tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name, auditOutcome(err))
+		if err != nil {
+			m.sendError(tunnel, err.Error())
+			return
+		}
+
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type: "process_kill_response",
+			Payload: map[string]interface{}{
+				"pid":    req.PID,
+				"signal": signal,
+			},
+		})
+	}()
+}
+
+// killProcess validates and sends the signal. PID 1 is refused since it is
+// the container's main process, and killing it ends the pod.
+func (m *Manager) killProcess(ctx context.Context, tunnel *Tunnel, pid int, signal string) error {
+	if pid <= 1 {
+		return fmt.Errorf("invalid pid %d", pid)
+	}
+	if !processSignals[signal] {
+		return fmt.Errorf("unsupported signal %q", signal)
+	}
+	if err := m.checkCommandAllowed(tunnel, "kill"); err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"kill", "-s", signal, strconv.Itoa(pid)},
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to signal pid %d: %s", pid, msg)
+		}
+		return fmt.Errorf("failed to signal pid %d: %w", pid, err)
+	}
+	return nil
+}
+
+// auditOutcome describes an operation's result for audit logs
+func auditOutcome(err error) string {
+	if err != nil {
+		return "failed: " + err.Error()
+	}
+	return "ok"
+}
@@ -0,0 +1,153 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	utilexec "k8s.io/utils/exec"
+)
+
+func TestParseProcesses(t *testing.T) {
+	output := `    1  0.0  0.1 jovyan   /usr/bin/python3 -m jupyterhub.singleuser
+  412 98.5 12.3 jovyan   python train.py --epochs 100
+
+  bad line
+`
+
+	processes := parseProcesses(output, []string{"user"})
+	if len(processes) != 2 {
+		t.Fatalf("Expected 2 processes, got %+v", processes)
+	}
+
+	train := processes[1]
+	if train.PID != 412 || train.CPU != 98.5 || train.Memory != 12.3 {
+		t.Errorf("Expected pid 412 at 98.5%% CPU and 12.3%% memory, got %+v", train)
+	}
+	if train.Command != "python train.py --epochs 100" || train.Columns["user"] != "jovyan" {
+		t.Errorf("Expected command and user column, got %+v", train)
+	}
+}
+
+func TestManager_ListProcesses(t *testing.T) {
+	var command []string
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			command = opts.Command
+			fmt.Fprintln(opts.Stdout, "  7  1.5  0.2 1024 sleep 60")
+			return nil
+		},
+	}
+	manager := NewManager(client, ManagerConfig{})
+
+	processes, err := manager.listProcesses(context.Background(), testTunnel(), []string{"rss"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(processes) != 1 || processes[0].Command != "sleep 60" || processes[0].Columns["rss"] != "1024" {
+		t.Fatalf("Expected sleep process, got %+v", processes)
+	}
+	if format := command[len(command)-1]; format != "pid=,pcpu=,pmem=,rss=,args=" {
+		t.Errorf("Expected ps format with args last, got %q", format)
+	}
+}
+
+func TestManager_ListProcessesWithoutPs(t *testing.T) {
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			return utilexec.CodeExitError{Err: fmt.Errorf("command terminated with exit code 127"), Code: 127}
+		},
+	}
+	manager := NewManager(client, ManagerConfig{})
+
+	_, err := manager.listProcesses(context.Background(), testTunnel(), nil)
+	if err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Fatalf("Expected error explaining ps is missing, got %v", err)
+	}
+}
+
+func TestValidateProcessColumns(t *testing.T) {
+	if err := ValidateProcessColumns(DefaultProcessColumns); err != nil {
+		t.Errorf("Expected default columns to be valid, got %v", err)
+	}
+	for _, column := range []string{"args", "lstart", "pid=", "user,rss"} {
+		if err := ValidateProcessColumns([]string{column}); err == nil {
+			t.Errorf("Expected column %q to be rejected", column)
+		}
+	}
+}
+
+func TestManager_KillProcess(t *testing.T) {
+	var command []string
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			command = opts.Command
+			return nil
+		},
+	}
+	manager := NewManager(client, ManagerConfig{})
+
+	if err := manager.killProcess(context.Background(), testTunnel(), 412, "KILL"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(command, " ") != "kill -s KILL 412" {
+		t.Errorf("Expected kill command, got %v", command)
+	}
+
+	tests := []struct {
+		name   string
+		pid    int
+		signal string
+	}{
+		{name: "container init", pid: 1, signal: "TERM"},
+		{name: "negative pid", pid: -1, signal: "TERM"},
+		{name: "unsupported signal", pid: 412, signal: "SEGV"},
+		{name: "signal injection", pid: 412, signal: "TERM; rm -rf /"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command = nil
+			if err := manager.killProcess(context.Background(), testTunnel(), tt.pid, tt.signal); err == nil {
+				t.Fatal("Expected kill to be refused")
+			}
+			if command != nil {
+				t.Errorf("Expected no exec, got %v", command)
+			}
+		})
+	}
+}
+
+func TestManager_ProcessListRunsOffMessageLoop(t *testing.T) {
+	release := make(chan struct{})
+	client := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		if len(opts.Command) > 2 && opts.Command[2] == psScript {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return nil
+		}
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(client, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{Type: "processlist", Payload: types.ProcessListRequest{}})
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+
+	// The loop answers while ps waits on the pod
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var first types.TunnelMessage
+	if err := conn.ReadJSON(&first); err != nil || first.Type != "exec_list_response" {
+		t.Fatalf("Expected exec_list_response before the process list, got %s %v", first.Type, err)
+	}
+
+	close(release)
+	readMessageOfType(t, conn, "processlist_response")
+}
@@ -0,0 +1,37 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// DefaultReconnectBackoff is the delay suggested to clients before
+// reconnecting after a transient close
+const DefaultReconnectBackoff = 2 * time.Second
+
+// retryableCloseReasons are the close reasons a reconnect can recover from.
+// The rest mean another connection holds the session or the session is gone.
+var retryableCloseReasons = map[string]bool{
+	"capacity":      true,
+	"write_timeout": true,
+	"setup_timeout": true,
+	"draining":      true,
+}
+
+// closeWithCode closes conn with a JSON reason telling the client whether to
+// reconnect and how long to wait first. WriteControl may be called
+// concurrently with other writes, so tunnels need no lock.
+func (m *Manager) closeWithCode(conn *websocket.Conn, code int, reason string) {
+	hint := types.CloseReason{Reason: reason, Reconnect: retryableCloseReasons[reason]}
+	if hint.Reconnect {
+		hint.BackoffMs = m.reconnectBackoff.Milliseconds()
+	}
+	// Well below the 123 bytes a close frame leaves for its reason
+	data, _ := json.Marshal(hint)
+
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, string(data)), time.Now().Add(time.Second))
+}
@@ -0,0 +1,78 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// readCloseReason reads from conn until it is closed and decodes the reason
+func readCloseReason(t *testing.T, conn *websocket.Conn, code int) types.CloseReason {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = conn.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != code {
+		t.Fatalf("Expected close code %d, got %v", code, err)
+	}
+
+	var reason types.CloseReason
+	if err := json.Unmarshal([]byte(closeErr.Text), &reason); err != nil {
+		t.Fatalf("Expected a JSON close reason, got %q: %v", closeErr.Text, err)
+	}
+	return reason
+}
+
+func TestManager_CloseReconnectHints(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{MaxTotalTunnels: 1, ReconnectBackoff: 3 * time.Second})
+	server := startTestServer(t, manager, testSession())
+
+	first := dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+
+	// At capacity the client should come back later
+	reason := readCloseReason(t, dialTestServer(t, server), CloseCapacity)
+	if reason.Reason != "capacity" || !reason.Reconnect || reason.BackoffMs != 3000 {
+		t.Errorf("Expected a retryable capacity close with a 3s backoff, got %+v", reason)
+	}
+
+	// A deleted session is gone for good
+	if err := manager.CloseTunnel(testSession().ID); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	reason = readCloseReason(t, first, CloseSessionClosed)
+	if reason.Reason != "session_closed" || reason.Reconnect || reason.BackoffMs != 0 {
+		t.Errorf("Expected a permanent session_closed close, got %+v", reason)
+	}
+}
+
+func TestManager_PodReassigned(t *testing.T) {
+	k8sClient := &fakeK8sClient{podOwner: "bob"}
+	manager := NewManager(k8sClient, ManagerConfig{})
+
+	// Sessions created before owners were recorded are not checked
+	dialReadyTunnel(t, startTestServer(t, manager, testSession())).Close()
+
+	session := testSession()
+	session.ID = "fedcba9876543210"
+	session.PodInfo.Owner = "alice"
+	conn := dialTestServer(t, startTestServer(t, manager, session))
+	reason := readCloseReason(t, conn, ClosePodReassigned)
+	if reason.Reason != "pod_reassigned" || reason.Reconnect {
+		t.Errorf("Expected a permanent pod_reassigned close, got %+v", reason)
+	}
+	if manager.hasTunnel(session.ID) {
+		t.Error("Expected no tunnel for a reassigned pod")
+	}
+	if created, _ := k8sClient.counts(); created != 1 {
+		t.Errorf("Expected no credentials issued for the reassigned pod, got %d issued", created)
+	}
+}
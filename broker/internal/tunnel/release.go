@@ -0,0 +1,144 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// pendingRelease is a closed tunnel's credentials awaiting release
+type pendingRelease struct {
+	session *types.Session
+	creds   *k8s.SessionCredentials
+	timer   *time.Timer
+
+	// issuedAt is when creds were issued, which bounds how long they may
+	// still be reused
+	issuedAt time.Time
+
+	// resumeToken must be presented by the reconnect reusing creds
+	resumeToken string
+}
+
+// releaseSet holds credentials kept for the release grace period, by session
+type releaseSet struct {
+	mutex   sync.Mutex
+	pending map[string]*pendingRelease
+	// closed releases credentials immediately once pending ones were flushed
+	// at shutdown
+	closed bool
+}
+
+// releaseCredentials releases the credentials issued for a tunnel, after the
+// release grace period if one is configured. The request context is typically
+// cancelled by the time the tunnel ends, e.g. by a client disconnect, so only
+// its values are kept and the release gets its own timeout. Credentials past
+// the maximum tunnel lifetime are never kept for reuse.
+func (m *Manager) releaseCredentials(ctx context.Context, session *types.Session, creds *k8s.SessionCredentials, issuedAt time.Time, resumeToken string) {
+	if m.releaseGrace > 0 && time.Since(issuedAt) < m.maxTunnelLifetime &&
+		m.scheduleRelease(session, creds, issuedAt, resumeToken) {
+		return
+	}
+
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), releaseTimeout)
+	defer cancel()
+	m.releaseNow(releaseCtx, session, creds)
+}
+
+func (m *Manager) releaseNow(ctx context.Context, session *types.Session, creds *k8s.SessionCredentials) {
+	if err := m.k8sClient.ReleaseSessionCredentials(ctx, session.PodInfo.Namespace, creds); err != nil {
+		log.Printf("Failed to release k8s credentials for session %s: %v", session.ID, err)
+	}
+}
+
+// scheduleRelease keeps the credentials for the release grace period so a
+// reconnect of the session presenting resumeToken can reuse them. It returns
+// false once pending releases have been flushed for shutdown.
+func (m *Manager) scheduleRelease(session *types.Session, creds *k8s.SessionCredentials, issuedAt time.Time, resumeToken string) bool {
+	m.releases.mutex.Lock()
+	defer m.releases.mutex.Unlock()
+
+	if m.releases.closed {
+		return false
+	}
+	if m.releases.pending == nil {
+		m.releases.pending = make(map[string]*pendingRelease)
+	}
+
+	// Only the latest credentials are kept for reuse
+	if previous, exists := m.releases.pending[session.ID]; exists && previous.timer.Stop() {
+		go m.expireRelease(previous)
+	}
+
+	pending := &pendingRelease{session: session, creds: creds, issuedAt: issuedAt, resumeToken: resumeToken}
+	pending.timer = time.AfterFunc(m.releaseGrace, func() { m.expireRelease(pending) })
+	m.releases.pending[session.ID] = pending
+	return true
+}
+
+// expireRelease releases credentials whose grace period ended, unless a
+// reconnect reclaimed them meanwhile
+func (m *Manager) expireRelease(pending *pendingRelease) {
+	m.releases.mutex.Lock()
+	if m.releases.pending[pending.session.ID] == pending {
+		delete(m.releases.pending, pending.session.ID)
+	}
+	m.releases.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+	m.releaseNow(ctx, pending.session, pending.creds)
+}
+
+// reclaimCredentials returns the credentials of the session's last tunnel and
+// when they were issued if they are still within the release grace period,
+// for the same pod and resumeToken is theirs, or nil if new ones must be
+// issued. Reclaiming uses up the resume token; the new tunnel gets another.
+func (m *Manager) reclaimCredentials(session *types.Session, resumeToken string) (*k8s.SessionCredentials, time.Time) {
+	m.releases.mutex.Lock()
+	defer m.releases.mutex.Unlock()
+
+	pending, exists := m.releases.pending[session.ID]
+	if !exists || pending.session.PodInfo.Namespace != session.PodInfo.Namespace ||
+		pending.session.PodInfo.Name != session.PodInfo.Name {
+		return nil, time.Time{}
+	}
+	if !m.mayResume(pending, resumeToken) {
+		if resumeToken != "" {
+			log.Printf("Audit: session %s (user %s) presented an invalid resume token, issuing new credentials",
+				session.ID, session.UserID)
+		}
+		return nil, time.Time{}
+	}
+	// A timer that already fired is releasing the credentials
+	if !pending.timer.Stop() {
+		return nil, time.Time{}
+	}
+
+	delete(m.releases.pending, session.ID)
+	return pending.creds, pending.issuedAt
+}
+
+// ReleasePending releases all credentials kept for the release grace period
+// without waiting for it to end, and releases any from tunnels closing later
+// immediately. Call it when shutting down so no credentials are left behind.
+func (m *Manager) ReleasePending(ctx context.Context) {
+	m.releases.mutex.Lock()
+	m.releases.closed = true
+	var pending []*pendingRelease
+	for sessionID, release := range m.releases.pending {
+		if release.timer.Stop() {
+			pending = append(pending, release)
+		}
+		delete(m.releases.pending, sessionID)
+	}
+	m.releases.mutex.Unlock()
+
+	for _, release := range pending {
+		m.releaseNow(ctx, release.session, release.creds)
+	}
+}
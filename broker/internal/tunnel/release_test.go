@@ -0,0 +1,105 @@
+package tunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// counts returns how many credentials the fake issued and released
+func (f *fakeK8sClient) counts() (created, released int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.created, len(f.released)
+}
+
+// hasPendingRelease reports whether the session's credentials await release
+func (m *Manager) hasPendingRelease(sessionID string) bool {
+	m.releases.mutex.Lock()
+	defer m.releases.mutex.Unlock()
+
+	_, exists := m.releases.pending[sessionID]
+	return exists
+}
+
+func TestManager_ReconnectReusesCredentialsWithinGrace(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{CredentialReleaseGrace: time.Minute})
+	server := startTestServer(t, manager, testSession())
+
+	first, resumeToken := dialResumedTunnel(t, server, "")
+	first.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+
+	second, _ := dialResumedTunnel(t, server, resumeToken)
+	waitFor(t, func() bool { return manager.hasTunnel(testSession().ID) })
+	if created, released := k8sClient.counts(); created != 1 || released != 0 {
+		t.Fatalf("Expected credentials reused, got %d created and %d released", created, released)
+	}
+
+	// Shutdown releases credentials still held for a reconnect
+	second.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+	manager.ReleasePending(context.Background())
+	if _, released := k8sClient.counts(); released != 1 {
+		t.Fatalf("Expected credentials released at shutdown, got %d releases", released)
+	}
+}
+
+func TestManager_ReleasesCredentialsAfterGrace(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{CredentialReleaseGrace: 200 * time.Millisecond})
+	server := startTestServer(t, manager, testSession())
+
+	conn := dialReadyTunnel(t, server)
+	conn.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+	if _, released := k8sClient.counts(); released != 0 {
+		t.Fatalf("Expected release to wait for the grace period, got %d releases", released)
+	}
+
+	waitFor(t, func() bool {
+		_, released := k8sClient.counts()
+		return released == 1
+	})
+
+	// A reconnect after the grace period issues new credentials
+	dialReadyTunnel(t, server)
+	waitFor(t, func() bool {
+		created, _ := k8sClient.counts()
+		return created == 2
+	})
+}
+
+func TestManager_MaxTunnelLifetime(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{
+		CredentialReleaseGrace: time.Minute,
+		MaxTunnelLifetime:      300 * time.Millisecond,
+	})
+	server := startTestServer(t, manager, testSession())
+
+	// A reconnect reusing the credentials keeps counting from their issue
+	first, resumeToken := dialResumedTunnel(t, server, "")
+	first.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+	second, _ := dialResumedTunnel(t, server, resumeToken)
+
+	reason := readCloseReason(t, second, CloseMaxLifetime)
+	if reason.Reason != "max_lifetime_reached" || reason.Reconnect {
+		t.Errorf("Expected a max_lifetime_reached close, got %+v", reason)
+	}
+
+	// Expired credentials are released rather than kept for a reconnect
+	waitFor(t, func() bool {
+		_, released := k8sClient.counts()
+		return released == 1
+	})
+	if manager.hasPendingRelease(testSession().ID) {
+		t.Error("Expected expired credentials not to be kept for reuse")
+	}
+	if created, _ := k8sClient.counts(); created != 1 {
+		t.Errorf("Expected the reconnect to reuse the credentials, got %d created", created)
+	}
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"log"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+)
+
+// remintRetryInterval is how long a tunnel waits to re-mint its token again
+// after failing to
+const remintRetryInterval = 30 * time.Second
+
+// credentials returns the tunnel's current k8s credentials
+func (t *Tunnel) credentials() *k8s.SessionCredentials {
+	t.credsMutex.RLock()
+	defer t.credsMutex.RUnlock()
+
+	return t.K8sCredentials
+}
+
+// setCredentials replaces the tunnel's k8s credentials, e.g. with a re-minted token
+func (t *Tunnel) setCredentials(creds *k8s.SessionCredentials) {
+	t.credsMutex.Lock()
+	defer t.credsMutex.Unlock()
+
+	t.K8sCredentials = creds
+	t.K8sToken = creds.Token
+}
+
+// remintDelay is how long until a token expiring at expiresAt is re-minted:
+// once four fifths of its remaining lifetime have passed, so a token is
+// replaced well before the expiry the API server actually gave it, whatever
+// lifetime was requested
+func remintDelay(expiresAt, now time.Time) time.Duration {
+	return max(expiresAt.Sub(now)*4/5, 0)
+}
+
+// remintTokens keeps the tunnel's ServiceAccount token valid by minting a new
+// one before each expires, until the tunnel ends. Credentials without an
+// expiry, such as impersonation, are left alone.
+func (m *Manager) remintTokens(tunnel *Tunnel) {
+	var failed bool
+	for {
+		creds := tunnel.credentials()
+		if creds == nil || creds.ExpiresAt.IsZero() {
+			return
+		}
+
+		delay := remintDelay(creds.ExpiresAt, time.Now())
+		if failed {
+			delay = max(delay, remintRetryInterval)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-tunnel.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		refreshed, err := m.k8sClient.RefreshSessionCredentials(tunnel.ctx, tunnel.Session.PodInfo.Namespace, creds)
+		if failed = err != nil; failed {
+			if tunnel.ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to re-mint k8s token for session %s, which expires at %v: %v",
+				tunnel.Session.ID, creds.ExpiresAt.Format(time.RFC3339), err)
+			continue
+		}
+		tunnel.setCredentials(refreshed)
+	}
+}
@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRemintDelay(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      time.Duration
+	}{
+		{name: "fresh token", expiresAt: now.Add(time.Hour), want: 48 * time.Minute},
+		{name: "extended by the API server", expiresAt: now.Add(10 * time.Minute), want: 8 * time.Minute},
+		{name: "expired", expiresAt: now.Add(-time.Minute), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remintDelay(tt.expiresAt, now); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestManager_RemintTokens(t *testing.T) {
+	// The fake API server gives tokens this lifetime whatever was requested
+	const lifetime = 500 * time.Millisecond
+	k8sClient := &fakeK8sClient{tokenLifetime: lifetime}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+
+	connected := time.Now()
+	dialReadyTunnel(t, server)
+	waitFor(t, func() bool { return len(k8sClient.refreshTimes()) >= 2 })
+
+	refreshes := k8sClient.refreshTimes()
+	if first := refreshes[0].Sub(connected); first < lifetime/2 || first >= lifetime {
+		t.Errorf("Expected the first re-mint before the returned expiry, after %v, got %v", lifetime, first)
+	}
+	if gap := refreshes[1].Sub(refreshes[0]); gap < lifetime/2 || gap >= lifetime {
+		t.Errorf("Expected the next re-mint scheduled from the new token's expiry, got %v later", gap)
+	}
+
+	manager.mutex.RLock()
+	tunnel := manager.tunnels[testSession().ID]
+	manager.mutex.RUnlock()
+	if token := tunnel.credentials().Token; token == "k8s-token" {
+		t.Errorf("Expected the tunnel to use the re-minted token, got %q", token)
+	}
+}
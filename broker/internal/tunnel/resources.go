@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"log"
+	"strings"
+)
+
+// parseResourceWrappers splits each configured wrapper, such as
+// "nice -n 10", into its words, skipping empty ones
+func parseResourceWrappers(specs []string) [][]string {
+	var wrappers [][]string
+	for _, spec := range specs {
+		if words := strings.Fields(spec); len(words) > 0 {
+			wrappers = append(wrappers, words)
+		}
+	}
+	return wrappers
+}
+
+// resourceTools are the binaries the resource wrappers run, probed with the
+// pod's other tools
+func (m *Manager) resourceTools() []string {
+	tools := make([]string, 0, len(m.execResourceWrappers))
+	for _, wrapper := range m.execResourceWrappers {
+		tools = append(tools, wrapper[0])
+	}
+	return tools
+}
+
+// setupResourceWrappers chains the resource wrappers whose tools the pod has
+// into the prefix of the tunnel's exec commands. A wrapper whose tool is
+// missing is skipped rather than failing every exec; without a probe result
+// none are used.
+func (m *Manager) setupResourceWrappers(tunnel *Tunnel, capabilities map[string]bool) {
+	for _, wrapper := range m.execResourceWrappers {
+		if !capabilities[wrapper[0]] {
+			log.Printf("Exec resource wrapper %s is not available in pod %s/%s, skipping it",
+				wrapper[0], tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name)
+			continue
+		}
+		tunnel.execPrefix = append(tunnel.execPrefix, wrapper...)
+	}
+}
+
+// resourceCommand wraps an exec request's argv with the tunnel's resource
+// wrappers, so the command runs at lower priority than the notebook
+func (m *Manager) resourceCommand(tunnel *Tunnel, argv []string) []string {
+	if len(tunnel.execPrefix) == 0 {
+		return argv
+	}
+	return append(append([]string(nil), tunnel.execPrefix...), argv...)
+}
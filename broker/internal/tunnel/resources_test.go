@@ -0,0 +1,57 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_ExecResourceWrappers(t *testing.T) {
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			if isCapabilityProbe(opts) {
+				// ionice is missing from the pod
+				fmt.Fprintln(opts.Stdout, "nice")
+				fmt.Fprintln(opts.Stdout, "systemd-run")
+				return nil
+			}
+			return echoExec(ctx, opts)
+		},
+	}
+	manager := NewManager(client, ManagerConfig{
+		ExecResourceWrappers: []string{"nice -n 10", "ionice  -c 3", " ", "systemd-run --user --scope"},
+	})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "ls", "args": []string{"-l"}, "stdout": true},
+	})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+		t.Fatalf("Expected exec_response, got %+v (%v)", response, err)
+	}
+
+	payload, _ := response.Payload.(map[string]interface{})
+	want := "Executed: nice -n 10 systemd-run --user --scope ls -l"
+	if payload["stdout"] != want {
+		t.Errorf("Expected the available wrappers around the command, got %v", payload["stdout"])
+	}
+}
+
+func TestManager_ExecResourceWrappersWithoutProbe(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{ExecResourceWrappers: []string{"nice -n 10"}})
+	tunnel := &Tunnel{Session: testSession()}
+
+	manager.setupResourceWrappers(tunnel, nil)
+	got := manager.resourceCommand(tunnel, []string{"ls"})
+	if len(got) != 1 || got[0] != "ls" {
+		t.Errorf("Expected no wrappers without a probe result, got %v", got)
+	}
+}
@@ -0,0 +1,34 @@
+package tunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// ResumeTokenHeader carries the resume token from a tunnel's ready message
+// when the client reconnects
+const ResumeTokenHeader = "X-Resume-Token"
+
+// newResumeToken generates a tunnel's resume token
+func newResumeToken() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// resumeToken returns the resume token a reconnecting client presented
+func resumeToken(r *http.Request) string {
+	return r.Header.Get(ResumeTokenHeader)
+}
+
+// mayResume reports whether a reconnect presenting token may take over the
+// credentials kept for pending. Without resume tokens any reconnect of the
+// session may.
+func (m *Manager) mayResume(pending *pendingRelease, token string) bool {
+	if m.disableResumeTokens {
+		return true
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(pending.resumeToken), []byte(token)) == 1
+}
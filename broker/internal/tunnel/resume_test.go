@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// dialResumedTunnel dials a tunnel presenting resumeToken, if any, and returns
+// it with the resume token from its ready message
+func dialResumedTunnel(t *testing.T, server *httptest.Server, resumeToken string) (*websocket.Conn, string) {
+	t.Helper()
+
+	header := http.Header{}
+	if resumeToken != "" {
+		header.Set(ResumeTokenHeader, resumeToken)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Expected no error dialing tunnel, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ready struct {
+		Type    string            `json:"type"`
+		Payload types.TunnelReady `json:"payload"`
+	}
+	if err := conn.ReadJSON(&ready); err != nil || ready.Type != "ready" {
+		t.Fatalf("Expected ready message, got %+v (%v)", ready, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	return conn, ready.Payload.ResumeToken
+}
+
+func TestManager_ResumeToken(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{CredentialReleaseGrace: time.Minute})
+	server := startTestServer(t, manager, testSession())
+
+	first, resumeToken := dialResumedTunnel(t, server, "")
+	if resumeToken == "" {
+		t.Fatal("Expected ready to carry a resume token")
+	}
+	first.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+
+	// The session token alone, or a wrong resume token, does not resume
+	for i, presented := range []string{"", strings.Repeat("0", len(resumeToken))} {
+		conn, _ := dialResumedTunnel(t, server, presented)
+		if created, _ := k8sClient.counts(); created != i+2 {
+			t.Fatalf("Expected resume token %q to get new credentials, got %d issued", presented, created)
+		}
+		conn.Close()
+		waitFor(t, func() bool { return !manager.hasTunnel(testSession().ID) })
+	}
+}
+
+func TestManager_ResumeTokenSingleUse(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{CredentialReleaseGrace: time.Minute})
+	server := startTestServer(t, manager, testSession())
+
+	first, resumeToken := dialResumedTunnel(t, server, "")
+	first.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+
+	second, nextToken := dialResumedTunnel(t, server, resumeToken)
+	if created, _ := k8sClient.counts(); created != 1 {
+		t.Fatalf("Expected the resume token to reuse the credentials, got %d issued", created)
+	}
+	if nextToken == "" || nextToken == resumeToken {
+		t.Fatalf("Expected a new resume token after resuming, got %q", nextToken)
+	}
+	second.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+
+	// A replayed token is refused; the reissued one still works
+	replayed, _ := dialResumedTunnel(t, server, resumeToken)
+	if created, _ := k8sClient.counts(); created != 2 {
+		t.Fatalf("Expected a replayed resume token to get new credentials, got %d issued", created)
+	}
+	replayed.Close()
+	waitFor(t, func() bool { return !manager.hasTunnel(testSession().ID) })
+}
+
+func TestManager_ResumeTokensDisabled(t *testing.T) {
+	k8sClient := &fakeK8sClient{}
+	manager := NewManager(k8sClient, ManagerConfig{CredentialReleaseGrace: time.Minute, DisableResumeTokens: true})
+	server := startTestServer(t, manager, testSession())
+
+	first, resumeToken := dialResumedTunnel(t, server, "")
+	if resumeToken != "" {
+		t.Errorf("Expected no resume token, got %q", resumeToken)
+	}
+	first.Close()
+	waitFor(t, func() bool { return manager.hasPendingRelease(testSession().ID) })
+
+	dialResumedTunnel(t, server, "")
+	if created, _ := k8sClient.counts(); created != 1 {
+		t.Errorf("Expected any reconnect of the session to reuse the credentials, got %d issued", created)
+	}
+}
@@ -0,0 +1,381 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// relayBinary is the tool exec'd in the pod to listen for reverse port forward
+// connections. It must be installed in the user's image.
+const relayBinary = "socat"
+
+// maxRelayConnections bounds the connections a reverse port forward relays at
+// once; further connections wait in the pod's listen backlog
+const maxRelayConnections = 16
+
+// relayQueueLength bounds the data messages from the client waiting to be
+// written to a relayed connection. A connection whose pod side reads slower
+// than the client sends is dropped once its queue is full, rather than
+// holding up the tunnel's other messages.
+const relayQueueLength = 64
+
+// relaySet tracks the reverse port forwards active on a tunnel
+type relaySet struct {
+	mutex       sync.Mutex
+	listeners   map[int]*relayListener
+	connections map[string]*relayConnection
+}
+
+// relayListener is a reverse port forward listening on a pod port
+type relayListener struct {
+	cancel context.CancelFunc
+}
+
+// relayConnection is a single pod-side connection relayed to the client
+type relayConnection struct {
+	port   int
+	queue  chan []byte
+	cancel context.CancelFunc
+}
+
+// enqueue hands client data to the connection's writer without blocking,
+// reporting false if its queue is full
+func (c *relayConnection) enqueue(data []byte) bool {
+	select {
+	case c.queue <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeQueued writes queued client data to the relay's stdin until ctx is
+// cancelled, dropping the connection if the relay stops reading
+func (c *relayConnection) writeQueued(ctx context.Context, stdin io.Writer) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-c.queue:
+			if _, err := stdin.Write(data); err != nil {
+				c.cancel()
+				return
+			}
+		}
+	}
+}
+
+// handleReversePortForwardRequest starts listening on a pod port. The relay
+// check and the listening run off the tunnel's message loop; the client hears
+// back once the forward is listening.
+func (m *Manager) handleReversePortForwardRequest(tunnel *Tunnel, payload interface{}) {
+	var req types.ReversePortForwardRequest
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid reverse_portforward request format")
+		return
+	}
+
+	if req.Port <= 0 || req.Port > 65535 {
+		m.sendError(tunnel, fmt.Sprintf("Invalid port: %d", req.Port))
+		return
+	}
+
+	tunnel.relays.mutex.Lock()
+	if tunnel.relays.listeners == nil {
+		tunnel.relays.listeners = make(map[int]*relayListener)
+		tunnel.relays.connections = make(map[string]*relayConnection)
+	}
+	if _, exists := tunnel.relays.listeners[req.Port]; exists {
+		tunnel.relays.mutex.Unlock()
+		m.sendError(tunnel, fmt.Sprintf("Reverse port forward already active on port %d", req.Port))
+		return
+	}
+	if !m.namespaceRelays.acquire(tunnel.Session.PodInfo.Namespace) {
+		tunnel.relays.mutex.Unlock()
+		m.sendNamespaceLimitError(tunnel)
+		return
+	}
+	ctx, cancel := context.WithCancel(tunnel.ctx)
+	listener := &relayListener{cancel: cancel}
+	tunnel.relays.listeners[req.Port] = listener
+	tunnel.relays.mutex.Unlock()
+
+	go m.runReverseListener(ctx, tunnel, req.Port, listener)
+}
+
+// handleReversePortForwardData queues client data for a relayed connection
+func (m *Manager) handleReversePortForwardData(tunnel *Tunnel, payload interface{}) {
+	var req types.ReversePortForwardData
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid reverse_portforward_data format")
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(req.Data)
+	if err != nil {
+		m.sendError(tunnel, "Invalid reverse_portforward_data encoding")
+		return
+	}
+
+	tunnel.relays.mutex.Lock()
+	conn, exists := tunnel.relays.connections[req.ConnectionID]
+	tunnel.relays.mutex.Unlock()
+	if !exists {
+		m.sendError(tunnel, fmt.Sprintf("Unknown connection: %s", req.ConnectionID))
+		return
+	}
+
+	if !conn.enqueue(data) {
+		conn.cancel()
+		m.sendError(tunnel, fmt.Sprintf("Dropped connection %s, the pod side is not reading its data", req.ConnectionID))
+	}
+}
+
+// handleReversePortForwardClose stops a listener or a single relayed connection
+func (m *Manager) handleReversePortForwardClose(tunnel *Tunnel, payload interface{}) {
+	var req types.ReversePortForwardClose
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid reverse_portforward_close format")
+		return
+	}
+
+	tunnel.relays.mutex.Lock()
+	defer tunnel.relays.mutex.Unlock()
+
+	if req.ConnectionID != "" {
+		if conn, exists := tunnel.relays.connections[req.ConnectionID]; exists {
+			conn.cancel()
+		}
+		return
+	}
+
+	if listener, exists := tunnel.relays.listeners[req.Port]; exists {
+		listener.cancel()
+		delete(tunnel.relays.listeners, req.Port)
+	}
+}
+
+// checkRelayAvailable verifies the relay binary is installed in the pod
+func (m *Manager) checkRelayAvailable(ctx context.Context, tunnel *Tunnel) error {
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"sh", "-c", "command -v " + relayBinary},
+		Stdout:    io.Discard,
+	})
+	if _, exited := k8s.ExitCode(err); exited {
+		return fmt.Errorf("reverse port forwarding requires %s in the pod, but it is not installed", relayBinary)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for %s in pod: %v", relayBinary, err)
+	}
+	return nil
+}
+
+// runReverseListener checks the relay is installed, then accepts pod-side
+// connections until ctx is cancelled. Each connection is served by its own
+// relay exec, which exits when the connection closes; the next relay starts
+// listening as soon as one accepts, so up to maxRelayConnections connections
+// are relayed at once.
+func (m *Manager) runReverseListener(ctx context.Context, tunnel *Tunnel, port int, listener *relayListener) {
+	defer m.recoverHandler(tunnel, "reverse_portforward")
+	defer m.namespaceRelays.release(tunnel.Session.PodInfo.Namespace)
+	defer func() {
+		listener.cancel()
+		tunnel.relays.mutex.Lock()
+		if tunnel.relays.listeners[port] == listener {
+			delete(tunnel.relays.listeners, port)
+		}
+		tunnel.relays.mutex.Unlock()
+	}()
+
+	if err := m.checkRelayAvailable(ctx, tunnel); err != nil {
+		if ctx.Err() == nil {
+			m.sendError(tunnel, err.Error())
+		}
+		return
+	}
+	m.sendMessage(tunnel, types.TunnelMessage{
+		Type: "reverse_portforward_response",
+		Payload: map[string]interface{}{
+			"port":   port,
+			"status": "listening",
+		},
+	})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	slots := make(chan struct{}, maxRelayConnections)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+
+		accepted := make(chan struct{})
+		failed := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer m.recoverHandler(tunnel, "reverse_portforward")
+			defer func() { <-slots }()
+			if err := m.relayConnection(ctx, tunnel, port, accepted); err != nil {
+				failed <- err
+			}
+		}()
+
+		select {
+		case <-accepted:
+		case err := <-failed:
+			if ctx.Err() == nil {
+				m.sendError(tunnel, fmt.Sprintf("Reverse port forward on port %d failed: %v", port, err))
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// relayConnection waits for one connection on the pod port and pipes it over
+// the tunnel, closing accepted once the connection is accepted
+func (m *Manager) relayConnection(ctx context.Context, tunnel *Tunnel, port int, accepted chan<- struct{}) error {
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	connectionID := uuid.New().String()
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinReader.Close()
+	defer stdinWriter.Close()
+
+	conn := &relayConnection{
+		port:   port,
+		queue:  make(chan []byte, relayQueueLength),
+		cancel: cancel,
+	}
+	tunnel.relays.mutex.Lock()
+	tunnel.relays.connections[connectionID] = conn
+	tunnel.relays.mutex.Unlock()
+	go conn.writeQueued(connCtx, stdinWriter)
+
+	defer func() {
+		tunnel.relays.mutex.Lock()
+		delete(tunnel.relays.connections, connectionID)
+		tunnel.relays.mutex.Unlock()
+	}()
+
+	// socat logs accepted connections at -d -d, which announces the connection
+	// to the client before any data flows
+	var wasAccepted atomic.Bool
+	stderr := &lineWatcher{match: []byte("accepting connection"), onMatch: func() {
+		wasAccepted.Store(true)
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type: "reverse_portforward_connection",
+			Payload: map[string]interface{}{
+				"port":          port,
+				"connection_id": connectionID,
+			},
+		})
+		close(accepted)
+	}}
+
+	stdout := &relayWriter{manager: m, tunnel: tunnel, connectionID: connectionID}
+
+	// The previous relay closes its listening socket just after accepting, so
+	// binding is retried briefly rather than failing the forward
+	err := m.k8sClient.Exec(connCtx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{relayBinary, "-d", "-d", "TCP-LISTEN:" + strconv.Itoa(port) + ",reuseaddr,retry=10,interval=0.1", "STDIO"},
+		Stdin:     stdinReader,
+		Stdout:    stdout,
+		Stderr:    stderr,
+	})
+
+	if wasAccepted.Load() {
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type: "reverse_portforward_closed",
+			Payload: map[string]interface{}{
+				"port":          port,
+				"connection_id": connectionID,
+			},
+		})
+		// A relayed connection ending is the normal case, keep listening
+		return nil
+	}
+
+	if err == nil {
+		err = fmt.Errorf("relay exited before accepting a connection")
+	}
+	return err
+}
+
+// relayWriter forwards pod output for a relayed connection to the client
+type relayWriter struct {
+	manager      *Manager
+	tunnel       *Tunnel
+	connectionID string
+}
+
+func (w *relayWriter) Write(p []byte) (int, error) {
+	w.manager.sendMessage(w.tunnel, types.TunnelMessage{
+		Type: "reverse_portforward_data",
+		Payload: types.ReversePortForwardData{
+			ConnectionID: w.connectionID,
+			Data:         base64.StdEncoding.EncodeToString(p),
+		},
+	})
+	return len(p), nil
+}
+
+// lineWatcher calls onMatch once when a written line contains match
+type lineWatcher struct {
+	match   []byte
+	onMatch func()
+	matched bool
+	buf     []byte
+}
+
+func (w *lineWatcher) Write(p []byte) (int, error) {
+	if w.matched {
+		return len(p), nil
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if bytes.Contains(line, w.match) {
+			w.matched = true
+			w.buf = nil
+			w.onMatch()
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// decodePayload converts a generic message payload into a typed request
+func decodePayload(payload interface{}, v interface{}) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payloadBytes, v)
+}
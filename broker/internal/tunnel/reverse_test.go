@@ -0,0 +1,181 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeRelay stands in for socat in the pod: each relay exec waits for a
+// connection on accepts, announces it like socat -d -d and then echoes the
+// connection's input back, unless it is told not to read
+type fakeRelay struct {
+	accepts   chan struct{}
+	noReading bool
+}
+
+func (r *fakeRelay) exec(ctx context.Context, opts k8s.ExecOptions) error {
+	if opts.Command[0] != relayBinary {
+		return echoExec(ctx, opts)
+	}
+
+	select {
+	case <-r.accepts:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	io.WriteString(opts.Stderr, "2026/10/16 12:00:00 socat[42] N accepting connection from AF=2 127.0.0.1:40000\n")
+	if r.noReading {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(opts.Stdout, opts.Stdin)
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// readMessageOfType reads tunnel messages until one of the given type arrives
+func readMessageOfType(t *testing.T, conn *websocket.Conn, messageType string) map[string]interface{} {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var message types.TunnelMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Expected a %s message, got %v", messageType, err)
+		}
+		if message.Type == messageType {
+			payload, _ := message.Payload.(map[string]interface{})
+			return payload
+		}
+	}
+}
+
+func startReverseForward(t *testing.T, relay *fakeRelay) (*Manager, *websocket.Conn) {
+	t.Helper()
+
+	manager := NewManager(&fakeK8sClient{execFunc: relay.exec}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "reverse_portforward",
+		Payload: types.ReversePortForwardRequest{Port: 5678},
+	})
+	if payload := readMessageOfType(t, conn, "reverse_portforward_response"); payload["status"] != "listening" {
+		t.Fatalf("Expected the forward to be listening, got %v", payload)
+	}
+	return manager, conn
+}
+
+func TestManager_ReversePortForward(t *testing.T) {
+	relay := &fakeRelay{accepts: make(chan struct{})}
+	manager, conn := startReverseForward(t, relay)
+
+	// Connections are relayed concurrently
+	relay.accepts <- struct{}{}
+	first, _ := readMessageOfType(t, conn, "reverse_portforward_connection")["connection_id"].(string)
+	relay.accepts <- struct{}{}
+	second, _ := readMessageOfType(t, conn, "reverse_portforward_connection")["connection_id"].(string)
+	if first == "" || second == "" || first == second {
+		t.Fatalf("Expected two distinct connections, got %q and %q", first, second)
+	}
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type: "reverse_portforward_data",
+		Payload: types.ReversePortForwardData{
+			ConnectionID: second,
+			Data:         base64.StdEncoding.EncodeToString([]byte("ping")),
+		},
+	})
+	payload := readMessageOfType(t, conn, "reverse_portforward_data")
+	data, _ := base64.StdEncoding.DecodeString(payload["data"].(string))
+	if payload["connection_id"] != second || string(data) != "ping" {
+		t.Errorf("Expected ping relayed back on %s, got %v", second, payload)
+	}
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "reverse_portforward_close",
+		Payload: types.ReversePortForwardClose{Port: 5678},
+	})
+	closed := map[interface{}]bool{}
+	for len(closed) < 2 {
+		closed[readMessageOfType(t, conn, "reverse_portforward_closed")["connection_id"]] = true
+	}
+	if !closed[first] || !closed[second] {
+		t.Errorf("Expected both connections closed with the forward, got %v", closed)
+	}
+	waitFor(t, func() bool {
+		return manager.namespaceRelays.count(testSession().PodInfo.Namespace) == 0
+	})
+}
+
+func TestManager_ReversePortForwardSlowReader(t *testing.T) {
+	relay := &fakeRelay{accepts: make(chan struct{}, 1), noReading: true}
+	_, conn := startReverseForward(t, relay)
+
+	relay.accepts <- struct{}{}
+	id, _ := readMessageOfType(t, conn, "reverse_portforward_connection")["connection_id"].(string)
+
+	// The relay never reads, so the queue fills instead of blocking the tunnel
+	for i := 0; i <= relayQueueLength+1; i++ {
+		conn.WriteJSON(types.TunnelMessage{
+			Type: "reverse_portforward_data",
+			Payload: types.ReversePortForwardData{
+				ConnectionID: id,
+				Data:         base64.StdEncoding.EncodeToString([]byte("data")),
+			},
+		})
+	}
+	readMessageOfType(t, conn, "error")
+	if payload := readMessageOfType(t, conn, "reverse_portforward_closed"); payload["connection_id"] != id {
+		t.Errorf("Expected the slow connection to be dropped, got %v", payload)
+	}
+
+	conn.WriteJSON(types.TunnelMessage{Type: "exec_list"})
+	readMessageOfType(t, conn, "exec_list_response")
+}
+
+func TestManager_ReversePortForwardWithoutRelay(t *testing.T) {
+	k8sClient := &fakeK8sClient{execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+		if opts.Command[0] == "sh" && opts.Command[2] == "command -v "+relayBinary {
+			return utilexec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1}
+		}
+		return echoExec(ctx, opts)
+	}}
+	manager := NewManager(k8sClient, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "reverse_portforward",
+		Payload: types.ReversePortForwardRequest{Port: 5678},
+	})
+	if payload := readMessageOfType(t, conn, "error"); payload["error"] == nil {
+		t.Errorf("Expected an error without socat, got %v", payload)
+	}
+	waitFor(t, func() bool {
+		manager.mutex.RLock()
+		tunnel := manager.tunnels[testSession().ID]
+		manager.mutex.RUnlock()
+		tunnel.relays.mutex.Lock()
+		defer tunnel.relays.mutex.Unlock()
+		return len(tunnel.relays.listeners) == 0
+	})
+}
@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// Mechanisms for running exec commands as another user in the pod. Kubernetes
+// exec always runs as the container's user, so the command is wrapped.
+const (
+	RunAsRunuser = "runuser"
+	RunAsSu      = "su"
+	RunAsSetpriv = "setpriv"
+)
+
+// runAsUserName matches user names and numeric UIDs accepted for run_as_user
+var runAsUserName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// runAsProbe checks the switching tool is installed and resolves the user
+// ($0) to its UID and name, exiting 127 without the tool and 1 for an
+// unknown user
+const runAsProbe = `command -v "$1" >/dev/null 2>&1 || exit 127; id -u -- "$0" 2>/dev/null && id -nu -- "$0" 2>/dev/null || exit 1`
+
+// errRunAsRoot refuses switching to root, which would let run_as_user lift a
+// non-root container's commands to full privileges
+var errRunAsRoot = errors.New("running commands as root is not allowed")
+
+// isRootUser reports whether user names root or UID 0, in any spelling
+func isRootUser(user string) bool {
+	return user == "root" || strings.Trim(user, "0") == ""
+}
+
+// validateRunAsUser checks a run_as_user request is allowed and well formed
+func (m *Manager) validateRunAsUser(user string) error {
+	if user == "" {
+		return nil
+	}
+	if m.runAsMechanism == "" {
+		return fmt.Errorf("running commands as another user is not enabled on this broker")
+	}
+	if !runAsUserName.MatchString(user) {
+		return fmt.Errorf("invalid user %q", user)
+	}
+	if isRootUser(user) {
+		return errRunAsRoot
+	}
+	return nil
+}
+
+// resolveRunAsUser confirms the switching tool is installed and the user
+// exists in the pod, replacing a UID with the user's name, and audits the
+// switch. Other names for UID 0 are refused like root.
+func (m *Manager) resolveRunAsUser(ctx context.Context, tunnel *Tunnel, req types.ExecRequest) (types.ExecRequest, error) {
+	if req.RunAsUser == "" {
+		return req, nil
+	}
+
+	var stdout bytes.Buffer
+	err := m.k8sClient.Exec(ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Container: req.Container,
+		Command:   []string{"sh", "-c", runAsProbe, req.RunAsUser, m.runAsMechanism},
+		Stdout:    &stdout,
+	})
+	switch code, exited := k8s.ExitCode(err); {
+	case exited && code == 127:
+		return req, fmt.Errorf("running as another user requires %s in the pod, but it is not installed", m.runAsMechanism)
+	case exited:
+		return req, fmt.Errorf("user %q does not exist in the pod", req.RunAsUser)
+	case err != nil:
+		return req, fmt.Errorf("failed to look up user %q in pod: %v", req.RunAsUser, err)
+	}
+
+	uid, name, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	if isRootUser(strings.TrimSpace(uid)) {
+		return req, errRunAsRoot
+	}
+	if name = strings.TrimSpace(name); name != "" {
+		req.RunAsUser = name
+	}
+	log.Printf("Audit: user %s exec in pod %s/%s as %s via %s: %s",
+		tunnel.Session.UserID, tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name,
+		req.RunAsUser, m.runAsMechanism, req.Command)
+	return req, nil
+}
+
+// runAsCommand wraps argv to run as user with the configured mechanism
+func (m *Manager) runAsCommand(user string, argv []string) []string {
+	switch m.runAsMechanism {
+	case RunAsSu:
+		// su passes the arguments after the user to the shell's -c script
+		return append([]string{"su", "-s", m.execShell, "-c", execWithArgs, user}, argv...)
+	case RunAsSetpriv:
+		return append([]string{"setpriv", "--reuid=" + user, "--regid=" + user, "--init-groups", "--"}, argv...)
+	default:
+		return append([]string{"runuser", "-u", user, "--"}, argv...)
+	}
+}
@@ -0,0 +1,106 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+	utilexec "k8s.io/utils/exec"
+)
+
+func TestManager_RunAsCommand(t *testing.T) {
+	req := types.ExecRequest{Command: "pip", Args: []string{"install", "x"}, Env: map[string]string{"A": "1"}, RunAsUser: "jovyan"}
+
+	tests := []struct {
+		mechanism string
+		want      []string
+	}{
+		{RunAsRunuser, []string{"runuser", "-u", "jovyan", "--", "env", "A=1", "pip", "install", "x"}},
+		{RunAsSu, []string{"su", "-s", DefaultExecShell, "-c", execWithArgs, "jovyan", "env", "A=1", "pip", "install", "x"}},
+		{RunAsSetpriv, []string{"setpriv", "--reuid=jovyan", "--regid=jovyan", "--init-groups", "--", "env", "A=1", "pip", "install", "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			manager := NewManager(&fakeK8sClient{}, ManagerConfig{RunAsMechanism: tt.mechanism})
+			if got := manager.execCommand(req); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestManager_ValidateRunAsUser(t *testing.T) {
+	disabled := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	if err := disabled.validateRunAsUser("jovyan"); err == nil {
+		t.Error("Expected run_as_user to be refused when no mechanism is configured")
+	}
+	if err := disabled.validateRunAsUser(""); err != nil {
+		t.Errorf("Expected requests without run_as_user to pass, got %v", err)
+	}
+
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{RunAsMechanism: RunAsRunuser})
+	for _, user := range []string{"jovyan", "1000", "first.last"} {
+		if err := manager.validateRunAsUser(user); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", user, err)
+		}
+	}
+	for _, user := range []string{"-u", "root;id", "a b", "../x", "root", "0", "000"} {
+		if err := manager.validateRunAsUser(user); err == nil {
+			t.Errorf("Expected %q to be rejected", user)
+		}
+	}
+}
+
+func TestManager_ResolveRunAsUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		exitCode int
+		output   string
+		wantUser string
+		wantErr  string
+	}{
+		{name: "resolves UID to name", output: "1000\njovyan\n", wantUser: "jovyan"},
+		{name: "another name for root", output: "0\ntoor\n", wantErr: "as root"},
+		{name: "tool missing", exitCode: 127, wantErr: "not installed"},
+		{name: "unknown user", exitCode: 1, wantErr: "does not exist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probe []string
+			client := &fakeK8sClient{
+				execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+					probe = opts.Command
+					if tt.exitCode != 0 {
+						return utilexec.CodeExitError{Err: fmt.Errorf("exit %d", tt.exitCode), Code: tt.exitCode}
+					}
+					fmt.Fprint(opts.Stdout, tt.output)
+					return nil
+				},
+			}
+			manager := NewManager(client, ManagerConfig{RunAsMechanism: RunAsSetpriv})
+
+			req, err := manager.resolveRunAsUser(context.Background(), testTunnel(), types.ExecRequest{Command: "id", RunAsUser: "1000"})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if req.RunAsUser != tt.wantUser {
+				t.Errorf("Expected user %q, got %q", tt.wantUser, req.RunAsUser)
+			}
+			if want := []string{"sh", "-c", runAsProbe, "1000", RunAsSetpriv}; !reflect.DeepEqual(probe, want) {
+				t.Errorf("Expected probe %q, got %q", want, probe)
+			}
+		})
+	}
+}
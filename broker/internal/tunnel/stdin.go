@@ -0,0 +1,227 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// maxPendingStdin bounds the stdin a stream buffers before its command reads
+// it, so a client cannot grow the broker's memory by writing to a command
+// that never reads
+const maxPendingStdin = 1 << 20
+
+// ttyEOF is the terminal's end-of-file character, Ctrl-D
+const ttyEOF = 0x04
+
+var (
+	errStdinClosed = errors.New("stdin already closed")
+	errStdinFull   = fmt.Errorf("stdin backlog full, at most %d bytes may wait for the command", maxPendingStdin)
+)
+
+// execStdin buffers the input sent to an exec stream until its command reads
+// it, so writing never blocks the tunnel's message loop. Closing it ends the
+// command's input once the buffered data has been read, while its output
+// keeps flowing until it exits.
+type execStdin struct {
+	mutex   sync.Mutex
+	ready   *sync.Cond
+	pending []byte
+	closed  bool
+	err     error // returned by Read once closed, io.EOF unless aborted
+
+	// tty streams deliver end of input through the terminal, which only
+	// treats Ctrl-D as end of file at the start of a line
+	tty         bool
+	atLineStart bool
+}
+
+func newExecStdin(tty bool) *execStdin {
+	stdin := &execStdin{tty: tty, atLineStart: true}
+	stdin.ready = sync.NewCond(&stdin.mutex)
+	return stdin
+}
+
+// Write queues data for the command
+func (s *execStdin) Write(data []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return 0, errStdinClosed
+	}
+	if len(s.pending)+len(data) > maxPendingStdin {
+		return 0, errStdinFull
+	}
+	if len(data) > 0 {
+		s.pending = append(s.pending, data...)
+		s.atLineStart = data[len(data)-1] == '\n'
+		s.ready.Broadcast()
+	}
+	return len(data), nil
+}
+
+// Close ends the command's input after the data already queued. A terminal
+// does not pass the end of its input on to the command, so TTY streams send
+// Ctrl-D instead, twice if a partial line must be flushed first.
+func (s *execStdin) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return errStdinClosed
+	}
+	if s.tty {
+		if !s.atLineStart {
+			s.pending = append(s.pending, ttyEOF)
+		}
+		s.pending = append(s.pending, ttyEOF)
+	}
+	s.closed = true
+	s.err = io.EOF
+	s.ready.Broadcast()
+	return nil
+}
+
+// abort discards queued data and fails reads with err, once the stream has
+// stopped
+func (s *execStdin) abort(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.pending = nil
+	s.closed = true
+	s.err = err
+	s.ready.Broadcast()
+}
+
+// Read returns queued data, waiting for some until the input is closed
+func (s *execStdin) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for len(s.pending) == 0 && !s.closed {
+		s.ready.Wait()
+	}
+	if len(s.pending) == 0 {
+		return 0, s.err
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// openStdin gives a stream running under ctx an input, which stops once the
+// stream does
+func (s *execSet) openStdin(ctx context.Context, streamID string, tty bool) *execStdin {
+	stdin := newExecStdin(tty)
+	context.AfterFunc(ctx, func() {
+		stdin.abort(ctx.Err())
+	})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stream, exists := s.streams[streamID]; exists {
+		stream.stdin = stdin
+	}
+	return stdin
+}
+
+// stdin returns the input of a running stream, or an error if the stream is
+// not running or was started without stdin
+func (s *execSet) stdin(streamID string) (*execStdin, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stream, exists := s.streams[streamID]
+	if !exists {
+		return nil, fmt.Errorf("unknown exec stream %s", streamID)
+	}
+	if stream.stdin == nil {
+		return nil, fmt.Errorf("exec stream %s was not started with stdin", streamID)
+	}
+	return stream.stdin, nil
+}
+
+// stdinStreamID returns the stream an exec_stdin or exec_stdin_close message
+// is for, which clients may name as exec_id instead of stream_id. Naming two
+// different streams is an error rather than a guess at which was meant.
+func stdinStreamID(streamID, execID string) (string, error) {
+	if streamID != "" && execID != "" && streamID != execID {
+		return "", fmt.Errorf("stream_id %s and exec_id %s name different exec streams", streamID, execID)
+	}
+	if streamID == "" {
+		return execID, nil
+	}
+	return streamID, nil
+}
+
+// handleExecStdin writes client input to an exec stream's command, closing
+// its input afterwards if the chunk is marked as the last
+func (m *Manager) handleExecStdin(tunnel *Tunnel, payload interface{}) {
+	var req types.ExecStdin
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid exec_stdin request format")
+		return
+	}
+
+	data := []byte(req.Data)
+	if req.Encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(req.Data)
+		if err != nil {
+			m.sendError(tunnel, "Invalid exec_stdin encoding")
+			return
+		}
+		data = decoded
+	}
+
+	streamID, err := stdinStreamID(req.StreamID, req.ExecID)
+	if err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	stdin, err := tunnel.execs.stdin(streamID)
+	if err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	if _, err := stdin.Write(data); err != nil {
+		m.sendError(tunnel, fmt.Sprintf("Failed to write to exec stream %s: %v", streamID, err))
+		return
+	}
+	if req.EOF {
+		m.closeExecStdin(tunnel, streamID, stdin)
+	}
+}
+
+// handleExecStdinClose ends the input of an exec stream's command
+func (m *Manager) handleExecStdinClose(tunnel *Tunnel, payload interface{}) {
+	var req types.ExecStdinClose
+	if err := decodePayload(payload, &req); err != nil {
+		m.sendError(tunnel, "Invalid exec_stdin_close request format")
+		return
+	}
+
+	streamID, err := stdinStreamID(req.StreamID, req.ExecID)
+	if err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	stdin, err := tunnel.execs.stdin(streamID)
+	if err != nil {
+		m.sendError(tunnel, err.Error())
+		return
+	}
+	m.closeExecStdin(tunnel, streamID, stdin)
+}
+
+func (m *Manager) closeExecStdin(tunnel *Tunnel, streamID string, stdin *execStdin) {
+	if err := stdin.Close(); err != nil {
+		m.sendError(tunnel, fmt.Sprintf("Failed to close stdin of exec stream %s: %v", streamID, err))
+	}
+}
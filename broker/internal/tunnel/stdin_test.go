@@ -0,0 +1,195 @@
+package tunnel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestExecStdin_Close(t *testing.T) {
+	tests := []struct {
+		name   string
+		tty    bool
+		writes []string
+		want   string
+	}{
+		{"pipe", false, []string{"one\n", "two"}, "one\ntwo"},
+		{"tty at line start", true, []string{"one\n"}, "one\n\x04"},
+		{"tty mid line", true, []string{"one\n", "two"}, "one\ntwo\x04\x04"},
+		{"tty without input", true, nil, "\x04"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin := newExecStdin(tt.tty)
+			for _, data := range tt.writes {
+				if _, err := stdin.Write([]byte(data)); err != nil {
+					t.Fatalf("Expected write to succeed, got %v", err)
+				}
+			}
+			if err := stdin.Close(); err != nil {
+				t.Fatalf("Expected close to succeed, got %v", err)
+			}
+
+			got, err := io.ReadAll(stdin)
+			if err != nil {
+				t.Fatalf("Expected input to end with EOF, got %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+			if _, err := stdin.Write([]byte("late")); !errors.Is(err, errStdinClosed) {
+				t.Errorf("Expected writes after close to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExecStdin_Limits(t *testing.T) {
+	stdin := newExecStdin(false)
+	if _, err := stdin.Write(make([]byte, maxPendingStdin)); err != nil {
+		t.Fatalf("Expected a full backlog to be accepted, got %v", err)
+	}
+	if _, err := stdin.Write([]byte("x")); !errors.Is(err, errStdinFull) {
+		t.Errorf("Expected writes past the backlog to fail, got %v", err)
+	}
+
+	// A stopped stream unblocks its command's reads
+	var execs execSet
+	ctx, err := execs.add(context.Background(), "cat", []string{"cat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := execs.openStdin(ctx, "cat", false)
+	done := make(chan error, 1)
+	go func() {
+		_, err := blocked.Read(make([]byte, 1))
+		done <- err
+	}()
+	execs.cancel("cat")
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the read to fail with the cancellation, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected cancelling the stream to unblock stdin")
+	}
+}
+
+func TestManager_ExecStdinRoundTrip(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "cat", "stream_id": "cat", "stdin": true, "stdout": true, "stream": true},
+	})
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_stdin",
+		Payload: types.ExecStdin{StreamID: "cat", Data: "hello\n"},
+	})
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_stdin",
+		Payload: types.ExecStdin{StreamID: "cat", Data: base64.StdEncoding.EncodeToString([]byte("world\n")), Encoding: "base64", EOF: true},
+	})
+
+	var output string
+	for {
+		var response types.TunnelMessage
+		if err := conn.ReadJSON(&response); err != nil {
+			t.Fatalf("Expected the command to finish once stdin closed, got %v", err)
+		}
+		payload, _ := response.Payload.(map[string]interface{})
+		if response.Type == "exec_response" {
+			break
+		}
+		if response.Type != "exec_output" {
+			t.Fatalf("Expected exec_output, got %s %v", response.Type, response.Payload)
+		}
+		data, _ := payload["data"].(string)
+		output += data
+	}
+	if want := "Executed: cathello\nworld\n"; output != want {
+		t.Errorf("Expected the input echoed back, got %q", output)
+	}
+
+	// The stream is gone once the command has exited
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_stdin_close",
+		Payload: types.ExecStdinClose{StreamID: "cat"},
+	})
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "error" {
+		t.Errorf("Expected an error closing a finished stream, got %s %v", response.Type, err)
+	}
+}
+
+func TestManager_ExecStdinByExecID(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec",
+		Payload: map[string]interface{}{"command": "sh", "stream_id": "terminal", "stdin": true, "stdout": true},
+	})
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_stdin",
+		Payload: map[string]interface{}{"exec_id": "terminal", "data": "ls\n"},
+	})
+	conn.WriteJSON(types.TunnelMessage{
+		Type:    "exec_stdin_close",
+		Payload: map[string]interface{}{"exec_id": "terminal"},
+	})
+
+	var response types.TunnelMessage
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "exec_response" {
+		t.Fatalf("Expected exec_response once stdin closed, got %s %v (%v)", response.Type, response.Payload, err)
+	}
+	payload, _ := response.Payload.(map[string]interface{})
+	if stdout, _ := payload["stdout"].(string); stdout != "Executed: shls\n" {
+		t.Errorf("Expected the input routed by exec_id, got %q", stdout)
+	}
+}
+
+func TestExecSet_StdinWithoutStdin(t *testing.T) {
+	tunnel := testTunnel()
+	if _, err := tunnel.execs.add(context.Background(), "build", []string{"make"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tunnel.execs.stdin("build"); err == nil {
+		t.Error("Expected streams started without stdin to refuse input")
+	}
+	if _, err := tunnel.execs.stdin("missing"); err == nil {
+		t.Error("Expected unknown streams to refuse input")
+	}
+}
+
+func TestStdinStreamID(t *testing.T) {
+	tests := []struct {
+		streamID, execID string
+		want             string
+		wantErr          bool
+	}{
+		{streamID: "terminal", want: "terminal"},
+		{execID: "terminal", want: "terminal"},
+		{streamID: "terminal", execID: "terminal", want: "terminal"},
+		{streamID: "terminal", execID: "build", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := stdinStreamID(tt.streamID, tt.execID)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("stdinStreamID(%q, %q) = %q, %v; want %q, error %v", tt.streamID, tt.execID, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/purdue-af/vscode-k8s-connector/internal/metrics"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// Reasons a WebSocket upgrade fails, reported to the client as the error code
+// and counted in the upgrade failure metric
+const (
+	upgradeOriginRejected     = "origin_rejected"
+	upgradeBadMethod          = "bad_method"
+	upgradeUnsupportedVersion = "unsupported_version"
+	upgradeMalformed          = "malformed_handshake"
+	upgradeServerError        = "server_error"
+
+	// upgradeAborted means the handshake failed after the connection was
+	// taken over, so no response could be sent
+	upgradeAborted = "handshake_aborted"
+)
+
+// upgradeMessages tell clients how to fix each kind of rejected handshake
+var upgradeMessages = map[string]string{
+	upgradeOriginRejected:     "WebSocket origin not allowed",
+	upgradeBadMethod:          "WebSocket upgrade must use GET",
+	upgradeUnsupportedVersion: "unsupported WebSocket version, 13 is required",
+	upgradeMalformed:          "malformed WebSocket handshake",
+	upgradeServerError:        "WebSocket upgrade failed on the server",
+}
+
+// upgrade upgrades the connection like upgrader.Upgrade, but answers a failed
+// handshake with a specific status and JSON error and logs it with the
+// client's handshake headers
+func (m *Manager) upgrade(w http.ResponseWriter, r *http.Request, session *types.Session) (*websocket.Conn, error) {
+	responded := false
+	upgrader := m.upgrader
+	upgrader.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		responded = true
+		code := upgradeFailureReason(status, reason)
+		m.logUpgradeFailure(r, session, code, reason)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Sec-Websocket-Version", "13")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": upgradeMessages[code],
+			"code":  code,
+		})
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil && !responded {
+		m.logUpgradeFailure(r, session, upgradeAborted, err)
+	}
+	return conn, err
+}
+
+// upgradeFailureReason classifies a handshake error from its status and the
+// websocket package's message
+func upgradeFailureReason(status int, reason error) string {
+	switch {
+	case status == http.StatusForbidden:
+		return upgradeOriginRejected
+	case status == http.StatusMethodNotAllowed:
+		return upgradeBadMethod
+	case status >= http.StatusInternalServerError:
+		return upgradeServerError
+	case strings.Contains(reason.Error(), "unsupported version"):
+		return upgradeUnsupportedVersion
+	default:
+		return upgradeMalformed
+	}
+}
+
+func (m *Manager) logUpgradeFailure(r *http.Request, session *types.Session, code string, reason error) {
+	metrics.UpgradeFailures.WithLabelValues(code).Inc()
+	log.Printf("WebSocket upgrade for session %s from %s failed (%s): %v; origin=%q connection=%q upgrade=%q version=%q user-agent=%q",
+		session.ID, clientIP(r), code, reason,
+		r.Header.Get("Origin"), r.Header.Get("Connection"), r.Header.Get("Upgrade"),
+		r.Header.Get("Sec-Websocket-Version"), r.UserAgent())
+}
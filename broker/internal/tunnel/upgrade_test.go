@@ -0,0 +1,62 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestManager_UpgradeFailures(t *testing.T) {
+	manager := NewManager(&fakeK8sClient{}, ManagerConfig{})
+	manager.upgrader.CheckOrigin = func(r *http.Request) bool {
+		return r.Header.Get("Origin") != "https://evil.example.org"
+	}
+	server := startTestServer(t, manager, testSession())
+
+	handshake := map[string]string{
+		"Connection":            "Upgrade",
+		"Upgrade":               "websocket",
+		"Sec-Websocket-Version": "13",
+		"Sec-Websocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		headers    map[string]string
+		wantStatus int
+		wantCode   string
+	}{
+		{"plain request", http.MethodGet, nil, http.StatusBadRequest, upgradeMalformed},
+		{"wrong method", http.MethodPost, handshake, http.StatusMethodNotAllowed, upgradeBadMethod},
+		{"old version", http.MethodGet, map[string]string{"Sec-Websocket-Version": "8"}, http.StatusBadRequest, upgradeUnsupportedVersion},
+		{"missing key", http.MethodGet, map[string]string{"Sec-Websocket-Key": ""}, http.StatusBadRequest, upgradeMalformed},
+		{"rejected origin", http.MethodGet, map[string]string{"Origin": "https://evil.example.org"}, http.StatusForbidden, upgradeOriginRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL, nil)
+			if tt.headers != nil {
+				for key, value := range handshake {
+					req.Header.Set(key, value)
+				}
+				for key, value := range tt.headers {
+					req.Header.Set(key, value)
+				}
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Expected a response, got %v", err)
+			}
+			defer resp.Body.Close()
+
+			var body map[string]string
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != tt.wantStatus || body["code"] != tt.wantCode {
+				t.Errorf("Expected %d with code %s, got %d with %v", tt.wantStatus, tt.wantCode, resp.StatusCode, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package tunnel
+
+import (
+	"fmt"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// handleResourceUsage reports the pod's CPU and memory usage next to its
+// limits, so the client can warn the user before the pod hits them. The
+// metrics API call is made asynchronously.
+func (m *Manager) handleResourceUsage(tunnel *Tunnel) {
+	go func() {
+		defer m.recoverHandler(tunnel, "resource_usage")
+
+		usage, err := m.k8sClient.GetPodResourceUsage(tunnel.ctx,
+			tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name)
+		if err != nil {
+			m.sendError(tunnel, fmt.Sprintf("Failed to get resource usage: %v", err))
+			return
+		}
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type:    "resource_usage_response",
+			Payload: usage,
+		})
+	}()
+}
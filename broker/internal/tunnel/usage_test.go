@@ -0,0 +1,32 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_ResourceUsage(t *testing.T) {
+	client := &fakeK8sClient{resourceUsage: &types.ResourceUsage{
+		Reason:     "metrics unavailable",
+		Containers: []types.ContainerResourceUsage{{Name: "notebook", MemoryLimitBytes: 4 << 30}},
+	}}
+	manager := NewManager(client, ManagerConfig{})
+	server := startTestServer(t, manager, testSession())
+	conn := dialReadyTunnel(t, server)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	conn.WriteJSON(types.TunnelMessage{Type: "resource_usage"})
+	var response struct {
+		Type    string              `json:"type"`
+		Payload types.ResourceUsage `json:"payload"`
+	}
+	if err := conn.ReadJSON(&response); err != nil || response.Type != "resource_usage_response" {
+		t.Fatalf("Expected resource_usage_response, got %+v (%v)", response, err)
+	}
+	if response.Payload.Available || len(response.Payload.Containers) != 1 ||
+		response.Payload.Containers[0].MemoryLimitBytes != 4<<30 {
+		t.Errorf("Expected the limits with usage unavailable, got %+v", response.Payload)
+	}
+}
@@ -0,0 +1,58 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// handleWorkspaceInfo reports where the user's files live, so the client can
+// open the right folder on connect. It needs an exec and an API call, so the
+// response is sent asynchronously.
+func (m *Manager) handleWorkspaceInfo(tunnel *Tunnel) {
+	go func() {
+		defer m.recoverHandler(tunnel, "workspace_info")
+
+		m.sendMessage(tunnel, types.TunnelMessage{
+			Type:    "workspace_info_response",
+			Payload: m.workspaceInfo(tunnel),
+		})
+	}()
+}
+
+// workspaceInfo collects the configured workspace root, the home directory
+// from the pod's environment and the volumes mounted into the pod. A part
+// that cannot be determined is reported in Errors without failing the rest.
+func (m *Manager) workspaceInfo(tunnel *Tunnel) *types.WorkspaceInfo {
+	info := &types.WorkspaceInfo{WorkspaceRoot: m.workspaceRoot}
+
+	var stdout, stderr bytes.Buffer
+	err := m.k8sClient.Exec(tunnel.ctx, tunnel.credentials(), k8s.ExecOptions{
+		Namespace: tunnel.Session.PodInfo.Namespace,
+		Pod:       tunnel.Session.PodInfo.Name,
+		Command:   []string{"sh", "-c", `printf %s "$HOME"`},
+		Stdout:    &stdout,
+		Stderr:    &stderr,
+	})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		info.Errors = append(info.Errors, fmt.Sprintf("failed to read home directory: %v", err))
+	} else {
+		info.Home = stdout.String()
+	}
+
+	volumes, err := m.k8sClient.GetVolumeMounts(tunnel.ctx,
+		tunnel.Session.PodInfo.Namespace, tunnel.Session.PodInfo.Name)
+	if err != nil {
+		info.Errors = append(info.Errors, fmt.Sprintf("failed to list volumes: %v", err))
+	} else {
+		info.Volumes = volumes
+	}
+
+	return info
+}
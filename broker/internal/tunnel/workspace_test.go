@@ -0,0 +1,54 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func TestManager_WorkspaceInfo(t *testing.T) {
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			io.WriteString(opts.Stdout, "/home/jovyan")
+			return nil
+		},
+		volumeMounts: []types.VolumeMount{{Name: "home", MountPath: "/home/jovyan", ClaimName: "claim-alice"}},
+	}
+
+	manager := NewManager(client, ManagerConfig{WorkspaceRoot: "/home/jovyan/work"})
+	info := manager.workspaceInfo(testTunnel())
+
+	if info.WorkspaceRoot != "/home/jovyan/work" || info.Home != "/home/jovyan" {
+		t.Errorf("Expected configured root and home directory, got %+v", info)
+	}
+	if len(info.Volumes) != 1 || info.Volumes[0].ClaimName != "claim-alice" {
+		t.Errorf("Expected the pod's volumes, got %+v", info.Volumes)
+	}
+	if len(info.Errors) != 0 {
+		t.Errorf("Expected no errors, got %v", info.Errors)
+	}
+}
+
+func TestManager_WorkspaceInfo_PartialFailure(t *testing.T) {
+	client := &fakeK8sClient{
+		execFunc: func(ctx context.Context, opts k8s.ExecOptions) error {
+			io.WriteString(opts.Stderr, "sh: not found")
+			return errors.New("command terminated with exit code 127")
+		},
+		volumeMounts: []types.VolumeMount{{Name: "home", MountPath: "/home/jovyan"}},
+	}
+
+	info := NewManager(client, ManagerConfig{}).workspaceInfo(testTunnel())
+
+	if info.Home != "" || len(info.Volumes) != 1 {
+		t.Errorf("Expected volumes without a home directory, got %+v", info)
+	}
+	if len(info.Errors) != 1 || !strings.Contains(info.Errors[0], "sh: not found") {
+		t.Errorf("Expected the exec failure to be reported, got %v", info.Errors)
+	}
+}
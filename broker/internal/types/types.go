@@ -14,8 +14,23 @@ type UserInfo struct {
 type TokenSet struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token,omitempty"`
 	ExpiresIn    int    `json:"expires_in"`
 	TokenType    string `json:"token_type"`
+
+	// Scopes are the scopes the issuer granted, which may differ from those
+	// requested
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// HasScope reports whether the scope was granted
+func (t *TokenSet) HasScope(scope string) bool {
+	for _, granted := range t.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
 }
 
 // PodInfo represents Kubernetes pod information
@@ -23,6 +38,18 @@ type PodInfo struct {
 	Name      string `json:"name"`
 	Namespace string `json:"namespace"`
 	Status    string `json:"status"`
+	// Owner is the JupyterHub user the pod was started for, checked against
+	// the pod again whenever a tunnel is set up
+	Owner string `json:"owner,omitempty"`
+}
+
+// PodEvent represents a Kubernetes event recorded for a pod
+type PodEvent struct {
+	Type      string    `json:"type"` // Normal or Warning
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // Session represents an active user session
@@ -34,6 +61,31 @@ type Session struct {
 	CreatedAt    time.Time `json:"created_at"`
 	ExpiresAt    time.Time `json:"expires_at"`
 	RefreshToken string    `json:"-"` // Not serialized for security
+
+	// Metadata is set by the client at creation, such as its workspace name,
+	// version and platform
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// TunnelOwner is the internal URL of the broker instance holding the
+	// session's active tunnel, empty without one. It is never sent to clients.
+	TunnelOwner string `json:"-"`
+}
+
+// CloseReason is the JSON reason of close frames the broker sends, telling
+// clients whether to reconnect
+type CloseReason struct {
+	Reason    string `json:"reason"`
+	Reconnect bool   `json:"reconnect"`
+	// BackoffMs is the suggested delay before reconnecting
+	BackoffMs int64 `json:"backoff_ms,omitempty"`
+}
+
+// ServerDraining warns a tunnel's client that the broker is about to shut
+// down, so it can save state and reconnect to another replica first
+type ServerDraining struct {
+	ShutdownAt time.Time `json:"shutdown_at"`
+	// RemainingMs is the time left until shutdown, unaffected by clock skew
+	RemainingMs int64 `json:"remaining_ms"`
 }
 
 // TunnelMessage represents WebSocket tunnel messages
@@ -46,16 +98,88 @@ type TunnelMessage struct {
 type ExecRequest struct {
 	Command string   `json:"command"`
 	Args    []string `json:"args"`
-	Stdin   bool     `json:"stdin"`
-	Stdout  bool     `json:"stdout"`
-	Stderr  bool     `json:"stderr"`
+	// Shell runs Command as a shell script, such as a pipeline, with Args as
+	// its positional parameters. Otherwise Command and Args are run directly
+	// and no shell ever interprets them.
+	Shell  bool `json:"shell,omitempty"`
+	Stdin  bool `json:"stdin"`
+	Stdout bool `json:"stdout"`
+	Stderr bool `json:"stderr"`
+	TTY    bool `json:"tty"`
+	// Container selects the container to exec into, such as a debug container
+	Container string `json:"container,omitempty"`
+	// Prelude overrides the configured exec prelude; an empty string disables it
+	Prelude *string `json:"prelude,omitempty"`
+	// LoginShell overrides whether TTY shells run as login shells
+	LoginShell *bool `json:"login_shell,omitempty"`
+	// StreamID names the exec stream for exec_list and exec_cancel, generated if empty
+	StreamID string `json:"stream_id,omitempty"`
+	// Binary returns output bytes untouched, base64 encoded
+	Binary bool `json:"binary,omitempty"`
+	// Env sets environment variables for the command
+	Env map[string]string `json:"env,omitempty"`
+	// EnvFrom adds the keys of "secret/<name>" and "configmap/<name>" objects
+	// in the pod's namespace to the environment; Env wins on conflicts
+	EnvFrom []string `json:"env_from,omitempty"`
+	// RunAsUser runs the command as this user name or UID in the pod
+	RunAsUser string `json:"run_as_user,omitempty"`
+	// Stream sends output in exec_output messages as it is produced; the
+	// exec_response then only carries the exit code
+	Stream bool `json:"stream,omitempty"`
+	// Verbatim returns stdout byte for byte, base64 encoded, in a single
+	// exec_response, for machine-readable output such as JSON. Anything the
+	// prelude prints goes to stderr. It cannot be combined with TTY or Stream.
+	Verbatim bool `json:"verbatim,omitempty"`
+}
+
+// ExecOutput carries a chunk of a streamed exec's output
+type ExecOutput struct {
+	StreamID string `json:"stream_id"`
+	Stream   string `json:"stream"` // stdout or stderr
+	Data     string `json:"data"`
+	// Encoding is "base64" when the output is base64 encoded binary
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // ExecResponse represents command execution response
 type ExecResponse struct {
+	StreamID string `json:"stream_id,omitempty"`
 	ExitCode int    `json:"exit_code"`
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
+	// Encoding is "base64" when the output is base64 encoded binary
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ExecCancel represents a request to terminate an exec stream
+type ExecCancel struct {
+	StreamID string `json:"stream_id"`
+}
+
+// ExecStdin carries a chunk of input for an exec stream started with stdin
+type ExecStdin struct {
+	StreamID string `json:"stream_id"`
+	// ExecID is accepted in place of StreamID
+	ExecID string `json:"exec_id,omitempty"`
+	Data   string `json:"data"`
+	// Encoding is "base64" when Data is base64 encoded binary
+	Encoding string `json:"encoding,omitempty"`
+	// EOF closes the stream's stdin after this chunk
+	EOF bool `json:"eof,omitempty"`
+}
+
+// ExecStdinClose closes the stdin of an exec stream, leaving its output open
+type ExecStdinClose struct {
+	StreamID string `json:"stream_id"`
+	// ExecID is accepted in place of StreamID
+	ExecID string `json:"exec_id,omitempty"`
+}
+
+// ExecStreamInfo describes an exec stream running on a tunnel
+type ExecStreamInfo struct {
+	StreamID  string    `json:"stream_id"`
+	Command   []string  `json:"command"`
+	StartedAt time.Time `json:"started_at"`
 }
 
 // PortForwardRequest represents port forwarding request
@@ -63,34 +187,198 @@ type PortForwardRequest struct {
 	Port int `json:"port"`
 }
 
-// FileOperation represents file system operations
-type FileOperation struct {
-	Operation string `json:"operation"` // read, write, list, delete
-	Path      string `json:"path"`
-	Content   string `json:"content,omitempty"`
+// Ping represents an application-level liveness check through the tunnel
+type Ping struct {
+	// Data is echoed back in the pong, e.g. a client send time or sequence number
+	Data interface{} `json:"data,omitempty"`
 }
 
-// FileOperationResponse represents file operation response
-type FileOperationResponse struct {
-	Success bool   `json:"success"`
-	Content string `json:"content,omitempty"`
-	Error   string `json:"error,omitempty"`
+// Pong answers a Ping with the server time and the pod's current phase
+type Pong struct {
+	Data       interface{} `json:"data,omitempty"`
+	ServerTime time.Time   `json:"server_time"`
+	PodPhase   string      `json:"pod_phase,omitempty"`
+	PodError   string      `json:"pod_error,omitempty"`
 }
 
+// WorkspaceInfo tells the client where the user's files live in the pod
+type WorkspaceInfo struct {
+	// WorkspaceRoot is the configured project root, omitted if unset
+	WorkspaceRoot string        `json:"workspace_root,omitempty"`
+	Home          string        `json:"home,omitempty"`
+	Volumes       []VolumeMount `json:"volumes,omitempty"`
+	// Errors explains fields that could not be determined
+	Errors []string `json:"errors,omitempty"`
+}
 
+// VolumeMount describes a volume mounted into the session's container
+type VolumeMount struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mount_path"`
+	ReadOnly  bool   `json:"read_only,omitempty"`
+	// ClaimName is the PersistentVolumeClaim backing the volume, if any
+	ClaimName string `json:"claim_name,omitempty"`
+}
 
+// ResourceUsage reports the session pod's current CPU and memory usage with
+// the requests and limits from its spec
+type ResourceUsage struct {
+	// Available is false when usage cannot be read, e.g. without
+	// metrics-server; requests and limits are still reported
+	Available bool `json:"available"`
+	// Reason explains why usage is unavailable
+	Reason string `json:"reason,omitempty"`
+	// Timestamp and WindowSeconds describe the sample usage was averaged over
+	Timestamp     *time.Time               `json:"timestamp,omitempty"`
+	WindowSeconds float64                  `json:"window_seconds,omitempty"`
+	Containers    []ContainerResourceUsage `json:"containers"`
+}
 
+// ContainerResourceUsage is one container's usage, requests and limits. CPU
+// is in millicores and memory in bytes; a request or limit of 0 is unset.
+type ContainerResourceUsage struct {
+	Name string `json:"name"`
+	// CPUMillicores and MemoryBytes are omitted when usage is unavailable
+	CPUMillicores        *int64 `json:"cpu_millicores,omitempty"`
+	MemoryBytes          *int64 `json:"memory_bytes,omitempty"`
+	CPURequestMillicores int64  `json:"cpu_request_millicores,omitempty"`
+	CPULimitMillicores   int64  `json:"cpu_limit_millicores,omitempty"`
+	MemoryRequestBytes   int64  `json:"memory_request_bytes,omitempty"`
+	MemoryLimitBytes     int64  `json:"memory_limit_bytes,omitempty"`
+}
 
+// DebugRequest represents a request to add an ephemeral debug container
+type DebugRequest struct {
+	// TargetContainer is the container whose processes the debugger can see
+	TargetContainer string `json:"target_container,omitempty"`
+}
 
+// ReversePortForwardRequest represents a request to listen on a pod port and
+// relay its connections back to the client
+type ReversePortForwardRequest struct {
+	Port int `json:"port"`
+}
 
+// ReversePortForwardData carries base64-encoded data for one relayed connection
+type ReversePortForwardData struct {
+	ConnectionID string `json:"connection_id"`
+	Data         string `json:"data"`
+}
 
+// ReversePortForwardClose stops a reverse port forward or a single relayed connection
+type ReversePortForwardClose struct {
+	Port         int    `json:"port,omitempty"`
+	ConnectionID string `json:"connection_id,omitempty"`
+}
 
+// FileOperation represents file system operations
+type FileOperation struct {
+	// RequestID is echoed on the file_response, as file operations can
+	// finish out of order
+	RequestID string `json:"request_id,omitempty"`
+	Operation string `json:"operation"` // read, write, list, stat, symlink, chmod, chown
+	Path      string `json:"path"`
+	Target    string `json:"target,omitempty"` // what a symlink points to
+	Content   string `json:"content,omitempty"`
+	Encoding  string `json:"encoding,omitempty"` // "base64" for binary write content
+	Mode      string `json:"mode,omitempty"`     // octal mode for writes, default 0644; octal or symbolic for chmod
+	Owner     string `json:"owner,omitempty"`    // user[:group] or :group for chown
+	Compress  bool   `json:"compress,omitempty"` // gzip large read results
+	// Offset and Limit page a list; Continue resumes from an earlier page's
+	// continuation token instead of Offset
+	Offset   int    `json:"offset,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	Continue string `json:"continue,omitempty"`
+}
 
+// FileOperationResponse represents file operation response
+type FileOperationResponse struct {
+	RequestID  string `json:"request_id,omitempty"`
+	Success    bool   `json:"success"`
+	Content    string `json:"content,omitempty"`
+	Compressed bool   `json:"compressed,omitempty"` // content is base64 gzip
+	Encoding   string `json:"encoding,omitempty"`   // "base64" for binary content
+	Mode       string `json:"mode,omitempty"`       // octal permission bits
+	LinkTarget string `json:"link_target,omitempty"`
+	// Entries lists a directory, Stat describes a single path
+	Entries []FileInfo `json:"entries,omitempty"`
+	Stat    *FileInfo  `json:"stat,omitempty"`
+	// HasMore is set when a list page is followed by more entries, which
+	// Continue resumes from
+	HasMore  bool   `json:"has_more,omitempty"`
+	Continue string `json:"continue,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
 
+// File types reported in FileInfo
+const (
+	FileTypeFile      = "file"
+	FileTypeDirectory = "directory"
+	FileTypeSymlink   = "symlink"
+	FileTypeOther     = "other"
+)
 
+// FileInfo describes a file in the pod
+type FileInfo struct {
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Mode         string    `json:"mode"`          // octal permission bits
+	ModeSymbolic string    `json:"mode_symbolic"` // as ls shows it, e.g. drwxr-xr-x
+	Size         int64     `json:"size"`
+	ModTime      time.Time `json:"mod_time"`
+	// LinkTarget is a symlink's target. TargetType is the type of the file
+	// it resolves to; Broken is set instead when that does not exist.
+	LinkTarget string `json:"link_target,omitempty"`
+	TargetType string `json:"target_type,omitempty"`
+	Broken     bool   `json:"broken,omitempty"`
+}
 
+// TunnelReady is sent once a tunnel is set up
+type TunnelReady struct {
+	SessionID string `json:"session_id"`
+	// Capabilities reports which tools the broker relies on are installed in
+	// the pod, omitted if the probe failed
+	Capabilities map[string]bool `json:"capabilities,omitempty"`
+	// ResumeToken lets one reconnect within the credential release grace
+	// period reuse the tunnel's credentials; send it in X-Resume-Token
+	ResumeToken string `json:"resume_token,omitempty"`
+}
 
+// ProcessListRequest asks for the processes running in the pod
+type ProcessListRequest struct {
+	// Columns are extra ps columns to report, the configured ones if empty
+	Columns []string `json:"columns,omitempty"`
+}
 
+// ProcessInfo describes a process running in the pod
+type ProcessInfo struct {
+	PID     int               `json:"pid"`
+	CPU     float64           `json:"cpu"`    // percent of one CPU
+	Memory  float64           `json:"memory"` // percent of the node's memory
+	Command string            `json:"command"`
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// ProcessListResponse carries the processes running in the pod
+type ProcessListResponse struct {
+	Processes []ProcessInfo `json:"processes"`
+}
 
+// ProcessKillRequest asks to signal a process in the pod
+type ProcessKillRequest struct {
+	PID    int    `json:"pid"`
+	Signal string `json:"signal,omitempty"` // TERM if empty
+}
 
+// FileBatchRequest carries several file operations in one message
+type FileBatchRequest struct {
+	// BatchID is echoed on the response, as batches can finish out of order
+	BatchID    string          `json:"batch_id,omitempty"`
+	Operations []FileOperation `json:"operations"`
+}
 
+// FileBatchResponse carries batch results, in the order of the operations
+type FileBatchResponse struct {
+	BatchID string                   `json:"batch_id,omitempty"`
+	Results []*FileOperationResponse `json:"results"`
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/purdue-af/vscode-k8s-connector/internal/jupyterhub"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// sessionCreations coalesces concurrent session creations for the same user,
+// as when several editor windows open at once: the first request creates the
+// session and the others wait for it and receive the same one, instead of
+// racing on the user's server and each creating a session. The creation runs
+// independently of the request that began it, so the others still get its
+// result if that request goes away.
+type sessionCreations struct {
+	reject bool
+
+	mutex sync.Mutex
+	calls map[string]*creationCall
+}
+
+// creationCall is a session creation in progress, with the progress
+// callbacks of the requests waiting for it
+type creationCall struct {
+	done    chan struct{}
+	session *types.Session
+	status  int
+	err     error
+
+	mutex     sync.Mutex
+	nextID    int
+	listeners map[int]jupyterhub.ProgressFunc
+}
+
+// newSessionCreations coalesces creations with concurrency
+// jupyterhub.SpawnConcurrencyWait, or refuses a user's concurrent creations
+// with jupyterhub.SpawnConcurrencyReject
+func newSessionCreations(concurrency string) *sessionCreations {
+	return &sessionCreations{
+		reject: concurrency == jupyterhub.SpawnConcurrencyReject,
+		calls:  make(map[string]*creationCall),
+	}
+}
+
+// do runs create for user unless a creation for them is already in progress,
+// in which case it waits for that one and returns its session
+func (s *sessionCreations) do(
+	ctx context.Context,
+	user string,
+	progress jupyterhub.ProgressFunc,
+	create func(ctx context.Context, progress jupyterhub.ProgressFunc) (*types.Session, int, error),
+) (*types.Session, int, error) {
+	s.mutex.Lock()
+	call, inProgress := s.calls[user]
+	if inProgress && s.reject {
+		s.mutex.Unlock()
+		return nil, http.StatusConflict, jupyterhub.ErrSpawnInProgress
+	}
+	if !inProgress {
+		call = &creationCall{done: make(chan struct{}), listeners: make(map[int]jupyterhub.ProgressFunc)}
+		s.calls[user] = call
+	}
+	id := call.listen(progress)
+	s.mutex.Unlock()
+
+	if !inProgress {
+		go s.create(context.WithoutCancel(ctx), user, call, create)
+	}
+	defer call.unlisten(id)
+
+	select {
+	case <-call.done:
+		return call.session, call.status, call.err
+	case <-ctx.Done():
+		return nil, http.StatusGatewayTimeout, ctx.Err()
+	}
+}
+
+// create makes the session for everyone waiting on call
+func (s *sessionCreations) create(
+	ctx context.Context,
+	user string,
+	call *creationCall,
+	create func(ctx context.Context, progress jupyterhub.ProgressFunc) (*types.Session, int, error),
+) {
+	call.session, call.status, call.err = create(ctx, call.report)
+
+	s.mutex.Lock()
+	delete(s.calls, user)
+	s.mutex.Unlock()
+	close(call.done)
+}
+
+// listen registers a waiting request's progress callback, if any
+func (c *creationCall) listen(progress jupyterhub.ProgressFunc) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nextID++
+	if progress != nil {
+		c.listeners[c.nextID] = progress
+	}
+	return c.nextID
+}
+
+// unlisten removes the callback of a request that stopped waiting, so
+// progress is never reported to a finished response
+func (c *creationCall) unlisten(id int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.listeners, id)
+}
+
+// report passes progress to every waiting request
+func (c *creationCall) report(progress jupyterhub.SpawnProgress) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, listener := range c.listeners {
+		listener(progress)
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// forwardTimeout bounds a request forwarded to another replica
+const forwardTimeout = 10 * time.Second
+
+// closeTunnel closes the session's tunnel, forwarding the request to the
+// replica holding it when that is not this one
+func (h *Handlers) closeTunnel(ctx context.Context, sessionID, owner string) {
+	if h.tunnelManager.CloseTunnel(sessionID) == nil {
+		return
+	}
+	if owner == "" || owner == h.instanceURL || h.internalToken == "" {
+		return
+	}
+
+	if err := h.forwardCloseTunnel(ctx, owner, sessionID); err != nil {
+		log.Printf("Failed to close tunnel for session %s on %s: %v", sessionID, owner, err)
+	}
+}
+
+// EndEvictedSession closes the tunnel of a session evicted from a full
+// store, releasing its credentials as deleting the session would
+func (h *Handlers) EndEvictedSession(evicted *types.Session) {
+	h.closeTunnel(context.Background(), evicted.ID, evicted.TunnelOwner)
+}
+
+// forwardCloseTunnel asks the owning replica to close a session's tunnel
+func (h *Handlers) forwardCloseTunnel(ctx context.Context, owner, sessionID string) error {
+	ctx, cancel := context.WithTimeout(ctx, forwardTimeout)
+	defer cancel()
+
+	target := strings.TrimSuffix(owner, "/") + "/internal/tunnels/" + url.PathEscape(sessionID) + "/close"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+h.internalToken)
+
+	resp, err := h.forwardClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The tunnel may have ended on its own in the meantime
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// RequireInternalToken rejects requests not carrying the internal token
+// shared between replicas
+func (h *Handlers) RequireInternalToken(c *gin.Context) {
+	token := bearerToken(c)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.internalToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid internal token"})
+		return
+	}
+	c.Next()
+}
+
+// CloseTunnel closes a tunnel held by this replica, on behalf of another
+func (h *Handlers) CloseTunnel(c *gin.Context) {
+	sessionID := c.Param("session_id")
+
+	if err := h.tunnelManager.CloseTunnel(sessionID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "closed"})
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+const testInternalToken = "internal-token"
+
+// startOwner serves the internal endpoints of a replica holding tunnels
+func startOwner(t *testing.T, tunnels *fakeTunnels) *httptest.Server {
+	t.Helper()
+
+	store := session.NewInMemoryStore("1h", "test-secret")
+	handlers := NewHandlers(&fakeProvider{}, store, &fakeHub{}, tunnels, HandlersConfig{InternalToken: testInternalToken})
+	router := gin.New()
+	RegisterRoutes(router, handlers)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDeleteSession_ForwardsToTunnelOwner(t *testing.T) {
+	ownerTunnels := &fakeTunnels{}
+	owner := startOwner(t, ownerTunnels)
+
+	store := session.NewInMemoryStore("1h", "test-secret")
+	handlers := NewHandlers(&fakeProvider{}, store, &fakeHub{}, &fakeTunnels{notHeld: true}, HandlersConfig{
+		InstanceURL:   "http://replica-a:8080",
+		InternalToken: testInternalToken,
+	})
+	router := gin.New()
+	RegisterRoutes(router, handlers)
+
+	created, _ := store.Create(context.Background(), session.CreateRequest{UserID: "alice@purdue.edu"})
+	store.SetTunnelOwner(context.Background(), created.ID, owner.URL)
+
+	recorder, body := serve(router, httptest.NewRequest(http.MethodDelete, "/session/"+created.ID, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %v", recorder.Code, body)
+	}
+
+	ownerTunnels.mutex.Lock()
+	defer ownerTunnels.mutex.Unlock()
+	if len(ownerTunnels.closed) != 1 || ownerTunnels.closed[0] != created.ID {
+		t.Errorf("Expected the owner to close the session's tunnel, got %v", ownerTunnels.closed)
+	}
+}
+
+func TestRequireInternalToken(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{InternalToken: testInternalToken})
+
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+	}{
+		{name: "missing token", wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer wrong-token", wantStatus: http.StatusUnauthorized},
+		{name: "session token scheme", header: testInternalToken, wantStatus: http.StatusUnauthorized},
+		{name: "internal token", header: "Bearer " + testInternalToken, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/internal/tunnels/0123456789abcdef/close", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if recorder, body := serve(router, req); recorder.Code != tt.wantStatus {
+				t.Errorf("Expected %d, got %d %v", tt.wantStatus, recorder.Code, body)
+			}
+		})
+	}
+}
+
+func TestInternalEndpointsNeedToken(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{})
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/tunnels/0123456789abcdef/close", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	if recorder, _ := serve(router, req); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected the internal endpoints not to be served without a token, got %d", recorder.Code)
+	}
+}
+
+func TestCloseTunnel_NotHeld(t *testing.T) {
+	router := gin.New()
+	store := session.NewInMemoryStore("1h", "test-secret")
+	RegisterRoutes(router, NewHandlers(&fakeProvider{}, store, &fakeHub{}, &fakeTunnels{notHeld: true},
+		HandlersConfig{InternalToken: testInternalToken}))
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/tunnels/0123456789abcdef/close", nil)
+	req.Header.Set("Authorization", "Bearer "+testInternalToken)
+	if recorder, body := serve(router, req); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a tunnel this replica does not hold, got %d %v", recorder.Code, body)
+	}
+}
+
+func TestForwardCloseTunnel(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "closed", status: http.StatusOK},
+		{name: "owner no longer holds it", status: http.StatusNotFound},
+		{name: "owner failed", status: http.StatusInternalServerError, wantErr: true},
+		{name: "owner rejected token", status: http.StatusUnauthorized, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotAuth, gotPath string
+			owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotAuth, gotPath = r.Header.Get("Authorization"), r.URL.Path
+				w.WriteHeader(tt.status)
+			}))
+			defer owner.Close()
+
+			handlers := NewHandlers(&fakeProvider{}, session.NewInMemoryStore("1h", "test-secret"), &fakeHub{}, &fakeTunnels{},
+				HandlersConfig{InternalToken: testInternalToken})
+			err := handlers.forwardCloseTunnel(context.Background(), owner.URL+"/", "0123456789abcdef")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if gotAuth != "Bearer "+testInternalToken || gotPath != "/internal/tunnels/0123456789abcdef/close" {
+				t.Errorf("Expected an authenticated close request, got %q %q", gotAuth, gotPath)
+			}
+		})
+	}
+}
+
+func TestSessionJSONOmitsTunnelOwner(t *testing.T) {
+	encoded, err := json.Marshal(types.Session{ID: "0123456789abcdef", TunnelOwner: "http://replica-a:8080"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Contains(string(encoded), "replica-a") {
+		t.Errorf("Expected the tunnel owner not to be serialized, got %s", encoded)
+	}
+}
@@ -1,22 +1,145 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/purdue-af/vscode-k8s-connector/internal/auth"
+	"github.com/purdue-af/vscode-k8s-connector/internal/httpclient"
 	"github.com/purdue-af/vscode-k8s-connector/internal/jupyterhub"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
 	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+	"github.com/purdue-af/vscode-k8s-connector/internal/tracing"
 	"github.com/purdue-af/vscode-k8s-connector/internal/tunnel"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
 )
 
 type Handlers struct {
-	oidcProvider     auth.Provider
-	sessionStore     session.Store
-	jupyterHubClient jupyterhub.ClientInterface
-	tunnelManager    tunnel.ManagerInterface
+	oidcProvider       auth.Provider
+	sessionStore       session.Store
+	jupyterHubClient   jupyterhub.ClientInterface
+	tunnelManager      tunnel.ManagerInterface
+	usernameNormalizer jupyterhub.UsernameNormalizer
+	maxBodyBytes       int64
+	podGetter          PodGetter
+	tokenSigner        *session.Signer
+
+	instanceURL   string
+	internalToken string
+	forwardClient *http.Client
+	tunnelBaseURL string
+	proxies       []*net.IPNet
+
+	dependencyChecks map[string]DependencyCheck
+	readinessPolicy  string
+
+	requestTimeout       time.Duration
+	sessionCreateTimeout time.Duration
+
+	sessionCreations *sessionCreations
+}
+
+// PodGetter looks up pods and their events in the cluster, implemented by
+// k8s.ClientInterface
+type PodGetter interface {
+	GetPod(ctx context.Context, namespace, name string) (*types.PodInfo, error)
+	GetPodEvents(ctx context.Context, namespace, name string) ([]types.PodEvent, error)
+}
+
+// podNotReadyError reports a pod that is not running with its recent events,
+// so users can see why without cluster access
+type podNotReadyError struct {
+	err    error
+	events []types.PodEvent
+}
+
+func (e *podNotReadyError) Error() string { return e.err.Error() }
+func (e *podNotReadyError) Unwrap() error { return e.err }
+
+// idempotencyKeyHeader names the header clients set to make session creation
+// safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// sessionMetadataHeader carries session metadata on streamed creation, which
+// has no request body
+const sessionMetadataHeader = "X-Session-Metadata"
+
+// DefaultMaxBodyBytes bounds JSON request bodies; session requests carry only tokens and metadata
+const DefaultMaxBodyBytes = 64 << 10
+
+// HandlersConfig represents optional handler behaviour
+type HandlersConfig struct {
+	// UsernameNormalizer maps the user's email to their JupyterHub username,
+	// defaults to using the email unchanged
+	UsernameNormalizer jupyterhub.UsernameNormalizer
+
+	// MaxBodyBytes bounds request bodies on JSON endpoints, DefaultMaxBodyBytes if zero
+	MaxBodyBytes int64
+
+	// PodGetter, if set, confirms the user's pod exists in the cluster before a
+	// session is created
+	PodGetter PodGetter
+
+	// TokenSigner, if it signs asymmetrically, has its public key published
+	// at /.well-known/jwks.json
+	TokenSigner *session.Signer
+
+	// InstanceURL is this broker's address for other replicas, recorded as
+	// the owner of tunnels it holds
+	InstanceURL string
+
+	// PublicURL is the broker's external address, such as
+	// https://broker.example.org, used for the tunnel URLs it advertises when
+	// clients reach it through an ingress under another host. Without it the
+	// request's host is used. It must be valid for TunnelBaseURL.
+	PublicURL string
+
+	// TrustedProxies are the IPs and CIDRs of reverse proxies whose
+	// X-Forwarded-Host and X-Forwarded-Proto are used for tunnel URLs when
+	// PublicURL is unset
+	TrustedProxies []string
+
+	// InternalToken authenticates requests between replicas. When set, the
+	// /internal endpoints are served and requests for tunnels held by another
+	// replica are forwarded to it.
+	InternalToken string
+
+	// ForwardClient makes requests to other replicas, a default httpclient
+	// client if nil
+	ForwardClient *http.Client
+
+	// DependencyChecks, keyed by DependencyKubernetes, DependencyOIDC and
+	// DependencyJupyterHub, are run by /ready
+	DependencyChecks map[string]DependencyCheck
+
+	// ReadinessPolicy is the capability /ready requires, CapabilityTunnels
+	// (the default) or CapabilitySessions
+	ReadinessPolicy string
+
+	// RequestTimeout bounds handling each request, DefaultRequestTimeout if
+	// zero. SessionCreateTimeout, DefaultSessionCreateTimeout if zero, applies
+	// instead to POST /session, which may wait for a server to start. The
+	// tunnel and streamed session creation are long-lived and exempt.
+	RequestTimeout       time.Duration
+	SessionCreateTimeout time.Duration
+
+	// CreateConcurrency is how a session request is handled while another
+	// is creating a session for the same user: jupyterhub.SpawnConcurrencyWait
+	// (the default) shares that session, jupyterhub.SpawnConcurrencyReject
+	// fails with 409
+	CreateConcurrency string
 }
 
 func NewHandlers(
@@ -24,30 +147,115 @@ func NewHandlers(
 	sessionStore session.Store,
 	jupyterHubClient jupyterhub.ClientInterface,
 	tunnelManager tunnel.ManagerInterface,
+	config HandlersConfig,
 ) *Handlers {
+	normalizer := config.UsernameNormalizer
+	if normalizer == nil {
+		normalizer, _ = jupyterhub.NewUsernameNormalizer(jupyterhub.UsernameNormalizerConfig{})
+	}
+
+	maxBodyBytes := config.MaxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = DefaultMaxBodyBytes
+	}
+
+	forwardClient := config.ForwardClient
+	if forwardClient == nil {
+		forwardClient = httpclient.NewClient(httpclient.Config{})
+	}
+
+	readinessPolicy := config.ReadinessPolicy
+	if readinessPolicy == "" {
+		readinessPolicy = CapabilityTunnels
+	}
+
+	// Empty when unset, falling back to the request's host; main rejects
+	// invalid public URLs
+	tunnelBaseURL, _ := TunnelBaseURL(config.PublicURL)
+
+	requestTimeout := config.RequestTimeout
+	if requestTimeout <= 0 {
+		requestTimeout = DefaultRequestTimeout
+	}
+	sessionCreateTimeout := config.SessionCreateTimeout
+	if sessionCreateTimeout <= 0 {
+		sessionCreateTimeout = DefaultSessionCreateTimeout
+	}
+
 	return &Handlers{
-		oidcProvider:     oidcProvider,
-		sessionStore:     sessionStore,
-		jupyterHubClient: jupyterHubClient,
-		tunnelManager:    tunnelManager,
+		oidcProvider:       oidcProvider,
+		sessionStore:       sessionStore,
+		jupyterHubClient:   jupyterHubClient,
+		tunnelManager:      tunnelManager,
+		usernameNormalizer: normalizer,
+		maxBodyBytes:       maxBodyBytes,
+		podGetter:          config.PodGetter,
+		tokenSigner:        config.TokenSigner,
+
+		instanceURL:   config.InstanceURL,
+		internalToken: config.InternalToken,
+		forwardClient: forwardClient,
+		tunnelBaseURL: tunnelBaseURL,
+		proxies:       parseProxies(config.TrustedProxies),
+
+		dependencyChecks: config.DependencyChecks,
+		readinessPolicy:  readinessPolicy,
+
+		requestTimeout:       requestTimeout,
+		sessionCreateTimeout: sessionCreateTimeout,
+
+		sessionCreations: newSessionCreations(config.CreateConcurrency),
 	}
 }
 
 func RegisterRoutes(router *gin.Engine, handlers *Handlers) {
+	timeout := Timeout(handlers.requestTimeout)
+
 	// Health check
-	router.GET("/health", handlers.Health)
+	router.GET("/health", timeout, handlers.Health)
+	router.GET("/ready", timeout, handlers.Ready)
+
+	// Observability
+	router.GET("/metrics", timeout, gin.WrapH(promhttp.Handler()))
+	router.GET("/stats", timeout, handlers.Stats)
+
+	// Session token verification keys
+	router.GET("/.well-known/jwks.json", timeout, handlers.JWKS)
 
 	// Auth endpoints
-	router.GET("/auth/start", handlers.StartAuth)
-	router.GET("/auth/callback", handlers.AuthCallback)
+	router.GET("/auth/start", timeout, handlers.StartAuth)
+	router.GET("/auth/callback", timeout, handlers.AuthCallback)
+	router.POST("/auth/logout", timeout, handlers.LimitBody, handlers.Logout)
 
-	// Session endpoints
-	router.POST("/session", handlers.CreateSession)
-	router.GET("/session/:id", handlers.GetSession)
-	router.DELETE("/session/:id", handlers.DeleteSession)
+	// Session endpoints; the streamed creation reports its own progress and
+	// runs without a timeout
+	router.POST("/session", Timeout(handlers.sessionCreateTimeout), handlers.LimitBody, handlers.CreateSession)
+	router.GET("/session/stream", handlers.StreamSession)
+	router.GET("/session/:id", timeout, handlers.GetSession)
+	router.GET("/session/:id/status", timeout, handlers.GetSessionStatus)
+	router.DELETE("/session/:id", timeout, handlers.DeleteSession)
+	router.POST("/session/:id/handoff", timeout, handlers.CreateHandoff)
+	router.POST("/session/claim", timeout, handlers.LimitBody, handlers.ClaimHandoff)
 
-	// Tunnel endpoint
+	// Tunnel endpoint, open for as long as the tunnel
 	router.GET("/tunnel/:session_id", handlers.HandleTunnel)
+
+	// Requests forwarded from other replicas
+	if handlers.internalToken != "" {
+		router.POST("/internal/tunnels/:session_id/close", timeout, handlers.RequireInternalToken, handlers.CloseTunnel)
+	}
+}
+
+// LimitBody rejects request bodies over the configured size with 413
+func (h *Handlers) LimitBody(c *gin.Context) {
+	if c.Request.ContentLength > h.maxBodyBytes {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+		return
+	}
+
+	// Bodies without a Content-Length fail when read past the limit
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxBodyBytes)
+	c.Next()
 }
 
 func (h *Handlers) Health(c *gin.Context) {
@@ -57,8 +265,38 @@ func (h *Handlers) Health(c *gin.Context) {
 	})
 }
 
+func (h *Handlers) Stats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"tunnels": h.tunnelManager.Stats(),
+	})
+}
+
+// JWKS publishes the session token public key so proxies can verify tokens
+// without the signing secret; not found with symmetric signing
+func (h *Handlers) JWKS(c *gin.Context) {
+	if h.tokenSigner == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session tokens are not signed with a public key"})
+		return
+	}
+
+	jwks, ok := h.tokenSigner.JWKS()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session tokens are not signed with a public key"})
+		return
+	}
+	c.JSON(http.StatusOK, jwks)
+}
+
+// StartAuth starts a login. With ?silent=true the issuer is asked not to
+// show any page, so a client can quietly renew its login while the user's
+// session with the identity provider lasts.
 func (h *Handlers) StartAuth(c *gin.Context) {
-	authURL, state, err := h.oidcProvider.StartFlow(c.Request.Context())
+	silent, _ := strconv.ParseBool(c.Query("silent"))
+	authURL, state, err := h.oidcProvider.StartFlow(c.Request.Context(), silent)
+	if errors.Is(err, auth.ErrTooManyPendingFlows) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "code": codeAuthFlowsFull})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -74,64 +312,279 @@ func (h *Handlers) AuthCallback(c *gin.Context) {
 	code := c.Query("code")
 	state := c.Query("state")
 
+	// The issuer redirects with an error instead of a code when it cannot
+	// log the user in, which a silent login does whenever it would need them
+	if issuerErr := c.Query("error"); issuerErr != "" {
+		err := auth.CallbackError(issuerErr, c.Query("error_description"))
+		if errors.Is(err, auth.ErrInteractionRequired) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "code": codeInteractionRequired})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if code == "" || state == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing code or state parameter"})
 		return
 	}
 
 	tokens, err := h.oidcProvider.HandleCallback(c.Request.Context(), code, state)
+	if errors.Is(err, auth.ErrAuthFlowExpired) || errors.Is(err, auth.ErrUnknownFlow) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "code": codeAuthFlowExpired})
+		return
+	}
+	if errors.Is(err, auth.ErrUpstream) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"access_token":  tokens.AccessToken,
 		"refresh_token": tokens.RefreshToken,
 		"expires_in":    tokens.ExpiresIn,
-	})
+		"scope":         strings.Join(tokens.Scopes, " "),
+	}
+	if tokens.IDToken != "" {
+		response["id_token"] = tokens.IDToken
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Logout revokes the bearer access token so it can no longer create sessions
+func (h *Handlers) Logout(c *gin.Context) {
+	accessToken := bearerToken(c)
+	if accessToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing bearer access token"})
+		return
+	}
+
+	if err := h.oidcProvider.RevokeToken(c.Request.Context(), accessToken); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "logged_out"})
 }
 
 func (h *Handlers) CreateSession(c *gin.Context) {
 	var req CreateSessionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := session.ValidateMetadata(req.Metadata); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Validate access token
-	userInfo, err := h.oidcProvider.ValidateToken(c.Request.Context(), req.AccessToken)
+	created, status, err := h.createSession(c.Request.Context(), req.AccessToken, req.RefreshToken,
+		c.GetHeader(idempotencyKeyHeader), req.Metadata, nil)
+	if errors.Is(err, session.ErrRequestInProgress) {
+		c.JSON(status, gin.H{"status": "pending", "error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+		c.JSON(status, errorResponse(err))
 		return
 	}
 
-	// Ensure JupyterHub pod is running
-	podInfo, err := h.jupyterHubClient.EnsurePodRunning(c.Request.Context(), userInfo.Email)
+	c.JSON(http.StatusOK, h.sessionResponse(c, created))
+}
+
+// StreamSession creates a session like CreateSession, streaming progress as
+// server-sent events: authenticating, spawning, waiting_for_ready, then ready
+// with the session payload or error. The access token is read from the
+// Authorization header, the refresh token from X-Refresh-Token and optional
+// metadata from X-Session-Metadata as a JSON object.
+func (h *Handlers) StreamSession(c *gin.Context) {
+	accessToken := bearerToken(c)
+	if accessToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing bearer access token"})
+		return
+	}
+	// The refresh token is required, as in CreateSession's body
+	refreshToken := c.GetHeader("X-Refresh-Token")
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing X-Refresh-Token header"})
+		return
+	}
+
+	var metadata map[string]string
+	if header := c.GetHeader(sessionMetadataHeader); header != "" {
+		if err := json.Unmarshal([]byte(header), &metadata); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s header: %v", sessionMetadataHeader, err)})
+			return
+		}
+	}
+	if err := session.ValidateMetadata(metadata); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering
+
+	send := func(event string, data interface{}) {
+		c.SSEvent(event, data)
+		c.Writer.Flush()
+	}
+
+	send("authenticating", gin.H{})
+	created, status, err := h.createSession(c.Request.Context(), accessToken, refreshToken,
+		c.GetHeader(idempotencyKeyHeader), metadata, func(progress jupyterhub.SpawnProgress) {
+			send(progress.Phase, progress)
+		})
+	if errors.Is(err, session.ErrRequestInProgress) {
+		send("pending", gin.H{"status": "pending", "error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		response := errorResponse(err)
+		response["status"] = status
+		send("error", response)
 		return
 	}
 
+	send("ready", h.sessionResponse(c, created))
+}
+
+// createSession validates the access token, ensures the user's pod is running
+// and stores a new session, returning the HTTP status to report on failure.
+// A retry carrying the idempotency key of an earlier request gets that
+// request's session instead of a new one, and requests arriving while one of
+// the user's sessions is being created get that session.
+func (h *Handlers) createSession(
+	ctx context.Context,
+	accessToken, refreshToken, idempotencyKey string,
+	metadata map[string]string,
+	progress jupyterhub.ProgressFunc,
+) (created *types.Session, _ int, err error) {
+	// Validate access token
+	authCtx, span := tracing.Start(ctx, "auth.validate_token")
+	userInfo, err := h.oidcProvider.ValidateToken(authCtx, accessToken)
+	tracing.End(span, err)
+	if errors.Is(err, auth.ErrUpstream) {
+		return nil, http.StatusBadGateway, err
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, errors.New("invalid access token")
+	}
+	tracing.SetAttributes(ctx, tracing.UserIDKey.String(userInfo.Email))
+
+	if idempotencyKey != "" {
+		// Keys are per user, so one user's key cannot return another's session
+		key := userInfo.Email + "\x00" + idempotencyKey
+		existing, err := h.sessionStore.ReserveIdempotencyKey(ctx, key, requestFingerprint(metadata))
+		if errors.Is(err, session.ErrRequestInProgress) {
+			return nil, http.StatusAccepted, err
+		}
+		if errors.Is(err, session.ErrIdempotencyKeyReused) {
+			return nil, http.StatusUnprocessableEntity, err
+		}
+		if err != nil {
+			status, _ := sessionErrorResponse(err)
+			return nil, status, err
+		}
+		if existing != nil {
+			return existing, http.StatusOK, nil
+		}
+
+		// Record the outcome even if the client has gone, for its retry
+		defer func() {
+			storeCtx := context.WithoutCancel(ctx)
+			if err != nil {
+				h.sessionStore.ReleaseIdempotencyKey(storeCtx, key)
+				return
+			}
+			h.sessionStore.CompleteIdempotencyKey(storeCtx, key, created.ID)
+		}()
+	}
+
+	return h.sessionCreations.do(ctx, userInfo.Email, progress,
+		func(ctx context.Context, progress jupyterhub.ProgressFunc) (*types.Session, int, error) {
+			return h.createUserSession(ctx, userInfo, refreshToken, metadata, progress)
+		})
+}
+
+// requestFingerprint identifies a session creation request by its metadata,
+// the only part of it besides the tokens. The tokens are left out: they
+// identify the user, who is already part of the idempotency key, and a
+// client may refresh them between retries.
+func requestFingerprint(metadata map[string]string) string {
+	if len(metadata) == 0 {
+		return ""
+	}
+	// Maps are marshalled with sorted keys, so equal metadata hashes equally
+	encoded, _ := json.Marshal(metadata)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// createUserSession ensures the user's pod is running and stores a new
+// session for them
+func (h *Handlers) createUserSession(
+	ctx context.Context,
+	userInfo *types.UserInfo,
+	refreshToken string,
+	metadata map[string]string,
+	progress jupyterhub.ProgressFunc,
+) (*types.Session, int, error) {
+	// Map the identity to the JupyterHub username the authenticator would use
+	hubUsername, err := h.usernameNormalizer.Normalize(userInfo.Email)
+	if err != nil {
+		return nil, http.StatusForbidden, err
+	}
+
+	// Ensure JupyterHub pod is running
+	hubCtx, span := tracing.Start(ctx, "jupyterhub.ensure_pod_running", tracing.UserIDKey.String(userInfo.Email))
+	podInfo, err := h.jupyterHubClient.EnsurePodRunningWithProgress(hubCtx, hubUsername, progress)
+	tracing.End(span, err)
+	if err != nil {
+		// The pod's events may explain why its server is slow to start
+		var timeoutErr *jupyterhub.SpawnTimeoutError
+		if errors.As(err, &timeoutErr) && timeoutErr.Pod != nil && h.podGetter != nil {
+			err = &podNotReadyError{err: err, events: h.podEvents(ctx, timeoutErr.Pod)}
+		}
+		// Pod discovery by label reports the same lookup errors as checkPod
+		return nil, podLookupStatus(err), err
+	}
+
+	podInfo.Owner = hubUsername
+
+	podCtx, span := tracing.Start(ctx, "k8s.check_pod", tracing.UserIDKey.String(userInfo.Email))
+	status, err := h.checkPod(podCtx, podInfo)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, status, err
+	}
+
 	// Create session
-	session, err := h.sessionStore.Create(c.Request.Context(), session.CreateRequest{
+	created, err := h.sessionStore.Create(ctx, session.CreateRequest{
 		UserID:       userInfo.Email,
-		RefreshToken: req.RefreshToken,
+		RefreshToken: refreshToken,
 		PodInfo:      *podInfo,
+		Metadata:     metadata,
 	})
+	if errors.Is(err, session.ErrStoreFull) {
+		return nil, http.StatusServiceUnavailable, err
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, http.StatusInternalServerError, err
 	}
+	tracing.SetAttributes(ctx, tracing.SessionIDKey.String(created.ID))
 
-	c.JSON(http.StatusOK, gin.H{
-		"session_id":    session.ID,
-		"username":      session.UserID,
-		"namespace":     session.PodInfo.Namespace,
-		"pod":           session.PodInfo.Name,
-		"tunnel_url":    fmt.Sprintf("wss://%s/tunnel/%s", c.Request.Host, session.ID),
-		"session_token": session.Token,
-	})
+	return created, http.StatusOK, nil
 }
 
 func (h *Handlers) GetSession(c *gin.Context) {
@@ -139,32 +592,148 @@ func (h *Handlers) GetSession(c *gin.Context) {
 
 	session, err := h.sessionStore.Get(c.Request.Context(), sessionID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		c.JSON(sessionErrorResponse(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"session_id":    session.ID,
-		"username":      session.UserID,
-		"namespace":     session.PodInfo.Namespace,
-		"pod":           session.PodInfo.Name,
-		"tunnel_url":    fmt.Sprintf("wss://%s/tunnel/%s", c.Request.Host, session.ID),
-		"session_token": session.Token,
-	})
+	c.JSON(http.StatusOK, h.sessionSummary(c, session))
+}
+
+// GetSessionStatus reports whether the session's pod is running, with its
+// recent events when it is not
+func (h *Handlers) GetSessionStatus(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	session, err := h.sessionStore.Get(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(sessionErrorResponse(err))
+		return
+	}
+
+	pod := &session.PodInfo
+	if h.podGetter != nil {
+		pod, err = h.podGetter.GetPod(c.Request.Context(), session.PodInfo.Namespace, session.PodInfo.Name)
+		if errors.Is(err, k8s.ErrNamespaceNotFound) || errors.Is(err, k8s.ErrPodNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, k8s.ErrPodNotAllowed) || errors.Is(err, k8s.ErrNamespaceDenied) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	response := SessionStatusResponse{
+		SessionID: session.ID,
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Status:    pod.Status,
+		Ready:     pod.Status == "Running",
+	}
+	if !response.Ready && h.podGetter != nil {
+		response.Events = h.podEvents(c.Request.Context(), pod)
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 func (h *Handlers) DeleteSession(c *gin.Context) {
 	sessionID := c.Param("id")
 
+	// Look up the tunnel owner first, it is gone with the session
+	var owner string
+	if existing, err := h.sessionStore.Get(c.Request.Context(), sessionID); err == nil {
+		owner = existing.TunnelOwner
+	}
+
 	err := h.sessionStore.Delete(c.Request.Context(), sessionID)
+	if err == nil || errors.Is(err, session.ErrSessionExpired) {
+		h.closeTunnel(c.Request.Context(), sessionID, owner)
+	}
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		c.JSON(sessionErrorResponse(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "session deleted"})
 }
 
+// CreateHandoff issues a one-time code for continuing the session on another
+// device. The caller proves it holds the session with its session token as
+// "Authorization: Bearer".
+func (h *Handlers) CreateHandoff(c *gin.Context) {
+	sessionID := c.Param("id")
+
+	existing, err := h.sessionStore.GetByToken(c.Request.Context(), bearerToken(c))
+	if err != nil || existing.ID != sessionID {
+		c.JSON(http.StatusUnauthorized, tokenErrorResponse(err))
+		return
+	}
+
+	code, expiresAt, err := h.sessionStore.CreateHandoff(c.Request.Context(), sessionID)
+	if err != nil {
+		c.JSON(sessionErrorResponse(err))
+		return
+	}
+
+	log.Printf("Audit: user %s issued a handoff code for session %s", existing.UserID, sessionID)
+	c.JSON(http.StatusOK, gin.H{
+		"handoff_code": code,
+		"expires_at":   expiresAt,
+		"expires_in":   int(time.Until(expiresAt).Seconds()),
+	})
+}
+
+// ClaimHandoff exchanges a handoff code for its session on another device.
+// The claiming user must log in themselves; the code only moves a session
+// between devices of the user who owns it.
+func (h *Handlers) ClaimHandoff(c *gin.Context) {
+	var req ClaimHandoffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body too large"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the access token before the code is spent
+	userInfo, err := h.oidcProvider.ValidateToken(c.Request.Context(), req.AccessToken)
+	if errors.Is(err, auth.ErrUpstream) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
+		return
+	}
+
+	claimed, err := h.sessionStore.ClaimHandoff(c.Request.Context(), req.HandoffCode)
+	if errors.Is(err, session.ErrHandoffInvalid) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "code": codeHandoffInvalid})
+		return
+	}
+	if err != nil {
+		c.JSON(sessionErrorResponse(err))
+		return
+	}
+
+	if claimed.UserID != userInfo.Email {
+		log.Printf("Audit: user %s was refused session %s handed off by %s", userInfo.Email, claimed.ID, claimed.UserID)
+		c.JSON(http.StatusForbidden, gin.H{"error": "handoff code was issued to another user"})
+		return
+	}
+
+	log.Printf("Audit: user %s claimed session %s on another device", userInfo.Email, claimed.ID)
+	c.JSON(http.StatusOK, h.sessionResponse(c, claimed))
+}
+
 func (h *Handlers) HandleTunnel(c *gin.Context) {
 	sessionID := c.Param("session_id")
 	token := c.Query("token")
@@ -172,15 +741,199 @@ func (h *Handlers) HandleTunnel(c *gin.Context) {
 	// Validate session token
 	session, err := h.sessionStore.GetByToken(c.Request.Context(), token)
 	if err != nil || session.ID != sessionID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid session token"})
+		c.JSON(http.StatusUnauthorized, tokenErrorResponse(err))
 		return
 	}
 
 	// Upgrade to WebSocket and start tunnel
-	h.tunnelManager.HandleConnection(c.Writer, c.Request, session)
+	// The tunnel logs the client address resolved through trusted proxies
+	r := c.Request.WithContext(tunnel.WithClientIP(c.Request.Context(), c.ClientIP()))
+	h.tunnelManager.HandleConnection(c.Writer, r, session)
 }
 
 type CreateSessionRequest struct {
 	AccessToken  string `json:"access_token" binding:"required"`
 	RefreshToken string `json:"refresh_token" binding:"required"`
+	// Metadata describes the client, such as its workspace name, version
+	// and platform
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type ClaimHandoffRequest struct {
+	HandoffCode string `json:"handoff_code" binding:"required"`
+	AccessToken string `json:"access_token" binding:"required"`
+}
+
+// checkPod confirms the pod JupyterHub reported exists and is running, so a
+// mis-resolved namespace or vanished pod fails now rather than in the tunnel
+func (h *Handlers) checkPod(ctx context.Context, podInfo *types.PodInfo) (int, error) {
+	if h.podGetter == nil {
+		return http.StatusOK, nil
+	}
+
+	pod, err := h.podGetter.GetPod(ctx, podInfo.Namespace, podInfo.Name)
+	if err != nil {
+		return podLookupStatus(err), err
+	}
+
+	if pod.Status != "Running" {
+		return http.StatusConflict, &podNotReadyError{
+			err:    fmt.Errorf("pod %s/%s is %s, not Running", pod.Namespace, pod.Name, pod.Status),
+			events: h.podEvents(ctx, pod),
+		}
+	}
+	return http.StatusOK, nil
+}
+
+// podLookupStatus maps an error looking up the user's pod to a status
+func podLookupStatus(err error) int {
+	switch {
+	case errors.Is(err, k8s.ErrNamespaceNotFound), errors.Is(err, k8s.ErrPodNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, k8s.ErrPodNotAllowed), errors.Is(err, k8s.ErrNamespaceDenied):
+		return http.StatusForbidden
+	case errors.Is(err, k8s.ErrMultiplePods), errors.Is(err, jupyterhub.ErrSpawnInProgress):
+		return http.StatusConflict
+	case errors.Is(err, k8s.ErrTimeout), errors.Is(err, jupyterhub.ErrSpawnTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// podEvents returns the pod's recent events, or none if they cannot be listed;
+// they only help explain another failure
+func (h *Handlers) podEvents(ctx context.Context, pod *types.PodInfo) []types.PodEvent {
+	events, err := h.podGetter.GetPodEvents(ctx, pod.Namespace, pod.Name)
+	if err != nil {
+		log.Printf("Failed to get events for pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	return events
+}
+
+// errorResponse builds an error payload, including pod events when the pod
+// is not ready and how far the spawn got when it timed out
+func errorResponse(err error) gin.H {
+	response := gin.H{"error": err.Error()}
+
+	var notReady *podNotReadyError
+	if errors.As(err, &notReady) {
+		response["events"] = notReady.events
+	}
+	if errors.Is(err, jupyterhub.ErrSpawnInProgress) {
+		response["code"] = codeSpawnInProgress
+	}
+	if errors.Is(err, session.ErrStoreFull) {
+		response["code"] = codeSessionStoreFull
+	}
+	if errors.Is(err, session.ErrIdempotencyKeyReused) {
+		response["code"] = codeIdempotencyKeyReused
+	}
+	var spawnTimeout *jupyterhub.SpawnTimeoutError
+	if errors.As(err, &spawnTimeout) {
+		response["code"] = codeSpawnTimeout
+		response["retryable"] = true
+		response["elapsed_seconds"] = int(spawnTimeout.Elapsed.Seconds())
+		response["progress"] = spawnTimeout.Progress
+	}
+	return response
+}
+
+// Error codes telling clients how to recover from a rejected session token,
+// all prefixed session_token_
+const (
+	// codeTokenInvalidReauth means no signing key verifies the token, as after
+	// a secret change, so the client must create a new session
+	codeTokenInvalidReauth = "session_token_invalid_reauth"
+
+	// codeTokenExpired means the token is past its expiry
+	codeTokenExpired = "session_token_expired"
+
+	// codeTokenMalformed means the token is not a JWT at all, e.g. truncated
+	// or oversized, so the client is sending the wrong value
+	codeTokenMalformed = "session_token_malformed"
+
+	// codeTokenInvalidSignature means the token is not signed the way the
+	// broker signs, e.g. with alg none, so it was never issued by the broker
+	codeTokenInvalidSignature = "session_token_invalid_signature"
+
+	// codeSessionExpired means the session itself timed out, so the client
+	// must log in again
+	codeSessionExpired = "session_expired"
+
+	// codeSessionNotFound means no session has the given ID
+	codeSessionNotFound = "session_not_found"
+
+	// codeAuthFlowExpired means the login callback came after the auth flow
+	// timeout or matches no pending login, so the client must start a new one
+	codeAuthFlowExpired = "auth_flow_expired"
+
+	// codeAuthFlowsFull means the broker holds its maximum number of pending
+	// logins, so the client should retry later
+	codeAuthFlowsFull = "auth_flows_full"
+
+	// codeInteractionRequired means a silent login needs the user, so the
+	// client must start an interactive one
+	codeInteractionRequired = "interaction_required"
+
+	// codeHandoffInvalid means the handoff code is unknown, expired or spent,
+	// so the first device must issue a new one
+	codeHandoffInvalid = "handoff_invalid"
+
+	// codeSpawnInProgress means another request is starting the user's
+	// server, so the client should retry shortly
+	codeSpawnInProgress = "spawn_in_progress"
+
+	// codeSpawnTimeout means the user's server did not start in time; it may
+	// still be starting, so the client can retry
+	codeSpawnTimeout = "spawn_timeout"
+
+	// codeSessionStoreFull means the broker holds its maximum number of
+	// sessions, so the client should retry later
+	codeSessionStoreFull = "session_store_full"
+
+	// codeIdempotencyKeyReused means the Idempotency-Key was first sent with
+	// a different request, so the client must use a new key for this one
+	codeIdempotencyKeyReused = "idempotency_key_reused"
+)
+
+// sessionErrorResponse maps a session lookup error to a status and payload:
+// 401 for a session that timed out, 404 for one that never existed
+func sessionErrorResponse(err error) (int, gin.H) {
+	switch {
+	case errors.Is(err, session.ErrSessionExpired):
+		return http.StatusUnauthorized, gin.H{"error": "session expired, log in again", "code": codeSessionExpired}
+	case errors.Is(err, session.ErrSessionNotFound):
+		return http.StatusNotFound, gin.H{"error": "session not found", "code": codeSessionNotFound}
+	default:
+		return http.StatusInternalServerError, gin.H{"error": err.Error()}
+	}
+}
+
+// tokenErrorResponse builds the error payload for a rejected session token
+func tokenErrorResponse(err error) gin.H {
+	switch {
+	case errors.Is(err, session.ErrTokenUnknownKey):
+		return gin.H{"error": "session token is no longer valid, create a new session", "code": codeTokenInvalidReauth}
+	case errors.Is(err, session.ErrTokenMalformed):
+		return gin.H{"error": "session token is malformed", "code": codeTokenMalformed}
+	case errors.Is(err, session.ErrTokenInvalidSignature):
+		return gin.H{"error": "session token signature is invalid", "code": codeTokenInvalidSignature}
+	case errors.Is(err, session.ErrTokenExpired):
+		return gin.H{"error": "session token expired", "code": codeTokenExpired}
+	case errors.Is(err, session.ErrSessionExpired):
+		return gin.H{"error": "session expired, log in again", "code": codeSessionExpired}
+	default:
+		return gin.H{"error": "invalid session token"}
+	}
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header, or ""
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(header, "Bearer ")
 }
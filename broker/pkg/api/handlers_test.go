@@ -0,0 +1,652 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/purdue-af/vscode-k8s-connector/internal/auth"
+	"github.com/purdue-af/vscode-k8s-connector/internal/jupyterhub"
+	"github.com/purdue-af/vscode-k8s-connector/internal/k8s"
+	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+	"github.com/purdue-af/vscode-k8s-connector/internal/tunnel"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeProvider accepts every access token as alice unless its funcs say otherwise
+type fakeProvider struct {
+	startFlow      func(ctx context.Context, silent bool) (string, string, error)
+	handleCallback func(ctx context.Context, code, state string) (*types.TokenSet, error)
+}
+
+func (p *fakeProvider) StartFlow(ctx context.Context, silent bool) (string, string, error) {
+	if p.startFlow != nil {
+		return p.startFlow(ctx, silent)
+	}
+	return "https://cilogon.org/authorize", "test-state", nil
+}
+
+func (p *fakeProvider) HandleCallback(ctx context.Context, code, state string) (*types.TokenSet, error) {
+	if p.handleCallback != nil {
+		return p.handleCallback(ctx, code, state)
+	}
+	return &types.TokenSet{AccessToken: "access-token", RefreshToken: "refresh-token"}, nil
+}
+
+func (p *fakeProvider) ValidateToken(ctx context.Context, accessToken string) (*types.UserInfo, error) {
+	return &types.UserInfo{Email: "alice@purdue.edu"}, nil
+}
+
+func (p *fakeProvider) RefreshToken(ctx context.Context, refreshToken string) (*types.TokenSet, error) {
+	return &types.TokenSet{AccessToken: "access-token", RefreshToken: refreshToken}, nil
+}
+
+func (p *fakeProvider) RevokeToken(ctx context.Context, accessToken string) error {
+	return nil
+}
+
+// fakeHub reports a running pod for every user, counting the servers it is
+// asked to start and holding each start until release is closed, if set
+type fakeHub struct {
+	mutex   sync.Mutex
+	starts  int
+	release chan struct{}
+}
+
+func (h *fakeHub) GetUserPod(ctx context.Context, username string) (*types.PodInfo, error) {
+	return &types.PodInfo{Name: "jupyter-" + username, Namespace: "cms", Status: "Running"}, nil
+}
+
+func (h *fakeHub) EnsurePodRunning(ctx context.Context, username string) (*types.PodInfo, error) {
+	return h.EnsurePodRunningWithProgress(ctx, username, nil)
+}
+
+func (h *fakeHub) EnsurePodRunningWithProgress(ctx context.Context, username string, progress jupyterhub.ProgressFunc) (*types.PodInfo, error) {
+	h.mutex.Lock()
+	h.starts++
+	h.mutex.Unlock()
+
+	if h.release != nil {
+		<-h.release
+	}
+	return h.GetUserPod(ctx, username)
+}
+
+func (h *fakeHub) StopUserPod(ctx context.Context, username string) error {
+	return nil
+}
+
+// fakeTunnels holds a tunnel for every session unless notHeld, recording
+// the ones closed
+type fakeTunnels struct {
+	mutex    sync.Mutex
+	closed   []string
+	notHeld  bool
+	draining bool
+}
+
+func (m *fakeTunnels) HandleConnection(w http.ResponseWriter, r *http.Request, session *types.Session) {
+	w.WriteHeader(http.StatusSwitchingProtocols)
+}
+
+func (m *fakeTunnels) CloseTunnel(sessionID string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.notHeld {
+		return errors.New("no tunnel for session")
+	}
+	m.closed = append(m.closed, sessionID)
+	return nil
+}
+
+func (m *fakeTunnels) Stats() tunnel.Stats {
+	return tunnel.Stats{Draining: m.draining}
+}
+
+// newTestRouter serves handlers built from provider, hub and an in-memory
+// store, using fakes for a nil provider or hub
+func newTestRouter(provider auth.Provider, hub jupyterhub.ClientInterface, config HandlersConfig) (*gin.Engine, *session.InMemoryStore) {
+	if provider == nil {
+		provider = &fakeProvider{}
+	}
+	if hub == nil {
+		hub = &fakeHub{}
+	}
+	store := session.NewInMemoryStore("1h", "test-secret")
+	return newRouterWithStore(provider, hub, store, config), store
+}
+
+// newRouterWithStore serves handlers built on store, as configured by a test
+func newRouterWithStore(provider auth.Provider, hub jupyterhub.ClientInterface, store session.Store, config HandlersConfig) *gin.Engine {
+	handlers := NewHandlers(provider, store, hub, &fakeTunnels{}, config)
+	router := gin.New()
+	RegisterRoutes(router, handlers)
+	return router
+}
+
+// serve sends req to router, decoding a JSON response body into a map
+func serve(router http.Handler, req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	var body map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder, body
+}
+
+func TestStartAuth_TooManyPendingFlows(t *testing.T) {
+	provider := &fakeProvider{startFlow: func(ctx context.Context, silent bool) (string, string, error) {
+		return "", "", auth.ErrTooManyPendingFlows
+	}}
+	router, _ := newTestRouter(provider, nil, HandlersConfig{})
+
+	recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/auth/start", nil))
+	if recorder.Code != http.StatusTooManyRequests || body["code"] != codeAuthFlowsFull {
+		t.Errorf("Expected 429 with code %s, got %d %v", codeAuthFlowsFull, recorder.Code, body)
+	}
+}
+
+func TestAuthCallback_UnknownFlow(t *testing.T) {
+	provider := &fakeProvider{handleCallback: func(ctx context.Context, code, state string) (*types.TokenSet, error) {
+		return nil, auth.ErrUnknownFlow
+	}}
+	router, _ := newTestRouter(provider, nil, HandlersConfig{})
+
+	recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/auth/callback?code=test-code&state=reused", nil))
+	if recorder.Code != http.StatusBadRequest || body["code"] != codeAuthFlowExpired {
+		t.Errorf("Expected 400 with code %s, got %d %v", codeAuthFlowExpired, recorder.Code, body)
+	}
+}
+
+// createSessionRequest is a POST /session for alice
+func createSessionRequest() *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/session",
+		strings.NewReader(`{"access_token": "access-token", "refresh_token": "refresh-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestCreateSession_CoalescesConcurrentRequests(t *testing.T) {
+	hub := &fakeHub{release: make(chan struct{})}
+	router, _ := newTestRouter(nil, hub, HandlersConfig{})
+
+	const requests = 5
+	var wg sync.WaitGroup
+	sessionIDs := make(chan interface{}, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recorder, body := serve(router, createSessionRequest())
+			if recorder.Code != http.StatusOK {
+				t.Errorf("Expected 200, got %d %v", recorder.Code, body)
+			}
+			sessionIDs <- body["session_id"]
+		}()
+	}
+
+	// Hold the server start until every request has had time to arrive
+	time.Sleep(50 * time.Millisecond)
+	close(hub.release)
+	wg.Wait()
+	close(sessionIDs)
+
+	unique := map[interface{}]bool{}
+	for id := range sessionIDs {
+		unique[id] = true
+	}
+	if len(unique) != 1 || unique[nil] {
+		t.Errorf("Expected every request to get the same session, got %v", unique)
+	}
+	if hub.starts != 1 {
+		t.Errorf("Expected one server start, got %d", hub.starts)
+	}
+
+	// Once the creation is done, the next request creates a new session
+	_, body := serve(router, createSessionRequest())
+	if unique[body["session_id"]] {
+		t.Errorf("Expected a later request to create a new session, got %v", body["session_id"])
+	}
+}
+
+func TestCreateSession_RejectsConcurrentRequests(t *testing.T) {
+	hub := &fakeHub{release: make(chan struct{})}
+	router, _ := newTestRouter(nil, hub, HandlersConfig{CreateConcurrency: jupyterhub.SpawnConcurrencyReject})
+
+	done := make(chan struct{})
+	go func() {
+		serve(router, createSessionRequest())
+		close(done)
+	}()
+	for {
+		hub.mutex.Lock()
+		started := hub.starts
+		hub.mutex.Unlock()
+		if started == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	recorder, body := serve(router, createSessionRequest())
+	if recorder.Code != http.StatusConflict || body["code"] != codeSpawnInProgress {
+		t.Errorf("Expected 409 with code %s, got %d %v", codeSpawnInProgress, recorder.Code, body)
+	}
+	close(hub.release)
+	<-done
+}
+
+func TestEndEvictedSession(t *testing.T) {
+	tunnels := &fakeTunnels{}
+	var handlers *Handlers
+	store := session.NewInMemoryStoreWithConfig(session.StoreConfig{
+		TTL:            "1h",
+		JWTSecret:      "test-secret",
+		MaxSessions:    1,
+		EvictionPolicy: session.EvictionOldest,
+		OnEvict:        func(evicted *types.Session) { handlers.EndEvictedSession(evicted) },
+	})
+	handlers = NewHandlers(&fakeProvider{}, store, &fakeHub{}, tunnels, HandlersConfig{})
+	router := gin.New()
+	RegisterRoutes(router, handlers)
+
+	_, first := serve(router, createSessionRequest())
+	recorder, second := serve(router, createSessionRequest())
+	if recorder.Code != http.StatusOK || second["session_id"] == first["session_id"] {
+		t.Fatalf("Expected a second session evicting the first, got %d %v", recorder.Code, second)
+	}
+
+	tunnels.mutex.Lock()
+	defer tunnels.mutex.Unlock()
+	if len(tunnels.closed) != 1 || tunnels.closed[0] != first["session_id"] {
+		t.Errorf("Expected the evicted session's tunnel to be closed, got %v", tunnels.closed)
+	}
+}
+
+// createHandoffRequest asks for a handoff code for sessionID with token
+func createHandoffRequest(sessionID, token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/session/"+sessionID+"/handoff", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// claimHandoffRequest claims code as alice
+func claimHandoffRequest(code interface{}) *http.Request {
+	body, _ := json.Marshal(gin.H{"handoff_code": code, "access_token": "access-token"})
+	req := httptest.NewRequest(http.MethodPost, "/session/claim", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestHandoff(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{})
+	_, created := serve(router, createSessionRequest())
+	sessionID, _ := created["session_id"].(string)
+	token, _ := created["session_token"].(string)
+
+	recorder, body := serve(router, createHandoffRequest(sessionID, "wrong-token"))
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong session token, got %d %v", recorder.Code, body)
+	}
+
+	recorder, body = serve(router, createHandoffRequest(sessionID, token))
+	if recorder.Code != http.StatusOK || body["handoff_code"] == nil {
+		t.Fatalf("Expected a handoff code, got %d %v", recorder.Code, body)
+	}
+	code := body["handoff_code"]
+
+	recorder, body = serve(router, claimHandoffRequest(code))
+	if recorder.Code != http.StatusOK || body["session_id"] != sessionID {
+		t.Errorf("Expected the claim to return session %s, got %d %v", sessionID, recorder.Code, body)
+	}
+
+	// A code is spent by its first claim
+	recorder, body = serve(router, claimHandoffRequest(code))
+	if recorder.Code != http.StatusNotFound || body["code"] != codeHandoffInvalid {
+		t.Errorf("Expected 404 with code %s for a second claim, got %d %v", codeHandoffInvalid, recorder.Code, body)
+	}
+}
+
+func TestHandoff_Expired(t *testing.T) {
+	store := session.NewInMemoryStoreWithConfig(session.StoreConfig{
+		TTL:        "1h",
+		JWTSecret:  "test-secret",
+		HandoffTTL: time.Millisecond,
+	})
+	router := newRouterWithStore(&fakeProvider{}, &fakeHub{}, store, HandlersConfig{})
+	_, created := serve(router, createSessionRequest())
+	sessionID, _ := created["session_id"].(string)
+	token, _ := created["session_token"].(string)
+
+	_, body := serve(router, createHandoffRequest(sessionID, token))
+	time.Sleep(10 * time.Millisecond)
+
+	recorder, body := serve(router, claimHandoffRequest(body["handoff_code"]))
+	if recorder.Code != http.StatusNotFound || body["code"] != codeHandoffInvalid {
+		t.Errorf("Expected 404 with code %s for an expired code, got %d %v", codeHandoffInvalid, recorder.Code, body)
+	}
+}
+
+// idempotentSessionRequest is a POST /session for alice with key and metadata
+func idempotentSessionRequest(key, workspace string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/session", strings.NewReader(
+		`{"access_token": "access-token", "refresh_token": "refresh-token", "metadata": {"workspace": "`+workspace+`"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, key)
+	return req
+}
+
+func TestCreateSession_IdempotencyKey(t *testing.T) {
+	hub := &fakeHub{}
+	router, _ := newTestRouter(nil, hub, HandlersConfig{})
+
+	_, first := serve(router, idempotentSessionRequest("key-1", "analysis"))
+	recorder, replay := serve(router, idempotentSessionRequest("key-1", "analysis"))
+	if recorder.Code != http.StatusOK || replay["session_id"] != first["session_id"] {
+		t.Errorf("Expected a replay to return session %v, got %d %v", first["session_id"], recorder.Code, replay)
+	}
+	if hub.starts != 1 {
+		t.Errorf("Expected one server start, got %d", hub.starts)
+	}
+
+	recorder, body := serve(router, idempotentSessionRequest("key-1", "other"))
+	if recorder.Code != http.StatusUnprocessableEntity || body["code"] != codeIdempotencyKeyReused {
+		t.Errorf("Expected 422 with code %s for a different request, got %d %v", codeIdempotencyKeyReused, recorder.Code, body)
+	}
+
+	_, other := serve(router, idempotentSessionRequest("key-2", "analysis"))
+	if other["session_id"] == first["session_id"] {
+		t.Errorf("Expected a new key to create a new session, got %v", other["session_id"])
+	}
+}
+
+func TestSessionErrorResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantCode   interface{}
+	}{
+		{"expired", session.ErrSessionExpired, http.StatusUnauthorized, codeSessionExpired},
+		{"wrapped expired", fmt.Errorf("get session: %w", session.ErrSessionExpired), http.StatusUnauthorized, codeSessionExpired},
+		{"not found", session.ErrSessionNotFound, http.StatusNotFound, codeSessionNotFound},
+		{"wrapped not found", fmt.Errorf("get session: %w", session.ErrSessionNotFound), http.StatusNotFound, codeSessionNotFound},
+		{"other", errors.New("store unavailable"), http.StatusInternalServerError, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := sessionErrorResponse(tt.err)
+			if status != tt.wantStatus || body["code"] != tt.wantCode {
+				t.Errorf("Expected %d with code %v, got %d %v", tt.wantStatus, tt.wantCode, status, body)
+			}
+		})
+	}
+}
+
+func TestGetSession_ExpiredAndMissing(t *testing.T) {
+	store := session.NewInMemoryStoreWithConfig(session.StoreConfig{TTL: "10ms", JWTSecret: "test-secret"})
+	router := newRouterWithStore(&fakeProvider{}, &fakeHub{}, store, HandlersConfig{})
+	_, created := serve(router, createSessionRequest())
+	time.Sleep(20 * time.Millisecond)
+
+	recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/session/"+created["session_id"].(string), nil))
+	if recorder.Code != http.StatusUnauthorized || body["code"] != codeSessionExpired {
+		t.Errorf("Expected 401 with code %s for an expired session, got %d %v", codeSessionExpired, recorder.Code, body)
+	}
+
+	recorder, body = serve(router, httptest.NewRequest(http.MethodGet, "/session/missing", nil))
+	if recorder.Code != http.StatusNotFound || body["code"] != codeSessionNotFound {
+		t.Errorf("Expected 404 with code %s for a missing session, got %d %v", codeSessionNotFound, recorder.Code, body)
+	}
+}
+
+// streamSessionRequest is a GET /session/stream for alice with refreshToken
+func streamSessionRequest(refreshToken string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/session/stream", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	if refreshToken != "" {
+		req.Header.Set("X-Refresh-Token", refreshToken)
+	}
+	return req
+}
+
+func TestCreateSession_RequiresRefreshToken(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{})
+
+	req := httptest.NewRequest(http.MethodPost, "/session", strings.NewReader(`{"access_token": "access-token"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if recorder, body := serve(router, req); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a refresh token, got %d %v", recorder.Code, body)
+	}
+
+	if recorder, body := serve(router, streamSessionRequest("")); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 streaming without a refresh token, got %d %v", recorder.Code, body)
+	}
+
+	recorder, _ := serve(router, streamSessionRequest("refresh-token"))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "event:ready") {
+		t.Errorf("Expected a ready event streaming with a refresh token, got %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestLogout_RevokedTokenRejected(t *testing.T) {
+	provider := auth.NewCachingProvider(&fakeProvider{}, auth.CacheConfig{TTL: time.Minute})
+	router, _ := newTestRouter(provider, nil, HandlersConfig{})
+
+	if recorder, body := serve(router, createSessionRequest()); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected a session before logout, got %d %v", recorder.Code, body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.Header.Set("Authorization", "Bearer access-token")
+	if recorder, body := serve(router, req); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected logout to succeed, got %d %v", recorder.Code, body)
+	}
+
+	// The token stays valid at the fake issuer, so only the denylist rejects it
+	if recorder, body := serve(router, createSessionRequest()); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a logged-out token, got %d %v", recorder.Code, body)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{MaxBodyBytes: 1024})
+
+	body := `{"access_token": "access-token", "refresh_token": "refresh-token", "metadata": {"workspace": "` +
+		strings.Repeat("a", 2048) + `"}}`
+	for _, path := range []string{"/session", "/session/claim"} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if recorder, response := serve(router, req); recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected 413 for an oversized body on %s, got %d %v", path, recorder.Code, response)
+		}
+	}
+
+	if recorder, response := serve(router, createSessionRequest()); recorder.Code != http.StatusOK {
+		t.Errorf("Expected a body within the limit to be accepted, got %d %v", recorder.Code, response)
+	}
+}
+
+// fakePodGetter reports the pod in status, or fails with err
+type fakePodGetter struct {
+	status string
+	err    error
+}
+
+func (g *fakePodGetter) GetPod(ctx context.Context, namespace, name string) (*types.PodInfo, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	return &types.PodInfo{Name: name, Namespace: namespace, Status: g.status}, nil
+}
+
+func (g *fakePodGetter) GetPodEvents(ctx context.Context, namespace, name string) ([]types.PodEvent, error) {
+	return []types.PodEvent{{Reason: "FailedScheduling", Message: "0/3 nodes are available"}}, nil
+}
+
+func TestCheckPod(t *testing.T) {
+	tests := []struct {
+		name       string
+		getter     *fakePodGetter
+		wantStatus int
+		wantEvents bool
+	}{
+		{"running", &fakePodGetter{status: "Running"}, http.StatusOK, false},
+		{"pending", &fakePodGetter{status: "Pending"}, http.StatusConflict, true},
+		{"succeeded", &fakePodGetter{status: "Succeeded"}, http.StatusConflict, true},
+		{"failed", &fakePodGetter{status: "Failed"}, http.StatusConflict, true},
+		{"unknown", &fakePodGetter{status: "Unknown"}, http.StatusConflict, true},
+		{"pod gone", &fakePodGetter{err: k8s.ErrPodNotFound}, http.StatusNotFound, false},
+		{"namespace gone", &fakePodGetter{err: k8s.ErrNamespaceNotFound}, http.StatusNotFound, false},
+		{"not the user's pod", &fakePodGetter{err: k8s.ErrPodNotAllowed}, http.StatusForbidden, false},
+		{"lookup timeout", &fakePodGetter{err: k8s.ErrTimeout}, http.StatusGatewayTimeout, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := NewHandlers(&fakeProvider{}, session.NewInMemoryStore("1h", "test-secret"),
+				&fakeHub{}, &fakeTunnels{}, HandlersConfig{PodGetter: tt.getter})
+
+			status, err := handlers.checkPod(context.Background(), &types.PodInfo{Name: "jupyter-alice", Namespace: "cms"})
+			if status != tt.wantStatus {
+				t.Errorf("Expected %d, got %d (%v)", tt.wantStatus, status, err)
+			}
+			if (status == http.StatusOK) != (err == nil) {
+				t.Errorf("Expected an error only for a failed check, got %d %v", status, err)
+			}
+			var notReady *podNotReadyError
+			if errors.As(err, &notReady) != tt.wantEvents {
+				t.Errorf("Expected events only for a pod that is not running, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthCallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		callbackErr error
+		wantStatus  int
+		wantCode    interface{}
+	}{
+		{"success", "code=test-code&state=test-state", nil, http.StatusOK, nil},
+		{"missing state", "code=test-code", nil, http.StatusBadRequest, nil},
+		{"silent login needs the user", "error=login_required&state=test-state", nil, http.StatusUnauthorized, codeInteractionRequired},
+		{"issuer refused", "error=access_denied&error_description=denied&state=test-state", nil, http.StatusBadRequest, nil},
+		{"expired flow", "code=test-code&state=test-state", auth.ErrAuthFlowExpired, http.StatusBadRequest, codeAuthFlowExpired},
+		{"issuer unusable", "code=test-code&state=test-state", fmt.Errorf("token exchange: %w", auth.ErrUpstream), http.StatusBadGateway, nil},
+		{"exchange refused", "code=test-code&state=test-state", errors.New("invalid_grant"), http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeProvider{handleCallback: func(ctx context.Context, code, state string) (*types.TokenSet, error) {
+				if tt.callbackErr != nil {
+					return nil, tt.callbackErr
+				}
+				return &types.TokenSet{AccessToken: "access-token", RefreshToken: "refresh-token"}, nil
+			}}
+			router, _ := newTestRouter(provider, nil, HandlersConfig{})
+
+			recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/auth/callback?"+tt.query, nil))
+			if recorder.Code != tt.wantStatus || body["code"] != tt.wantCode {
+				t.Errorf("Expected %d with code %v, got %d %v", tt.wantStatus, tt.wantCode, recorder.Code, body)
+			}
+			if tt.wantStatus == http.StatusOK && body["access_token"] != "access-token" {
+				t.Errorf("Expected the tokens, got %v", body)
+			}
+		})
+	}
+}
+
+func TestStartAuth_Silent(t *testing.T) {
+	var gotSilent bool
+	provider := &fakeProvider{startFlow: func(ctx context.Context, silent bool) (string, string, error) {
+		gotSilent = silent
+		return "https://cilogon.org/authorize?prompt=none", "test-state", nil
+	}}
+	router, _ := newTestRouter(provider, nil, HandlersConfig{})
+
+	recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/auth/start?silent=true", nil))
+	if recorder.Code != http.StatusOK || !gotSilent || body["state"] != "test-state" {
+		t.Errorf("Expected a silent flow, got %d %v (silent %v)", recorder.Code, body, gotSilent)
+	}
+}
+
+func TestPodNotReady_ReportsEvents(t *testing.T) {
+	getter := &fakePodGetter{status: "Pending"}
+	router, _ := newTestRouter(nil, nil, HandlersConfig{PodGetter: getter})
+
+	recorder, body := serve(router, createSessionRequest())
+	if recorder.Code != http.StatusConflict || body["events"] == nil {
+		t.Errorf("Expected 409 with the pod's events, got %d %v", recorder.Code, body)
+	}
+
+	getter.status = "Running"
+	_, created := serve(router, createSessionRequest())
+	statusPath := "/session/" + created["session_id"].(string) + "/status"
+	recorder, body = serve(router, httptest.NewRequest(http.MethodGet, statusPath, nil))
+	if recorder.Code != http.StatusOK || body["ready"] != true || body["events"] != nil {
+		t.Errorf("Expected a ready pod without events, got %d %v", recorder.Code, body)
+	}
+
+	// The pod stops after the session was created
+	getter.status = "Failed"
+	recorder, body = serve(router, httptest.NewRequest(http.MethodGet, statusPath, nil))
+	events, _ := body["events"].([]interface{})
+	if recorder.Code != http.StatusOK || body["ready"] != false || len(events) != 1 {
+		t.Errorf("Expected a pod that is not ready with its events, got %d %v", recorder.Code, body)
+	}
+
+	getter.err = k8s.ErrPodNotFound
+	if recorder, body = serve(router, httptest.NewRequest(http.MethodGet, statusPath, nil)); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 once the pod is gone, got %d %v", recorder.Code, body)
+	}
+}
+
+func TestHandleTunnel_RejectedTokens(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{})
+	_, created := serve(router, createSessionRequest())
+	sessionID := created["session_id"].(string)
+
+	claims := jwt.MapClaims{"session_id": sessionID, "exp": time.Now().Add(time.Hour).Unix()}
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	otherSecret, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("other-secret"))
+
+	tests := []struct {
+		name     string
+		token    string
+		wantCode string
+	}{
+		{"not a JWT", "not-a-jwt", codeTokenMalformed},
+		{"oversized", created["session_token"].(string) + strings.Repeat("A", 4096), codeTokenMalformed},
+		{"alg none", unsigned, codeTokenInvalidSignature},
+		{"unknown key", otherSecret, codeTokenInvalidReauth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/tunnel/"+sessionID+"?token="+url.QueryEscape(tt.token), nil)
+			recorder, body := serve(router, req)
+			if recorder.Code != http.StatusUnauthorized || body["code"] != tt.wantCode {
+				t.Errorf("Expected 401 with code %s, got %d %v", tt.wantCode, recorder.Code, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Dependencies checked by /ready
+const (
+	DependencyKubernetes = "kubernetes"
+	DependencyOIDC       = "oidc"
+	DependencyJupyterHub = "jupyterhub"
+)
+
+// Capabilities reported by /ready, which are also the readiness policies:
+// the broker is ready while the capability named by its policy is
+const (
+	// CapabilityTunnels is serving tunnels for existing sessions, which only
+	// needs the Kubernetes API
+	CapabilityTunnels = "tunnels"
+
+	// CapabilitySessions is creating new sessions, which also needs the OIDC
+	// issuer to validate tokens and JupyterHub to start servers
+	CapabilitySessions = "sessions"
+)
+
+// capabilityDependencies are the dependencies each capability needs
+var capabilityDependencies = map[string][]string{
+	CapabilityTunnels:  {DependencyKubernetes},
+	CapabilitySessions: {DependencyKubernetes, DependencyOIDC, DependencyJupyterHub},
+}
+
+// readinessCheckTimeout bounds each dependency check, so a hung dependency
+// fails its check rather than the probe
+const readinessCheckTimeout = 3 * time.Second
+
+// DependencyCheck reports whether a dependency is reachable
+type DependencyCheck func(ctx context.Context) error
+
+// Ready reports which capabilities the broker can currently provide. It
+// answers 200 while the capability named by the readiness policy is
+// available and 503 otherwise, so with the tunnels policy a broker stays in
+// rotation for active users while JupyterHub or the issuer is down.
+// Dependencies without a check are assumed to be up. A draining broker is
+// never ready, so it is taken out of rotation before it shuts down.
+func (h *Handlers) Ready(c *gin.Context) {
+	results := h.checkDependencies(c.Request.Context())
+
+	dependencies := make(gin.H, len(results))
+	for name, err := range results {
+		if err != nil {
+			dependencies[name] = err.Error()
+		} else {
+			dependencies[name] = "ok"
+		}
+	}
+
+	capabilities := make(map[string]bool, len(capabilityDependencies))
+	for capability, needs := range capabilityDependencies {
+		capabilities[capability] = true
+		for _, name := range needs {
+			if results[name] != nil {
+				capabilities[capability] = false
+			}
+		}
+	}
+
+	draining := h.tunnelManager.Stats().Draining
+	status := http.StatusOK
+	if !capabilities[h.readinessPolicy] || draining {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"ready":        status == http.StatusOK,
+		"policy":       h.readinessPolicy,
+		"capabilities": capabilities,
+		"dependencies": dependencies,
+		"draining":     draining,
+	})
+}
+
+// checkDependencies runs the dependency checks concurrently
+func (h *Handlers) checkDependencies(ctx context.Context) map[string]error {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(h.dependencyChecks))
+	for name, check := range h.dependencyChecks {
+		wg.Add(1)
+		go func(name string, check DependencyCheck) {
+			defer wg.Done()
+			err := check(ctx)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[name] = err
+		}(name, check)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+)
+
+// readinessRouter serves /ready with the given policy, failing the named
+// dependency's check
+func readinessRouter(policy, failing string, tunnels *fakeTunnels) *gin.Engine {
+	checks := map[string]DependencyCheck{}
+	for _, name := range []string{DependencyKubernetes, DependencyOIDC, DependencyJupyterHub} {
+		checks[name] = func(ctx context.Context) error { return nil }
+	}
+	if failing != "" {
+		checks[failing] = func(ctx context.Context) error { return errors.New("connection refused") }
+	}
+
+	handlers := NewHandlers(&fakeProvider{}, session.NewInMemoryStore("1h", "test-secret"), &fakeHub{}, tunnels,
+		HandlersConfig{DependencyChecks: checks, ReadinessPolicy: policy})
+	router := gin.New()
+	RegisterRoutes(router, handlers)
+	return router
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		failing    string
+		wantStatus int
+	}{
+		{"all up", CapabilitySessions, "", http.StatusOK},
+		{"default policy, hub down", "", DependencyJupyterHub, http.StatusOK},
+		{"tunnels policy, issuer down", CapabilityTunnels, DependencyOIDC, http.StatusOK},
+		{"tunnels policy, kubernetes down", CapabilityTunnels, DependencyKubernetes, http.StatusServiceUnavailable},
+		{"sessions policy, hub down", CapabilitySessions, DependencyJupyterHub, http.StatusServiceUnavailable},
+		{"sessions policy, issuer down", CapabilitySessions, DependencyOIDC, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := readinessRouter(tt.policy, tt.failing, &fakeTunnels{})
+			recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/ready", nil))
+			if recorder.Code != tt.wantStatus || body["ready"] != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Expected %d, got %d %v", tt.wantStatus, recorder.Code, body)
+			}
+			if tt.failing != "" {
+				dependencies, _ := body["dependencies"].(map[string]interface{})
+				if dependencies[tt.failing] == "ok" {
+					t.Errorf("Expected %s to be reported down, got %v", tt.failing, dependencies)
+				}
+			}
+		})
+	}
+}
+
+func TestReady_Draining(t *testing.T) {
+	router := readinessRouter(CapabilityTunnels, "", &fakeTunnels{draining: true})
+	recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if recorder.Code != http.StatusServiceUnavailable || body["draining"] != true {
+		t.Errorf("Expected 503 while draining, got %d %v", recorder.Code, body)
+	}
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// SessionSummary describes a session without its token, for responses
+// about an existing session
+type SessionSummary struct {
+	SessionID string            `json:"session_id"`
+	Username  string            `json:"username"`
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	TunnelURL string            `json:"tunnel_url"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// SessionResponse is a session with the token that authenticates it, only
+// returned to the client that created or claimed it
+type SessionResponse struct {
+	SessionSummary
+	SessionToken string `json:"session_token"`
+}
+
+// SessionStatusResponse reports whether the session's pod is running
+type SessionStatusResponse struct {
+	SessionID string `json:"session_id"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Status    string `json:"status"`
+	Ready     bool   `json:"ready"`
+	// Events are the pod's recent events, only reported while it is not ready
+	Events []types.PodEvent `json:"events,omitempty"`
+}
+
+// sessionSummary builds the tokenless session payload
+func (h *Handlers) sessionSummary(c *gin.Context, session *types.Session) SessionSummary {
+	return SessionSummary{
+		SessionID: session.ID,
+		Username:  session.UserID,
+		Namespace: session.PodInfo.Namespace,
+		Pod:       session.PodInfo.Name,
+		TunnelURL: h.tunnelURL(c, session.ID),
+		Metadata:  session.Metadata,
+		CreatedAt: session.CreatedAt,
+		ExpiresAt: session.ExpiresAt,
+	}
+}
+
+// sessionResponse builds the session payload returned on creation and claim
+func (h *Handlers) sessionResponse(c *gin.Context, session *types.Session) SessionResponse {
+	return SessionResponse{
+		SessionSummary: h.sessionSummary(c, session),
+		SessionToken:   session.Token,
+	}
+}
+
+// TunnelBaseURL turns the broker's public URL into the base of the tunnel
+// URLs it advertises: https becomes wss and http becomes ws, and any path
+// prefix the ingress serves the broker under is kept
+func TunnelBaseURL(publicURL string) (string, error) {
+	parsed, err := url.Parse(publicURL)
+	if err != nil {
+		return "", err
+	}
+	switch parsed.Scheme {
+	case "https", "wss":
+		parsed.Scheme = "wss"
+	case "http", "ws":
+		parsed.Scheme = "ws"
+	default:
+		return "", fmt.Errorf("unsupported scheme %q, expected https, http, wss or ws", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return "", fmt.Errorf("no host in %q", publicURL)
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", fmt.Errorf("query or fragment in %q", publicURL)
+	}
+	return strings.TrimSuffix(parsed.String(), "/"), nil
+}
+
+// tunnelURL is the URL a client connects to for a session's tunnel. Without
+// a configured public URL it is built from the request's host, or the
+// X-Forwarded-Host and X-Forwarded-Proto set by a trusted proxy, which is
+// only right when the proxy does not serve the broker under a path prefix.
+func (h *Handlers) tunnelURL(c *gin.Context, sessionID string) string {
+	if h.tunnelBaseURL != "" {
+		return h.tunnelBaseURL + "/tunnel/" + sessionID
+	}
+
+	scheme, host := "wss", c.Request.Host
+	if h.fromTrustedProxy(c) {
+		if forwarded := firstHeaderValue(c, "X-Forwarded-Host"); forwarded != "" {
+			host = forwarded
+		}
+		if firstHeaderValue(c, "X-Forwarded-Proto") == "http" {
+			scheme = "ws"
+		}
+	}
+	return fmt.Sprintf("%s://%s/tunnel/%s", scheme, host, sessionID)
+}
+
+// fromTrustedProxy reports whether the request came from one of the
+// configured proxies, whose forwarding headers can be believed
+func (h *Handlers) fromTrustedProxy(c *gin.Context) bool {
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range h.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstHeaderValue returns the first of a header's comma-separated values,
+// the one set by the proxy nearest the client
+func firstHeaderValue(c *gin.Context, name string) string {
+	value, _, _ := strings.Cut(c.GetHeader(name), ",")
+	return strings.TrimSpace(value)
+}
+
+// parseProxies parses IPs and CIDRs, skipping invalid entries, which main
+// rejects when it configures the router's trusted proxies
+func parseProxies(proxies []string) []*net.IPNet {
+	var parsed []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		if _, network, err := net.ParseCIDR(proxy); err == nil {
+			parsed = append(parsed, network)
+		}
+	}
+	return parsed
+}
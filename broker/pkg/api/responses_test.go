@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/purdue-af/vscode-k8s-connector/internal/session"
+	"github.com/purdue-af/vscode-k8s-connector/internal/types"
+)
+
+// These responses are the API's wire format; clients depend on the exact
+// field names, so any change here must be deliberate
+func TestResponses_JSON(t *testing.T) {
+	createdAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	summary := SessionSummary{
+		SessionID: "abc123",
+		Username:  "alice@purdue.edu",
+		Namespace: "cms",
+		Pod:       "jupyter-alice",
+		TunnelURL: "wss://broker.example.org/tunnel/abc123",
+		Metadata:  map[string]string{"workspace": "analysis"},
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(time.Hour),
+	}
+
+	tests := []struct {
+		name     string
+		response interface{}
+		want     string
+	}{
+		{
+			name:     "session summary",
+			response: summary,
+			want: `{"session_id":"abc123","username":"alice@purdue.edu","namespace":"cms","pod":"jupyter-alice",` +
+				`"tunnel_url":"wss://broker.example.org/tunnel/abc123","metadata":{"workspace":"analysis"},` +
+				`"created_at":"2026-10-16T12:00:00Z","expires_at":"2026-10-16T13:00:00Z"}`,
+		},
+		{
+			name:     "session response",
+			response: SessionResponse{SessionSummary: summary, SessionToken: "token"},
+			want: `{"session_id":"abc123","username":"alice@purdue.edu","namespace":"cms","pod":"jupyter-alice",` +
+				`"tunnel_url":"wss://broker.example.org/tunnel/abc123","metadata":{"workspace":"analysis"},` +
+				`"created_at":"2026-10-16T12:00:00Z","expires_at":"2026-10-16T13:00:00Z","session_token":"token"}`,
+		},
+		{
+			name: "ready pod status",
+			response: SessionStatusResponse{
+				SessionID: "abc123", Namespace: "cms", Pod: "jupyter-alice", Status: "Running", Ready: true,
+			},
+			want: `{"session_id":"abc123","namespace":"cms","pod":"jupyter-alice","status":"Running","ready":true}`,
+		},
+		{
+			name: "pending pod status",
+			response: SessionStatusResponse{
+				SessionID: "abc123", Namespace: "cms", Pod: "jupyter-alice", Status: "Pending",
+				Events: []types.PodEvent{{Type: "Warning", Reason: "FailedScheduling", Message: "0/3 nodes are available", Timestamp: createdAt}},
+			},
+			want: `{"session_id":"abc123","namespace":"cms","pod":"jupyter-alice","status":"Pending","ready":false,` +
+				`"events":[{"type":"Warning","reason":"FailedScheduling","message":"0/3 nodes are available","timestamp":"2026-10-16T12:00:00Z"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.response)
+			if err != nil {
+				t.Fatalf("Failed to marshal: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Unexpected JSON\n got: %s\nwant: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetSession_OmitsToken(t *testing.T) {
+	router, _ := newTestRouter(nil, nil, HandlersConfig{})
+	_, created := serve(router, createSessionRequest())
+	if created["session_token"] == nil {
+		t.Fatalf("Expected the creation response to carry the token, got %v", created)
+	}
+
+	_, body := serve(router, httptest.NewRequest(http.MethodGet, "/session/"+created["session_id"].(string), nil))
+	if _, exists := body["session_token"]; exists || body["session_id"] != created["session_id"] {
+		t.Errorf("Expected the session without its token, got %v", body)
+	}
+}
+
+func TestTunnelBaseURL(t *testing.T) {
+	tests := []struct {
+		publicURL string
+		want      string
+		wantErr   bool
+	}{
+		{publicURL: "https://broker.example.org", want: "wss://broker.example.org"},
+		{publicURL: "https://broker.example.org/", want: "wss://broker.example.org"},
+		{publicURL: "http://broker.example.org:8080", want: "ws://broker.example.org:8080"},
+		{publicURL: "wss://broker.example.org", want: "wss://broker.example.org"},
+		{publicURL: "https://example.org/broker/", want: "wss://example.org/broker"},
+		{publicURL: "ftp://broker.example.org", wantErr: true},
+		{publicURL: "https://", wantErr: true},
+		{publicURL: "https://broker.example.org/?debug=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.publicURL, func(t *testing.T) {
+			got, err := TunnelBaseURL(tt.publicURL)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Expected %q (error %v), got %q, %v", tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func TestTunnelURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		publicURL string
+		proxies   []string
+		headers   map[string]string
+		want      string
+	}{
+		{
+			name: "request host",
+			want: "wss://broker.internal/tunnel/abc123",
+		},
+		{
+			name:      "public URL",
+			publicURL: "https://example.org/broker/",
+			headers:   map[string]string{"X-Forwarded-Host": "other.example.org"},
+			want:      "wss://example.org/broker/tunnel/abc123",
+		},
+		{
+			name:    "trusted proxy",
+			proxies: []string{"192.0.2.0/24"},
+			headers: map[string]string{"X-Forwarded-Host": "broker.example.org, proxy.internal", "X-Forwarded-Proto": "http"},
+			want:    "ws://broker.example.org/tunnel/abc123",
+		},
+		{
+			name:    "trusted proxy IP",
+			proxies: []string{"192.0.2.1"},
+			headers: map[string]string{"X-Forwarded-Host": "broker.example.org", "X-Forwarded-Proto": "https"},
+			want:    "wss://broker.example.org/tunnel/abc123",
+		},
+		{
+			name:    "untrusted proxy",
+			proxies: []string{"10.0.0.0/8"},
+			headers: map[string]string{"X-Forwarded-Host": "evil.example.org", "X-Forwarded-Proto": "http"},
+			want:    "wss://broker.internal/tunnel/abc123",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := NewHandlers(&fakeProvider{}, session.NewInMemoryStore("1h", "test-secret"), &fakeHub{}, &fakeTunnels{},
+				HandlersConfig{PublicURL: tt.publicURL, TrustedProxies: tt.proxies})
+
+			// httptest requests come from 192.0.2.1
+			req := httptest.NewRequest(http.MethodGet, "/session", nil)
+			req.Host = "broker.internal"
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = req
+
+			if got := handlers.tunnelURL(c, "abc123"); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultRequestTimeout bounds handling a request
+const DefaultRequestTimeout = 30 * time.Second
+
+// DefaultSessionCreateTimeout bounds creating a session, which may wait for
+// JupyterHub to start the user's server
+const DefaultSessionCreateTimeout = 6 * time.Minute
+
+// codeRequestTimeout means the request took longer than its route allows
+const codeRequestTimeout = "request_timeout"
+
+// Timeout cancels the request's context once timeout has passed, stopping
+// the downstream calls made with it, and answers 504 if the handler returned
+// without writing a response. A response the handler did write is kept, even
+// after the deadline, so it is never replaced by a 504 that hides its status.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{"error": "request timed out", "code": codeRequestTimeout})
+		}
+	}
+}
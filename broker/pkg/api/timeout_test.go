@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// timeoutRouter serves handler on GET /test behind Timeout
+func timeoutRouter(timeout time.Duration, handler gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+	router.GET("/test", Timeout(timeout), handler)
+	return router
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  gin.HandlerFunc
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "in time",
+			handler:  func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) },
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "no response after deadline",
+			handler: func(c *gin.Context) {
+				<-c.Request.Context().Done()
+			},
+			wantCode: http.StatusGatewayTimeout,
+			wantBody: codeRequestTimeout,
+		},
+		{
+			name: "success after deadline",
+			handler: func(c *gin.Context) {
+				<-c.Request.Context().Done()
+				c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "error after deadline",
+			handler: func(c *gin.Context) {
+				<-c.Request.Context().Done()
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found", "code": codeSessionNotFound})
+			},
+			wantCode: http.StatusNotFound,
+			wantBody: codeSessionNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := timeoutRouter(10*time.Millisecond, tt.handler)
+			recorder, body := serve(router, httptest.NewRequest(http.MethodGet, "/test", nil))
+			if recorder.Code != tt.wantCode {
+				t.Errorf("Expected %d, got %d %v", tt.wantCode, recorder.Code, body)
+			}
+			if tt.wantBody != "" && body["code"] != tt.wantBody {
+				t.Errorf("Expected code %s, got %v", tt.wantBody, body)
+			}
+		})
+	}
+}